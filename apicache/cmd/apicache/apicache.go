@@ -5,24 +5,28 @@ import (
 	"path"
 
 	"github.com/kxnes/go-interviews/apicache/internal/apicache"
-	"github.com/kxnes/go-interviews/apicache/internal/drivers/memcache"
-	"github.com/kxnes/go-interviews/apicache/internal/drivers/redis"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/encrypt"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/disk"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/memcache"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/redis"
 	"github.com/kxnes/go-interviews/apicache/internal/fs"
 	"github.com/kxnes/go-interviews/apicache/internal/options"
+	_ "github.com/kxnes/go-interviews/apicache/internal/raft"
+	_ "github.com/kxnes/go-interviews/apicache/internal/replication"
 )
 
 func main() {
 	opts := options.Load(path.Join("configs", "dev.json"))
 
-	var driver fs.Driver
-
-	switch d := opts.Driver; d.Name {
-	case "redis":
-		driver = redis.New(d.Addr)
-	case "memcache":
-		driver = memcache.New(d.Addr)
-	default:
-		log.Fatalln("driver not set")
+	driver, err := opts.Driver.Open()
+	if err != nil {
+		log.Fatalln(err)
 	}
 
 	srv := apicache.NewServer(
@@ -0,0 +1,88 @@
+// Command replay drives APICache (or it's driver) with the workload recorded by "record" decorator
+// and reports divergences in results and latency.
+//
+//	replay -file record.jsonl -addr http://127.0.0.1:8080 -speed 2
+//	replay -file record.jsonl -config configs/dev.json
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/encrypt"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/disk"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/memcache"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/redis"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/options"
+	"github.com/kxnes/go-interviews/apicache/internal/replay"
+)
+
+func main() {
+	var (
+		file   = flag.String("file", "", "recorded workload")
+		addr   = flag.String("addr", "", "target APICache address, like http://127.0.0.1:8080")
+		config = flag.String("config", "", "config of target driver (used if -addr is not set)")
+		opts   replay.Options
+	)
+
+	flag.Float64Var(&opts.Speed, "speed", 1, "replay speed factor (2 is twice as fast)")
+	flag.Float64Var(&opts.Slowdown, "slowdown", 2, "report calls this times slower than recorded")
+	flag.IntVar(&opts.MaxDivergences, "divergences", 100, "maximal number of reported divergences")
+	flag.Parse()
+
+	if *file == "" || *addr == "" && *config == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	entries, err := replay.Read(record.NewReader(f))
+	_ = f.Close()
+
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var target fs.ContextDriver
+
+	if *addr != "" {
+		target = replay.NewClient(*addr, nil)
+	} else {
+		driver, err := options.Load(*config).Driver.Open()
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		target = fs.WithContext(driver)
+	}
+
+	defer target.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// interrupted replay reports started calls
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+
+	replay.Run(ctx, target, entries, &opts).Print(os.Stdout)
+}
@@ -0,0 +1,139 @@
+package apicache
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/raft"
+)
+
+// adminPrefix is the path prefix of all administrative routes.
+const adminPrefix = "/_admin/"
+
+// LimitsHandler exposes `fs.Driver` concurrency limits for monitoring.
+type LimitsHandler struct {
+	monitor fs.Monitor
+}
+
+func (api *LimitsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.monitor.Limits())
+}
+
+// HotKeysHandler exposes the most frequently accessed keys of `fs.Driver`.
+type HotKeysHandler struct {
+	profiler fs.Profiler
+}
+
+func (api *HotKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.profiler.TopKeys())
+}
+
+// FaultsHandler exposes fault injection configuration of `fs.Driver`:
+// GET returns the current one and PUT replaces it.
+type FaultsHandler struct {
+	injector *faults.Driver
+}
+
+func (api *FaultsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var opts faults.Options
+
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+			writeJSON(w, http.StatusBadRequest, &Response{Err: &MarshalError{&ErrInvalidJSON{}}})
+			return
+		}
+
+		if err := api.injector.Configure(&opts); err != nil {
+			writeJSON(w, http.StatusBadRequest, &Response{Err: &MarshalError{err}})
+			return
+		}
+
+		log.Printf("faults are reconfigured (enabled = %v, rules = %d)", opts.Enabled, len(opts.Rules))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.injector.Options())
+}
+
+// membersPath is the path of cluster members route.
+const membersPath = adminPrefix + "cluster/members"
+
+// ClusterHandler exposes Raft cluster of `fs.Driver`:
+// GET "cluster" returns the node status, POST "cluster/members" adds member
+// and DELETE "cluster/members/<id>" removes it.
+type ClusterHandler struct {
+	node *raft.Node
+}
+
+func (api *ClusterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	switch {
+	case r.URL.Path == adminPrefix+"cluster" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, api.node.Status())
+		return
+	case r.URL.Path == membersPath && r.Method == http.MethodPost:
+		var m raft.Member
+
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			writeJSON(w, http.StatusBadRequest, &Response{Err: &MarshalError{&ErrInvalidJSON{}}})
+			return
+		}
+
+		err = api.node.AddMember(r.Context(), &m)
+	case strings.HasPrefix(r.URL.Path, membersPath+"/") && r.Method == http.MethodDelete:
+		err = api.node.RemoveMember(r.Context(), strings.TrimPrefix(r.URL.Path, membersPath+"/"))
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	var (
+		enl *raft.ErrNoLeader
+		ecc *raft.ErrConfigChange
+		emb *raft.ErrMember
+		eim *raft.ErrInvalidMember
+	)
+
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, api.node.Status())
+	case errors.As(err, &enl):
+		writeJSON(w, http.StatusServiceUnavailable, &Response{Err: &MarshalError{err}})
+	case errors.As(err, &ecc), errors.As(err, &emb):
+		writeJSON(w, http.StatusConflict, &Response{Err: &MarshalError{err}})
+	case errors.As(err, &eim):
+		writeJSON(w, http.StatusBadRequest, &Response{Err: &MarshalError{err}})
+	default:
+		writeJSON(w, http.StatusInternalServerError, &Response{Err: &MarshalError{err}})
+	}
+}
+
+// writeJSON writes `v` as JSON response with `status`.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("response write err = %v\n", err)
+	}
+}
@@ -0,0 +1,246 @@
+package apicache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestLimitsHandler(t *testing.T) {
+	d := fs.New(
+		&test.DriverMock{Storage: &sync.Map{}},
+		&fs.Options{
+			ReadConn:  5,
+			WriteConn: 2,
+			Timeout:   timeout,
+		},
+	)
+	ts := httptest.NewServer(&LimitsHandler{monitor: d.(fs.Monitor)})
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		code   int
+		body   string
+	}{
+		{
+			name:   "200",
+			method: http.MethodGet,
+			code:   http.StatusOK,
+			body:   `{"read":{"limit":5,"used":0,"waiting":0},"write":{"limit":2,"used":0,"waiting":0}}`,
+		},
+		{
+			name:   "405",
+			method: http.MethodPost,
+			code:   http.StatusMethodNotAllowed,
+			body:   `{}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, err := http.NewRequest(c.method, ts.URL, nil)
+			if err != nil {
+				t.Errorf("%s new request unexpected error = %v", c.method, err)
+				return
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Errorf("%s unexpected error = %v", c.method, err)
+				return
+			}
+
+			if resp.StatusCode != c.code {
+				t.Errorf("%s code = %v, want = %v", c.method, resp.StatusCode, c.code)
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("%s unexpected body read = %v", c.method, err)
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			got := strings.TrimSpace(string(body))
+			if got != c.body {
+				t.Errorf("%s body = %v, want = %v", c.method, got, c.body)
+			}
+		})
+	}
+}
+
+func TestHotKeysHandler(t *testing.T) {
+	d := fs.New(
+		&test.DriverMock{Storage: &sync.Map{}},
+		&fs.Options{
+			MaxConn: maxConn,
+			Timeout: timeout,
+			HotKeys: &fs.HotKeys{TopK: 1},
+		},
+	)
+
+	srv := NewServer(&Dependencies{Driver: d}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	for _, key := range []string{test.KeyNotExist, test.KeyNotExist, test.KeyError} {
+		resp, err := http.Get(ts.URL + "/" + key)
+		if err != nil {
+			t.Fatalf("GET unexpected error = %v", err)
+		}
+
+		_ = resp.Body.Close()
+	}
+
+	resp, err := http.Get(ts.URL + adminPrefix + "hotkeys")
+	if err != nil {
+		t.Fatalf("GET unexpected error = %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var got fs.TopKeys
+
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("GET unexpected body read = %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || len(got.Read) != 1 || got.Read[0].Key != test.KeyNotExist || len(got.Write) != 0 {
+		t.Errorf("GET got = %d %+v, want = %d with the hottest read key (%s)", resp.StatusCode, got, http.StatusOK, test.KeyNotExist)
+	}
+
+	resp, err = http.Post(ts.URL+adminPrefix+"hotkeys", "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST unexpected error = %v", err)
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST code = %v, want = %v", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestFaultsHandler(t *testing.T) {
+	d := fs.New(
+		faults.New(drivertest.NewFake(), &faults.Options{}),
+		&fs.Options{MaxConn: maxConn, Timeout: timeout},
+	)
+
+	srv := NewServer(&Dependencies{Driver: d}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s unexpected error = %v", method, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{
+			name:   "set before outage",
+			method: http.MethodPost,
+			path:   "/",
+			body:   `{"key":"users:1","val":"alice","ttl":10}`,
+			code:   http.StatusCreated,
+		},
+		{
+			name:   "invalid JSON",
+			method: http.MethodPut,
+			path:   adminPrefix + "faults",
+			body:   `{`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid JSON"}`,
+		},
+		{
+			name:   "invalid options",
+			method: http.MethodPut,
+			path:   adminPrefix + "faults",
+			body:   `{"enabled":true,"rules":[{"errorRate":2}]}`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid Rules[0].ErrorRate (2)"}`,
+		},
+		{
+			name:   "enable outage",
+			method: http.MethodPut,
+			path:   adminPrefix + "faults",
+			body:   `{"enabled":true,"hang":5,"rules":[{"prefix":"users:","outage":true}]}`,
+			code:   http.StatusOK,
+			want: `{"enabled":true,"seed":0,"hang":5,"rules":[{"prefix":"users:","ops":null,"latency":null,` +
+				`"errorRate":0,"timeoutRate":0,"outage":true}]}`,
+		},
+		{
+			name:   "get in outage",
+			method: http.MethodGet,
+			path:   "/users:1",
+			code:   http.StatusInternalServerError,
+			want:   `{"error":"injected outage on get of key (users:1)"}`,
+		},
+		{
+			name:   "get out of outage",
+			method: http.MethodGet,
+			path:   "/orders:1",
+			code:   http.StatusNotFound,
+		},
+		{
+			name:   "disable",
+			method: http.MethodPut,
+			path:   adminPrefix + "faults",
+			body:   `{"enabled":false}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "get after outage",
+			method: http.MethodGet,
+			path:   "/users:1",
+			code:   http.StatusOK,
+		},
+		{
+			name:   "405",
+			method: http.MethodPost,
+			path:   adminPrefix + "faults",
+			code:   http.StatusMethodNotAllowed,
+			want:   `{}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, body := do(c.method, c.path, c.body)
+
+			if code != c.code {
+				t.Errorf("%s code = %v, want = %v", c.method, code, c.code)
+			}
+
+			if c.want != "" && body != c.want {
+				t.Errorf("%s body = %v, want = %v", c.method, body, c.want)
+			}
+		})
+	}
+}
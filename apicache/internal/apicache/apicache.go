@@ -6,29 +6,58 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
 	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/raft"
+	"github.com/kxnes/go-interviews/apicache/internal/replication"
+	"github.com/kxnes/go-interviews/apicache/internal/webhook"
 )
 
 type (
 	// Options contains `Server` specific parameters, like `Addr`.
+	// `Timeout` is a per-request deadline in seconds, zero means no deadline.
+	// `ReadThrough` binds key prefixes to upstream URLs.
+	// `Proxy` turns `Server` into caching reverse-proxy instead of storage API.
+	// `Drain` is the shutdown timeout in seconds for in-flight requests (30 if zero).
+	// `Audit` enables recording of successful mutations.
+	// `Webhooks` posts key expiration and deletion events, driver must implement `fs.Notifier`.
 	Options struct {
-		Addr string `json:"addr"`
+		Addr        string           `json:"addr"`
+		Timeout     time.Duration    `json:"timeout"`
+		Drain       time.Duration    `json:"drain"`
+		ReadThrough []*ReadThrough   `json:"readThrough"`
+		Proxy       *Proxy           `json:"proxy"`
+		Audit       *audit.Options   `json:"audit"`
+		Webhooks    *webhook.Options `json:"webhooks"`
 	}
 	// Dependencies represents external dependencies that `Server` has.
+	// `AuditSink` replaces `Options.Audit` file if it is set.
 	Dependencies struct {
-		Driver fs.Driver
+		Driver    fs.Driver
+		AuditSink audit.Sink
 	}
 	// Server represents the main APICache Server.
 	Server struct {
 		http.Server
-		deps *Dependencies
-		opts *Options
-		done chan struct{}
+		deps     *Dependencies
+		opts     *Options
+		audit    *audit.Log
+		webhooks *webhook.Dispatcher
+		unnotify context.CancelFunc
+		done     chan struct{}
+		base     context.Context
+		abort    context.CancelFunc
+		draining int32
+		inflight int64
 	}
 	// MarshalError decorates outgoing responses to for marshalling `error` type.
 	MarshalError struct {
@@ -42,26 +71,88 @@ func (e *MarshalError) MarshalJSON() ([]byte, error) {
 }
 
 // stop provides all `Shutdown()`-specific dependencies, like free resources.
+// The first signal starts draining, the second one aborts it.
 func (srv *Server) stop() {
-	defer func() {
-		srv.deps.Driver.Close()
-		close(srv.done)
-	}()
+	defer close(srv.done)
 
-	sigint := make(chan os.Signal, 1)
+	sigint := make(chan os.Signal, 2)
 	signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
 	<-sigint
 
-	if err := srv.Shutdown(context.Background()); err != nil {
-		log.Printf("server shutdown err = %v", err)
-	}
+	log.Printf("server shutdown, draining up to %ds (repeat signal to force)", srv.opts.Drain)
+
+	force := make(chan struct{})
+
+	go func() {
+		<-sigint
+		log.Printf("server shutdown forced")
+		close(force)
+	}()
+
+	report := srv.drain(force)
+
+	log.Printf(
+		"server shutdown report: forced = %v, aborted requests = %d, aborted operations = %d, took %v",
+		report.Forced, report.Requests, report.Operations, report.Duration,
+	)
 }
 
 // routing builds inner `Server` routing.
 func (srv *Server) routing() {
+	driver := fs.WithContext(srv.deps.Driver)
+	loaders := make([]*loader, 0, len(srv.opts.ReadThrough))
+
+	for _, rt := range srv.opts.ReadThrough {
+		loaders = append(loaders, newLoader(driver, rt))
+	}
+
 	mux := http.NewServeMux()
-	mux.Handle("/", &StorageHandler{driver: srv.deps.Driver})
-	srv.Handler = mux
+
+	if srv.opts.Proxy != nil {
+		mux.Handle("/", newProxyHandler(driver, srv.opts.Proxy))
+	} else {
+		api := &StorageHandler{
+			driver:  driver,
+			timeout: srv.opts.Timeout * time.Second,
+			loaders: loaders,
+			audit:   srv.audit,
+		}
+
+		mux.Handle("/", api)
+		mux.Handle(tagsPrefix, &TagsHandler{api: api})
+		mux.Handle(locksPrefix, &LocksHandler{api: api})
+	}
+
+	if srv.audit != nil {
+		mux.Handle(adminPrefix+"audit", &AuditHandler{log: srv.audit})
+	}
+
+	if m, ok := srv.deps.Driver.(fs.Monitor); ok {
+		mux.Handle(adminPrefix+"limits", &LimitsHandler{monitor: m})
+	}
+
+	if p, ok := srv.deps.Driver.(fs.Profiler); ok {
+		mux.Handle(adminPrefix+"hotkeys", &HotKeysHandler{profiler: p})
+	}
+
+	var injector *faults.Driver
+	if fs.As(srv.deps.Driver, &injector) {
+		mux.Handle(adminPrefix+"faults", &FaultsHandler{injector: injector})
+	}
+
+	var node *replication.Node
+	if fs.As(srv.deps.Driver, &node) {
+		mux.Handle(replication.Prefix, node)
+	}
+
+	var member *raft.Node
+	if fs.As(srv.deps.Driver, &member) {
+		mux.Handle(raft.Prefix, member)
+		mux.Handle(adminPrefix+"cluster", &ClusterHandler{node: member})
+		mux.Handle(adminPrefix+"cluster/", &ClusterHandler{node: member})
+	}
+
+	srv.Handler = srv.track(mux)
 }
 
 // Listen aggregates `ListenAndServe()` and adds signal listener for graceful shutdown.
@@ -77,8 +168,33 @@ func (srv *Server) Listen() {
 	<-srv.done
 }
 
+// notify subscribes `webhooks` to events of driver notifier, it is unsubscribed by `unnotify`.
+func (srv *Server) notify() {
+	var notifier fs.Notifier
+	if !fs.As(srv.deps.Driver, &notifier) {
+		log.Panicf("driver doesn't support webhooks")
+	}
+
+	srv.webhooks = webhook.New(srv.opts.Webhooks)
+
+	var ctx context.Context
+	ctx, srv.unnotify = context.WithCancel(context.Background())
+
+	if err := notifier.Notify(ctx, srv.webhooks.Dispatch); err != nil {
+		log.Panicf("webhooks subscription err = %v", err)
+	}
+}
+
 // NewServer returns new `Server`.
 func NewServer(deps *Dependencies, opts *Options) *Server {
+	if opts.Drain == 0 {
+		opts.Drain = defaultDrain
+	}
+
+	if opts.Drain < 0 {
+		log.Panicf("negative Drain")
+	}
+
 	srv := &Server{
 		Server: http.Server{Addr: opts.Addr},
 		deps:   deps,
@@ -86,6 +202,17 @@ func NewServer(deps *Dependencies, opts *Options) *Server {
 		done:   make(chan struct{}),
 	}
 
+	if opts.Audit != nil {
+		srv.audit = audit.New(opts.Audit, deps.AuditSink)
+	}
+
+	if opts.Webhooks != nil {
+		srv.notify()
+	}
+
+	srv.base, srv.abort = context.WithCancel(context.Background())
+	srv.BaseContext = func(net.Listener) context.Context { return srv.base }
+
 	srv.routing()
 
 	return srv
@@ -93,20 +220,35 @@ func NewServer(deps *Dependencies, opts *Options) *Server {
 
 type (
 	// StorageHandler handles all specific routes for interrupt with inner `fs.Driver`.
+	// Driver calls are aborted if client goes away or `timeout` is exceeded.
+	// GET of keys bound to `loaders` reads through upstream on miss.
+	// Successful POST and DELETE of existing key are recorded to `audit` if it is set.
+	// POST with `tags` and `DELETE /?pattern=` are available if driver supports them.
 	StorageHandler struct {
-		driver fs.Driver
+		driver  fs.ContextDriver
+		timeout time.Duration
+		loaders []*loader
+		audit   *audit.Log
 	}
 	// request uses for unmarshal incoming POST requests.
+	// `Mode`, `PTTL` (milliseconds), `ExpireAt` and `NoExpire` make the conditional set.
 	request struct {
-		Key string `json:"key"`
-		Val string `json:"val"`
-		TTL int    `json:"ttl"`
+		Key      string     `json:"key"`
+		Val      string     `json:"val"`
+		TTL      int        `json:"ttl"`
+		Tags     []string   `json:"tags"`
+		Mode     string     `json:"mode"`
+		PTTL     int64      `json:"pttl"`
+		ExpireAt *time.Time `json:"expireAt"`
+		NoExpire bool       `json:"noExpire"`
 	}
 	// Response uses to goal the same interface for all requests.
+	// `Deleted` is the number of keys deleted by tag or pattern.
 	Response struct {
-		status int
-		Val    string `json:"value,omitempty"`
-		Err    error  `json:"error,omitempty"`
+		status  int
+		Val     string `json:"value,omitempty"`
+		Deleted *int   `json:"deleted,omitempty"`
+		Err     error  `json:"error,omitempty"`
 	}
 	// ErrInvalidJSON occurred if incoming POST request cannot parse as JSON.
 	ErrInvalidJSON struct{}
@@ -125,22 +267,48 @@ func (e *ErrInvalidType) Error() string {
 	return fmt.Sprintf("invalid type (%s) for field (%s)", e._type, e.field)
 }
 
+// loader returns `loader` with the longest prefix of `key` or `nil`.
+func (api *StorageHandler) loader(key string) *loader {
+	var found *loader
+
+	for _, l := range api.loaders {
+		if strings.HasPrefix(key, l.opts.Prefix) && (found == nil || len(l.opts.Prefix) > len(found.opts.Prefix)) {
+			found = l
+		}
+	}
+
+	return found
+}
+
 // Get contains all GET method logic for `StorageHandler`.
 func (api *StorageHandler) Get(r *http.Request) *Response {
 	var (
+		val  string
+		err  error
 		etc  *fs.ErrConcurrentTimeout
+		ecl  *fs.ErrCanceled
 		ene  *fs.ErrNotExist
+		eup  *ErrUpstream
 		resp = new(Response)
 	)
 
 	key := r.URL.EscapedPath()[1:]
-	val, err := api.driver.Get(key)
+
+	if l := api.loader(key); l != nil {
+		val, err = l.get(r.Context(), key)
+	} else {
+		val, err = api.driver.GetContext(r.Context(), key)
+	}
 
 	if err != nil {
 		resp.Err = err
 		wrapped := errors.Unwrap(err)
 
 		switch {
+		case errors.As(err, &ecl):
+			resp.status = http.StatusRequestTimeout
+		case errors.As(err, &eup):
+			resp.status = http.StatusBadGateway
 		case wrapped != nil:
 			resp.status = http.StatusInternalServerError
 			resp.Err = wrapped
@@ -164,6 +332,11 @@ func (api *StorageHandler) Post(r *http.Request) *Response {
 	var (
 		req  request
 		etc  *fs.ErrConcurrentTimeout
+		ecl  *fs.ErrCanceled
+		eis  *fs.ErrInsufficientStorage
+		ens  *fs.ErrNotSupported
+		eex  *fs.ErrExist
+		ene  *fs.ErrNotExist
 		ute  *json.UnmarshalTypeError
 		resp = new(Response)
 	)
@@ -187,44 +360,73 @@ func (api *StorageHandler) Post(r *http.Request) *Response {
 
 	defer func() { _ = r.Body.Close() }()
 
-	err = api.driver.Set(req.Key, req.Val, req.TTL)
+	ttl := req.TTL
+
+	switch {
+	case req.cond():
+		ttl, err = api.setCond(r.Context(), &req)
+	case len(req.Tags) > 0:
+		err = api.setTags(r.Context(), &req)
+	default:
+		err = api.driver.SetContext(r.Context(), req.Key, req.Val, req.TTL)
+	}
 
 	if err != nil {
 		resp.Err = err
 		wrapped := errors.Unwrap(err)
 
 		switch {
+		case errors.As(err, &ecl):
+			resp.status = http.StatusRequestTimeout
 		case wrapped != nil:
 			resp.status = http.StatusInternalServerError
 			resp.Err = wrapped
 		case errors.As(err, &etc):
 			resp.status = http.StatusRequestTimeout
+		case errors.As(err, &eis):
+			resp.status = http.StatusInsufficientStorage
+		case errors.As(err, &ens):
+			resp.status = http.StatusNotImplemented
+		case errors.As(err, &eex):
+			resp.status = http.StatusConflict
+		case errors.As(err, &ene):
+			resp.status = http.StatusNotFound
 		default:
 			resp.status = http.StatusBadRequest
 		}
 	} else {
 		resp.status = http.StatusCreated
+		api.record(r, audit.OpSet, req.Key, req.Val, ttl)
 	}
 
 	return resp
 }
 
 // Delete contains all DELETE method logic for `StorageHandler`.
+// `DELETE /?pattern=` deletes all keys matching glob-style pattern.
 func (api *StorageHandler) Delete(r *http.Request) *Response {
 	var (
 		etc  *fs.ErrConcurrentTimeout
+		ecl  *fs.ErrCanceled
 		ene  *fs.ErrNotExist
 		resp = new(Response)
 	)
 
 	key := r.URL.EscapedPath()[1:]
-	_, err := api.driver.Delete(key)
+
+	if params := r.URL.Query(); key == "" && params["pattern"] != nil {
+		return api.deletePattern(r, params.Get("pattern"))
+	}
+
+	ok, err := api.driver.DeleteContext(r.Context(), key)
 
 	if err != nil {
 		resp.Err = err
 		wrapped := errors.Unwrap(err)
 
 		switch {
+		case errors.As(err, &ecl):
+			resp.status = http.StatusRequestTimeout
 		case wrapped != nil:
 			resp.status = http.StatusInternalServerError
 			resp.Err = wrapped
@@ -237,14 +439,40 @@ func (api *StorageHandler) Delete(r *http.Request) *Response {
 		}
 	} else {
 		resp.status = http.StatusNoContent
+
+		if ok {
+			api.record(r, audit.OpDel, key, "", 0)
+		}
 	}
 
 	return resp
 }
 
+// prepare applies request deadline and request ID to `r`.
+// Returned function must be called to release deadline resources.
+func (api *StorageHandler) prepare(w http.ResponseWriter, r *http.Request) (*http.Request, context.CancelFunc) {
+	cancel := func() {}
+
+	if api.timeout > 0 {
+		var ctx context.Context
+
+		ctx, cancel = context.WithTimeout(r.Context(), api.timeout)
+		r = r.WithContext(ctx)
+	}
+
+	if api.audit != nil {
+		requestID(w, r)
+	}
+
+	return r, cancel
+}
+
 func (api *StorageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var resp *Response
 
+	r, cancel := api.prepare(w, r)
+	defer cancel()
+
 	switch r.Method {
 	case http.MethodGet:
 		resp = api.Get(r)
@@ -11,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
 	"github.com/kxnes/go-interviews/apicache/internal/fs"
 	"github.com/kxnes/go-interviews/apicache/test"
 )
@@ -281,6 +282,68 @@ func TestStorageHandlerPost(t *testing.T) {
 	}
 }
 
+func TestStorageHandlerPostInsufficientStorage(t *testing.T) {
+	d := fs.New(
+		memory.New(&memory.Options{
+			MaxEntries: 1,
+			Policy:     memory.PolicyNoEviction,
+		}),
+		&fs.Options{
+			MaxConn: maxConn,
+			Timeout: timeout,
+		},
+	)
+	defer d.Close()
+
+	ts := httptest.NewServer(&StorageHandler{driver: d})
+	defer ts.Close()
+
+	cases := []struct {
+		name string
+		code int
+		body string
+		form string
+	}{
+		{
+			name: "201",
+			code: http.StatusCreated,
+			body: "",
+			form: fmt.Sprintf(`{"key":"%s","val":"1","ttl":1}`, keyExist),
+		},
+		{
+			name: "507",
+			code: http.StatusInsufficientStorage,
+			body: `{"error":"insufficient storage for key (` + test.KeyNotExist + `)"}`,
+			form: fmt.Sprintf(`{"key":"%s","val":"1","ttl":1}`, test.KeyNotExist),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL, "application/json", strings.NewReader(c.form))
+			if err != nil {
+				t.Errorf("POST unexpected error = %v", err)
+				return
+			}
+
+			if resp.StatusCode != c.code {
+				t.Errorf("POST code = %v, want = %v", resp.StatusCode, c.code)
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("POST unexpected body read = %v", err)
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			got := strings.TrimSpace(string(body))
+			if got != c.body {
+				t.Errorf("POST body = %v, want = %v", got, c.body)
+			}
+		})
+	}
+}
+
 func TestStorageHandlerDelete(t *testing.T) {
 	ts := httptest.NewServer(&StorageHandler{driver: d})
 	defer ts.Close()
@@ -499,3 +562,48 @@ func TestStorageHandlersRequestTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageHandlerContextTimeout(t *testing.T) {
+	d := fs.New(
+		&test.DriverMock{
+			Storage:      &sync.Map{},
+			IsConcurrent: true,
+		},
+		&fs.Options{
+			MaxConn: maxConn,
+			Timeout: timeout,
+		},
+	)
+	ts := httptest.NewServer(&StorageHandler{driver: d, timeout: 100 * time.Millisecond})
+	defer ts.Close()
+
+	start := time.Now()
+
+	resp, err := http.Get(ts.URL + "/" + keyExist)
+	if err != nil {
+		t.Errorf("GET unexpected error = %v", err)
+		return
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("GET driver call not aborted")
+	}
+
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("GET code = %v, want = %v", resp.StatusCode, http.StatusRequestTimeout)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Errorf("GET unexpected body read = %v", err)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	want := `{"error":"operation (get) canceled: context deadline exceeded"}`
+
+	got := strings.TrimSpace(string(body))
+	if got != want {
+		t.Errorf("GET body = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package apicache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+)
+
+// requestIDHeader carries request ID, it is generated if client doesn't send it.
+const requestIDHeader = "X-Request-Id"
+
+type (
+	// AuditHandler queries recent mutations recorded by `audit.Log`.
+	// Parameters are `key`, `from` and `to` (RFC 3339) and `limit`.
+	AuditHandler struct {
+		log *audit.Log
+	}
+	// ErrInvalidParam occurred if query parameter cannot be parsed.
+	ErrInvalidParam struct {
+		name string
+		err  error
+	}
+)
+
+func (e *ErrInvalidParam) Error() string {
+	return fmt.Sprintf("invalid parameter (%s): %v", e.name, e.err)
+}
+
+// newRequestID returns random request ID.
+func newRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// requestID sets request ID of `r` (generated if it is missing) to response headers.
+func requestID(w http.ResponseWriter, r *http.Request) {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" {
+		id = newRequestID()
+		r.Header.Set(requestIDHeader, id)
+	}
+
+	w.Header().Set(requestIDHeader, id)
+}
+
+// client returns identity of `r` client by `header` or by remote address.
+func client(r *http.Request, header string) string {
+	if header != "" {
+		if id := r.Header.Get(header); id != "" {
+			return id
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
+
+// record writes successful mutation `op` made by `r` if audit is enabled.
+func (api *StorageHandler) record(r *http.Request, op, key, val string, ttl int) {
+	if api.audit == nil {
+		return
+	}
+
+	e := &audit.Entry{
+		Op:        op,
+		Key:       key,
+		TTL:       ttl,
+		Client:    client(r, api.audit.ClientHeader()),
+		RequestID: r.Header.Get(requestIDHeader),
+	}
+
+	if op == audit.OpSet {
+		e.ValueHash = audit.Hash(val)
+	}
+
+	api.audit.Record(e)
+}
+
+// query parses `audit.Query` from `r` parameters.
+func query(r *http.Request) (*audit.Query, error) {
+	var (
+		params = r.URL.Query()
+		q      = &audit.Query{Key: params.Get("key")}
+		err    error
+	)
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+	} {
+		if v := params.Get(p.name); v != "" {
+			if *p.dst, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, &ErrInvalidParam{name: p.name, err: err}
+			}
+		}
+	}
+
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 0 {
+			return nil, &ErrInvalidParam{name: "limit", err: fmt.Errorf("non-negative integer expected (%s)", v)}
+		}
+	}
+
+	return q, nil
+}
+
+func (api *AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	q, err := query(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, &Response{Err: &MarshalError{err}})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, api.log.Query(q))
+}
@@ -0,0 +1,181 @@
+package apicache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// auditSink keeps written entries in memory.
+type auditSink struct {
+	mu      sync.Mutex
+	entries []*audit.Entry
+}
+
+func (s *auditSink) Write(e *audit.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, e)
+
+	return nil
+}
+
+func (s *auditSink) Close() error {
+	return nil
+}
+
+func TestServerAudit(t *testing.T) {
+	sink := &auditSink{}
+	srv := NewServer(
+		&Dependencies{
+			Driver:    fs.New(&test.DriverMock{Storage: &sync.Map{}}, &fs.Options{MaxConn: maxConn, Timeout: timeout}),
+			AuditSink: sink,
+		},
+		&Options{Audit: &audit.Options{ClientHeader: "X-Client-Id"}},
+	)
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	do := func(method, path, body string, headers map[string]string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s unexpected error = %v", method, err)
+		}
+
+		return resp
+	}
+
+	resp := do(http.MethodPost, "/", `{"key":"audited","val":"secret","ttl":10}`, map[string]string{
+		"X-Client-Id":   "batch-job",
+		requestIDHeader: "req-1",
+	})
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get(requestIDHeader) != "req-1" {
+		t.Errorf("POST got = %d (%s), want = %d (%s)", resp.StatusCode, resp.Header.Get(requestIDHeader), http.StatusCreated, "req-1")
+	}
+
+	// failed mutations are not recorded
+	do(http.MethodPost, "/", `{"key":"","val":"secret"}`, nil)
+	do(http.MethodDelete, "/"+test.KeyNotExist, "", nil)
+
+	resp = do(http.MethodDelete, "/audited", "", nil)
+	if resp.StatusCode != http.StatusNoContent || resp.Header.Get(requestIDHeader) == "" {
+		t.Errorf("DELETE got = %d (%s), want = %d with generated request ID", resp.StatusCode, resp.Header.Get(requestIDHeader), http.StatusNoContent)
+	}
+
+	if len(sink.entries) != 2 {
+		t.Fatalf("recorded entries got = %d, want = %d", len(sink.entries), 2)
+	}
+
+	set, del := sink.entries[0], sink.entries[1]
+
+	if set.Op != audit.OpSet || set.Key != "audited" || set.ValueHash != audit.Hash("secret") ||
+		set.TTL != 10 || set.Client != "batch-job" || set.RequestID != "req-1" {
+		t.Errorf("set entry got = %+v", set)
+	}
+
+	if del.Op != audit.OpDel || del.Key != "audited" || del.ValueHash != "" ||
+		del.Client != "127.0.0.1" || del.RequestID != resp.Header.Get(requestIDHeader) {
+		t.Errorf("del entry got = %+v", del)
+	}
+
+	cases := []struct {
+		name  string
+		query string
+		code  int
+		want  []string
+		body  string
+	}{
+		{name: "all", query: "", code: http.StatusOK, want: []string{audit.OpDel, audit.OpSet}},
+		{name: "key", query: "?key=audited&limit=1", code: http.StatusOK, want: []string{audit.OpDel}},
+		{name: "other key", query: "?key=other", code: http.StatusOK, want: []string{}},
+		{name: "time range", query: "?to=2000-01-01T00:00:00Z", code: http.StatusOK, want: []string{}},
+		{
+			name:  "invalid from",
+			query: "?from=yesterday",
+			code:  http.StatusBadRequest,
+			body:  `{"error":"invalid parameter (from): parsing time \"yesterday\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"yesterday\" as \"2006\""}`,
+		},
+		{
+			name:  "invalid limit",
+			query: "?limit=-1",
+			code:  http.StatusBadRequest,
+			body:  `{"error":"invalid parameter (limit): non-negative integer expected (-1)"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp := do(http.MethodGet, adminPrefix+"audit"+c.query, "", nil)
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != c.code {
+				t.Errorf("GET code = %v, want = %v", resp.StatusCode, c.code)
+			}
+
+			if c.code != http.StatusOK {
+				if strings.TrimSpace(string(body)) != c.body {
+					t.Errorf("GET body = %v, want = %v", string(body), c.body)
+				}
+
+				return
+			}
+
+			var entries []*audit.Entry
+			if err := json.Unmarshal(body, &entries); err != nil {
+				t.Fatalf("GET body decode error = %v", err)
+			}
+
+			ops := make([]string, 0, len(entries))
+			for _, e := range entries {
+				ops = append(ops, e.Op)
+			}
+
+			if strings.Join(ops, ",") != strings.Join(c.want, ",") {
+				t.Errorf("GET entries got = %v, want = %v", ops, c.want)
+			}
+		})
+	}
+}
+
+func TestServerAuditMissedKey(t *testing.T) {
+	sink := &auditSink{}
+	srv := NewServer(
+		&Dependencies{Driver: &test.DriverMock{Storage: &sync.Map{}}, AuditSink: sink},
+		&Options{Audit: &audit.Options{}},
+	)
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	// inner driver reports missed key without error
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/missed", nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE unexpected error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE got = %d, want = %d", resp.StatusCode, http.StatusNoContent)
+	}
+
+	if len(sink.entries) != 0 {
+		t.Errorf("recorded entries got = %+v, want = %v", sink.entries, "none")
+	}
+}
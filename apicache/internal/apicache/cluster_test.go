@@ -0,0 +1,210 @@
+package apicache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/raft"
+)
+
+func TestServerCluster(t *testing.T) {
+	const size = 3
+
+	servers := make([]*httptest.Server, size)
+	handlers := make([]http.Handler, size)
+	nodes := make([]*raft.Node, size)
+
+	dir, err := ioutil.TempDir("", "apicache-cluster")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+	}
+
+	members := make([]*raft.Member, size)
+	for i, ts := range servers {
+		members[i] = &raft.Member{ID: string(rune('a' + i)), Addr: "http://" + ts.Listener.Addr().String()}
+	}
+
+	for i, ts := range servers {
+		node := raft.New(memory.New(&memory.Options{}), &raft.Options{
+			ID:        members[i].ID,
+			Dir:       filepath.Join(dir, members[i].ID),
+			Members:   members,
+			Heartbeat: 10,
+			Election:  100,
+		})
+		nodes[i] = node
+		srv := NewServer(&Dependencies{Driver: fs.New(node, &fs.Options{MaxConn: maxConn, Timeout: timeout})}, &Options{})
+
+		handlers[i] = srv.Handler
+		ts.Start()
+	}
+
+	defer func() {
+		for i, ts := range servers {
+			ts.Close()
+			nodes[i].Close()
+		}
+	}()
+
+	do := func(i int, method, path, body string) (int, string) {
+		req, _ := http.NewRequest(method, servers[i].URL+path, strings.NewReader(body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s unexpected error = %v", method, err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, _ := ioutil.ReadAll(resp.Body)
+
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	// follower is the node which knows the leader
+	follower := -1
+
+	for deadline := time.Now().Add(3 * time.Second); follower == -1 && time.Now().Before(deadline); {
+		for i := range servers {
+			var s raft.Status
+
+			_, body := do(i, http.MethodGet, adminPrefix+"cluster", "")
+			_ = json.Unmarshal([]byte(body), &s)
+
+			if s.State == raft.StateFollower && s.Leader != "" {
+				follower = i
+				break
+			}
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if follower == -1 {
+		t.Fatalf("leader is not elected")
+	}
+
+	other := (follower + 1) % size
+
+	cases := []struct {
+		name   string
+		node   int
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{
+			name:   "set through follower",
+			node:   follower,
+			method: http.MethodPost,
+			path:   "/",
+			body:   `{"key":"key","val":"val","ttl":10}`,
+			code:   http.StatusCreated,
+		},
+		{
+			name:   "linearizable get",
+			node:   other,
+			method: http.MethodGet,
+			path:   "/key",
+			code:   http.StatusOK,
+			want:   `{"value":"val"}`,
+		},
+		{
+			name:   "lock through follower",
+			node:   follower,
+			method: http.MethodPost,
+			path:   locksPrefix + "job",
+			body:   `{"owner":"a","pttl":10000}`,
+			code:   http.StatusOK,
+		},
+		{
+			name:   "lock held on other",
+			node:   other,
+			method: http.MethodPost,
+			path:   locksPrefix + "job",
+			body:   `{"owner":"b","pttl":10000}`,
+			code:   http.StatusConflict,
+			want:   `{"error":"lock (job) is held by another owner"}`,
+		},
+		{
+			name:   "invalid JSON",
+			node:   follower,
+			method: http.MethodPost,
+			path:   membersPath,
+			body:   `{`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid JSON"}`,
+		},
+		{
+			name:   "invalid member",
+			node:   follower,
+			method: http.MethodPost,
+			path:   membersPath,
+			body:   `{"id":"d"}`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"empty member ID or address"}`,
+		},
+		{
+			name:   "duplicated member",
+			node:   follower,
+			method: http.MethodPost,
+			path:   membersPath,
+			body:   `{"id":"a","addr":"http://127.0.0.1:1"}`,
+			code:   http.StatusConflict,
+			want:   `{"error":"member (a) already exists"}`,
+		},
+		{
+			name:   "missed member",
+			node:   follower,
+			method: http.MethodDelete,
+			path:   membersPath + "/z",
+			code:   http.StatusConflict,
+			want:   `{"error":"member (z) not exist"}`,
+		},
+		{
+			name:   "method",
+			node:   follower,
+			method: http.MethodPut,
+			path:   membersPath,
+			code:   http.StatusMethodNotAllowed,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, body := do(c.node, c.method, c.path, c.body)
+
+			if code != c.code || c.want != "" && body != c.want {
+				t.Errorf("%s got = %d %s, want = %d %s", c.method, code, body, c.code, c.want)
+			}
+		})
+	}
+
+	// the follower is removed from the cluster
+	id := string(rune('a' + follower))
+
+	if code, body := do(other, http.MethodDelete, membersPath+"/"+id, ""); code != http.StatusOK {
+		t.Fatalf("DELETE got = %d %s, want = %d", code, body, http.StatusOK)
+	}
+
+	if got := len(nodes[other].Status().Members); got != size-1 {
+		t.Errorf("Status() members got = %v, want = %v", got, size-1)
+	}
+}
@@ -0,0 +1,66 @@
+package apicache
+
+import (
+	"context"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// ErrTaggedCond occurred if incoming POST request has both tags and conditional set fields.
+type ErrTaggedCond struct{}
+
+func (e *ErrTaggedCond) Error() string {
+	return "tags can't be combined with mode, pttl, expireAt or noExpire"
+}
+
+// cond checks `req` uses conditional set fields.
+func (req *request) cond() bool {
+	return req.Mode != "" || req.PTTL != 0 || req.ExpireAt != nil || req.NoExpire
+}
+
+// options returns `fs.SetOptions` of `req`, `ttl` is in seconds and `pttl` is in milliseconds.
+func (req *request) options() (*fs.SetOptions, error) {
+	opts := &fs.SetOptions{Mode: req.Mode, NoExpire: req.NoExpire}
+
+	switch {
+	case req.TTL != 0 && req.PTTL != 0:
+		return nil, fs.NewErrInvalidExpiry(req.Key, "only one of ttl or pttl is allowed")
+	case req.TTL != 0:
+		opts.TTL = time.Duration(req.TTL) * time.Second
+	case req.PTTL != 0:
+		opts.TTL = time.Duration(req.PTTL) * time.Millisecond
+	}
+
+	if req.ExpireAt != nil {
+		opts.ExpireAt = *req.ExpireAt
+	}
+
+	return opts, nil
+}
+
+// setCond sets key and value by conditional set fields of `req` if driver is `fs.CondSetter`.
+// Returns "time-to-live" of key rounded up to seconds.
+func (api *StorageHandler) setCond(ctx context.Context, req *request) (int, error) {
+	if len(req.Tags) > 0 {
+		return 0, &ErrTaggedCond{}
+	}
+
+	setter, ok := api.driver.(fs.CondSetter)
+	if !ok {
+		return 0, fs.NewErrNotSupported("mode")
+	}
+
+	opts, err := req.options()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+
+	if _, err := setter.SetCondContext(ctx, req.Key, req.Val, opts); err != nil {
+		return 0, err
+	}
+
+	return opts.TTLSeconds(now), nil
+}
@@ -0,0 +1,172 @@
+package apicache
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/encrypt"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestServerCond(t *testing.T) {
+	testServerCond(t, memory.New(nil))
+}
+
+func TestServerCondDecorated(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	inner := writebehind.New(memory.New(nil), nil)
+
+	testServerCond(t, cache.New(encrypt.New(retry.New(inner, nil), &encrypt.Options{Key: key}), nil))
+}
+
+func testServerCond(t *testing.T, inner fs.Driver) {
+	sink := &auditSink{}
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver, AuditSink: sink}, &Options{Audit: &audit.Options{}})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	defer driver.Close()
+
+	at := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		form   string
+		code   int
+		body   string
+	}{
+		{"xx missed", http.MethodPost, "/", `{"key":"lock","val":"1","ttl":10,"mode":"xx"}`, http.StatusNotFound, `{"error":"key (lock) not exist"}`},
+		{"nx missed", http.MethodPost, "/", `{"key":"lock","val":"1","pttl":1500,"mode":"nx"}`, http.StatusCreated, ""},
+		{"nx existed", http.MethodPost, "/", `{"key":"lock","val":"2","ttl":10,"mode":"nx"}`, http.StatusConflict, `{"error":"key (lock) already exists"}`},
+		{"xx existed", http.MethodPost, "/", `{"key":"lock","val":"3","expireAt":"` + at + `","mode":"xx"}`, http.StatusCreated, ""},
+		{"not overwritten", http.MethodGet, "/lock", "", http.StatusOK, `{"value":"3"}`},
+		{"no expire", http.MethodPost, "/", `{"key":"forever","val":"1","noExpire":true}`, http.StatusCreated, ""},
+		{"short pttl", http.MethodPost, "/", `{"key":"short","val":"1","pttl":50}`, http.StatusCreated, ""},
+		{
+			"invalid mode", http.MethodPost, "/", `{"key":"lock","val":"1","ttl":10,"mode":"ex"}`,
+			http.StatusBadRequest, `{"error":"invalid mode (ex) for key (lock)"}`,
+		},
+		{
+			"ttl and pttl", http.MethodPost, "/", `{"key":"lock","val":"1","ttl":10,"pttl":10}`,
+			http.StatusBadRequest, `{"error":"invalid expiry for key (lock): only one of ttl or pttl is allowed"}`,
+		},
+		{
+			"ambiguous", http.MethodPost, "/", `{"key":"lock","val":"1","ttl":10,"noExpire":true}`,
+			http.StatusBadRequest, `{"error":"invalid expiry for key (lock): only one of ttl, expireAt or noExpire is allowed"}`,
+		},
+		{
+			"missed expiry", http.MethodPost, "/", `{"key":"lock","val":"1","mode":"nx"}`,
+			http.StatusBadRequest, `{"error":"invalid expiry for key (lock): ttl, expireAt or noExpire is required"}`,
+		},
+		{
+			"passed expireAt", http.MethodPost, "/", `{"key":"lock","val":"1","expireAt":"2000-01-01T00:00:00Z"}`,
+			http.StatusBadRequest, `{"error":"invalid expiry for key (lock): expireAt (2000-01-01T00:00:00Z) is passed"}`,
+		},
+		{
+			"invalid expireAt", http.MethodPost, "/", `{"key":"lock","val":"1","expireAt":"tomorrow"}`,
+			http.StatusBadRequest, `{"error":"invalid JSON"}`,
+		},
+		{
+			"tagged", http.MethodPost, "/", `{"key":"lock","val":"1","ttl":10,"mode":"nx","tags":["locks"]}`,
+			http.StatusBadRequest, `{"error":"tags can't be combined with mode, pttl, expireAt or noExpire"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.form))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s unexpected error = %v", c.method, err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != c.code {
+				t.Errorf("%s code = %v, want = %v", c.method, resp.StatusCode, c.code)
+			}
+
+			if got := strings.TrimSpace(string(body)); got != c.body {
+				t.Errorf("%s body = %v, want = %v", c.method, got, c.body)
+			}
+		})
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if val, err := driver.Get("short"); err == nil {
+		t.Errorf("Get() of expired got = %v, want error", val)
+	}
+
+	if val, err := driver.Get("forever"); val != "1" || err != nil {
+		t.Errorf("Get() got = %v, %v, want = %v, %v", val, err, "1", nil)
+	}
+
+	// audit records "time-to-live" rounded up to seconds
+	var ttls []int
+
+	for _, e := range sink.entries {
+		ttls = append(ttls, e.TTL)
+	}
+
+	if got, want := len(ttls), 4; got != want || ttls[0] != 2 || ttls[1] != 3600 || ttls[2] != 0 || ttls[3] != 1 {
+		t.Errorf("audit TTLs got = %v, want = %v", ttls, []int{2, 3600, 0, 1})
+	}
+}
+
+func TestServerCondNotSupported(t *testing.T) {
+	testServerCondNotSupported(t, &test.DriverMock{Storage: &sync.Map{}})
+}
+
+func TestServerCondNotSupportedDecorated(t *testing.T) {
+	testServerCondNotSupported(t, cache.New(retry.New(&test.DriverMock{Storage: &sync.Map{}}, nil), nil))
+}
+
+func testServerCondNotSupported(t *testing.T, inner fs.Driver) {
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	cases := []struct {
+		name string
+		form string
+		code int
+		body string
+	}{
+		{"mode", `{"key":"key","val":"1","ttl":10,"mode":"nx"}`, http.StatusNotImplemented, `{"error":"operation (mode) is not supported by driver"}`},
+		{"pttl", `{"key":"key","val":"1","pttl":1500}`, http.StatusCreated, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			resp, err := http.Post(ts.URL, "application/json", strings.NewReader(c.form))
+			if err != nil {
+				t.Fatalf("POST unexpected error = %v", err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if got := strings.TrimSpace(string(body)); resp.StatusCode != c.code || got != c.body {
+				t.Errorf("POST got = %d %v, want = %d %v", resp.StatusCode, got, c.code, c.body)
+			}
+		})
+	}
+}
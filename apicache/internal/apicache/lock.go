@@ -0,0 +1,109 @@
+package apicache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// locksPrefix is the path prefix of lock routes.
+const locksPrefix = "/_locks/"
+
+type (
+	// LocksHandler grants leases of locks (`/_locks/{name}`) via `StorageHandler` driver:
+	// POST acquires, PUT renews and DELETE releases the lock of `owner`.
+	// Blocking acquire is aborted by request deadline as other driver calls.
+	LocksHandler struct {
+		api *StorageHandler
+	}
+	// lockRequest uses for unmarshal incoming lock requests.
+	// `PTTL` is "time-to-live" of lease and `Wait` is the limit of blocking acquire, both in milliseconds.
+	lockRequest struct {
+		Owner string `json:"owner"`
+		PTTL  int64  `json:"pttl"`
+		Wait  int64  `json:"wait"`
+	}
+)
+
+// lockResponse returns `Response` of lock operation failed with `err`.
+func lockResponse(err error) *Response {
+	var (
+		etc  *fs.ErrConcurrentTimeout
+		ecl  *fs.ErrCanceled
+		ens  *fs.ErrNotSupported
+		elk  *fs.ErrLocked
+		eno  *fs.ErrNotOwner
+		resp = &Response{Err: err}
+	)
+
+	wrapped := errors.Unwrap(err)
+
+	switch {
+	case errors.As(err, &ecl):
+		resp.status = http.StatusRequestTimeout
+	case wrapped != nil:
+		resp.status = http.StatusInternalServerError
+		resp.Err = wrapped
+	case errors.As(err, &etc):
+		resp.status = http.StatusRequestTimeout
+	case errors.As(err, &ens):
+		resp.status = http.StatusNotImplemented
+	case errors.As(err, &elk), errors.As(err, &eno):
+		resp.status = http.StatusConflict
+	default:
+		resp.status = http.StatusBadRequest
+	}
+
+	return resp
+}
+
+func (h *LocksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		req   lockRequest
+		lease *fs.Lease
+		err   error
+	)
+
+	r, cancel := h.api.prepare(w, r)
+	defer cancel()
+
+	leaser, ok := h.api.driver.(fs.Leaser)
+
+	switch {
+	case r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete:
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	case !ok:
+		err = fs.NewErrNotSupported("lock")
+	case json.NewDecoder(r.Body).Decode(&req) != nil:
+		err = &ErrInvalidJSON{}
+	}
+
+	name := r.URL.EscapedPath()[len(locksPrefix):]
+	ttl := time.Duration(req.PTTL) * time.Millisecond
+
+	if err == nil {
+		switch r.Method {
+		case http.MethodPost:
+			lease, err = leaser.AcquireContext(r.Context(), name, req.Owner, ttl, time.Duration(req.Wait)*time.Millisecond)
+		case http.MethodPut:
+			lease, err = leaser.RenewContext(r.Context(), name, req.Owner, ttl)
+		default:
+			err = leaser.ReleaseContext(r.Context(), name, req.Owner)
+		}
+	}
+
+	switch {
+	case err != nil:
+		resp := lockResponse(err)
+		resp.Err = &MarshalError{resp.Err}
+		writeJSON(w, resp.status, resp)
+	case lease == nil:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, lease)
+	}
+}
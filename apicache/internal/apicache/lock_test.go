@@ -0,0 +1,191 @@
+package apicache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/redis"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test"
+	"github.com/kxnes/go-interviews/apicache/test/redistest"
+)
+
+// lockDo sends lock request to `ts` and returns response status and body.
+func lockDo(t *testing.T, ts *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s unexpected error = %v", method, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	b, _ := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestServerLocks(t *testing.T) {
+	testServerLocks(t, memory.New(nil))
+}
+
+func TestServerLocksDecorated(t *testing.T) {
+	inner := writebehind.New(memory.New(nil), nil)
+
+	testServerLocks(t, cache.New(retry.New(inner, nil), nil))
+}
+
+func testServerLocks(t *testing.T, inner fs.Driver) {
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	defer driver.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+		want   string
+		token  int64
+	}{
+		{name: "acquire", method: http.MethodPost, body: `{"owner":"a","pttl":10000}`, code: http.StatusOK, token: 1},
+		{
+			name:   "acquire held",
+			method: http.MethodPost,
+			body:   `{"owner":"b","pttl":10000,"wait":20}`,
+			code:   http.StatusConflict,
+			want:   `{"error":"lock (job) is held by another owner"}`,
+		},
+		{name: "renew", method: http.MethodPut, body: `{"owner":"a","pttl":20000}`, code: http.StatusOK, token: 1},
+		{
+			name:   "renew other",
+			method: http.MethodPut,
+			body:   `{"owner":"b","pttl":20000}`,
+			code:   http.StatusConflict,
+			want:   `{"error":"lock (job) is not held by owner (b)"}`,
+		},
+		{
+			name:   "release other",
+			method: http.MethodDelete,
+			body:   `{"owner":"b"}`,
+			code:   http.StatusConflict,
+			want:   `{"error":"lock (job) is not held by owner (b)"}`,
+		},
+		{name: "release", method: http.MethodDelete, body: `{"owner":"a"}`, code: http.StatusNoContent},
+		{name: "acquire released", method: http.MethodPost, body: `{"owner":"b","pttl":50}`, code: http.StatusOK, token: 2},
+		{
+			name:   "acquire expired",
+			method: http.MethodPost,
+			body:   `{"owner":"c","pttl":10000,"wait":1000}`,
+			code:   http.StatusOK,
+			token:  3,
+		},
+		{
+			name:   "empty owner",
+			method: http.MethodPost,
+			body:   `{"pttl":10000}`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid lease of lock (job): empty owner"}`,
+		},
+		{
+			name:   "missed ttl",
+			method: http.MethodPut,
+			body:   `{"owner":"c"}`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid lease of lock (job): ttl (0s) is less than 1ms"}`,
+		},
+		{name: "invalid JSON", method: http.MethodPost, body: `{`, code: http.StatusBadRequest, want: `{"error":"invalid JSON"}`},
+		{name: "method", method: http.MethodGet, code: http.StatusMethodNotAllowed, want: `{}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, body := lockDo(t, ts, c.method, locksPrefix+"job", c.body)
+
+			if code != c.code || c.want != "" && body != c.want {
+				t.Errorf("%s got = %d %s, want = %d %s", c.method, code, body, c.code, c.want)
+			}
+
+			if c.token == 0 {
+				return
+			}
+
+			var lease fs.Lease
+			if err := json.Unmarshal([]byte(body), &lease); err != nil || lease.Token != c.token || lease.Name != "job" {
+				t.Errorf("%s lease got = %s, want token = %d", c.method, body, c.token)
+			}
+		})
+	}
+
+	// blocking acquire waits until the lock is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+
+		req, _ := http.NewRequest(http.MethodDelete, ts.URL+locksPrefix+"job", strings.NewReader(`{"owner":"c"}`))
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+
+	if code, body := lockDo(t, ts, http.MethodPost, locksPrefix+"job", `{"owner":"d","pttl":10000,"wait":5000}`); code != http.StatusOK {
+		t.Errorf("POST got = %d %s, want = %d", code, body, http.StatusOK)
+	}
+}
+
+func TestServerLocksNotSupported(t *testing.T) {
+	testServerLocksNotSupported(t, &test.DriverMock{Storage: &sync.Map{}})
+}
+
+func TestServerLocksNotSupportedDecorated(t *testing.T) {
+	testServerLocksNotSupported(t, cache.New(retry.New(&test.DriverMock{Storage: &sync.Map{}}, nil), nil))
+}
+
+func testServerLocksNotSupported(t *testing.T, inner fs.Driver) {
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	code, body := lockDo(t, ts, http.MethodPost, locksPrefix+"job", `{"owner":"a","pttl":10000}`)
+	if want := `{"error":"operation (lock) is not supported by driver"}`; code != http.StatusNotImplemented || body != want {
+		t.Errorf("POST got = %d %s, want = %d %s", code, body, http.StatusNotImplemented, want)
+	}
+}
+
+func TestServerLockKeysReserved(t *testing.T) {
+	storage := redistest.NewServer()
+	defer storage.Close()
+
+	driver := fs.New(redis.New(&redis.Options{Addr: storage.Addr()}), &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	defer driver.Close()
+
+	for _, key := range []string{"_lock:{job}", "_lock:{job}:fence", "_tag:job", "_keytags:{job}"} {
+		body := `{"key":"` + key + `","val":"val"}`
+		if code, got := lockDo(t, ts, http.MethodPost, "/", body); code != http.StatusBadRequest {
+			t.Errorf("POST %s got = %d %s, want = %d", key, code, got, http.StatusBadRequest)
+		}
+
+		if code, got := lockDo(t, ts, http.MethodDelete, "/"+key, ""); code != http.StatusBadRequest {
+			t.Errorf("DELETE %s got = %d %s, want = %d", key, code, got, http.StatusBadRequest)
+		}
+	}
+}
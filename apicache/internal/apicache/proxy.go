@@ -0,0 +1,398 @@
+package apicache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	proxyPrefix     = "proxy:"
+	proxyVaryPrefix = "proxy:vary:"
+	defaultMaxBody  = 1 << 20
+	cacheHit        = "HIT"
+	cacheMiss       = "MISS"
+)
+
+// heuristic are statuses cacheable by default when freshness is not set (RFC 7231, section 6.1).
+var heuristic = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// hopHeaders are not forwarded by proxy and not stored (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type (
+	// Proxy contains parameters of caching reverse-proxy mode.
+	// GET and HEAD responses of `Upstream` are cached for `Cache-Control`
+	// "s-maxage" or "max-age" seconds, or for `TTL` seconds if they are not set and status
+	// is cacheable by default (zero `TTL` means such responses are not cached).
+	// Responses larger than `MaxBody` bytes or with `Set-Cookie` are not cached.
+	Proxy struct {
+		Upstream string `json:"upstream"`
+		TTL      int    `json:"ttl"`
+		MaxBody  int64  `json:"maxBody"`
+	}
+	// ProxyHandler forwards requests to upstream and caches responses in `fs.Driver`.
+	ProxyHandler struct {
+		driver   fs.ContextDriver
+		upstream *url.URL
+		client   *http.Client
+		opts     *Proxy
+	}
+	// cached is a stored upstream response.
+	cached struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body"`
+		Date   time.Time   `json:"date"`
+	}
+)
+
+// cacheControl parses `Cache-Control` header directives.
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+
+	for _, line := range h["Cache-Control"] {
+		for _, part := range strings.Split(line, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			kv := strings.SplitN(part, "=", 2)
+			name := strings.ToLower(kv[0])
+
+			if len(kv) == 2 {
+				directives[name] = strings.Trim(kv[1], `"`)
+			} else {
+				directives[name] = ""
+			}
+		}
+	}
+
+	return directives
+}
+
+// has checks `directives` contain `name`.
+func has(directives map[string]string, name string) bool {
+	_, ok := directives[name]
+	return ok
+}
+
+// removeHopHeaders removes hop-by-hop headers from `h`.
+func removeHopHeaders(h http.Header) {
+	for _, f := range h["Connection"] {
+		for _, name := range strings.Split(f, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// varyNames returns sorted canonical header names listed in `Vary` of `h`.
+func varyNames(h http.Header) []string {
+	var names []string
+
+	for _, line := range h["Vary"] {
+		for _, name := range strings.Split(line, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// baseKey returns cache key material of `r` without `Vary` headers.
+func baseKey(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI()
+}
+
+// hashKey returns `prefix` with hex encoded SHA-256 of `material`,
+// so keys are short and printable for every driver whatever the request is.
+func hashKey(prefix, material string) string {
+	sum := sha256.Sum256([]byte(material))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+// varyKey returns key of `Vary` header names stored for `r`.
+func varyKey(r *http.Request) string {
+	return hashKey(proxyVaryPrefix, baseKey(r))
+}
+
+// variantKey returns cache key of `r` with values of `vary` headers.
+func variantKey(r *http.Request, vary []string) string {
+	var b strings.Builder
+
+	b.WriteString(baseKey(r))
+
+	for _, name := range vary {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(r.Header[name], ","))
+	}
+
+	return hashKey(proxyPrefix, b.String())
+}
+
+// ttl returns seconds to cache `resp` or zero if it is not cacheable.
+func (api *ProxyHandler) ttl(resp *http.Response) int {
+	cc := cacheControl(resp.Header)
+
+	// cookie of one client must not be served to others
+	if has(cc, "no-store") || has(cc, "private") || has(cc, "no-cache") || len(resp.Header["Set-Cookie"]) != 0 {
+		return 0
+	}
+
+	for _, name := range varyNames(resp.Header) {
+		if name == "*" {
+			return 0
+		}
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			ttl, err := strconv.Atoi(v)
+			if err != nil || ttl < 0 {
+				return 0
+			}
+
+			return ttl
+		}
+	}
+
+	if !heuristic[resp.StatusCode] {
+		return 0
+	}
+
+	return api.opts.TTL
+}
+
+// lookup returns cached response for `r` or `nil`.
+// Storage errors are logged and treated as miss.
+func (api *ProxyHandler) lookup(ctx context.Context, r *http.Request) *cached {
+	var (
+		vary []string
+		ene  *fs.ErrNotExist
+		c    cached
+	)
+
+	names, err := api.driver.GetContext(ctx, varyKey(r))
+
+	switch {
+	case err == nil:
+		vary = strings.Split(names, ",")
+	case !errors.As(err, &ene):
+		log.Printf("proxy lookup err = %v", err)
+		return nil
+	}
+
+	raw, err := api.driver.GetContext(ctx, variantKey(r, vary))
+	if err != nil {
+		if !errors.As(err, &ene) {
+			log.Printf("proxy lookup err = %v", err)
+		}
+
+		return nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &c); err != nil {
+		log.Printf("proxy lookup decode err = %v", err)
+		return nil
+	}
+
+	return &c
+}
+
+// store stores `c` as response for `r` for `ttl` seconds.
+func (api *ProxyHandler) store(ctx context.Context, r *http.Request, c *cached, ttl int) {
+	vary := varyNames(c.Header)
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("proxy store encode err = %v", err)
+		return
+	}
+
+	if len(vary) != 0 {
+		err = api.driver.SetContext(ctx, varyKey(r), strings.Join(vary, ","), ttl)
+		if err != nil {
+			log.Printf("proxy store err = %v", err)
+			return
+		}
+	}
+
+	if err = api.driver.SetContext(ctx, variantKey(r, vary), string(raw), ttl); err != nil {
+		log.Printf("proxy store err = %v", err)
+	}
+}
+
+// forward sends `r` to upstream.
+func (api *ProxyHandler) forward(r *http.Request) (*http.Response, error) {
+	target := *api.upstream
+	target.Path = strings.TrimSuffix(target.Path, "/") + r.URL.Path
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+
+	out, err := http.NewRequestWithContext(r.Context(), r.Method, target.String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	out.ContentLength = r.ContentLength
+	out.Header = r.Header.Clone()
+	removeHopHeaders(out.Header)
+
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := out.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+
+		out.Header.Set("X-Forwarded-For", ip)
+	}
+
+	return api.client.Do(out)
+}
+
+// write writes `c` to `w` with `X-Cache` status.
+func (api *ProxyHandler) write(w http.ResponseWriter, c *cached, status string) {
+	for name, values := range c.Header {
+		w.Header()[name] = values
+	}
+
+	w.Header().Set("X-Cache", status)
+
+	if status == cacheHit {
+		w.Header().Set("Age", strconv.Itoa(int(time.Since(c.Date).Seconds())))
+	}
+
+	w.WriteHeader(c.Status)
+
+	if _, err := w.Write(c.Body); err != nil {
+		log.Printf("response write err = %v\n", err)
+	}
+}
+
+func (api *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cc := cacheControl(r.Header)
+	cacheable := (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		r.Header.Get("Authorization") == "" && !has(cc, "no-store")
+
+	if cacheable && !has(cc, "no-cache") {
+		if c := api.lookup(r.Context(), r); c != nil {
+			api.write(w, c, cacheHit)
+			return
+		}
+	}
+
+	resp, err := api.forward(r)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, &Response{Err: &MarshalError{&ErrUpstream{api.opts.Upstream, err}}})
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	removeHopHeaders(resp.Header)
+
+	c := &cached{Status: resp.StatusCode, Header: resp.Header, Date: time.Now()}
+
+	// read one byte more to know the body is too large for caching
+	c.Body, err = ioutil.ReadAll(io.LimitReader(resp.Body, api.opts.MaxBody+1))
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, &Response{Err: &MarshalError{&ErrUpstream{api.opts.Upstream, err}}})
+		return
+	}
+
+	if int64(len(c.Body)) <= api.opts.MaxBody {
+		if ttl := api.ttl(resp); cacheable && ttl > 0 {
+			api.store(r.Context(), r, c, ttl)
+		}
+
+		api.write(w, c, cacheMiss)
+
+		return
+	}
+
+	// stream too large body as is
+	api.write(w, &cached{Status: c.Status, Header: c.Header}, cacheMiss)
+
+	if _, err := io.Copy(w, io.MultiReader(bytes.NewReader(c.Body), resp.Body)); err != nil {
+		log.Printf("response write err = %v\n", err)
+	}
+}
+
+// newProxyHandler returns `ProxyHandler` for `opts`.
+// Panics if `opts` are invalid.
+func newProxyHandler(driver fs.ContextDriver, opts *Proxy) *ProxyHandler {
+	upstream, err := url.Parse(opts.Upstream)
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		log.Panicf("invalid Proxy.Upstream (%s)", opts.Upstream)
+	}
+
+	if opts.TTL < 0 {
+		log.Panicf("negative Proxy.TTL")
+	}
+
+	if opts.MaxBody == 0 {
+		opts.MaxBody = defaultMaxBody
+	}
+
+	if opts.MaxBody < 0 {
+		log.Panicf("negative Proxy.MaxBody")
+	}
+
+	return &ProxyHandler{
+		driver:   driver,
+		upstream: upstream,
+		opts:     opts,
+		client: &http.Client{
+			Timeout: upstreamTimeout * time.Second,
+			// redirects are returned to the client as is
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
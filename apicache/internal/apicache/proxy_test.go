@@ -0,0 +1,318 @@
+package apicache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memcache"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test/memcachetest"
+)
+
+func TestProxyHandler(t *testing.T) {
+	var hits int64
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&hits, 1)
+
+		w.Header().Set("X-Upstream", "yes")
+
+		switch r.URL.Path {
+		case "/max-age":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		case "/missing":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusNotFound)
+		case "/cookie":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", fmt.Sprintf("session=%d", n))
+		}
+
+		_, _ = fmt.Fprintf(w, "%s %s?%s %s-%d", r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Accept-Language"), n)
+	}))
+	defer upstream.Close()
+
+	d := fs.New(memory.New(nil), &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	defer d.Close()
+
+	ts := httptest.NewServer(newProxyHandler(d, &Proxy{Upstream: upstream.URL}))
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		lang   string
+		code   int
+		cache  string
+		body   string
+	}{
+		{
+			name:   "miss",
+			method: http.MethodGet,
+			path:   "/max-age?a=1",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /max-age?a=1 -1",
+		},
+		{
+			name:   "hit",
+			method: http.MethodGet,
+			path:   "/max-age?a=1",
+			code:   http.StatusOK,
+			cache:  cacheHit,
+			body:   "GET /max-age?a=1 -1",
+		},
+		{
+			name:   "miss (other query)",
+			method: http.MethodGet,
+			path:   "/max-age?a=2",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /max-age?a=2 -2",
+		},
+		{
+			name:   "miss (no-store)",
+			method: http.MethodGet,
+			path:   "/no-store",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /no-store? -3",
+		},
+		{
+			name:   "miss (no-store not cached)",
+			method: http.MethodGet,
+			path:   "/no-store",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /no-store? -4",
+		},
+		{
+			name:   "miss (no max-age and no default TTL)",
+			method: http.MethodGet,
+			path:   "/plain",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /plain? -5",
+		},
+		{
+			name:   "miss (vary en)",
+			method: http.MethodGet,
+			path:   "/vary",
+			lang:   "en",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /vary? en-6",
+		},
+		{
+			name:   "miss (vary de)",
+			method: http.MethodGet,
+			path:   "/vary",
+			lang:   "de",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /vary? de-7",
+		},
+		{
+			name:   "hit (vary en)",
+			method: http.MethodGet,
+			path:   "/vary",
+			lang:   "en",
+			code:   http.StatusOK,
+			cache:  cacheHit,
+			body:   "GET /vary? en-6",
+		},
+		{
+			name:   "miss (POST not cached)",
+			method: http.MethodPost,
+			path:   "/max-age?a=1",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "POST /max-age?a=1 -8",
+		},
+		{
+			name:   "miss (404)",
+			method: http.MethodGet,
+			path:   "/missing",
+			code:   http.StatusNotFound,
+			cache:  cacheMiss,
+			body:   "GET /missing? -9",
+		},
+		{
+			name:   "hit (404)",
+			method: http.MethodGet,
+			path:   "/missing",
+			code:   http.StatusNotFound,
+			cache:  cacheHit,
+			body:   "GET /missing? -9",
+		},
+		{
+			name:   "miss (cookie)",
+			method: http.MethodGet,
+			path:   "/cookie",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /cookie? -10",
+		},
+		{
+			name:   "miss (cookie not cached)",
+			method: http.MethodGet,
+			path:   "/cookie",
+			code:   http.StatusOK,
+			cache:  cacheMiss,
+			body:   "GET /cookie? -11",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, ts.URL+c.path, nil)
+			if c.lang != "" {
+				req.Header.Set("Accept-Language", c.lang)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != c.code {
+				t.Errorf("StatusCode got = %d, want = %d", resp.StatusCode, c.code)
+			}
+
+			if got := resp.Header.Get("X-Cache"); got != c.cache {
+				t.Errorf("X-Cache got = %s, want = %s", got, c.cache)
+			}
+
+			if got := resp.Header.Get("X-Upstream"); got != "yes" {
+				t.Errorf("X-Upstream got = %s, want = %s", got, "yes")
+			}
+
+			if string(body) != c.body {
+				t.Errorf("Body got = %s, want = %s", body, c.body)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerMemcache(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer upstream.Close()
+
+	srv := memcachetest.NewServer()
+	defer srv.Close()
+
+	// memcache keys are limited to 250 bytes without whitespaces
+	d := fs.New(memcache.New(&memcache.Options{Addr: srv.Addr()}), &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	defer d.Close()
+
+	ts := httptest.NewServer(newProxyHandler(d, &Proxy{Upstream: upstream.URL}))
+	defer ts.Close()
+
+	path := "/long?q=" + strings.Repeat("a", 300)
+
+	for _, want := range []string{cacheMiss, cacheHit} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Accept-Language", "en, de")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		_ = resp.Body.Close()
+
+		if got := resp.Header.Get("X-Cache"); got != want {
+			t.Errorf("X-Cache got = %s, want = %s", got, want)
+		}
+	}
+}
+
+func TestProxyHandlerBadGateway(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+
+	d := fs.New(memory.New(nil), &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	defer d.Close()
+
+	ts := httptest.NewServer(newProxyHandler(d, &Proxy{Upstream: upstream.URL, TTL: 10}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/any")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("StatusCode got = %d, want = %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestProxyHandlerTTL(t *testing.T) {
+	api := newProxyHandler(nil, &Proxy{Upstream: "http://localhost", TTL: 5})
+
+	cases := []struct {
+		status int
+		header http.Header
+		want   int
+	}{
+		{http.StatusOK, http.Header{}, 5},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}}, 60},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=60, s-maxage=30"}}, 30},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=60, no-store"}}, 0},
+		{http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}}, 0},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=bad"}}, 0},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, 0},
+		{http.StatusOK, http.Header{"Set-Cookie": {"session=1"}}, 0},
+		{http.StatusOK, http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"session=1"}}, 0},
+		{http.StatusNotFound, http.Header{}, 5},
+		{http.StatusInternalServerError, http.Header{}, 0},
+		{http.StatusBadGateway, http.Header{}, 0},
+		{http.StatusServiceUnavailable, http.Header{}, 0},
+		{http.StatusServiceUnavailable, http.Header{"Cache-Control": {"max-age=60"}}, 60},
+	}
+	for _, c := range cases {
+		if got := api.ttl(&http.Response{StatusCode: c.status, Header: c.header}); got != c.want {
+			t.Errorf("ttl(%d, %v) got = %d, want = %d", c.status, c.header, got, c.want)
+		}
+	}
+}
+
+func TestNewProxyHandler(t *testing.T) {
+	cases := []struct {
+		opts *Proxy
+		err  string
+	}{
+		{&Proxy{Upstream: "localhost"}, "invalid Proxy.Upstream (localhost)"},
+		{&Proxy{Upstream: "http://localhost", TTL: -1}, "negative Proxy.TTL"},
+		{&Proxy{Upstream: "http://localhost", MaxBody: -1}, "negative Proxy.MaxBody"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			newProxyHandler(nil, c.opts)
+		})
+	}
+}
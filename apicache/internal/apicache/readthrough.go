@@ -0,0 +1,195 @@
+package apicache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	// envelope marks values stored by read-through `loader`: "\x00rt|<fresh until>|<value>",
+	// the leading NUL byte keeps text values written otherwise (e.g. "rt|1|val") from being mistaken for it.
+	envelope        = "\x00rt|"
+	upstreamTimeout = 10
+)
+
+type (
+	// ReadThrough binds keys with `Prefix` to upstream `URL` template,
+	// where `{key}` is replaced by key (as it is in request path) without `Prefix`.
+	// Loaded value is fresh for `TTL` seconds and then it is served stale
+	// for `Stale` seconds while it is refreshed in background.
+	ReadThrough struct {
+		Prefix string `json:"prefix"`
+		URL    string `json:"url"`
+		TTL    int    `json:"ttl"`
+		Stale  int    `json:"stale"`
+	}
+	// loader loads missed keys of `ReadThrough` prefix from upstream.
+	loader struct {
+		opts   *ReadThrough
+		driver fs.ContextDriver
+		client *http.Client
+		mu     sync.Mutex
+		loads  map[string]*load
+	}
+	// load is in-flight upstream request shared by concurrent callers.
+	load struct {
+		done chan struct{}
+		val  string
+		err  error
+	}
+	// ErrUpstream occurred if upstream request failed.
+	ErrUpstream struct {
+		url string
+		err error
+	}
+)
+
+func (e *ErrUpstream) Error() string {
+	return fmt.Sprintf("upstream (%s) error: %v", e.url, e.err)
+}
+
+// encode packs `val` with time until it is fresh.
+func encode(val string, fresh time.Time) string {
+	return envelope + strconv.FormatInt(fresh.UnixNano(), 10) + "|" + val
+}
+
+// decode unpacks value and checks it is fresh.
+// Values stored not by `loader` (e.g. with POST) are always fresh.
+func decode(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, envelope) {
+		return raw, true
+	}
+
+	parts := strings.SplitN(raw[len(envelope):], "|", 2)
+	if len(parts) != 2 {
+		return raw, true
+	}
+
+	fresh, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return raw, true
+	}
+
+	return parts[1], time.Now().UnixNano() < fresh
+}
+
+// get gets `key` from storage or from upstream on miss.
+// Stale value is returned immediately and refreshed in background.
+func (l *loader) get(ctx context.Context, key string) (string, error) {
+	var ene *fs.ErrNotExist
+
+	raw, err := l.driver.GetContext(ctx, key)
+
+	switch {
+	case err == nil:
+		val, fresh := decode(raw)
+		if !fresh {
+			go func() { _, _ = l.load(context.Background(), key) }()
+		}
+
+		return val, nil
+	case errors.As(err, &ene):
+		return l.load(ctx, key)
+	default:
+		return "", err
+	}
+}
+
+// load fetches `key` from upstream and stores it.
+// Concurrent calls for the same key share the single upstream request.
+func (l *loader) load(ctx context.Context, key string) (string, error) {
+	l.mu.Lock()
+
+	ld, ok := l.loads[key]
+	if !ok {
+		ld = &load{done: make(chan struct{})}
+		l.loads[key] = ld
+
+		go func() {
+			ld.val, ld.err = l.fetch(key)
+
+			l.mu.Lock()
+			delete(l.loads, key)
+			l.mu.Unlock()
+
+			close(ld.done)
+		}()
+	}
+
+	l.mu.Unlock()
+
+	select {
+	case <-ld.done:
+		return ld.val, ld.err
+	case <-ctx.Done():
+		return "", fs.NewErrCanceled("get", ctx.Err())
+	}
+}
+
+// fetch requests `key` from upstream and stores the response body.
+func (l *loader) fetch(key string) (string, error) {
+	u := strings.Replace(l.opts.URL, "{key}", strings.TrimPrefix(key, l.opts.Prefix), -1)
+
+	resp, err := l.client.Get(u)
+	if err != nil {
+		return "", &ErrUpstream{u, err}
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &ErrUpstream{u, fmt.Errorf("status code %d", resp.StatusCode)}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", &ErrUpstream{u, err}
+	}
+
+	val := string(body)
+	fresh := time.Now().Add(time.Duration(l.opts.TTL) * time.Second)
+
+	err = l.driver.SetContext(context.Background(), key, encode(val, fresh), l.opts.TTL+l.opts.Stale)
+	if err != nil {
+		log.Printf("read-through store key (%s) err = %v", key, err)
+	}
+
+	return val, nil
+}
+
+// newLoader returns `loader` for `opts`.
+// Panics if `opts` are invalid.
+func newLoader(driver fs.ContextDriver, opts *ReadThrough) *loader {
+	if opts.Prefix == "" {
+		log.Panicf("empty ReadThrough.Prefix")
+	}
+
+	if _, err := url.Parse(opts.URL); err != nil || opts.URL == "" {
+		log.Panicf("invalid ReadThrough.URL (%s)", opts.URL)
+	}
+
+	if opts.TTL < 1 {
+		log.Panicf("non-positive ReadThrough.TTL")
+	}
+
+	if opts.Stale < 0 {
+		log.Panicf("negative ReadThrough.Stale")
+	}
+
+	return &loader{
+		opts:   opts,
+		driver: driver,
+		client: &http.Client{Timeout: upstreamTimeout * time.Second},
+		loads:  make(map[string]*load),
+	}
+}
@@ -0,0 +1,219 @@
+package apicache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+func TestStorageHandlerReadThrough(t *testing.T) {
+	var hits int64
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&hits, 1)
+
+		if r.URL.Path == "/users/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = fmt.Fprintf(w, "%s-%d", r.URL.Path, n)
+	}))
+	defer upstream.Close()
+
+	d := fs.New(memory.New(nil), &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	defer d.Close()
+
+	ts := httptest.NewServer(&StorageHandler{
+		driver: d,
+		loaders: []*loader{
+			newLoader(d, &ReadThrough{Prefix: "user:", URL: upstream.URL + "/users/{key}", TTL: 1, Stale: 10}),
+		},
+	})
+	defer ts.Close()
+
+	cases := []struct {
+		name  string
+		key   string
+		sleep time.Duration
+		code  int
+		body  string
+		hits  int64
+	}{
+		{
+			name: "200 (miss loaded from upstream)",
+			key:  "/user:1",
+			code: http.StatusOK,
+			body: `{"value":"/users/1-1"}`,
+			hits: 1,
+		},
+		{
+			name: "200 (fresh hit)",
+			key:  "/user:1",
+			code: http.StatusOK,
+			body: `{"value":"/users/1-1"}`,
+			hits: 1,
+		},
+		{
+			name:  "200 (stale hit refreshed in background)",
+			key:   "/user:1",
+			sleep: 1100 * time.Millisecond,
+			code:  http.StatusOK,
+			body:  `{"value":"/users/1-1"}`,
+			hits:  2,
+		},
+		{
+			name:  "200 (refreshed hit)",
+			key:   "/user:1",
+			sleep: 100 * time.Millisecond,
+			code:  http.StatusOK,
+			body:  `{"value":"/users/1-2"}`,
+			hits:  2,
+		},
+		{
+			name: "502",
+			key:  "/user:broken",
+			code: http.StatusBadGateway,
+			body: `{"error":"upstream (` + upstream.URL + `/users/broken) error: status code 500"}`,
+			hits: 3,
+		},
+		{
+			name: "404 (not bound key)",
+			key:  "/item:1",
+			code: http.StatusNotFound,
+			body: `{"error":"key (item:1) not exist"}`,
+			hits: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			time.Sleep(c.sleep)
+
+			resp, err := http.Get(ts.URL + c.key)
+			if err != nil {
+				t.Errorf("GET unexpected error = %v", err)
+				return
+			}
+
+			if resp.StatusCode != c.code {
+				t.Errorf("GET code = %v, want = %v", resp.StatusCode, c.code)
+			}
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Errorf("GET unexpected body read = %v", err)
+				return
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			got := strings.TrimSpace(string(body))
+			if got != c.body {
+				t.Errorf("GET body = %v, want = %v", got, c.body)
+			}
+
+			// wait for background refresh
+			time.Sleep(50 * time.Millisecond)
+
+			if n := atomic.LoadInt64(&hits); n != c.hits {
+				t.Errorf("GET upstream hits = %d, want = %d", n, c.hits)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		name  string
+		raw   string
+		val   string
+		fresh bool
+	}{
+		{
+			name:  "fresh",
+			raw:   encode("val", time.Now().Add(time.Minute)),
+			val:   "val",
+			fresh: true,
+		},
+		{
+			name:  "stale",
+			raw:   encode("val|with|separators", time.Now().Add(-time.Minute)),
+			val:   "val|with|separators",
+			fresh: false,
+		},
+		{
+			name:  "stored by POST",
+			raw:   "val",
+			val:   "val",
+			fresh: true,
+		},
+		{
+			name:  "stored by POST like envelope",
+			raw:   "rt|1|val",
+			val:   "rt|1|val",
+			fresh: true,
+		},
+		{
+			name:  "broken envelope",
+			raw:   envelope + "val",
+			val:   envelope + "val",
+			fresh: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			val, fresh := decode(c.raw)
+			if val != c.val || fresh != c.fresh {
+				t.Errorf("decode() = %v, %v, want = %v, %v", val, fresh, c.val, c.fresh)
+			}
+		})
+	}
+}
+
+func TestNewLoader(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *ReadThrough
+		err  string
+	}{
+		{
+			name: "empty prefix",
+			opts: &ReadThrough{URL: "http://127.0.0.1/{key}", TTL: 1},
+			err:  "empty ReadThrough.Prefix",
+		},
+		{
+			name: "empty url",
+			opts: &ReadThrough{Prefix: "user:", TTL: 1},
+			err:  "invalid ReadThrough.URL ()",
+		},
+		{
+			name: "non-positive ttl",
+			opts: &ReadThrough{Prefix: "user:", URL: "http://127.0.0.1/{key}"},
+			err:  "non-positive ReadThrough.TTL",
+		},
+		{
+			name: "negative stale",
+			opts: &ReadThrough{Prefix: "user:", URL: "http://127.0.0.1/{key}", TTL: 1, Stale: -1},
+			err:  "negative ReadThrough.Stale",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err.(string) != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			newLoader(d, c.opts)
+		})
+	}
+}
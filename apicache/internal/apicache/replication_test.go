@@ -0,0 +1,126 @@
+package apicache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/replication"
+)
+
+func TestServerReplication(t *testing.T) {
+	const size = 3
+
+	servers := make([]*httptest.Server, size)
+	handlers := make([]http.Handler, size)
+	nodes := make([]*replication.Node, size)
+
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+	}
+
+	// start starts `i`-th server with memory driver replicated to the others.
+	start := func(i int) {
+		var peers []string
+
+		for j, ts := range servers {
+			if j != i {
+				peers = append(peers, "http://"+ts.Listener.Addr().String())
+			}
+		}
+
+		node := replication.New(memory.New(&memory.Options{}), &replication.Options{
+			Node:     string(rune('a' + i)),
+			Peers:    peers,
+			Interval: 20,
+		})
+		nodes[i] = node
+		srv := NewServer(&Dependencies{Driver: fs.New(node, &fs.Options{MaxConn: maxConn, Timeout: timeout})}, &Options{})
+
+		handlers[i] = srv.Handler
+		servers[i].Start()
+	}
+
+	defer func() {
+		for i, ts := range servers {
+			ts.Close()
+
+			if nodes[i] != nil {
+				nodes[i].Close()
+			}
+		}
+	}()
+
+	get := func(i int, key string) (int, string) {
+		resp, err := http.Get(servers[i].URL + "/" + key)
+		if err != nil {
+			t.Fatalf("GET unexpected error = %v", err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		var r struct {
+			Val string `json:"value"`
+		}
+
+		_ = json.NewDecoder(resp.Body).Decode(&r)
+
+		return resp.StatusCode, r.Val
+	}
+
+	eventually := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if cond() {
+				return
+			}
+		}
+
+		t.Errorf("%s is not reached", what)
+	}
+
+	start(0)
+	start(1)
+
+	resp, err := http.Post(servers[0].URL, "application/json", strings.NewReader(`{"key":"key","val":"val","ttl":10}`))
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("POST got = %v, %v, want = %d", resp, err, http.StatusCreated)
+	}
+
+	_ = resp.Body.Close()
+
+	eventually("replicated set", func() bool {
+		code, val := get(1, "key")
+		return code == http.StatusOK && val == "val"
+	})
+
+	// the third server was offline, so it catches up
+	start(2)
+
+	eventually("caught up", func() bool {
+		code, val := get(2, "key")
+		return code == http.StatusOK && val == "val"
+	})
+
+	req, _ := http.NewRequest(http.MethodDelete, servers[2].URL+"/key", nil)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("DELETE got = %v, %v, want = %d", resp, err, http.StatusNoContent)
+	}
+
+	_ = resp.Body.Close()
+
+	eventually("replicated delete", func() bool {
+		c0, _ := get(0, "key")
+		c1, _ := get(1, "key")
+
+		return c0 == http.StatusNotFound && c1 == http.StatusNotFound
+	})
+}
@@ -0,0 +1,141 @@
+package apicache
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	// defaultDrain is the drain timeout in seconds if `Options.Drain` is not set.
+	defaultDrain = 30
+	// abortGrace is the timeout in seconds for aborted requests and driver calls to return.
+	abortGrace = 5
+)
+
+type (
+	// ShutdownReport contains the outcome of `Server` shutdown.
+	// `Forced` is true if draining is aborted by timeout or by the second signal,
+	// `Requests` and `Operations` are numbers of aborted HTTP requests and `fs.Driver` calls.
+	ShutdownReport struct {
+		Forced     bool
+		Requests   int64
+		Operations int
+		Duration   time.Duration
+	}
+	// ErrShuttingDown occurred if request is received while `Server` is draining.
+	ErrShuttingDown struct{}
+)
+
+func (e *ErrShuttingDown) Error() string {
+	return "server is shutting down"
+}
+
+// track rejects requests with 503 while `srv` is draining
+// and counts in-flight requests otherwise.
+func (srv *Server) track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&srv.draining) == 1 {
+			w.Header().Set("Connection", "close")
+			writeJSON(w, http.StatusServiceUnavailable, &Response{Err: &MarshalError{&ErrShuttingDown{}}})
+
+			return
+		}
+
+		atomic.AddInt64(&srv.inflight, 1)
+		defer atomic.AddInt64(&srv.inflight, -1)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// settle waits up to `abortGrace` seconds for aborted handlers and `drainer` calls (if it is set) to return,
+// so the driver is not closed under them.
+func (srv *Server) settle(drainer fs.Drainer) {
+	ctx, cancel := context.WithTimeout(context.Background(), abortGrace*time.Second)
+	defer cancel()
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&srv.inflight) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Printf("server shutdown: %d aborted requests are still running", atomic.LoadInt64(&srv.inflight))
+			return
+		}
+	}
+
+	if drainer != nil {
+		if n := drainer.Drain(ctx); n > 0 {
+			log.Printf("server shutdown: %d aborted operations are still running", n)
+		}
+	}
+}
+
+// drain stops `srv` gracefully: new requests are rejected with 503 and in-flight ones
+// are waited for `Options.Drain` seconds, then (or as soon as `force` is closed)
+// they are aborted along with `fs.Driver` calls.
+// The driver is closed in any case, after aborted calls return (see `settle()`).
+func (srv *Server) drain(force <-chan struct{}) *ShutdownReport {
+	start := time.Now()
+	report := &ShutdownReport{}
+
+	atomic.StoreInt32(&srv.draining, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), srv.opts.Drain*time.Second)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-force:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	drainer, ok := srv.deps.Driver.(fs.Drainer)
+
+	if err := srv.Shutdown(ctx); err != nil {
+		report.Forced = true
+		report.Requests = atomic.LoadInt64(&srv.inflight)
+
+		// `ctx` is done, so it is the snapshot of calls to be aborted
+		if ok {
+			report.Operations = drainer.Drain(ctx)
+		}
+
+		// in-flight requests contexts are derived from `srv.base`
+		srv.abort()
+		_ = srv.Close()
+	} else if ok {
+		// calls made outside of requests (e.g. background refresh) may be still running
+		report.Operations = drainer.Drain(ctx)
+		report.Forced = report.Operations > 0
+	}
+
+	if report.Forced {
+		srv.settle(drainer)
+	}
+
+	// notifications are stopped before driver, so closing doesn't produce events
+	if srv.webhooks != nil {
+		srv.unnotify()
+		srv.webhooks.Close()
+	}
+
+	srv.deps.Driver.Close()
+
+	if srv.audit != nil {
+		srv.audit.Close()
+	}
+
+	report.Duration = time.Since(start)
+
+	return report
+}
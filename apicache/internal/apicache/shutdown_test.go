@@ -0,0 +1,215 @@
+package apicache
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// drainServer returns started `Server` with slow driver and draining timeout `drain`.
+func drainServer(t *testing.T, drain time.Duration) (*Server, *test.DriverMock, string) {
+	dm := &test.DriverMock{Storage: &sync.Map{}, IsConcurrent: true}
+	dm.Storage.Store(keyExist, valExist)
+
+	srv := NewServer(
+		&Dependencies{Driver: fs.New(dm, &fs.Options{MaxConn: maxConn, Timeout: timeout})},
+		&Options{Drain: drain},
+	)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error = %v", err)
+	}
+
+	go func() { _ = srv.Serve(ln) }()
+
+	return srv, dm, "http://" + ln.Addr().String() + "/" + keyExist
+}
+
+// slowGet starts GET of `url` and returns channel with response status (0 on error).
+func slowGet(url string) <-chan int {
+	status := make(chan int, 1)
+
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			status <- 0
+			return
+		}
+
+		_ = resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	// wait for request to reach the driver
+	time.Sleep(200 * time.Millisecond)
+
+	return status
+}
+
+func TestServerDrain(t *testing.T) {
+	cases := []struct {
+		name   string
+		drain  time.Duration
+		force  time.Duration
+		status int
+		want   ShutdownReport
+		max    time.Duration
+	}{
+		{
+			name:   "graceful",
+			drain:  5,
+			status: http.StatusOK,
+			want:   ShutdownReport{},
+			max:    3 * time.Second,
+		},
+		{
+			name:   "timeout",
+			drain:  1,
+			status: http.StatusRequestTimeout,
+			want:   ShutdownReport{Forced: true, Requests: 1, Operations: 1},
+			max:    1500 * time.Millisecond,
+		},
+		{
+			name:   "second signal",
+			drain:  30,
+			force:  100 * time.Millisecond,
+			status: http.StatusRequestTimeout,
+			want:   ShutdownReport{Forced: true, Requests: 1, Operations: 1},
+			max:    time.Second,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv, dm, url := drainServer(t, c.drain)
+			status := slowGet(url)
+
+			force := make(chan struct{})
+			if c.force > 0 {
+				time.AfterFunc(c.force, func() { close(force) })
+			}
+
+			report := srv.drain(force)
+
+			// closing the mock takes the same time as it's calls
+			if d := report.Duration - 2*time.Second; d > c.max {
+				t.Errorf("drain() took = %v, want <= %v", d, c.max)
+			}
+
+			report.Duration = 0
+			if *report != c.want {
+				t.Errorf("drain() report got = %+v, want = %+v", *report, c.want)
+			}
+
+			if got := <-status; got != c.status && !(c.want.Forced && got == 0) {
+				t.Errorf("GET status got = %d, want = %d", got, c.status)
+			}
+
+			if dm.Closed != 1 {
+				t.Errorf("dependent driver not closed")
+			}
+		})
+	}
+}
+
+// lingering driver takes `linger` to return after the call is aborted
+// and records whether it is closed under the call.
+type lingering struct {
+	test.DriverMock
+	linger time.Duration
+	mu     sync.Mutex
+	calls  int
+	unsafe bool
+}
+
+func (d *lingering) GetContext(ctx context.Context, _ string) (string, error) {
+	d.mu.Lock()
+	d.calls++
+	d.mu.Unlock()
+
+	<-ctx.Done()
+	time.Sleep(d.linger)
+
+	d.mu.Lock()
+	d.calls--
+	d.mu.Unlock()
+
+	return "", ctx.Err()
+}
+
+func (d *lingering) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.unsafe = d.calls > 0
+}
+
+func TestServerDrainAbortedCalls(t *testing.T) {
+	ld := &lingering{linger: 300 * time.Millisecond}
+	srv := NewServer(&Dependencies{Driver: fs.New(ld, &fs.Options{MaxConn: maxConn, Timeout: timeout})}, &Options{Drain: 30})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error = %v", err)
+	}
+
+	go func() { _ = srv.Serve(ln) }()
+
+	status := slowGet("http://" + ln.Addr().String() + "/" + keyExist)
+
+	force := make(chan struct{})
+	close(force)
+
+	if report := srv.drain(force); !report.Forced || report.Requests != 1 {
+		t.Errorf("drain() report got = %+v, want forced with 1 request", *report)
+	}
+
+	<-status
+
+	if ld.unsafe {
+		t.Errorf("driver is closed under aborted call")
+	}
+}
+
+func TestServerDrainReject(t *testing.T) {
+	srv := NewServer(&Dependencies{Driver: d}, &Options{})
+	srv.draining = 1
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/" + keyExist)
+	if err != nil {
+		t.Fatalf("GET unexpected error = %v", err)
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("GET code = %v, want = %v", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if want := `{"error":"server is shutting down"}`; strings.TrimSpace(string(body)) != want {
+		t.Errorf("GET body = %v, want = %v", string(body), want)
+	}
+}
+
+func TestNewServerNegativeDrain(t *testing.T) {
+	defer func() {
+		if err := recover(); err != "negative Drain" {
+			t.Errorf("panic got = %v, want = %v", err, "negative Drain")
+		}
+	}()
+
+	NewServer(&Dependencies{Driver: d}, &Options{Drain: -1})
+}
@@ -0,0 +1,110 @@
+package apicache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// tagsPrefix is the path prefix of tag invalidation route.
+const tagsPrefix = "/_tags/"
+
+// TagsHandler invalidates all keys with tag (`DELETE /_tags/{tag}`) via `StorageHandler` driver.
+type TagsHandler struct {
+	api *StorageHandler
+}
+
+// bulkResponse returns `Response` of deletion of `n` keys selected by tag or pattern.
+func bulkResponse(n int, err error) *Response {
+	var (
+		etc  *fs.ErrConcurrentTimeout
+		ecl  *fs.ErrCanceled
+		ens  *fs.ErrNotSupported
+		resp = new(Response)
+	)
+
+	if err != nil {
+		resp.Err = err
+		wrapped := errors.Unwrap(err)
+
+		switch {
+		case errors.As(err, &ecl):
+			resp.status = http.StatusRequestTimeout
+		case wrapped != nil:
+			resp.status = http.StatusInternalServerError
+			resp.Err = wrapped
+		case errors.As(err, &etc):
+			resp.status = http.StatusRequestTimeout
+		case errors.As(err, &ens):
+			resp.status = http.StatusNotImplemented
+		default:
+			resp.status = http.StatusBadRequest
+		}
+	} else {
+		resp.status = http.StatusOK
+		resp.Deleted = &n
+	}
+
+	return resp
+}
+
+// setTags sets key, value and "time-to-live" with `tags` if driver is `fs.Tagger`.
+func (api *StorageHandler) setTags(ctx context.Context, req *request) error {
+	tagger, ok := api.driver.(fs.Tagger)
+	if !ok {
+		return fs.NewErrNotSupported("tag")
+	}
+
+	return tagger.SetTagsContext(ctx, req.Key, req.Val, req.TTL, req.Tags)
+}
+
+// deletePattern deletes all keys matching glob-style `pattern` if driver is `fs.Matcher`.
+func (api *StorageHandler) deletePattern(r *http.Request, pattern string) *Response {
+	matcher, ok := api.driver.(fs.Matcher)
+	if !ok {
+		return bulkResponse(0, fs.NewErrNotSupported("pattern"))
+	}
+
+	n, err := matcher.DeletePatternContext(r.Context(), pattern)
+	if err == nil {
+		api.record(r, audit.OpDelPattern, pattern, "", 0)
+	}
+
+	return bulkResponse(n, err)
+}
+
+// deleteTag deletes all keys with `tag` if driver is `fs.Tagger`.
+func (api *StorageHandler) deleteTag(r *http.Request, tag string) *Response {
+	tagger, ok := api.driver.(fs.Tagger)
+	if !ok {
+		return bulkResponse(0, fs.NewErrNotSupported("tag"))
+	}
+
+	n, err := tagger.DeleteTagContext(r.Context(), tag)
+	if err == nil {
+		api.record(r, audit.OpDelTag, tag, "", 0)
+	}
+
+	return bulkResponse(n, err)
+}
+
+func (h *TagsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, &Response{})
+		return
+	}
+
+	r, cancel := h.api.prepare(w, r)
+	defer cancel()
+
+	resp := h.api.deleteTag(r, r.URL.EscapedPath()[len(tagsPrefix):])
+
+	if resp.Err != nil {
+		resp.Err = &MarshalError{resp.Err}
+	}
+
+	writeJSON(w, resp.status, resp)
+}
@@ -0,0 +1,146 @@
+package apicache
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/audit"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/encrypt"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestServerTags(t *testing.T) {
+	testServerTags(t, memory.New(nil))
+}
+
+func TestServerTagsDecorated(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	inner := writebehind.New(memory.New(nil), nil)
+
+	testServerTags(t, cache.New(encrypt.New(retry.New(inner, nil), &encrypt.Options{Key: key}), nil))
+}
+
+func testServerTags(t *testing.T, inner fs.Driver) {
+	sink := &auditSink{}
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver, AuditSink: sink}, &Options{Audit: &audit.Options{}})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	defer driver.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		form   string
+		code   int
+		body   string
+	}{
+		{"tagged", http.MethodPost, "/", `{"key":"user:42:name","val":"1","ttl":10,"tags":["user:42"]}`, http.StatusCreated, ""},
+		{"tagged read", http.MethodGet, "/user:42:name", "", http.StatusOK, `{"value":"1"}`},
+		{"tagged twice", http.MethodPost, "/", `{"key":"user:42:mail","val":"1","ttl":10,"tags":["user:42","mails"]}`, http.StatusCreated, ""},
+		{"untagged", http.MethodPost, "/", `{"key":"user:43:name","val":"1","ttl":10}`, http.StatusCreated, ""},
+		{"empty tag", http.MethodPost, "/", `{"key":"user:44:name","val":"1","ttl":10,"tags":[""]}`, http.StatusBadRequest, `{"error":"empty tag"}`},
+		{"invalid tags", http.MethodPost, "/", `{"key":"user:44:name","val":"1","ttl":10,"tags":"user:44"}`, http.StatusBadRequest, `{"error":"invalid type ([]string) for field (tags)"}`},
+		{"delete tag", http.MethodDelete, "/_tags/user:42", "", http.StatusOK, `{"deleted":2}`},
+		{"tagged key deleted", http.MethodGet, "/user:42:name", "", http.StatusNotFound, `{"error":"key (user:42:name) not exist"}`},
+		{"other tag cleaned", http.MethodDelete, "/_tags/mails", "", http.StatusOK, `{"deleted":0}`},
+		{"empty tag deletion", http.MethodDelete, "/_tags/", "", http.StatusBadRequest, `{"error":"empty tag"}`},
+		{"tag method not allowed", http.MethodGet, "/_tags/user:42", "", http.StatusMethodNotAllowed, `{}`},
+		{"delete pattern", http.MethodDelete, "/?pattern=user:4*", "", http.StatusOK, `{"deleted":1}`},
+		{"empty pattern", http.MethodDelete, "/?pattern=", "", http.StatusBadRequest, `{"error":"empty pattern"}`},
+		{"pattern key deleted", http.MethodGet, "/user:43:name", "", http.StatusNotFound, `{"error":"key (user:43:name) not exist"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.form))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s unexpected error = %v", c.method, err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != c.code {
+				t.Errorf("%s code = %v, want = %v", c.method, resp.StatusCode, c.code)
+			}
+
+			if got := strings.TrimSpace(string(body)); got != c.body {
+				t.Errorf("%s body = %v, want = %v", c.method, got, c.body)
+			}
+		})
+	}
+
+	var ops []string
+
+	for _, e := range sink.entries {
+		ops = append(ops, e.Op+" "+e.Key)
+	}
+
+	want := "set user:42:name,set user:42:mail,set user:43:name,deltag user:42,deltag mails,delpattern user:4*"
+	if got := strings.Join(ops, ","); got != want {
+		t.Errorf("recorded entries got = %v, want = %v", got, want)
+	}
+}
+
+func TestServerTagsNotSupported(t *testing.T) {
+	testServerTagsNotSupported(t, &test.DriverMock{Storage: &sync.Map{}})
+}
+
+func TestServerTagsNotSupportedDecorated(t *testing.T) {
+	testServerTagsNotSupported(t, cache.New(retry.New(&test.DriverMock{Storage: &sync.Map{}}, nil), nil))
+}
+
+func testServerTagsNotSupported(t *testing.T, inner fs.Driver) {
+	driver := fs.New(inner, &fs.Options{MaxConn: maxConn, Timeout: timeout})
+	srv := NewServer(&Dependencies{Driver: driver}, &Options{})
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		form   string
+		body   string
+	}{
+		{"tagged", http.MethodPost, "/", `{"key":"key","val":"1","ttl":10,"tags":["tag"]}`, `{"error":"operation (tag) is not supported by driver"}`},
+		{"delete tag", http.MethodDelete, "/_tags/tag", "", `{"error":"operation (tag) is not supported by driver"}`},
+		{"delete pattern", http.MethodDelete, "/?pattern=*", "", `{"error":"operation (pattern) is not supported by driver"}`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.form))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s unexpected error = %v", c.method, err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+
+			if resp.StatusCode != http.StatusNotImplemented {
+				t.Errorf("%s code = %v, want = %v", c.method, resp.StatusCode, http.StatusNotImplemented)
+			}
+
+			if got := strings.TrimSpace(string(body)); got != c.body {
+				t.Errorf("%s body = %v, want = %v", c.method, got, c.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package apicache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/webhook"
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestServerWebhooks(t *testing.T) {
+	events := make(chan string, 10)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var e fs.Event
+		if err := json.Unmarshal(body, &e); err != nil || r.Header.Get(webhook.SignatureHeader) != webhook.Sign("secret", body) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		events <- e.Type + ":" + e.Key
+	}))
+	defer receiver.Close()
+
+	srv := NewServer(
+		&Dependencies{Driver: fs.New(memory.New(nil), &fs.Options{MaxConn: maxConn, Timeout: timeout})},
+		&Options{Webhooks: &webhook.Options{Hooks: []*webhook.Hook{{URL: receiver.URL, Prefix: "user:", Secret: "secret"}}}},
+	)
+
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	do := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s unexpected error = %v", method, err)
+		}
+
+		_ = resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		code   int
+	}{
+		{http.MethodPost, "/", `{"key":"user:1","val":"1","pttl":20}`, http.StatusCreated},
+		{http.MethodPost, "/", `{"key":"user:2","val":"2","ttl":10}`, http.StatusCreated},
+		{http.MethodPost, "/", `{"key":"team:1","val":"1","ttl":10}`, http.StatusCreated},
+		{http.MethodDelete, "/team:1", "", http.StatusNoContent},
+		{http.MethodDelete, "/user:2", "", http.StatusNoContent},
+		{http.MethodDelete, "/" + test.KeyNotExist, "", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		if code := do(c.method, c.path, c.body); code != c.code {
+			t.Errorf("%s %s got = %d, want = %d", c.method, c.path, code, c.code)
+		}
+	}
+
+	time.Sleep(40 * time.Millisecond)
+
+	if code := do(http.MethodGet, "/user:1", ""); code != http.StatusNotFound {
+		t.Errorf("GET expired got = %d, want = %d", code, http.StatusNotFound)
+	}
+
+	for _, want := range []string{"deleted:user:2", "expired:user:1"} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Errorf("webhook got = %v, want = %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("webhook got nothing, want = %v", want)
+		}
+	}
+
+	srv.drain(make(chan struct{}))
+
+	select {
+	case got := <-events:
+		t.Errorf("webhook got = %v, want nothing", got)
+	default:
+	}
+}
+
+func TestServerWebhooksNotSupported(t *testing.T) {
+	defer func() {
+		if err := recover(); err != "driver doesn't support webhooks" {
+			t.Errorf("panic got = %v, want = %v", err, "driver doesn't support webhooks")
+		}
+	}()
+
+	NewServer(
+		&Dependencies{Driver: fs.New(&test.DriverMock{Storage: &sync.Map{}}, &fs.Options{MaxConn: maxConn, Timeout: timeout})},
+		&Options{Webhooks: &webhook.Options{}},
+	)
+}
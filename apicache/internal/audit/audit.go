@@ -0,0 +1,168 @@
+// Package audit records key-value storage mutations for compliance.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+)
+
+// Operations recorded by `Log`.
+// Bulk deletions are recorded with tag or pattern as the key.
+const (
+	OpSet        = "set"
+	OpDel        = "del"
+	OpDelTag     = "deltag"
+	OpDelPattern = "delpattern"
+)
+
+const defaultRecent = 1000
+
+type (
+	// Options contains `Log` specific parameters.
+	// Entries are written to `File` (rotated after `MaxSize` bytes keeping `MaxFiles` old files)
+	// unless the sink is passed to `New`, the last `Recent` entries are kept in memory for `Query`.
+	// Client is identified by `ClientHeader` request header if it is set (remote address otherwise).
+	Options struct {
+		File         string `json:"file"`
+		MaxSize      int64  `json:"maxSize"`
+		MaxFiles     int    `json:"maxFiles"`
+		Recent       int    `json:"recent"`
+		ClientHeader string `json:"clientHeader"`
+	}
+	// Entry is a single successful mutation.
+	// `ValueHash` is hex encoded SHA-256 of the value, so values are not disclosed.
+	Entry struct {
+		Time      time.Time `json:"time"`
+		Op        string    `json:"op"`
+		Key       string    `json:"key"`
+		ValueHash string    `json:"valueHash,omitempty"`
+		TTL       int       `json:"ttl"`
+		Client    string    `json:"client"`
+		RequestID string    `json:"requestId"`
+	}
+	// Query selects entries with `Key` (any if empty) made in `[From, To]` (unbounded if zero),
+	// up to `Limit` (all if zero) newest ones.
+	Query struct {
+		Key   string
+		From  time.Time
+		To    time.Time
+		Limit int
+	}
+	// Sink stores entries, e.g. ships them to external system.
+	// Implementations must be safe for concurrent use.
+	Sink interface {
+		Write(e *Entry) error
+		Close() error
+	}
+	// Log records entries to `Sink` and keeps the recent ones for queries.
+	Log struct {
+		sink   Sink
+		opts   *Options
+		mu     sync.Mutex
+		recent []*Entry
+		next   int
+	}
+)
+
+// Hash returns hex encoded SHA-256 of `val`.
+func Hash(val string) string {
+	sum := sha256.Sum256([]byte(val))
+	return hex.EncodeToString(sum[:])
+}
+
+// match checks `e` is selected by `q`.
+func (q *Query) match(e *Entry) bool {
+	switch {
+	case q.Key != "" && e.Key != q.Key:
+		return false
+	case !q.From.IsZero() && e.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && e.Time.After(q.To):
+		return false
+	default:
+		return true
+	}
+}
+
+// Record writes `e` to the sink and keeps it as recent.
+// Sink errors are logged, mutation is already done and it must not fail.
+func (l *Log) Record(e *Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.sink.Write(e); err != nil {
+		log.Printf("audit write err = %v\n", err)
+	}
+
+	if len(l.recent) < cap(l.recent) {
+		l.recent = append(l.recent, e)
+		return
+	}
+
+	l.recent[l.next] = e
+	l.next = (l.next + 1) % len(l.recent)
+}
+
+// Query returns recent entries selected by `q` from the newest to the oldest.
+func (l *Log) Query(q *Query) []*Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := make([]*Entry, 0)
+
+	for i := 1; i <= len(l.recent); i++ {
+		e := l.recent[(l.next-i+len(l.recent))%len(l.recent)]
+
+		if !q.match(e) {
+			continue
+		}
+
+		out = append(out, e)
+
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+
+	return out
+}
+
+// ClientHeader returns request header identifying client or empty string.
+func (l *Log) ClientHeader() string {
+	return l.opts.ClientHeader
+}
+
+// Close calls to release sink resources.
+func (l *Log) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.sink.Close(); err != nil {
+		log.Printf("audit close err = %v\n", err)
+	}
+}
+
+// New returns "ready-to-use" `Log` writing to `sink` or to rotating `Options.File` if `sink` is `nil`.
+// Panics if `opts` are invalid.
+func New(opts *Options, sink Sink) *Log {
+	if opts.Recent == 0 {
+		opts.Recent = defaultRecent
+	}
+
+	if opts.Recent < 0 {
+		log.Panicf("negative Recent")
+	}
+
+	if sink == nil {
+		sink = NewFile(opts)
+	}
+
+	return &Log{sink: sink, opts: opts, recent: make([]*Entry, 0, opts.Recent)}
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sink keeps written entries in memory.
+type sink struct {
+	entries []*Entry
+	err     error
+	closed  bool
+}
+
+func (s *sink) Write(e *Entry) error {
+	s.entries = append(s.entries, e)
+	return s.err
+}
+
+func (s *sink) Close() error {
+	s.closed = true
+	return s.err
+}
+
+// keys returns keys of `entries`.
+func keys(entries []*Entry) []string {
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.Key)
+	}
+
+	return out
+}
+
+func TestLogQuery(t *testing.T) {
+	s := &sink{}
+	l := New(&Options{Recent: 3}, s)
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i, key := range []string{"a", "b", "a", "c"} {
+		l.Record(&Entry{Time: base.Add(time.Duration(i) * time.Minute), Op: OpSet, Key: key})
+	}
+
+	if len(s.entries) != 4 {
+		t.Errorf("sink entries got = %d, want = %d", len(s.entries), 4)
+	}
+
+	cases := []struct {
+		name  string
+		query *Query
+		want  []string
+	}{
+		{name: "all recent", query: &Query{}, want: []string{"c", "a", "b"}},
+		{name: "key", query: &Query{Key: "a"}, want: []string{"a"}},
+		{name: "from", query: &Query{From: base.Add(2 * time.Minute)}, want: []string{"c", "a"}},
+		{name: "to", query: &Query{To: base.Add(2 * time.Minute)}, want: []string{"a", "b"}},
+		{name: "limit", query: &Query{Limit: 1}, want: []string{"c"}},
+		{name: "nothing", query: &Query{Key: "d"}, want: []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := keys(l.Query(c.query)); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Query() got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestLogRecord(t *testing.T) {
+	s := &sink{err: errors.New("sink error")}
+	l := New(&Options{ClientHeader: "X-Client"}, s)
+
+	// sink errors don't lose the entry
+	l.Record(&Entry{Op: OpDel, Key: "key"})
+
+	got := l.Query(&Query{})
+	if len(got) != 1 || got[0].Time.IsZero() {
+		t.Errorf("Query() got = %v, want the single entry with time", got)
+	}
+
+	if l.ClientHeader() != "X-Client" {
+		t.Errorf("ClientHeader() got = %s, want = %s", l.ClientHeader(), "X-Client")
+	}
+
+	l.Close()
+
+	if !s.closed {
+		t.Errorf("Close() sink not closed")
+	}
+}
+
+func TestHash(t *testing.T) {
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+	if got := Hash("hello"); got != want {
+		t.Errorf("Hash() got = %s, want = %s", got, want)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{Recent: -1}, "negative Recent"},
+		{&Options{}, "empty File"},
+		{&Options{File: "audit.jsonl", MaxSize: -1}, "negative MaxSize"},
+		{&Options{File: "audit.jsonl", MaxFiles: -1}, "negative MaxFiles"},
+		{&Options{File: "not-exist/audit.jsonl"}, "open File error (open not-exist/audit.jsonl: no such file or directory)"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts, nil)
+		})
+	}
+}
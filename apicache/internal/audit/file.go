@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+const (
+	defaultMaxSize  = 100 << 20
+	defaultMaxFiles = 5
+)
+
+// File is `Sink` writing entries as JSON lines.
+// The file is rotated after `maxSize` bytes: "audit.jsonl" becomes "audit.jsonl.1",
+// "audit.jsonl.1" becomes "audit.jsonl.2" and so on up to `maxFiles` old files.
+type File struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// open opens (or creates) the current file for appending.
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file, f.size = file, info.Size()
+
+	return nil
+}
+
+// backup returns the path of `n`-th old file.
+func (f *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// rotate shifts old files, the oldest one is removed, and opens the new current file.
+// If rotation fails the current file is reopened, so entries are still written to it.
+func (f *File) rotate() error {
+	err := f.file.Close()
+	if err == nil {
+		err = f.shift()
+	}
+
+	if err != nil {
+		if oerr := f.open(); oerr != nil {
+			log.Printf("audit reopen err = %v\n", oerr)
+		}
+
+		return err
+	}
+
+	return f.open()
+}
+
+// shift renames the current file and old ones to the next old ones, the oldest one is removed.
+func (f *File) shift() error {
+	_ = os.Remove(f.backup(f.maxFiles))
+
+	for n := f.maxFiles - 1; n >= 1; n-- {
+		if err := os.Rename(f.backup(n), f.backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(f.path, f.backup(1))
+}
+
+// Write appends `e` as JSON line, the file is rotated before if it becomes too large.
+// Rotation error is returned after `e` is appended to the current file.
+func (f *File) Write(e *Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var rerr error
+	if f.size > 0 && f.size+int64(len(line)) > f.maxSize {
+		rerr = f.rotate()
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+
+	if rerr != nil {
+		return rerr
+	}
+
+	return err
+}
+
+// Close closes the current file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+// NewFile returns `File` sink by `Options.File`, `Options.MaxSize` and `Options.MaxFiles`.
+// Panics if `opts` are invalid or the file cannot be opened.
+func NewFile(opts *Options) *File {
+	if opts.File == "" {
+		log.Panicf("empty File")
+	}
+
+	if opts.MaxSize == 0 {
+		opts.MaxSize = defaultMaxSize
+	}
+
+	if opts.MaxSize < 0 {
+		log.Panicf("negative MaxSize")
+	}
+
+	if opts.MaxFiles == 0 {
+		opts.MaxFiles = defaultMaxFiles
+	}
+
+	if opts.MaxFiles < 0 {
+		log.Panicf("negative MaxFiles")
+	}
+
+	f := &File{path: opts.File, maxSize: opts.MaxSize, maxFiles: opts.MaxFiles}
+
+	if err := f.open(); err != nil {
+		log.Panicf("open File error (%v)", err)
+	}
+
+	return f
+}
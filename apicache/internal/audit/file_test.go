@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// lines returns entries of JSONL file `p`.
+func lines(t *testing.T, p string) []*Entry {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatalf("open error = %v", err)
+	}
+	defer f.Close()
+
+	var out []*Entry
+
+	for s := bufio.NewScanner(f); s.Scan(); {
+		e := &Entry{}
+		if err := json.Unmarshal(s.Bytes(), e); err != nil {
+			t.Fatalf("decode error = %v", err)
+		}
+
+		out = append(out, e)
+	}
+
+	return out
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("temp dir error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "audit.jsonl")
+	entry := &Entry{
+		Time:      time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Op:        OpSet,
+		Key:       "key-0",
+		ValueHash: Hash("val"),
+		TTL:       10,
+		Client:    "127.0.0.1",
+		RequestID: "id",
+	}
+
+	line, _ := json.Marshal(entry)
+
+	// two entries per file
+	f := NewFile(&Options{File: p, MaxSize: int64(2*len(line) + 2), MaxFiles: 2})
+
+	for i := 0; i < 7; i++ {
+		e := *entry
+		e.Key = "key-" + strconv.Itoa(i)
+
+		if err := f.Write(&e); err != nil {
+			t.Fatalf("Write() error = %v, want = %v", err, nil)
+		}
+	}
+
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want = %v", err, nil)
+	}
+
+	cases := []struct {
+		file string
+		want []string
+	}{
+		{file: p, want: []string{"key-6"}},
+		{file: p + ".1", want: []string{"key-4", "key-5"}},
+		{file: p + ".2", want: []string{"key-2", "key-3"}},
+	}
+	for _, c := range cases {
+		t.Run(filepath.Base(c.file), func(t *testing.T) {
+			if got := keys(lines(t, c.file)); !reflect.DeepEqual(got, c.want) {
+				t.Errorf("entries got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+
+	if _, err := os.Stat(p + ".3"); !os.IsNotExist(err) {
+		t.Errorf("the oldest file is not removed (%v)", err)
+	}
+
+	if got := lines(t, p)[0]; got.ValueHash != entry.ValueHash || got.Client != entry.Client || !got.Time.Equal(entry.Time) {
+		t.Errorf("entry got = %+v, want = %+v", got, entry)
+	}
+
+	// appends to the existing file
+	f = NewFile(&Options{File: p, MaxSize: 1 << 20})
+	_ = f.Write(entry)
+	_ = f.Close()
+
+	if got := keys(lines(t, p)); !reflect.DeepEqual(got, []string{"key-6", "key-0"}) {
+		t.Errorf("entries got = %v, want = %v", got, []string{"key-6", "key-0"})
+	}
+}
+
+func TestFileRotateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("temp dir error = %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "audit.jsonl")
+
+	// the current file can't be renamed to the old one
+	if err := os.MkdirAll(filepath.Join(p+".1", "busy"), 0755); err != nil {
+		t.Fatalf("mkdir error = %v", err)
+	}
+
+	f := NewFile(&Options{File: p, MaxSize: 1, MaxFiles: 1})
+	defer f.Close()
+
+	for i := 0; i < 3; i++ {
+		err := f.Write(&Entry{Op: OpDel, Key: "key-" + strconv.Itoa(i)})
+		if (err != nil) != (i > 0) {
+			t.Errorf("Write() error = %v, want error = %v", err, i > 0)
+		}
+	}
+
+	if got, want := keys(lines(t, p)), []string{"key-0", "key-1", "key-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries got = %v, want = %v", got, want)
+	}
+
+	// rotation succeeds again
+	_ = os.RemoveAll(p + ".1")
+
+	if err := f.Write(&Entry{Op: OpDel, Key: "key-3"}); err != nil {
+		t.Errorf("Write() error = %v, want = %v", err, nil)
+	}
+
+	if got, want := keys(lines(t, p)), []string{"key-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("entries got = %v, want = %v", got, want)
+	}
+
+	if got, want := keys(lines(t, p+".1")), []string{"key-0", "key-1", "key-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("old entries got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,181 @@
+// Package cache provides `fs.Driver` decorator keeping recently read values in process memory.
+package cache
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultMaxEntries = 10000
+	defaultTTL        = 1
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Up to `MaxEntries` values read from inner driver are kept (least recently used are evicted)
+	// for `TTL` seconds, so writes made by other nodes are visible with `TTL` delay.
+	Options struct {
+		MaxEntries int `json:"maxEntries"`
+		TTL        int `json:"ttl"`
+	}
+	// Driver implements Driver interface.
+	// Reads are served by local `memory.Driver` and fall back to inner driver,
+	// writes go to inner driver and invalidate local values.
+	Driver struct {
+		driver fs.ContextDriver
+		local  *memory.Driver
+		opts   *Options
+	}
+)
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+// Missing keys are not cached.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if val, _ := d.local.Get(key); val != "" {
+		return val, nil
+	}
+
+	val, err := d.driver.GetContext(ctx, key)
+	if err != nil || val == "" {
+		return val, err
+	}
+
+	// local storage is bounded, rejected value is just not cached
+	_ = d.local.Set(key, val, d.opts.TTL)
+
+	return val, nil
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	_, _ = d.local.Delete(key)
+
+	return d.driver.SetContext(ctx, key, val, ttl)
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	_, _ = d.local.Delete(key)
+
+	return d.driver.DeleteContext(ctx, key)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	_, _ = d.local.Delete(key)
+
+	return fs.CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	_, _ = d.local.Delete(key)
+
+	return fs.TaggerOf(d.driver).SetTagsContext(ctx, key, val, ttl, tags)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Local values are not tagged, so all of them are invalidated.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	n, err := fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+	_, _ = d.local.DeletePattern("*")
+
+	return n, err
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	n, err := fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+	_, _ = d.local.DeletePattern(pattern)
+
+	return n, err
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Leases are not values, so they are never cached.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	return fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	d.local.Close()
+	d.driver.Close()
+}
+
+func init() {
+	fs.RegisterDecorator("cache", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with in-process cache.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.MaxEntries == 0 {
+		opts.MaxEntries = defaultMaxEntries
+	}
+
+	if opts.MaxEntries < 0 {
+		log.Panicf("negative MaxEntries")
+	}
+
+	if opts.TTL == 0 {
+		opts.TTL = defaultTTL
+	}
+
+	if opts.TTL < 0 {
+		log.Panicf("negative TTL")
+	}
+
+	return &Driver{
+		driver: fs.WithContext(driver),
+		local:  memory.New(&memory.Options{MaxEntries: opts.MaxEntries, Policy: memory.PolicyLRU}),
+		opts:   opts,
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+const (
+	key    = "key"
+	val    = "val"
+	newVal = "new-val"
+)
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(drivertest.NewFake(), nil) })
+}
+
+func TestDriverGet(t *testing.T) {
+	inner := drivertest.NewFake()
+	d := New(inner, &Options{TTL: 1})
+
+	defer d.Close()
+
+	_ = inner.Set(key, val, 0)
+
+	if got, err := d.Get(key); got != val || err != nil {
+		t.Errorf("Get() got = %s, %v, want = %s, %v", got, err, val, nil)
+	}
+
+	// changed by another node is visible after TTL
+	_ = inner.Set(key, newVal, 0)
+
+	if got, _ := d.Get(key); got != val {
+		t.Errorf("Get() cached got = %s, want = %s", got, val)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if got, _ := d.Get(key); got != newVal {
+		t.Errorf("Get() expired got = %s, want = %s", got, newVal)
+	}
+}
+
+func TestDriverInvalidate(t *testing.T) {
+	inner := drivertest.NewFake()
+	d := New(inner, &Options{TTL: 100})
+
+	defer d.Close()
+
+	_ = d.Set(key, val, 0)
+	_, _ = d.Get(key)
+
+	if err := d.Set(key, newVal, 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if got, _ := d.Get(key); got != newVal {
+		t.Errorf("Get() after Set() got = %s, want = %s", got, newVal)
+	}
+
+	if ok, err := d.Delete(key); !ok || err != nil {
+		t.Fatalf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if got, _ := d.Get(key); got != "" {
+		t.Errorf("Get() after Delete() got = %s, want = %s", got, "")
+	}
+}
+
+func TestNew(t *testing.T) {
+	d := New(drivertest.NewFake(), nil)
+	defer d.Close()
+
+	if d.opts.MaxEntries != defaultMaxEntries || d.opts.TTL != defaultTTL {
+		t.Errorf("New() opts got = %v, want defaults", d.opts)
+	}
+
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{MaxEntries: -1}, "negative MaxEntries"},
+		{&Options{TTL: -1}, "negative TTL"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
@@ -0,0 +1,210 @@
+// Package encrypt provides `fs.Driver` decorator encrypting values at rest with AES-GCM.
+package encrypt
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// `Key` is base64 encoded AES key of 16, 24 or 32 bytes.
+	Options struct {
+		Key string `json:"key"`
+	}
+	// Driver implements Driver interface.
+	// Values are stored by inner driver as base64 encoded nonce and ciphertext,
+	// the key is authenticated too, so value cannot be moved to another key.
+	// Keys are stored as is.
+	Driver struct {
+		driver fs.ContextDriver
+		aead   cipher.AEAD
+	}
+	// ErrDecrypt occurred if stored value cannot be decrypted (it is corrupted or encrypted by another key).
+	ErrDecrypt struct {
+		key string
+		err error
+	}
+)
+
+func (e *ErrDecrypt) Error() string {
+	return fmt.Sprintf("decrypt value of key (%s): %v", e.key, e.err)
+}
+
+func (e *ErrDecrypt) Unwrap() error {
+	return e.err
+}
+
+// seal returns encrypted `val` of `key`.
+func (d *Driver) seal(key, val string) (string, error) {
+	nonce := make([]byte, d.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(d.aead.Seal(nonce, nonce, []byte(val), []byte(key))), nil
+}
+
+// open returns decrypted `val` of `key`.
+func (d *Driver) open(key, val string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(val)
+	if err != nil {
+		return "", &ErrDecrypt{key: key, err: err}
+	}
+
+	if len(raw) < d.aead.NonceSize() {
+		return "", &ErrDecrypt{key: key, err: io.ErrUnexpectedEOF}
+	}
+
+	nonce, ciphertext := raw[:d.aead.NonceSize()], raw[d.aead.NonceSize():]
+
+	plain, err := d.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", &ErrDecrypt{key: key, err: err}
+	}
+
+	return string(plain), nil
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	val, err := d.driver.GetContext(ctx, key)
+	if err != nil || val == "" {
+		return val, err
+	}
+
+	return d.open(key, val)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	sealed, err := d.seal(key, val)
+	if err != nil {
+		return err
+	}
+
+	return d.driver.SetContext(ctx, key, sealed, ttl)
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	return d.driver.DeleteContext(ctx, key)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	sealed, err := d.seal(key, val)
+	if err != nil {
+		return false, err
+	}
+
+	return fs.CondSetterOf(d.driver).SetCondContext(ctx, key, sealed, opts)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Tags are stored as is. Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	sealed, err := d.seal(key, val)
+	if err != nil {
+		return err
+	}
+
+	return fs.TaggerOf(d.driver).SetTagsContext(ctx, key, sealed, ttl, tags)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	return fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	return fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Lock names and owners are not sealed, like keys.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	return fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	d.driver.Close()
+}
+
+func init() {
+	fs.RegisterDecorator("encrypt", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with values encryption.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil || opts.Key == "" {
+		log.Panicf("empty Key")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(opts.Key)
+	if err != nil {
+		log.Panicf("invalid Key (%v)", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		log.Panicf("invalid Key (%v)", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		log.Panicf("invalid Key (%v)", err)
+	}
+
+	return &Driver{driver: fs.WithContext(driver), aead: aead}
+}
@@ -0,0 +1,86 @@
+package encrypt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+var testKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(drivertest.NewFake(), &Options{Key: testKey}) })
+}
+
+func TestDriverEncrypt(t *testing.T) {
+	inner := drivertest.NewFake()
+	d := New(inner, &Options{Key: testKey})
+
+	if err := d.Set("key", "secret", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	stored, _ := inner.Get("key")
+	if stored == "" || strings.Contains(stored, "secret") {
+		t.Errorf("stored value got = %s, want encrypted", stored)
+	}
+
+	if got, err := d.Get("key"); got != "secret" || err != nil {
+		t.Errorf("Get() got = %s, %v, want = %s, %v", got, err, "secret", nil)
+	}
+
+	// value moved to another key is not accepted
+	_ = inner.Set("other", stored, 0)
+
+	// value is not readable by another key
+	other := New(inner, &Options{Key: base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))})
+
+	for _, c := range []struct {
+		name string
+		d    *Driver
+		key  string
+	}{
+		{name: "moved", d: d, key: "other"},
+		{name: "another key", d: other, key: "key"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			var ed *ErrDecrypt
+
+			if _, err := c.d.Get(c.key); !errors.As(err, &ed) {
+				t.Errorf("Get() error got = %v, want = %T", err, ed)
+			}
+		})
+	}
+
+	_ = inner.Set("plain", "not encrypted", 0)
+
+	if _, err := d.Get("plain"); !strings.HasPrefix(err.Error(), "decrypt value of key (plain)") {
+		t.Errorf("Get() error got = %v, want = decrypt error", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{nil, "empty Key"},
+		{&Options{Key: "%"}, "invalid Key (illegal base64 data at input byte 0)"},
+		{&Options{Key: base64.StdEncoding.EncodeToString([]byte("short"))}, "invalid Key (crypto/aes: invalid key size 5)"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
@@ -0,0 +1,481 @@
+// Package faults provides `fs.Driver` decorator injecting latency, errors, timeouts and outages.
+package faults
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	opGet = "get"
+	opSet = "set"
+	opDel = "del"
+
+	defaultHang = 10000
+
+	// DistFixed delays calls by `Latency.Mean`.
+	DistFixed = "fixed"
+	// DistUniform delays calls uniformly between `Latency.Min` and `Latency.Max`.
+	DistUniform = "uniform"
+	// DistNormal delays calls normally around `Latency.Mean` with `Latency.StdDev`.
+	DistNormal = "normal"
+	// DistExponential delays calls exponentially with `Latency.Mean`.
+	DistExponential = "exponential"
+
+	faultOutage  = "outage"
+	faultTimeout = "timeout"
+	faultError   = "error"
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Faults are injected only if `Enabled` is set, so decorator may be configured
+	// and then toggled live (see `Driver.Configure()`).
+	// Call is affected by the first of `Rules` matching it's key and operation.
+	// Timed out call hangs until it's context is done or `Hang` milliseconds pass.
+	// Random source is seeded by `Seed` (by current time if zero).
+	Options struct {
+		Enabled bool          `json:"enabled"`
+		Seed    int64         `json:"seed"`
+		Hang    time.Duration `json:"hang"`
+		Rules   []*Rule       `json:"rules"`
+	}
+	// Rule contains faults of calls with keys starting with `Prefix` (any if empty)
+	// and operations ("get", "set", "del") listed in `Ops` (any if empty).
+	// Deletion by tag or pattern is "del" operation of the tag or pattern as key.
+	// If `Outage` is set then all calls fail immediately,
+	// otherwise call is delayed by `Latency` and then times out with `TimeoutRate` probability
+	// or fails with `ErrorRate` probability.
+	Rule struct {
+		Prefix      string   `json:"prefix"`
+		Ops         []string `json:"ops"`
+		Latency     *Latency `json:"latency"`
+		ErrorRate   float64  `json:"errorRate"`
+		TimeoutRate float64  `json:"timeoutRate"`
+		Outage      bool     `json:"outage"`
+	}
+	// Latency contains delay distribution in milliseconds ("fixed" if `Distribution` is empty).
+	// Sampled delay is bounded by `Min` and `Max` (if set).
+	Latency struct {
+		Distribution string        `json:"distribution"`
+		Mean         time.Duration `json:"mean"`
+		StdDev       time.Duration `json:"stddev"`
+		Min          time.Duration `json:"min"`
+		Max          time.Duration `json:"max"`
+	}
+	// Driver implements Driver interface.
+	// Faults are injected before calls of inner driver, failed calls don't reach it.
+	Driver struct {
+		driver fs.ContextDriver
+		// opts keeps current `*Options`, they are replaced but never changed.
+		opts atomic.Value
+		mu   sync.Mutex
+		rand *rand.Rand
+	}
+	// ErrInjected occurred if call is failed by injected fault.
+	// Injected timeout unwraps to `context.DeadlineExceeded`.
+	ErrInjected struct {
+		fault string
+		op    string
+		key   string
+	}
+	// ErrInvalidOptions occurred if `Options` field has invalid value.
+	ErrInvalidOptions struct {
+		field string
+		value interface{}
+	}
+)
+
+func (e *ErrInjected) Error() string {
+	return fmt.Sprintf("injected %s on %s of key (%s)", e.fault, e.op, e.key)
+}
+
+func (e *ErrInjected) Unwrap() error {
+	if e.fault == faultTimeout {
+		return context.DeadlineExceeded
+	}
+
+	return nil
+}
+
+func (e *ErrInvalidOptions) Error() string {
+	return fmt.Sprintf("invalid %s (%v)", e.field, e.value)
+}
+
+// match checks `r` affects `op` of `key`.
+func (r *Rule) match(op, key string) bool {
+	if !strings.HasPrefix(key, r.Prefix) {
+		return false
+	}
+
+	if len(r.Ops) == 0 {
+		return true
+	}
+
+	for _, o := range r.Ops {
+		if o == op {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sample returns delay from `l` distribution using `rnd`.
+func (l *Latency) sample(rnd *rand.Rand) time.Duration {
+	var ms float64
+
+	switch l.Distribution {
+	case DistUniform:
+		ms = float64(l.Min) + rnd.Float64()*float64(l.Max-l.Min)
+	case DistNormal:
+		ms = float64(l.Mean) + rnd.NormFloat64()*float64(l.StdDev)
+	case DistExponential:
+		ms = rnd.ExpFloat64() * float64(l.Mean)
+	default:
+		ms = float64(l.Mean)
+	}
+
+	if ms < float64(l.Min) {
+		ms = float64(l.Min)
+	}
+
+	if l.Max > 0 && ms > float64(l.Max) {
+		ms = float64(l.Max)
+	}
+
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// validate checks `l` parameters, `field` is the path of `l` in `Options`.
+func (l *Latency) validate(field string) error {
+	switch l.Distribution {
+	case "", DistFixed, DistUniform, DistNormal, DistExponential:
+	default:
+		return &ErrInvalidOptions{field + ".Distribution", l.Distribution}
+	}
+
+	for _, p := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"Mean", l.Mean},
+		{"StdDev", l.StdDev},
+		{"Min", l.Min},
+		{"Max", l.Max},
+	} {
+		if p.value < 0 {
+			return &ErrInvalidOptions{field + "." + p.name, int64(p.value)}
+		}
+	}
+
+	if l.Max > 0 && l.Max < l.Min {
+		return &ErrInvalidOptions{field + ".Max", int64(l.Max)}
+	}
+
+	if l.Distribution == DistUniform && l.Max == 0 {
+		return &ErrInvalidOptions{field + ".Max", int64(l.Max)}
+	}
+
+	return nil
+}
+
+// validate checks `opts` and sets defaults.
+func (opts *Options) validate() error {
+	if opts.Hang == 0 {
+		opts.Hang = defaultHang
+	}
+
+	if opts.Hang < 0 {
+		return &ErrInvalidOptions{"Hang", int64(opts.Hang)}
+	}
+
+	for i, r := range opts.Rules {
+		field := fmt.Sprintf("Rules[%d]", i)
+
+		if r == nil {
+			return &ErrInvalidOptions{field, nil}
+		}
+
+		for _, op := range r.Ops {
+			if op != opGet && op != opSet && op != opDel {
+				return &ErrInvalidOptions{field + ".Ops", op}
+			}
+		}
+
+		if r.ErrorRate < 0 || r.ErrorRate > 1 {
+			return &ErrInvalidOptions{field + ".ErrorRate", r.ErrorRate}
+		}
+
+		if r.TimeoutRate < 0 || r.TimeoutRate > 1 {
+			return &ErrInvalidOptions{field + ".TimeoutRate", r.TimeoutRate}
+		}
+
+		if r.Latency != nil {
+			if err := r.Latency.validate(field + ".Latency"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Options returns current faults configuration.
+func (d *Driver) Options() *Options {
+	return d.opts.Load().(*Options)
+}
+
+// Configure replaces faults configuration by `opts` for the next calls.
+// Returns `ErrInvalidOptions` if `opts` are invalid, current configuration is kept then.
+func (d *Driver) Configure(opts *Options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	if opts.Seed != 0 {
+		d.mu.Lock()
+		d.rand.Seed(opts.Seed)
+		d.mu.Unlock()
+	}
+
+	d.opts.Store(opts)
+
+	return nil
+}
+
+// roll returns `true` with probability `p`.
+func (d *Driver) roll(p float64) bool {
+	if p == 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.rand.Float64() < p
+}
+
+// delay returns sampled delay of `l` (zero if it's not set).
+func (d *Driver) delay(l *Latency) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return l.sample(d.rand)
+}
+
+// inject applies faults of the first rule matching `op` of `key`.
+// Returns error if call must not reach inner driver.
+func (d *Driver) inject(ctx context.Context, op, key string) error {
+	opts := d.Options()
+	if !opts.Enabled {
+		return nil
+	}
+
+	var rule *Rule
+
+	for _, r := range opts.Rules {
+		if r.match(op, key) {
+			rule = r
+			break
+		}
+	}
+
+	if rule == nil {
+		return nil
+	}
+
+	if rule.Outage {
+		return &ErrInjected{faultOutage, op, key}
+	}
+
+	if err := sleep(ctx, d.delay(rule.Latency)); err != nil {
+		return err
+	}
+
+	if d.roll(rule.TimeoutRate) {
+		if err := sleep(ctx, opts.Hang*time.Millisecond); err != nil {
+			return err
+		}
+
+		return &ErrInjected{faultTimeout, op, key}
+	}
+
+	if d.roll(rule.ErrorRate) {
+		return &ErrInjected{faultError, op, key}
+	}
+
+	return nil
+}
+
+// sleep waits `delay` or until `ctx` is done.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := d.inject(ctx, opGet, key); err != nil {
+		return "", err
+	}
+
+	return d.driver.GetContext(ctx, key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := d.inject(ctx, opSet, key); err != nil {
+		return err
+	}
+
+	return d.driver.SetContext(ctx, key, val, ttl)
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := d.inject(ctx, opDel, key); err != nil {
+		return false, err
+	}
+
+	return d.driver.DeleteContext(ctx, key)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if err := d.inject(ctx, opSet, key); err != nil {
+		return false, err
+	}
+
+	return fs.CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if err := d.inject(ctx, opSet, key); err != nil {
+		return err
+	}
+
+	return fs.TaggerOf(d.driver).SetTagsContext(ctx, key, val, ttl, tags)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	if err := d.inject(ctx, opDel, tag); err != nil {
+		return 0, err
+	}
+
+	return fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	if err := d.inject(ctx, opDel, pattern); err != nil {
+		return 0, err
+	}
+
+	return fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	if err := d.inject(ctx, opSet, name); err != nil {
+		return nil, false, err
+	}
+
+	return fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	if err := d.inject(ctx, opSet, name); err != nil {
+		return nil, false, err
+	}
+
+	return fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	if err := d.inject(ctx, opDel, name); err != nil {
+		return false, err
+	}
+
+	return fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	d.driver.Close()
+}
+
+func init() {
+	fs.RegisterDecorator("faults", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with fault injection.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	d := &Driver{driver: fs.WithContext(driver), rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	if err := d.Configure(opts); err != nil {
+		log.Panicf("%v", err)
+	}
+
+	return d
+}
@@ -0,0 +1,166 @@
+package faults
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver {
+		return New(drivertest.NewFake(), &Options{Enabled: true, Rules: []*Rule{{Prefix: "faulty:", Outage: true}}})
+	})
+}
+
+func TestDriverInject(t *testing.T) {
+	fake := drivertest.NewFake()
+	_ = fake.Set("users:1", "alice", 0)
+	_ = fake.Set("orders:1", "book", 0)
+
+	d := New(fake, &Options{
+		Enabled: true,
+		Seed:    1,
+		Hang:    10,
+		Rules: []*Rule{
+			{Prefix: "users:", Ops: []string{opSet, opDel}, Outage: true},
+			{Prefix: "orders:", Ops: []string{opGet}, TimeoutRate: 1},
+			{Prefix: "orders:", ErrorRate: 1},
+		},
+	})
+
+	cases := []struct {
+		name  string
+		call  func() error
+		fault string
+	}{
+		{
+			name: "outage not matched op",
+			call: func() error { _, err := d.Get("users:1"); return err },
+		},
+		{
+			name:  "outage",
+			call:  func() error { return d.Set("users:1", "bob", 0) },
+			fault: faultOutage,
+		},
+		{
+			name:  "timeout",
+			call:  func() error { _, err := d.Get("orders:1"); return err },
+			fault: faultTimeout,
+		},
+		{
+			name:  "error",
+			call:  func() error { _, err := d.Delete("orders:1"); return err },
+			fault: faultError,
+		},
+		{
+			name: "not matched prefix",
+			call: func() error { return d.Set("items:1", "pen", 0) },
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.call()
+
+			var ei *ErrInjected
+			if c.fault == "" && err != nil || c.fault != "" && (!errors.As(err, &ei) || ei.fault != c.fault) {
+				t.Errorf("call() error = %v, want = %v", err, c.fault)
+			}
+		})
+	}
+
+	if val, _ := fake.Get("users:1"); val != "alice" {
+		t.Errorf("Get() got = %v, want = %v (failed call reached inner driver)", val, "alice")
+	}
+
+	if _, err := d.Get("orders:1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want = %v", err, context.DeadlineExceeded)
+	}
+
+	if err := d.Configure(&Options{Rules: d.Options().Rules}); err != nil {
+		t.Fatalf("Configure() error = %v, want = %v", err, nil)
+	}
+
+	if err := d.Set("users:1", "bob", 0); err != nil {
+		t.Errorf("Set() error = %v, want = %v (faults are disabled)", err, nil)
+	}
+}
+
+func TestDriverLatency(t *testing.T) {
+	d := New(drivertest.NewFake(), &Options{
+		Enabled: true,
+		Hang:    1000,
+		Rules:   []*Rule{{Latency: &Latency{Mean: 1000}, TimeoutRate: 1}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	_, err := d.GetContext(ctx, "key")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext() error = %v, want = %v", err, context.DeadlineExceeded)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetContext() elapsed = %v, want to be aborted by context", elapsed)
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	cases := []struct {
+		name     string
+		latency  *Latency
+		min, max time.Duration
+	}{
+		{name: "fixed", latency: &Latency{Mean: 5}, min: 5, max: 5},
+		{name: "uniform", latency: &Latency{Distribution: DistUniform, Min: 2, Max: 4}, min: 2, max: 4},
+		{name: "normal bounded", latency: &Latency{Distribution: DistNormal, Mean: 10, StdDev: 50, Max: 20}, min: 0, max: 20},
+		{name: "exponential bounded", latency: &Latency{Distribution: DistExponential, Mean: 10, Min: 1, Max: 30}, min: 1, max: 30},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				got := c.latency.sample(rnd)
+				if got < c.min*time.Millisecond || got > c.max*time.Millisecond {
+					t.Fatalf("sample() got = %v, want in [%v, %v]", got, c.min*time.Millisecond, c.max*time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{Hang: -1}, "invalid Hang (-1)"},
+		{&Options{Rules: []*Rule{nil}}, "invalid Rules[0] (<nil>)"},
+		{&Options{Rules: []*Rule{{Ops: []string{"put"}}}}, "invalid Rules[0].Ops (put)"},
+		{&Options{Rules: []*Rule{{}, {ErrorRate: 1.5}}}, "invalid Rules[1].ErrorRate (1.5)"},
+		{&Options{Rules: []*Rule{{TimeoutRate: -1}}}, "invalid Rules[0].TimeoutRate (-1)"},
+		{&Options{Rules: []*Rule{{Latency: &Latency{Distribution: "pareto"}}}}, "invalid Rules[0].Latency.Distribution (pareto)"},
+		{&Options{Rules: []*Rule{{Latency: &Latency{Mean: -1}}}}, "invalid Rules[0].Latency.Mean (-1)"},
+		{&Options{Rules: []*Rule{{Latency: &Latency{Min: 5, Max: 1}}}}, "invalid Rules[0].Latency.Max (1)"},
+		{&Options{Rules: []*Rule{{Latency: &Latency{Distribution: DistUniform}}}}, "invalid Rules[0].Latency.Max (0)"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
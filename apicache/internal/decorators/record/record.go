@@ -0,0 +1,306 @@
+// Package record provides `fs.Driver` decorator recording operations for replay.
+package record
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// Operations recorded by `Driver`.
+const (
+	OpGet = "get"
+	OpSet = "set"
+	OpDel = "del"
+	// OpSetCond is conditional set with `Entry.Mode` and `Entry.PTTL` or `Entry.NoExpire`.
+	OpSetCond = "setcond"
+	// OpDelTag is deletion of keys by tag passed as `Entry.Key`.
+	OpDelTag = "deltag"
+	// OpDelPattern is deletion of keys by pattern passed as `Entry.Key`.
+	OpDelPattern = "delpattern"
+)
+
+// maxLine is the maximal length of recorded line read by `Reader`.
+const maxLine = 64 << 20
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Operations are appended to `File` as JSON lines.
+	Options struct {
+		File string `json:"file"`
+	}
+	// Entry is a single operation started at `Time` and finished after `Latency` nanoseconds.
+	// `Val` is the value written by "set" or read by "get", `TTL` is the "time-to-live" of "set",
+	// `Tags` are the tags of "set", `Mode`, `PTTL` ("time-to-live" in milliseconds rounded up) and `NoExpire`
+	// are the options of "setcond", `OK` is the result of "del" and "setcond", `N` is the number
+	// of keys deleted by "deltag" or "delpattern" and `Err` is the error message if operation failed.
+	Entry struct {
+		Time     time.Time     `json:"time"`
+		Op       string        `json:"op"`
+		Key      string        `json:"key"`
+		Val      string        `json:"val,omitempty"`
+		TTL      int           `json:"ttl,omitempty"`
+		Tags     []string      `json:"tags,omitempty"`
+		Mode     string        `json:"mode,omitempty"`
+		PTTL     int64         `json:"pttl,omitempty"`
+		NoExpire bool          `json:"noExpire,omitempty"`
+		OK       bool          `json:"ok,omitempty"`
+		N        int           `json:"n,omitempty"`
+		Err      string        `json:"err,omitempty"`
+		Latency  time.Duration `json:"latency"`
+	}
+	// Driver implements Driver interface.
+	// Every call of inner driver is recorded with it's timing and result,
+	// recording failures are logged and don't affect calls.
+	Driver struct {
+		driver fs.ContextDriver
+		mu     sync.Mutex
+		file   *os.File
+	}
+	// Reader reads entries recorded by `Driver`.
+	Reader struct {
+		scanner *bufio.Scanner
+	}
+)
+
+// errString returns message of `err` or empty string.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// write appends `e` finished now to the file.
+func (d *Driver) write(e *Entry) {
+	e.Latency = time.Since(e.Time)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("record of key (%s) err = %v\n", e.Key, err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return
+	}
+
+	if _, err := d.file.Write(append(line, '\n')); err != nil {
+		log.Printf("record of key (%s) err = %v\n", e.Key, err)
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	e := &Entry{Time: time.Now(), Op: OpGet, Key: key}
+
+	val, err := d.driver.GetContext(ctx, key)
+	e.Val, e.Err = val, errString(err)
+	d.write(e)
+
+	return val, err
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	e := &Entry{Time: time.Now(), Op: OpSet, Key: key, Val: val, TTL: ttl}
+
+	err := d.driver.SetContext(ctx, key, val, ttl)
+	e.Err = errString(err)
+	d.write(e)
+
+	return err
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	e := &Entry{Time: time.Now(), Op: OpDel, Key: key}
+
+	ok, err := d.driver.DeleteContext(ctx, key)
+	e.OK, e.Err = ok, errString(err)
+	d.write(e)
+
+	return ok, err
+}
+
+// pttl returns `ttl` in milliseconds rounded up, so expiry shorter than 1ms (or passed) is kept as 1ms.
+func pttl(ttl time.Duration) int64 {
+	ms := int64((ttl + time.Millisecond - 1) / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+
+	return ms
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	e := &Entry{Time: time.Now(), Op: OpSetCond, Key: key, Val: val, Mode: opts.Mode}
+	if expire := opts.Expire(e.Time); expire.IsZero() {
+		e.NoExpire = true
+	} else {
+		e.PTTL = pttl(expire.Sub(e.Time))
+	}
+
+	ok, err := fs.CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+	e.OK, e.Err = ok, errString(err)
+	d.write(e)
+
+	return ok, err
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	e := &Entry{Time: time.Now(), Op: OpSet, Key: key, Val: val, TTL: ttl, Tags: tags}
+
+	err := fs.TaggerOf(d.driver).SetTagsContext(ctx, key, val, ttl, tags)
+	e.Err = errString(err)
+	d.write(e)
+
+	return err
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	e := &Entry{Time: time.Now(), Op: OpDelTag, Key: tag}
+
+	n, err := fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+	e.N, e.Err = n, errString(err)
+	d.write(e)
+
+	return n, err
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	e := &Entry{Time: time.Now(), Op: OpDelPattern, Key: pattern}
+
+	n, err := fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+	e.N, e.Err = n, errString(err)
+	d.write(e)
+
+	return n, err
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Locks are not recorded, leases cannot be replayed against another storage.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	return fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close closes the file and calls to release key-value storage resources.
+// Calls made after it are not recorded.
+func (d *Driver) Close() {
+	defer d.driver.Close()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.file == nil {
+		return
+	}
+
+	if err := d.file.Close(); err != nil {
+		log.Printf("record close err = %v\n", err)
+	}
+
+	d.file = nil
+}
+
+// Next returns the next entry or `io.EOF` if there are no more entries.
+func (r *Reader) Next() (*Entry, error) {
+	for r.scanner.Scan() {
+		if len(r.scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := &Entry{}
+		if err := json.Unmarshal(r.scanner.Bytes(), e); err != nil {
+			return nil, err
+		}
+
+		return e, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.EOF
+}
+
+// NewReader returns `Reader` of entries from `r`.
+func NewReader(r io.Reader) *Reader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLine)
+
+	return &Reader{scanner: scanner}
+}
+
+func init() {
+	fs.RegisterDecorator("record", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with recording to `Options.File`.
+// Panics if `opts` are invalid or the file cannot be opened.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil || opts.File == "" {
+		log.Panicf("empty File")
+	}
+
+	file, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Panicf("open File error (%v)", err)
+	}
+
+	return &Driver{driver: fs.WithContext(driver), file: file}
+}
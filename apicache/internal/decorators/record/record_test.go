@@ -0,0 +1,138 @@
+package record
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatalf("temp dir error = %v", err)
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestDriverConformance(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	drivertest.Run(t, func() fs.Driver {
+		return New(drivertest.NewFake(), &Options{File: filepath.Join(dir, "record.jsonl")})
+	})
+}
+
+func TestDriverRecord(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	file := filepath.Join(dir, "record.jsonl")
+	inner := faults.New(memory.New(nil), &faults.Options{
+		Enabled: true,
+		Rules:   []*faults.Rule{{Prefix: "fail:", ErrorRate: 1}},
+	})
+	d := New(inner, &Options{File: file})
+
+	_ = d.Set("key", "val", 10)
+	_, _ = d.Get("key")
+	_, _ = d.Delete("key")
+	_, _ = d.Delete("key")
+	_, _ = d.Get("fail:key")
+	_ = d.SetTagsContext(context.Background(), "tagged", "val", 10, []string{"tag"})
+	_, _ = d.DeleteTagContext(context.Background(), "tag")
+	_, _ = d.DeletePatternContext(context.Background(), "*")
+	_, _ = d.SetCondContext(context.Background(), "cond", "val", &fs.SetOptions{Mode: fs.ModeNX, TTL: 1500 * time.Millisecond})
+	_, _ = d.SetCondContext(context.Background(), "cond:never", "val", &fs.SetOptions{NoExpire: true})
+	_, _ = d.SetCondContext(context.Background(), "cond:short", "val", &fs.SetOptions{ExpireAt: time.Now().Add(500 * time.Microsecond)})
+	d.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	want := []Entry{
+		{Op: OpSet, Key: "key", Val: "val", TTL: 10},
+		{Op: OpGet, Key: "key", Val: "val"},
+		{Op: OpDel, Key: "key", OK: true},
+		{Op: OpDel, Key: "key"},
+		{Op: OpGet, Key: "fail:key", Err: "injected error on get of key (fail:key)"},
+		{Op: OpSet, Key: "tagged", Val: "val", TTL: 10, Tags: []string{"tag"}},
+		{Op: OpDelTag, Key: "tag", N: 1},
+		{Op: OpDelPattern, Key: "*"},
+		{Op: OpSetCond, Key: "cond", Val: "val", Mode: fs.ModeNX, PTTL: 1500, OK: true},
+		{Op: OpSetCond, Key: "cond:never", Val: "val", NoExpire: true, OK: true},
+		{Op: OpSetCond, Key: "cond:short", Val: "val", PTTL: 1, OK: true},
+	}
+
+	r := NewReader(f)
+
+	for i, w := range want {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v, want = %v", err, nil)
+		}
+
+		if got.Time.IsZero() || got.Latency <= 0 {
+			t.Errorf("Next() entry %d has no timing = %+v", i, got)
+		}
+
+		got.Time, got.Latency = w.Time, w.Latency
+
+		if !reflect.DeepEqual(*got, w) {
+			t.Errorf("Next() got = %+v, want = %+v", *got, w)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want = %v", err, io.EOF)
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	r := NewReader(strings.NewReader("\n{\"op\":\"get\",\"key\":\"key\"}\nnot json\n"))
+
+	if e, err := r.Next(); err != nil || e.Key != "key" {
+		t.Errorf("Next() got = %v, %v, want = %v, %v", e, err, "key", nil)
+	}
+
+	if _, err := r.Next(); err == nil {
+		t.Errorf("Next() error = %v, want decode error", err)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{nil, "empty File"},
+		{&Options{}, "empty File"},
+		{&Options{File: filepath.Join("not-exist", "record.jsonl")}, "open File error (open not-exist/record.jsonl: no such file or directory)"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
@@ -0,0 +1,267 @@
+// Package retry provides `fs.Driver` decorator retrying failed storage calls.
+package retry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultAttempts = 3
+	defaultBackoff  = 10
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Failed call is made up to `Attempts` times,
+	// waiting `Backoff` milliseconds before the second one and doubling it up to `MaxBackoff` then.
+	Options struct {
+		Attempts   int           `json:"attempts"`
+		Backoff    time.Duration `json:"backoff"`
+		MaxBackoff time.Duration `json:"maxBackoff"`
+	}
+	// Driver implements Driver interface.
+	// Storage failures of inner driver are retried,
+	// typed rejections (like `fs.ErrInsufficientStorage` or `fs.ErrNotSupported`) and context errors are returned immediately.
+	Driver struct {
+		driver fs.ContextDriver
+		opts   *Options
+	}
+)
+
+// retryable checks call failed with `err` may succeed next time.
+func retryable(err error) bool {
+	var (
+		eis *fs.ErrInsufficientStorage
+		eik *fs.ErrInvalidKey
+		ens *fs.ErrNotSupported
+	)
+
+	switch {
+	case err == nil, errors.As(err, &eis), errors.As(err, &eik), errors.As(err, &ens):
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	default:
+		return true
+	}
+}
+
+// do calls `fn` until it succeeds, fails with not retryable error, attempts are over or `ctx` is done.
+func (d *Driver) do(ctx context.Context, fn func() error) error {
+	backoff := d.opts.Backoff * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !retryable(err) || attempt >= d.opts.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if limit := d.opts.MaxBackoff * time.Millisecond; limit > 0 && backoff > limit {
+			backoff = limit
+		}
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	var val string
+
+	err := d.do(ctx, func() (err error) {
+		val, err = d.driver.GetContext(ctx, key)
+		return err
+	})
+
+	return val, err
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	return d.do(ctx, func() error {
+		return d.driver.SetContext(ctx, key, val, ttl)
+	})
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+// Retried call may report missing key if the failed one has deleted it.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+
+	err := d.do(ctx, func() (err error) {
+		ok, err = d.driver.DeleteContext(ctx, key)
+		return err
+	})
+
+	return ok, err
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+// Retried call may report unmet condition if the failed one has set the key.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	var ok bool
+
+	err := d.do(ctx, func() (err error) {
+		ok, err = fs.CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+		return err
+	})
+
+	return ok, err
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	return d.do(ctx, func() error {
+		return fs.TaggerOf(d.driver).SetTagsContext(ctx, key, val, ttl, tags)
+	})
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Retried call counts only keys which are not deleted by the failed one.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	var n int
+
+	err := d.do(ctx, func() (err error) {
+		n, err = fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+		return err
+	})
+
+	return n, err
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Retried call counts only keys which are not deleted by the failed one.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	var n int
+
+	err := d.do(ctx, func() (err error) {
+		n, err = fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+		return err
+	})
+
+	return n, err
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Retried call extends the lock if the failed one has granted it.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	var (
+		lease *fs.Lease
+		ok    bool
+	)
+
+	err := d.do(ctx, func() (err error) {
+		lease, ok, err = fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+		return err
+	})
+
+	return lease, ok, err
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	var (
+		lease *fs.Lease
+		ok    bool
+	)
+
+	err := d.do(ctx, func() (err error) {
+		lease, ok, err = fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+		return err
+	})
+
+	return lease, ok, err
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Retried call may report not held lock if the failed one has released it.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	var ok bool
+
+	err := d.do(ctx, func() (err error) {
+		ok, err = fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+		return err
+	})
+
+	return ok, err
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	d.driver.Close()
+}
+
+func init() {
+	fs.RegisterDecorator("retry", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with retries.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.Attempts == 0 {
+		opts.Attempts = defaultAttempts
+	}
+
+	if opts.Attempts < 0 {
+		log.Panicf("negative Attempts")
+	}
+
+	if opts.Backoff == 0 {
+		opts.Backoff = defaultBackoff
+	}
+
+	if opts.Backoff < 0 {
+		log.Panicf("negative Backoff")
+	}
+
+	if opts.MaxBackoff < 0 {
+		log.Panicf("negative MaxBackoff")
+	}
+
+	return &Driver{driver: fs.WithContext(driver), opts: opts}
+}
@@ -0,0 +1,117 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+var errStorage = errors.New("storage error")
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(drivertest.NewFake(), nil) })
+}
+
+func TestDriverRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures int
+		err      error
+		calls    int
+		want     error
+	}{
+		{name: "recovered", failures: 2, err: errStorage, calls: 3, want: nil},
+		{name: "attempts are over", failures: 5, err: errStorage, calls: 3, want: errStorage},
+		{name: "rejected", failures: 5, err: fs.NewErrInsufficientStorage("key"), calls: 1, want: fs.NewErrInsufficientStorage("key")},
+		{name: "invalid key", failures: 5, err: fs.NewErrInvalidKey("key", "reason"), calls: 1, want: fs.NewErrInvalidKey("key", "reason")},
+		{name: "not supported", failures: 5, err: fs.NewErrNotSupported("tag"), calls: 1, want: fs.NewErrNotSupported("tag")},
+		{name: "deadline", failures: 5, err: context.DeadlineExceeded, calls: 1, want: context.DeadlineExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			f := drivertest.NewFlaky()
+			f.Fail(c.failures, c.err)
+
+			d := New(f, &Options{Attempts: 3, Backoff: 1})
+
+			err := d.Set("key", "val", 0)
+			if (err == nil) != (c.want == nil) || (err != nil && err.Error() != c.want.Error()) {
+				t.Errorf("Set() error got = %v, want = %v", err, c.want)
+			}
+
+			if f.Calls() != c.calls {
+				t.Errorf("Set() calls got = %d, want = %d", f.Calls(), c.calls)
+			}
+		})
+	}
+}
+
+func TestDriverRetryCanceled(t *testing.T) {
+	f := drivertest.NewFlaky()
+	f.Fail(5, errStorage)
+
+	d := New(f, &Options{Attempts: 5, Backoff: 1000})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, err := d.GetContext(ctx, "key"); err != errStorage {
+		t.Errorf("GetContext() error got = %v, want = %v", err, errStorage)
+	}
+
+	if f.Calls() != 1 || time.Since(start) > time.Second {
+		t.Errorf("GetContext() calls got = %d in %v, want = 1 without backoff", f.Calls(), time.Since(start))
+	}
+}
+
+func TestDriverBackoff(t *testing.T) {
+	f := drivertest.NewFlaky()
+	f.Fail(3, errStorage)
+
+	d := New(f, &Options{Attempts: 4, Backoff: 20, MaxBackoff: 30})
+
+	start := time.Now()
+
+	if _, err := d.Delete("key"); err != nil {
+		t.Errorf("Delete() error got = %v, want = %v", err, nil)
+	}
+
+	// 20 + 30 + 30 milliseconds
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Delete() elapsed got = %v, want >= %v", elapsed, 80*time.Millisecond)
+	}
+}
+
+func TestNew(t *testing.T) {
+	d := New(drivertest.NewFake(), nil)
+
+	if d.opts.Attempts != defaultAttempts || d.opts.Backoff != defaultBackoff {
+		t.Errorf("New() opts got = %v, want defaults", d.opts)
+	}
+
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{Attempts: -1}, "negative Attempts"},
+		{&Options{Backoff: -1}, "negative Backoff"},
+		{&Options{MaxBackoff: -1}, "negative MaxBackoff"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
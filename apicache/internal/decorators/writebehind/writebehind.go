@@ -0,0 +1,502 @@
+// Package writebehind provides `fs.Driver` decorator acknowledging writes before they are stored.
+package writebehind
+
+import (
+	"context"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultBatchSize  = 100
+	defaultInterval   = 100
+	defaultMaxPending = 10000
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Buffered writes are flushed every `Interval` milliseconds or as soon as `BatchSize` keys are buffered,
+	// up to `BatchSize` keys at once. Writes are rejected if `MaxPending` keys cannot be flushed.
+	Options struct {
+		BatchSize  int           `json:"batchSize"`
+		Interval   time.Duration `json:"interval"`
+		MaxPending int           `json:"maxPending"`
+	}
+	// Driver implements Driver interface.
+	// Sets are acknowledged immediately and buffered (the last write of a key wins),
+	// Gets read the buffer first, Deletes and tagged Sets are made immediately.
+	// Failed writes are returned to the buffer unless the key is written again.
+	Driver struct {
+		driver   fs.ContextDriver
+		opts     *Options
+		mu       sync.Mutex
+		buffer   map[string]*entry
+		flushing map[string]*entry
+		// busy keys are being written to inner driver (flushed, deleted or set unbuffered), writes of the same key
+		// wait for each other, so flushed value never overrides deletion, and writes of other keys don't wait.
+		busy    map[string]chan struct{}
+		kick    chan struct{}
+		done    chan struct{}
+		closing sync.Once
+		wg      sync.WaitGroup
+	}
+	// entry is buffered write.
+	entry struct {
+		val    string
+		ttl    int
+		expire time.Time
+	}
+)
+
+// live checks `e` is not deleted or expired at `now`.
+func (e *entry) live(now time.Time) bool {
+	return e.ttl >= 0 && (e.expire.IsZero() || now.Before(e.expire))
+}
+
+// remaining returns "time-to-live" of `e` left at `now` (negative if it is gone).
+func (e *entry) remaining(now time.Time) int {
+	switch {
+	case e.ttl <= 0:
+		return e.ttl
+	case !e.live(now):
+		return -1
+	default:
+		return int(math.Ceil(e.expire.Sub(now).Seconds()))
+	}
+}
+
+// lookup returns buffered (or being flushed) write of `key`.
+func (d *Driver) lookup(key string) (*entry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.buffer[key]; ok {
+		return e, true
+	}
+
+	e, ok := d.flushing[key]
+
+	return e, ok
+}
+
+// full checks `key` cannot be added to the buffer,
+// keys being flushed are counted too since failed ones are returned.
+// It must be called under `mu`.
+func (d *Driver) full(key string) bool {
+	if _, ok := d.buffer[key]; ok {
+		return false
+	}
+
+	n := len(d.buffer)
+
+	for k := range d.flushing {
+		if _, ok := d.buffer[k]; !ok && k != key {
+			n++
+		}
+	}
+
+	return n >= d.opts.MaxPending
+}
+
+// pending returns the number of buffered keys.
+func (d *Driver) pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.buffer)
+}
+
+// claim waits until `key` is not busy (or `ctx` is done), makes it busy and takes it's buffered write.
+// Claimed `key` must be released by `release()`.
+func (d *Driver) claim(ctx context.Context, key string) (*entry, bool, error) {
+	d.mu.Lock()
+
+	for {
+		wait, ok := d.busy[key]
+		if !ok {
+			break
+		}
+
+		d.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+
+		d.mu.Lock()
+	}
+
+	defer d.mu.Unlock()
+
+	d.busy[key] = make(chan struct{})
+
+	e, ok := d.buffer[key]
+	delete(d.buffer, key)
+
+	return e, ok, nil
+}
+
+// release makes `key` not busy and returns failed write `e` (if it is not nil) to the buffer
+// unless the key is written again.
+func (d *Driver) release(key string, e *entry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.buffer[key]; e != nil && !ok {
+		d.buffer[key] = e
+	}
+
+	delete(d.flushing, key)
+	close(d.busy[key])
+	delete(d.busy, key)
+}
+
+// flush writes up to `BatchSize` buffered keys (busy ones are skipped) to inner driver
+// and returns the number of written ones. Every key is released as soon as it is written.
+func (d *Driver) flush() int {
+	d.mu.Lock()
+
+	batch := make(map[string]*entry, d.opts.BatchSize)
+
+	for key, e := range d.buffer {
+		if len(batch) == d.opts.BatchSize {
+			break
+		}
+
+		if _, ok := d.busy[key]; ok {
+			continue
+		}
+
+		batch[key] = e
+		d.flushing[key] = e
+		d.busy[key] = make(chan struct{})
+		delete(d.buffer, key)
+	}
+
+	d.mu.Unlock()
+
+	written := 0
+	now := time.Now()
+
+	for key, e := range batch {
+		if err := d.driver.SetContext(context.Background(), key, e.val, e.remaining(now)); err != nil {
+			log.Printf("write-behind flush of key (%s) err = %v\n", key, err)
+
+			d.release(key, e)
+
+			continue
+		}
+
+		d.release(key, nil)
+		written++
+	}
+
+	return written
+}
+
+// flushAll flushes buffer while it makes progress.
+func (d *Driver) flushAll() {
+	for d.pending() > 0 {
+		if d.flush() == 0 {
+			return
+		}
+	}
+}
+
+// flusher flushes buffer every `Interval` or when it is kicked until `done`.
+func (d *Driver) flusher() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.opts.Interval * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case <-d.kick:
+		}
+
+		d.flushAll()
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from the buffer or from key-value storage until `ctx` is done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if e, ok := d.lookup(key); ok {
+		if !e.live(time.Now()) {
+			return "", nil
+		}
+
+		return e.val, nil
+	}
+
+	return d.driver.GetContext(ctx, key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext buffers key, value and "time-to-live" to be written to key-value storage.
+// Returns `fs.ErrInsufficientStorage` if the buffer is full and it cannot be flushed.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	e := &entry{val: val, ttl: ttl}
+	if ttl > 0 {
+		e.expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.full(key) {
+		d.mu.Unlock()
+		d.flush()
+		d.mu.Lock()
+
+		if d.full(key) {
+			return fs.NewErrInsufficientStorage(key)
+		}
+	}
+
+	d.buffer[key] = e
+
+	if len(d.buffer) >= d.opts.BatchSize {
+		select {
+		case d.kick <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from the buffer and from key-value storage until `ctx` is done.
+// It waits for the flush of `key` in progress.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	e, buffered, err := d.claim(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	ok, err := d.driver.DeleteContext(ctx, key)
+	if err != nil {
+		d.release(key, e)
+		return false, err
+	}
+
+	d.release(key, nil)
+
+	return ok || (buffered && e.live(time.Now())), nil
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+// Conditional write is not buffered: buffered write of `key` is flushed before it, so condition sees it.
+// It waits for the flush of `key` in progress.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	e, buffered, err := d.claim(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	if buffered {
+		if err := d.driver.SetContext(ctx, key, e.val, e.remaining(time.Now())); err != nil {
+			d.release(key, e)
+			return false, err
+		}
+	}
+
+	defer d.release(key, nil)
+
+	return fs.CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Tagged write is not buffered, it overrides buffered write of `key` and waits for the flush of `key` in progress.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	e, _, err := d.claim(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	err = fs.TaggerOf(d.driver).SetTagsContext(ctx, key, val, ttl, tags)
+	if err != nil {
+		d.release(key, e)
+		return err
+	}
+
+	d.release(key, nil)
+
+	return nil
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Buffered writes are not tagged, so they are kept, and flushed ones drop tags of keys, so they are not waited for.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	return fs.TaggerOf(d.driver).DeleteTagContext(ctx, tag)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from the buffer and from key-value storage
+// until `ctx` is done. Buffered keys and keys being flushed are deleted one by one, so they are counted once
+// and flushed value doesn't override deletion. Returns `fs.ErrNotSupported` if inner driver is not `fs.Matcher`.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	matcher, ok := d.driver.(fs.Matcher)
+	if !ok {
+		return fs.MatcherOf(d.driver).DeletePatternContext(ctx, pattern)
+	}
+
+	d.mu.Lock()
+
+	var keys []string
+
+	for key := range d.buffer {
+		if fs.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	for key := range d.flushing {
+		if _, ok := d.buffer[key]; !ok && fs.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	d.mu.Unlock()
+
+	n := 0
+
+	for _, key := range keys {
+		ok, err := d.DeleteContext(ctx, key)
+		if err != nil {
+			return n, err
+		}
+
+		if ok {
+			n++
+		}
+	}
+
+	deleted, err := matcher.DeletePatternContext(ctx, pattern)
+
+	return n + deleted, err
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Leases are not buffered, they are granted by inner driver immediately.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).AcquireContext(ctx, name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return fs.LockerOf(d.driver).RenewContext(ctx, name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Locker`.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	return fs.LockerOf(d.driver).ReleaseContext(ctx, name, owner)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (d *Driver) Unwrap() fs.Driver {
+	return d.driver
+}
+
+// Close flushes the buffer and calls to release key-value storage resources.
+// Keys that cannot be flushed are lost.
+func (d *Driver) Close() {
+	d.closing.Do(func() {
+		close(d.done)
+		d.wg.Wait()
+
+		d.flushAll()
+
+		if n := d.pending(); n > 0 {
+			log.Printf("write-behind close: %d keys are not flushed\n", n)
+		}
+
+		d.driver.Close()
+	})
+}
+
+func init() {
+	fs.RegisterDecorator("writebehind", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with write-behind buffer.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Driver {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	for _, p := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"BatchSize", &opts.BatchSize, defaultBatchSize},
+		{"MaxPending", &opts.MaxPending, defaultMaxPending},
+	} {
+		if *p.value == 0 {
+			*p.value = p.def
+		}
+
+		if *p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+
+	if opts.Interval == 0 {
+		opts.Interval = defaultInterval
+	}
+
+	if opts.Interval < 0 {
+		log.Panicf("negative Interval")
+	}
+
+	d := &Driver{
+		driver:   fs.WithContext(driver),
+		opts:     opts,
+		buffer:   make(map[string]*entry),
+		flushing: make(map[string]*entry),
+		busy:     make(map[string]chan struct{}),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	d.wg.Add(1)
+
+	go d.flusher()
+
+	return d
+}
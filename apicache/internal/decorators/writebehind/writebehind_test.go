@@ -0,0 +1,398 @@
+package writebehind
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+var errStorage = errors.New("storage error")
+
+// writes returns the number of writes of `key` to `inner`.
+func writes(inner *drivertest.Flaky, key string) int {
+	n, _ := inner.Writes(key)
+	return n
+}
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(drivertest.NewFake(), &Options{Interval: 10}) })
+}
+
+func TestDriverCoalesce(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	d := New(inner, &Options{Interval: 50})
+
+	defer d.Close()
+
+	for i := 0; i < 10; i++ {
+		_ = d.Set("key", strconv.Itoa(i), 0)
+	}
+
+	// read through the buffer before flush
+	if val, _ := d.Get("key"); val != "9" {
+		t.Errorf("Get() got = %s, want = %s", val, "9")
+	}
+
+	if val, _ := inner.Get("key"); val != "" {
+		t.Errorf("inner Get() before flush got = %s, want = %s", val, "")
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if val, _ := inner.Get("key"); val != "9" || writes(inner, "key") != 1 {
+		t.Errorf("inner Get() got = %s (%d writes), want = %s (1 write)", val, writes(inner, "key"), "9")
+	}
+}
+
+func TestDriverBatchSize(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	d := New(inner, &Options{BatchSize: 5, Interval: 60 * 1000})
+
+	defer d.Close()
+
+	for i := 0; i < 4; i++ {
+		_ = d.Set(strconv.Itoa(i), "val", 0)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if d.pending() != 4 {
+		t.Errorf("pending() got = %d, want = %d", d.pending(), 4)
+	}
+
+	_ = d.Set("4", "val", 0)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if d.pending() != 0 {
+		t.Errorf("pending() after batch got = %d, want = %d", d.pending(), 0)
+	}
+
+	for i := 0; i < 5; i++ {
+		if n := writes(inner, strconv.Itoa(i)); n != 1 {
+			t.Errorf("key (%d) writes got = %d, want = %d", i, n, 1)
+		}
+	}
+}
+
+func TestDriverTTL(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	d := New(inner, &Options{Interval: 60 * 1000})
+
+	_ = d.Set("expire", "val", 10)
+	_ = d.Set("delete", "val", -1)
+	_ = d.Set("expired", "val", 1)
+
+	if val, _ := d.Get("delete"); val != "" {
+		t.Errorf("Get() of deleted got = %s, want = %s", val, "")
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if val, _ := d.Get("expired"); val != "" {
+		t.Errorf("Get() of expired got = %s, want = %s", val, "")
+	}
+
+	d.Close()
+
+	// "time-to-live" is counted from acknowledgement
+	for key, want := range map[string]int{"expire": 9, "delete": -1, "expired": -1} {
+		if _, got := inner.Writes(key); got != want {
+			t.Errorf("key (%s) flushed ttl got = %d, want = %d", key, got, want)
+		}
+	}
+}
+
+func TestDriverDelete(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	d := New(inner, &Options{Interval: 60 * 1000})
+
+	defer d.Close()
+
+	_ = d.Set("buffered", "val", 0)
+
+	if ok, err := d.Delete("buffered"); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if ok, _ := d.Delete("buffered"); ok {
+		t.Errorf("Delete() of deleted got = %v, want = %v", ok, false)
+	}
+
+	d.flushAll()
+
+	if writes(inner, "buffered") != 0 {
+		t.Errorf("deleted key is flushed")
+	}
+}
+
+func TestDriverTags(t *testing.T) {
+	inner := memory.New(nil)
+	d := New(inner, &Options{Interval: 60 * 1000})
+
+	defer d.Close()
+
+	ctx := context.Background()
+
+	// tagged write overrides the buffered one and is not flushed over
+	_ = d.Set("tagged", "old", 0)
+
+	if err := d.SetTagsContext(ctx, "tagged", "new", 0, []string{"tag"}); err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	d.flushAll()
+
+	if val, _ := inner.Get("tagged"); val != "new" {
+		t.Errorf("Get() of tagged got = %s, want = %s", val, "new")
+	}
+
+	_ = d.Set("key:buffered", "val", 0)
+	_ = inner.Set("key:stored", "val", 0)
+
+	if n, err := d.DeletePatternContext(ctx, "key:*"); n != 2 || err != nil {
+		t.Errorf("DeletePatternContext() got = %v, %v, want = %v, %v", n, err, 2, nil)
+	}
+
+	d.flushAll()
+
+	if val, _ := d.Get("key:buffered"); val != "" {
+		t.Errorf("Get() of deleted by pattern got = %s, want = %s", val, "")
+	}
+
+	if n, err := d.DeleteTagContext(ctx, "tag"); n != 1 || err != nil {
+		t.Errorf("DeleteTagContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+}
+
+func TestDriverSetCond(t *testing.T) {
+	d := New(memory.New(nil), &Options{Interval: 60 * 1000})
+
+	defer d.Close()
+
+	ctx := context.Background()
+
+	// buffered write is flushed before condition is checked
+	_ = d.Set("key", "buffered", 0)
+
+	if ok, err := d.SetCondContext(ctx, "key", "new", &fs.SetOptions{Mode: fs.ModeNX, NoExpire: true}); ok || err != nil {
+		t.Errorf("SetCondContext() got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	if ok, err := d.SetCondContext(ctx, "key", "new", &fs.SetOptions{Mode: fs.ModeXX, NoExpire: true}); !ok || err != nil {
+		t.Errorf("SetCondContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	d.flushAll()
+
+	if val, _ := d.Get("key"); val != "new" {
+		t.Errorf("Get() got = %s, want = %s", val, "new")
+	}
+}
+
+func TestDriverFlushFailure(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	d := New(inner, &Options{Interval: 20, MaxPending: 2})
+
+	inner.Fail(-1, errStorage)
+
+	_ = d.Set("a", "val", 0)
+	_ = d.Set("b", "val", 0)
+
+	time.Sleep(100 * time.Millisecond)
+
+	// failed writes are kept
+	if val, _ := d.Get("a"); val != "val" {
+		t.Errorf("Get() got = %s, want = %s", val, "val")
+	}
+
+	var eis *fs.ErrInsufficientStorage
+
+	if err := d.Set("c", "val", 0); !errors.As(err, &eis) {
+		t.Errorf("Set() to full buffer error = %v, want = %T", err, eis)
+	}
+
+	// the buffered key can be overwritten
+	if err := d.Set("a", "new", 0); err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	inner.Fail(0, nil)
+	d.Close()
+
+	if val, _ := inner.Get("a"); val != "new" || !inner.Closed() {
+		t.Errorf("Close() flushed = %s (closed %v), want = %s (closed true)", val, inner.Closed(), "new")
+	}
+}
+
+func TestDriverFullWhileFlushing(t *testing.T) {
+	inner := drivertest.NewFlaky()
+	gate := make(chan struct{})
+
+	inner.Hold(gate)
+	inner.Fail(-1, errStorage)
+
+	d := New(inner, &Options{BatchSize: 2, MaxPending: 2, Interval: 60 * 1000})
+
+	_ = d.Set("a", "val", 0)
+	_ = d.Set("b", "val", 0)
+
+	// the batch is taken from the buffer and the flush is stuck in the inner driver
+	for d.pending() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// keys being flushed are busy, so they are counted and the flush is not waited for
+	var eis *fs.ErrInsufficientStorage
+
+	if err := d.Set("c", "val", 0); !errors.As(err, &eis) {
+		t.Errorf("Set() while flushing error = %v, want = %T", err, eis)
+	}
+
+	close(gate)
+
+	// failed writes are returned to the buffer
+	for deadline := time.Now().Add(time.Second); d.pending() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	if val, _ := d.Get("c"); val != "" || d.pending() != 2 {
+		t.Errorf("Get() got = %s (%d pending), want = %s (%d pending)", val, d.pending(), "", 2)
+	}
+
+	inner.Fail(0, nil)
+	d.Close()
+}
+
+// heldSets is `memory.Driver` with sets of `key` held until token is sent to `release`.
+type heldSets struct {
+	*memory.Driver
+	key     string
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *heldSets) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if key == h.key {
+		h.started <- struct{}{}
+		<-h.release
+	}
+
+	return h.Driver.SetContext(ctx, key, val, ttl)
+}
+
+func TestDriverDeleteWhileFlushing(t *testing.T) {
+	inner := &heldSets{Driver: memory.New(nil), key: "slow", started: make(chan struct{}), release: make(chan struct{})}
+	d := New(inner, &Options{Interval: 60 * 1000})
+
+	defer d.Close()
+
+	ctx := context.Background()
+
+	// flushes the only buffered key until it is released
+	flush := func(val string) {
+		_ = d.Set("slow", val, 0)
+
+		go d.flush()
+
+		<-inner.started
+	}
+
+	flush("old")
+
+	// deletion of another key doesn't wait for the flush
+	_ = d.Set("other", "val", 0)
+
+	if ok, err := d.DeleteContext(ctx, "other"); !ok || err != nil {
+		t.Errorf("DeleteContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	// deletion of the key being flushed waits for it's flush
+	deleted := make(chan bool, 1)
+
+	go func() {
+		ok, _ := d.DeleteContext(ctx, "slow")
+		deleted <- ok
+	}()
+
+	select {
+	case <-deleted:
+		t.Fatalf("DeleteContext() of key being flushed doesn't wait for the flush")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	inner.release <- struct{}{}
+
+	if ok := <-deleted; !ok {
+		t.Errorf("DeleteContext() got = %v, want = %v", ok, true)
+	}
+
+	// deletion by pattern waits for the flush of matching key too
+	flush("new")
+
+	matched := make(chan int, 1)
+
+	go func() {
+		n, _ := d.DeletePatternContext(ctx, "sl*")
+		matched <- n
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	inner.release <- struct{}{}
+
+	if n := <-matched; n != 1 {
+		t.Errorf("DeletePatternContext() got = %v, want = %v", n, 1)
+	}
+
+	if val, _ := inner.Get("slow"); val != "" {
+		t.Errorf("inner Get() of deleted got = %s, want = %s", val, "")
+	}
+
+	// canceled wait for the flush
+	flush("last")
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := d.DeleteContext(canceled, "slow"); err != context.Canceled {
+		t.Errorf("DeleteContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	inner.release <- struct{}{}
+}
+
+func TestNew(t *testing.T) {
+	d := New(drivertest.NewFake(), nil)
+	defer d.Close()
+
+	if d.opts.BatchSize != defaultBatchSize || d.opts.Interval != defaultInterval || d.opts.MaxPending != defaultMaxPending {
+		t.Errorf("New() opts got = %v, want defaults", d.opts)
+	}
+
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{BatchSize: -1}, "negative BatchSize"},
+		{&Options{MaxPending: -1}, "negative MaxPending"},
+		{&Options{Interval: -1}, "negative Interval"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
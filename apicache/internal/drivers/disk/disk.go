@@ -0,0 +1,485 @@
+package disk
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultSegmentSize = 64 << 20
+	defaultCompact     = 60
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Records are appended to `Dir` segments up to `SegmentSize` bytes,
+	// segments are compacted every `Compact` seconds when at least a half of data is dead.
+	// `Sync` flushes every write to disk.
+	Options struct {
+		Dir         string        `json:"dir"`
+		SegmentSize int64         `json:"segmentSize"`
+		Compact     time.Duration `json:"compact"`
+		Sync        bool          `json:"sync"`
+	}
+	// Stats contains `Driver` storage statistics.
+	Stats struct {
+		Keys     int64 `json:"keys"`
+		Segments int64 `json:"segments"`
+		Bytes    int64 `json:"bytes"`
+		Dead     int64 `json:"dead"`
+	}
+	// location points to the latest record of key.
+	location struct {
+		segment int64
+		offset  int64
+		size    int64
+		expire  int64
+	}
+	// Driver implements Driver interface.
+	Driver struct {
+		mu       sync.Mutex
+		opts     *Options
+		index    map[string]*location
+		segments map[int64]*segment
+		active   *segment
+		dead     int64
+		done     chan struct{}
+		wg       sync.WaitGroup
+	}
+)
+
+// expired checks `l` is expired at `now`.
+func (l *location) expired(now int64) bool {
+	return l.expire != 0 && l.expire <= now
+}
+
+// bytes returns total size of all segments.
+func (d *Driver) bytes() int64 {
+	var total int64
+
+	for _, s := range d.segments {
+		total += s.size
+	}
+
+	return total
+}
+
+// roll makes a new empty active segment.
+func (d *Driver) roll() error {
+	if err := d.active.file.Sync(); err != nil {
+		return err
+	}
+
+	s, err := openSegment(d.opts.Dir, d.active.id+1)
+	if err != nil {
+		return err
+	}
+
+	d.segments[s.id] = s
+	d.active = s
+
+	return nil
+}
+
+// write appends `r` to active segment and returns it's location.
+func (d *Driver) write(r *record) (*location, error) {
+	if d.active.size > 0 && d.active.size+r.size() > d.opts.SegmentSize {
+		if err := d.roll(); err != nil {
+			return nil, err
+		}
+	}
+
+	off, err := d.active.append(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.opts.Sync {
+		if err := d.active.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &location{segment: d.active.id, offset: off, size: r.size(), expire: r.expire}, nil
+}
+
+// drop removes `key` from index and counts it's record as dead.
+func (d *Driver) drop(key string) {
+	if l, ok := d.index[key]; ok {
+		delete(d.index, key)
+		d.dead += l.size
+	}
+}
+
+// apply applies `r` found at `l` while recovering.
+func (d *Driver) apply(r *record, l *location, now int64) {
+	d.drop(r.key)
+
+	if r.tombstone || l.expired(now) {
+		d.dead += l.size
+		return
+	}
+
+	d.index[r.key] = l
+}
+
+// recover rebuilds index by scanning all segments in `Dir`.
+func (d *Driver) recover() error {
+	ids, err := segmentIDs(d.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+
+	for i, id := range ids {
+		s, err := openSegment(d.opts.Dir, id)
+		if err != nil {
+			return err
+		}
+
+		d.segments[id] = s
+		d.active = s
+
+		err = s.scan(i == len(ids)-1, func(r *record, off, size int64) {
+			d.apply(r, &location{segment: id, offset: off, size: size, expire: r.expire}, now)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.active != nil {
+		return nil
+	}
+
+	d.active, err = openSegment(d.opts.Dir, 1)
+	if err != nil {
+		return err
+	}
+
+	d.segments[d.active.id] = d.active
+
+	return nil
+}
+
+// sweep removes all expired keys from index.
+func (d *Driver) sweep(now int64) {
+	for key, l := range d.index {
+		if l.expired(now) {
+			d.drop(key)
+		}
+	}
+}
+
+// compact rewrites live records to new segments and removes the old ones.
+// Old segments are removed in ascending order,
+// so that crash at any point is recovered to the same state.
+func (d *Driver) compact() error {
+	old := d.active.id
+
+	if err := d.roll(); err != nil {
+		return err
+	}
+
+	for key, l := range d.index {
+		r, err := d.segments[l.segment].read(l.offset, l.size)
+		if err != nil {
+			return err
+		}
+
+		nl, err := d.write(r)
+		if err != nil {
+			return err
+		}
+
+		d.index[key] = nl
+	}
+
+	if err := d.roll(); err != nil {
+		return err
+	}
+
+	ids, err := segmentIDs(d.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id > old {
+			break
+		}
+
+		if s, ok := d.segments[id]; ok {
+			_ = s.file.Close()
+			delete(d.segments, id)
+		}
+
+		if err := os.Remove(segmentName(d.opts.Dir, id)); err != nil {
+			return err
+		}
+	}
+
+	d.dead = 0
+
+	return nil
+}
+
+// Compact removes expired keys and compacts segments if at least a half of data is dead.
+func (d *Driver) Compact() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sweep(time.Now().UnixNano())
+
+	if d.dead == 0 || d.dead*2 < d.bytes() {
+		return nil
+	}
+
+	return d.compact()
+}
+
+// compactor compacts segments regardless of user requests.
+func (d *Driver) compactor() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.opts.Compact * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			if err := d.Compact(); err != nil {
+				log.Printf("disk compaction err = %v", err)
+			}
+		}
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.index[key]
+	if !ok {
+		return "", nil
+	}
+
+	if l.expired(time.Now().UnixNano()) {
+		d.drop(key)
+		return "", nil
+	}
+
+	r, err := d.segments[l.segment].read(l.offset, l.size)
+	if err != nil {
+		return "", err
+	}
+
+	return r.val, nil
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+// Storage deletes `key` if `ttl < 0` and keeps it forever if `ttl == 0`.
+func (d *Driver) Set(key, val string, ttl int) error {
+	if ttl < 0 {
+		_, err := d.Delete(key)
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	r := &record{key: key, val: val}
+	if ttl > 0 {
+		r.expire = time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+
+	l, err := d.write(r)
+	if err != nil {
+		return err
+	}
+
+	d.drop(key)
+	d.index[key] = l
+
+	return nil
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	l, ok := d.index[key]
+	if !ok {
+		return false, nil
+	}
+
+	return d.remove(key, l, time.Now().UnixNano())
+}
+
+// DeletePattern deletes all keys matching glob-style `pattern` from key-value storage.
+func (d *Driver) DeletePattern(pattern string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	n := 0
+
+	for key, l := range d.index {
+		if !fs.Match(pattern, key) {
+			continue
+		}
+
+		ok, err := d.remove(key, l, now)
+		if err != nil {
+			return n, err
+		}
+
+		if ok {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// remove writes tombstone of `key` found at `l` and checks it was not expired at `now`.
+func (d *Driver) remove(key string, l *location, now int64) (bool, error) {
+	r := &record{key: key, tombstone: true}
+
+	if _, err := d.write(r); err != nil {
+		return false, err
+	}
+
+	d.drop(key)
+	d.dead += r.size()
+
+	return !l.expired(now), nil
+}
+
+// GetContext gets key from key-value storage if `ctx` is not done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return d.Get(key)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage if `ctx` is not done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return d.Set(key, val, ttl)
+}
+
+// DeleteContext deletes key from key-value storage if `ctx` is not done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return d.Delete(key)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage if `ctx` is not done.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return d.DeletePattern(pattern)
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	close(d.done)
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.active.file.Sync(); err != nil {
+		log.Printf("disk sync err = %v", err)
+	}
+
+	for _, s := range d.segments {
+		_ = s.file.Close()
+	}
+}
+
+// Stats returns the snapshot of `Driver` storage statistics.
+func (d *Driver) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return Stats{
+		Keys:     int64(len(d.index)),
+		Segments: int64(len(d.segments)),
+		Bytes:    d.bytes(),
+		Dead:     d.dead,
+	}
+}
+
+func init() {
+	fs.Register("disk", func() interface{} { return &Options{} }, func(config interface{}) fs.Driver {
+		return New(config.(*Options))
+	})
+}
+
+// New returns "ready-to-use" `Driver` with storage in `opts.Dir`.
+// Existing segments are scanned to recover the index.
+// Panics if `opts` are invalid or storage cannot be recovered.
+func New(opts *Options) *Driver {
+	if opts == nil || opts.Dir == "" {
+		log.Panicf("empty Dir")
+	}
+
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+
+	if opts.SegmentSize < 0 {
+		log.Panicf("negative SegmentSize")
+	}
+
+	if opts.Compact == 0 {
+		opts.Compact = defaultCompact
+	}
+
+	if opts.Compact < 0 {
+		log.Panicf("negative Compact")
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		log.Panicf("create Dir error (%v)", err)
+	}
+
+	d := &Driver{
+		opts:     opts,
+		index:    make(map[string]*location),
+		segments: make(map[int64]*segment),
+		done:     make(chan struct{}),
+	}
+
+	if err := d.recover(); err != nil {
+		log.Panicf("recover error (%v)", err)
+	}
+
+	d.wg.Add(1)
+
+	go d.compactor()
+
+	return d
+}
@@ -0,0 +1,390 @@
+package disk
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+const (
+	keyWithoutExpire = "key-without-expire"
+	valWithoutExpire = "val-without-expire"
+	withoutExpire    = 0 // seconds
+
+	keyWithShortExpire = "key-with-short-expire"
+	valWithShortExpire = "val-with-short-expire"
+	shortExpire        = 1 // seconds
+
+	keyNotExist   = "key-not-exist"
+	valNotExist   = ""
+	invalidExpire = -10 // seconds
+)
+
+// tempDir returns a new temporary directory and it's cleanup function.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "apicache-disk")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+func TestDriverConformance(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	var n int
+
+	drivertest.Run(t, func() fs.Driver {
+		n++
+		return New(&Options{Dir: dir + "/" + strconv.Itoa(n)})
+	})
+}
+
+func TestDriverGet(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+
+	cases := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{
+			name: "key exists",
+			key:  keyWithoutExpire,
+			want: valWithoutExpire,
+		},
+		{
+			name: "key not exists",
+			key:  keyNotExist,
+			want: valNotExist,
+		},
+		{
+			name: "key with expire exists",
+			key:  keyWithShortExpire,
+			want: valWithShortExpire,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := d.Get(c.key)
+
+			if got != c.want {
+				t.Errorf("Get() got = %v, want = %v", got, c.want)
+			}
+
+			if err != nil {
+				t.Errorf("Get() error = %v, want = %v", err, nil)
+			}
+		})
+	}
+}
+
+func TestDriverGetKeyExpired(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+	defer d.Close()
+
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+
+	time.Sleep(shortExpire*time.Second + 100*time.Millisecond)
+
+	if got, err := d.Get(keyWithShortExpire); got != valNotExist || err != nil {
+		t.Errorf("Get() got = %v, %v, want = %v, %v", got, err, valNotExist, nil)
+	}
+}
+
+func TestDriverSetNegativeTTL(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+
+	if err := d.Set(keyWithoutExpire, valWithoutExpire, invalidExpire); err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if got, _ := d.Get(keyWithoutExpire); got != valNotExist {
+		t.Errorf("Get() got = %v, want = %v", got, valNotExist)
+	}
+}
+
+func TestDriverDelete(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+
+	if ok, err := d.Delete(keyWithoutExpire); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if ok, err := d.Delete(keyWithoutExpire); ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+}
+
+func TestDriverDeletePattern(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+
+	for _, key := range []string{"user:42:name", "user:42:mail", "user:420:name", "user:43:name"} {
+		_ = d.Set(key, valWithoutExpire, withoutExpire)
+	}
+
+	if n, err := d.DeletePattern("user:42:*"); n != 2 || err != nil {
+		t.Errorf("DeletePattern() got = %v, %v, want = %v, %v", n, err, 2, nil)
+	}
+
+	d.Close()
+
+	// deletion is durable
+	d = New(&Options{Dir: dir})
+	defer d.Close()
+
+	if s := d.Stats(); s.Keys != 2 {
+		t.Errorf("Stats() keys = %d, want = %d", s.Keys, 2)
+	}
+}
+
+func TestDriverRecover(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir, SegmentSize: 100})
+
+	_ = d.Set(keyWithoutExpire, "old", withoutExpire)
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+	_ = d.Set(keyNotExist, valWithoutExpire, withoutExpire)
+	_, _ = d.Delete(keyNotExist)
+
+	if s := d.Stats(); s.Segments < 2 {
+		t.Errorf("Stats() segments = %d, want >= %d", s.Segments, 2)
+	}
+
+	d.Close()
+
+	// torn record of crashed write
+	ids, _ := segmentIDs(dir)
+
+	f, _ := os.OpenFile(segmentName(dir, ids[len(ids)-1]), os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.Write((&record{key: "torn", val: "torn"}).encode()[:10])
+	_ = f.Close()
+
+	d = New(&Options{Dir: dir, SegmentSize: 100})
+	defer d.Close()
+
+	cases := []struct {
+		key  string
+		want string
+	}{
+		{keyWithoutExpire, valWithoutExpire},
+		{keyWithShortExpire, valWithShortExpire},
+		{keyNotExist, valNotExist},
+		{"torn", valNotExist},
+	}
+	for _, c := range cases {
+		if got, err := d.Get(c.key); got != c.want || err != nil {
+			t.Errorf("Get(%s) got = %v, %v, want = %v, %v", c.key, got, err, c.want, nil)
+		}
+	}
+
+	// appends after recovered torn tail are readable
+	_ = d.Set("after", "crash", withoutExpire)
+
+	if got, _ := d.Get("after"); got != "crash" {
+		t.Errorf("Get() got = %v, want = %v", got, "crash")
+	}
+}
+
+func TestDriverRecoverCorrupt(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir, SegmentSize: 100})
+
+	// 3 records of 33 bytes per segment
+	for _, key := range []string{"k1", "k2", "k3", "k4", "k5", "k6"} {
+		_ = d.Set(key, "vvvvvvvvvv", withoutExpire)
+	}
+
+	d.Close()
+
+	ids, _ := segmentIDs(dir)
+	if len(ids) != 2 {
+		t.Fatalf("segmentIDs() got = %v, want = %v", len(ids), 2)
+	}
+
+	// damaged value of the second record of sealed and active segments
+	for _, id := range ids {
+		f, _ := os.OpenFile(segmentName(dir, id), os.O_WRONLY, 0644)
+		_, _ = f.WriteAt([]byte("x"), 33+headerSize+2)
+		_ = f.Close()
+	}
+
+	d = New(&Options{Dir: dir, SegmentSize: 100})
+	defer d.Close()
+
+	for key, want := range map[string]string{
+		"k1": "vvvvvvvvvv", "k2": valNotExist, "k3": "vvvvvvvvvv",
+		"k4": "vvvvvvvvvv", "k5": valNotExist, "k6": "vvvvvvvvvv",
+	} {
+		if got, err := d.Get(key); got != want || err != nil {
+			t.Errorf("Get(%s) got = %v, %v, want = %v, %v", key, got, err, want, nil)
+		}
+	}
+
+	// records after damaged ones are not truncated
+	for _, id := range ids {
+		if info, _ := os.Stat(segmentName(dir, id)); info.Size() != 99 {
+			t.Errorf("segment (%d) size got = %v, want = %v", id, info.Size(), 99)
+		}
+	}
+}
+
+func TestReadRecordCorruptLength(t *testing.T) {
+	raw := (&record{key: "key", val: "val"}).encode()
+
+	// corrupt length must not be allocated before CRC check
+	binary.BigEndian.PutUint32(raw[17:], math.MaxUint32)
+
+	if _, _, err := readRecord(bytes.NewReader(raw), int64(len(raw))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("readRecord() error = %v, want = %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDriverCompact(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir, SegmentSize: 1024})
+
+	for i := 0; i < 100; i++ {
+		_ = d.Set(keyWithoutExpire, strings.Repeat("v", i), withoutExpire)
+	}
+
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+	_ = d.Set(keyNotExist, valWithoutExpire, withoutExpire)
+	_, _ = d.Delete(keyNotExist)
+
+	before := d.Stats()
+
+	time.Sleep(shortExpire*time.Second + 100*time.Millisecond)
+
+	if err := d.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+
+	after := d.Stats()
+
+	if after.Bytes >= before.Bytes || after.Segments >= before.Segments {
+		t.Errorf("Compact() stats = %+v, before = %+v", after, before)
+	}
+
+	if after.Keys != 1 || after.Dead != 0 {
+		t.Errorf("Compact() keys, dead = %d, %d, want = %d, %d", after.Keys, after.Dead, 1, 0)
+	}
+
+	d.Close()
+
+	d = New(&Options{Dir: dir, SegmentSize: 1024})
+	defer d.Close()
+
+	if got, _ := d.Get(keyWithoutExpire); got != strings.Repeat("v", 99) {
+		t.Errorf("Get() got = %v, want = %v", got, strings.Repeat("v", 99))
+	}
+
+	if got, _ := d.Get(keyNotExist); got != valNotExist {
+		t.Errorf("Get() got = %v, want = %v", got, valNotExist)
+	}
+}
+
+func TestDriverContextCanceled(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	d := New(&Options{Dir: dir})
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.GetContext(ctx, keyWithoutExpire); err != context.Canceled {
+		t.Errorf("GetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if err := d.SetContext(ctx, keyWithoutExpire, valWithoutExpire, withoutExpire); err != context.Canceled {
+		t.Errorf("SetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if _, err := d.DeleteContext(ctx, keyWithoutExpire); err != context.Canceled {
+		t.Errorf("DeleteContext() error = %v, want = %v", err, context.Canceled)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *Options
+		err  string
+	}{
+		{
+			name: "nil options",
+			err:  "empty Dir",
+		},
+		{
+			name: "negative SegmentSize",
+			opts: &Options{Dir: "unused", SegmentSize: -1},
+			err:  "negative SegmentSize",
+		},
+		{
+			name: "negative Compact",
+			opts: &Options{Dir: "unused", Compact: -1},
+			err:  "negative Compact",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts)
+		})
+	}
+}
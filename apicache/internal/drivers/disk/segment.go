@@ -0,0 +1,218 @@
+package disk
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentExt = ".log"
+	// headerSize is crc (4) + expire (8) + flags (1) + key length (4) + value length (4).
+	headerSize    = 21
+	flagTombstone = 1
+)
+
+type (
+	// record is a single entry of append-only segment.
+	// Tombstone records mark deleted keys.
+	record struct {
+		key       string
+		val       string
+		expire    int64 // unix nanoseconds, zero means no expiration
+		tombstone bool
+	}
+	// segment is a single append-only file.
+	segment struct {
+		id   int64
+		file *os.File
+		size int64
+	}
+	// ErrCorruptRecord occurred if segment contains a torn or damaged record.
+	ErrCorruptRecord struct {
+		segment int64
+		offset  int64
+	}
+)
+
+// errChecksum occurred if record is read completely but it's checksum doesn't match.
+var errChecksum = errors.New("record checksum mismatch")
+
+func (e *ErrCorruptRecord) Error() string {
+	return fmt.Sprintf("corrupt record in segment (%d) at offset (%d)", e.segment, e.offset)
+}
+
+// size returns encoded size of `r`.
+func (r *record) size() int64 {
+	return int64(headerSize + len(r.key) + len(r.val))
+}
+
+// encode returns binary representation of `r`.
+func (r *record) encode() []byte {
+	buf := make([]byte, r.size())
+
+	binary.BigEndian.PutUint64(buf[4:], uint64(r.expire))
+
+	if r.tombstone {
+		buf[12] = flagTombstone
+	}
+
+	binary.BigEndian.PutUint32(buf[13:], uint32(len(r.key)))
+	binary.BigEndian.PutUint32(buf[17:], uint32(len(r.val)))
+	copy(buf[headerSize:], r.key)
+	copy(buf[headerSize+len(r.key):], r.val)
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// readRecord reads a record from `rd` which has at most `limit` bytes left.
+// Lengths of the header are checked against `limit` before allocation, so corrupt ones are torn records.
+// Returns `io.EOF` at the end of segment, `io.ErrUnexpectedEOF` for torn record
+// and `errChecksum` with the record size for damaged one.
+func readRecord(rd io.Reader, limit int64) (*record, int64, error) {
+	header := make([]byte, headerSize)
+
+	if _, err := io.ReadFull(rd, header); err != nil {
+		return nil, 0, err
+	}
+
+	keyLen := binary.BigEndian.Uint32(header[13:])
+	valLen := binary.BigEndian.Uint32(header[17:])
+
+	if int64(keyLen)+int64(valLen) > limit-int64(headerSize) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	body := make([]byte, int(keyLen)+int(valLen))
+
+	if _, err := io.ReadFull(rd, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, 0, err
+	}
+
+	crc := crc32.ChecksumIEEE(header[4:])
+	crc = crc32.Update(crc, crc32.IEEETable, body)
+
+	if crc != binary.BigEndian.Uint32(header) {
+		return nil, int64(headerSize) + int64(len(body)), errChecksum
+	}
+
+	r := &record{
+		key:       string(body[:keyLen]),
+		val:       string(body[keyLen:]),
+		expire:    int64(binary.BigEndian.Uint64(header[4:])),
+		tombstone: header[12]&flagTombstone != 0,
+	}
+
+	return r, int64(headerSize) + int64(len(body)), nil
+}
+
+// append writes `r` to the end of `s` and returns it's offset.
+func (s *segment) append(r *record) (int64, error) {
+	off := s.size
+
+	n, err := s.file.WriteAt(r.encode(), off)
+	s.size += int64(n)
+
+	return off, err
+}
+
+// read reads record of `size` bytes at `off`.
+func (s *segment) read(off, size int64) (*record, error) {
+	r, _, err := readRecord(io.NewSectionReader(s.file, off, size), size)
+	if err != nil {
+		return nil, &ErrCorruptRecord{s.id, off}
+	}
+
+	return r, nil
+}
+
+// scan calls `fn` for every record of `s` with it's offset and size.
+// Only torn tail (e.g. after crash) of `active` segment is truncated. Damaged records elsewhere are logged
+// as `ErrCorruptRecord` and skipped, the rest of sealed segment is skipped if the record length is damaged.
+func (s *segment) scan(active bool, fn func(r *record, off, size int64)) error {
+	rd := bufio.NewReader(io.NewSectionReader(s.file, 0, s.size))
+
+	var off int64
+
+	for {
+		r, n, err := readRecord(rd, s.size-off)
+		tail := errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errChecksum) && off+n == s.size
+
+		switch {
+		case err == nil:
+			fn(r, off, n)
+			off += n
+		case errors.Is(err, io.EOF):
+			return nil
+		case active && tail:
+			s.size = off
+			return s.file.Truncate(off)
+		case errors.Is(err, errChecksum):
+			log.Printf("disk recover err = %v", &ErrCorruptRecord{s.id, off})
+			off += n
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			log.Printf("disk recover err = %v", &ErrCorruptRecord{s.id, off})
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// segmentName returns file name of segment `id`.
+func segmentName(dir string, id int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// openSegment opens (or creates) segment `id` in `dir`.
+func openSegment(dir string, id int64) (*segment, error) {
+	f, err := os.OpenFile(segmentName(dir, id), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return &segment{id: id, file: f, size: info.Size()}, nil
+}
+
+// segmentIDs returns sorted ids of segments in `dir`.
+func segmentIDs(dir string) ([]int64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(names))
+
+	for _, name := range names {
+		id, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
@@ -1,13 +1,151 @@
 package memcache
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
 )
 
+// relativeExpireMax is the largest expiration treated by memcached as relative seconds.
+const relativeExpireMax = 60 * 60 * 24 * 30
+
+// result is the outcome of `memcache` call made in background.
+type result struct {
+	val string
+	ok  bool
+	err error
+}
+
+// call runs `fn` in background and waits for it until `ctx` is done.
+// `memcache` client has no context support, so the call itself is not
+// interrupted, but the caller doesn't wait for it anymore.
+func call(ctx context.Context, fn func() result) result {
+	if err := ctx.Err(); err != nil {
+		return result{err: err}
+	}
+
+	done := make(chan result, 1)
+
+	go func() { done <- fn() }()
+
+	select {
+	case r := <-done:
+		return r
+	case <-ctx.Done():
+		return result{err: ctx.Err()}
+	}
+}
+
+// withTimeout returns `ctx` limited by `timeout` (in milliseconds) if it is positive.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout*time.Millisecond)
+}
+
+// checkKey returns `fs.ErrInvalidKey` if memcached rejects `key`.
+func checkKey(key string) error {
+	if reason := validKey(key); reason != "" {
+		return fs.NewErrInvalidKey(key, reason)
+	}
+
+	return nil
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (r *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Get)
+	defer cancel()
+
+	res := call(ctx, func() result {
+		val, err := r.get(key)
+		return result{val: val, err: err}
+	})
+
+	return res.val, res.err
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (r *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Set)
+	defer cancel()
+
+	return call(ctx, func() result {
+		return result{err: r.set(key, val, ttl)}
+	}).err
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done,
+// `ok` is false if `opts.Mode` condition is not met.
+// memcached expires keys with seconds granularity, so "time-to-live" is rounded up.
+func (r *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Set)
+	defer cancel()
+
+	res := call(ctx, func() result {
+		ok, err := r.setCond(key, val, opts)
+		return result{ok: ok, err: err}
+	})
+
+	return res.ok, res.err
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (r *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+
+	ctx, cancel := withTimeout(ctx, r.timeouts.Del)
+	defer cancel()
+
+	res := call(ctx, func() result {
+		ok, err := r.delete(key)
+		return result{ok: ok, err: err}
+	})
+
+	return res.ok, res.err
+}
+
 // Get gets key from key-value storage.
 func (r *Driver) Get(key string) (string, error) {
+	return r.GetContext(context.Background(), key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+// memcached storage deletes `key` if `ttl < 0`. It is expected behavior.
+func (r *Driver) Set(key, val string, ttl int) error {
+	return r.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetCond sets key and value by `opts` to key-value storage, `ok` is false if `opts.Mode` condition is not met.
+func (r *Driver) SetCond(key, val string, opts *fs.SetOptions) (bool, error) {
+	return r.SetCondContext(context.Background(), key, val, opts)
+}
+
+// Delete deletes key from key-value storage.
+func (r *Driver) Delete(key string) (bool, error) {
+	return r.DeleteContext(context.Background(), key)
+}
+
+func (r *Driver) get(key string) (string, error) {
 	val, err := r.storage.Get(key)
 
 	if err != nil {
@@ -21,9 +159,7 @@ func (r *Driver) Get(key string) (string, error) {
 	return string(val.Value), nil
 }
 
-// Set sets key, value and "time-to-live" to key-value storage.
-// memcached storage deletes `key` if `ttl < 0`. It is expected behavior.
-func (r *Driver) Set(key, val string, ttl int) error {
+func (r *Driver) set(key, val string, ttl int) error {
 	return r.storage.Set(&memcache.Item{
 		Key:        key,
 		Value:      []byte(val),
@@ -31,8 +167,37 @@ func (r *Driver) Set(key, val string, ttl int) error {
 	})
 }
 
-// Delete deletes key from key-value storage.
-func (r *Driver) Delete(key string) (bool, error) {
+// setCond stores key by "set", "add" (`fs.ModeNX`) or "replace" (`fs.ModeXX`) command.
+// Expiration longer than `relativeExpireMax` is passed as unix time.
+func (r *Driver) setCond(key, val string, opts *fs.SetOptions) (bool, error) {
+	now := time.Now()
+	exptime := int64(opts.TTLSeconds(now))
+
+	if exptime > relativeExpireMax {
+		exptime = opts.Expire(now).Unix() + 1
+	}
+
+	item := &memcache.Item{Key: key, Value: []byte(val), Expiration: int32(exptime)}
+
+	var err error
+
+	switch opts.Mode {
+	case fs.ModeNX:
+		err = r.storage.Add(item)
+	case fs.ModeXX:
+		err = r.storage.Replace(item)
+	default:
+		err = r.storage.Set(item)
+	}
+
+	if errors.Is(err, memcache.ErrNotStored) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (r *Driver) delete(key string) (bool, error) {
 	err := r.storage.Delete(key)
 
 	if err != nil {
@@ -50,13 +215,29 @@ func (r *Driver) Delete(key string) (bool, error) {
 func (r *Driver) Close() {}
 
 // Driver implements Driver interface.
+// Inner storage is the set of weighted memcached servers.
 type Driver struct {
-	storage *memcache.Client
+	storage  *memcache.Client
+	timeouts Timeouts
+}
+
+func init() {
+	fs.Register("memcache", func() interface{} { return &Options{} }, func(config interface{}) fs.Driver {
+		return New(config.(*Options))
+	})
 }
 
 // New returns "ready-to-use" `Driver` with `memcache` inner storage.
-func New(addr string) *Driver {
-	return &Driver{
-		storage: memcache.New(addr),
+// Panics if `opts` are invalid.
+func New(opts *Options) *Driver {
+	opts.validate()
+
+	storage := memcache.NewFromSelector(&selector{servers: opts.servers()})
+	storage.MaxIdleConns = opts.MaxIdleConns
+
+	if opts.Timeout > 0 {
+		storage.Timeout = opts.Timeout * time.Millisecond
 	}
+
+	return &Driver{storage: storage, timeouts: opts.Timeouts}
 }
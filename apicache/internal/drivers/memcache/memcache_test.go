@@ -1,59 +1,40 @@
 package memcache
 
 import (
+	"context"
 	"errors"
-	"github.com/bradfitz/gomemcache/memcache"
 	"log"
+	"net"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+	"github.com/kxnes/go-interviews/apicache/test/memcachetest"
 )
 
 const (
-	testInstance = ":11211"
-
-	keyWithoutExpire = "key-without-expire"
 	valWithoutExpire = "val-without-expire"
-	withoutExpire    = 0 // seconds
-
-	keyWithLongExpire = "key-with-long-expire"
-	valWithLongExpire = "val-with-long-expire"
-	longExpire        = 100 // seconds
-
-	keyWithShortExpire = "key-with-short-expire"
-	valWithShortExpire = "val-with-short-expire"
-	shortExpire        = 2 // seconds
+	longExpire       = 100 // seconds
+	invalidExpire    = -10 // seconds
 
-	keyNotExist   = "key-not-exist"
-	valNotExist   = ""
-	invalidExpire = -10 // seconds
+	valNotExist = ""
 )
 
-var d = Driver{storage: memcache.New(testInstance)}
+var (
+	srv          *memcachetest.Server
+	testInstance string
+	d            Driver
+)
 
 func TestMain(m *testing.M) {
-	for _, item := range []*memcache.Item{
-		{
-			Key:   keyWithoutExpire,
-			Value: []byte(valWithoutExpire),
-		},
-		{
-			Key:        keyWithLongExpire,
-			Value:      []byte(valWithLongExpire),
-			Expiration: longExpire,
-		},
-		{
-			Key:        keyWithShortExpire,
-			Value:      []byte(valWithShortExpire),
-			Expiration: shortExpire,
-		},
-	} {
-		err := d.storage.Set(item)
-		if err != nil {
-			log.Fatalf("setup fixture %v error = %v\n", *item, err)
-		}
-	}
+	srv = memcachetest.NewServer()
+	testInstance = srv.Addr()
+	d = *New(&Options{Addr: testInstance})
 
 	code := m.Run()
 
@@ -62,199 +43,251 @@ func TestMain(m *testing.M) {
 		log.Fatalf("teardown error = %v\n", err)
 	}
 
+	srv.Close()
 	os.Exit(code)
 }
 
-func TestDriverGetKeyExists(t *testing.T) {
-	val, err := d.Get(keyWithoutExpire)
-
-	if val != valWithoutExpire {
-		t.Errorf("Get() = %s, want = %s", val, valWithoutExpire)
-	}
-
-	if err != nil {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
-	}
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(&Options{Addr: testInstance}) })
 }
 
-func TestDriverGetKeyNotExists(t *testing.T) {
-	val, err := d.Get(keyNotExist)
+func TestDriverGetUnexpectedError(t *testing.T) {
+	val, err := d.Get(valNotExist)
 
 	if val != valNotExist {
 		t.Errorf("Get() = %s, want = %s", val, valNotExist)
 	}
 
-	if err != nil {
+	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected line in get response: "ERROR\r\n"`)) {
 		t.Errorf("Get() error = %v, want = %v", err, nil)
 	}
 }
 
-func TestDriverGetKeyExpired(t *testing.T) {
-	time.Sleep(shortExpire * time.Second)
+func TestDriverSetUnexpectedError(t *testing.T) {
+	err := d.Set(valNotExist, valWithoutExpire, invalidExpire)
 
-	val, err := d.Get(keyWithShortExpire)
+	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected response line from "set": "ERROR\r\n"`)) {
+		t.Errorf("Get() error = %v, want = %v", err, nil)
+	}
+}
 
-	if val != valNotExist {
-		t.Errorf("Get() = %s, want = %s", val, valNotExist)
+func TestDriverDeleteUnexpectedError(t *testing.T) {
+	ok, err := d.Delete(valNotExist)
+	if ok {
+		t.Errorf("Delete() = %v, want = %v", ok, false)
 	}
 
-	if err != nil {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
+	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected response line: "ERROR\r\n"`)) {
+		t.Errorf("Delete() error = %v, want = %v", err, nil)
 	}
 }
 
-func TestDriverGetUnexpectedError(t *testing.T) {
-	val, err := d.Get(valNotExist)
+func TestDriverServerError(t *testing.T) {
+	srv.SetError("set", "SERVER_ERROR out of memory storing object")
+	defer srv.SetError("set", "")
 
-	if val != valNotExist {
-		t.Errorf("Get() = %s, want = %s", val, valNotExist)
-	}
+	err := d.Set(valWithoutExpire, valWithoutExpire, longExpire)
 
-	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected line in get response: "ERROR\r\n"`)) {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
+	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected response line from "set": "SERVER_ERROR out of memory storing object\r\n"`)) {
+		t.Errorf("Set() error = %v", err)
 	}
 }
 
-func TestDriverSetNotExistKey(t *testing.T) {
-	item, err := d.storage.Get(keyNotExist)
-	if !errors.Is(err, memcache.ErrCacheMiss) || item != nil {
-		t.Errorf("Key exists but doesn't")
-	}
+func TestDriverExpire(t *testing.T) {
+	_ = d.Set(valWithoutExpire, valWithoutExpire, longExpire)
 
-	err = d.Set(keyNotExist, valWithoutExpire, withoutExpire)
+	srv.FastForward(longExpire * time.Second)
 
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+	if val, err := d.Get(valWithoutExpire); val != valNotExist || err != nil {
+		t.Errorf("Get() = %s, %v, want = %s, %v", val, err, valNotExist, nil)
 	}
+}
+
+func TestDriverSetCond(t *testing.T) {
+	const key = "cond"
 
-	item, err = d.storage.Get(keyNotExist)
-	if errors.Is(err, memcache.ErrCacheMiss) || item == nil {
-		t.Errorf("Set() doesn't set")
+	cases := []struct {
+		name string
+		opts *fs.SetOptions
+		want bool
+		val  string
+	}{
+		{"xx missed", &fs.SetOptions{Mode: fs.ModeXX, NoExpire: true}, false, valNotExist},
+		{"nx missed", &fs.SetOptions{Mode: fs.ModeNX, TTL: 1500 * time.Millisecond}, true, "nx missed"},
+		{"nx existed", &fs.SetOptions{Mode: fs.ModeNX, NoExpire: true}, false, "nx missed"},
+		{"xx existed", &fs.SetOptions{Mode: fs.ModeXX, TTL: 1500 * time.Millisecond}, true, "xx existed"},
 	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ok, err := d.SetCond(key, c.name, c.opts); ok != c.want || err != nil {
+				t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
 
-	if string(item.Value) != valWithoutExpire {
-		t.Errorf("Set() wrong value = %s", item.Value)
+			if val, _ := d.Get(key); val != c.val {
+				t.Errorf("Get() got = %v, want = %v", val, c.val)
+			}
+		})
 	}
-}
 
-func TestDriverSetExistKey(t *testing.T) {
-	item, err := d.storage.Get(keyWithoutExpire)
-	if errors.Is(err, memcache.ErrCacheMiss) || item == nil {
-		t.Errorf("Key not exists but doesn't")
+	// milliseconds are rounded up to seconds
+	srv.FastForward(1900 * time.Millisecond)
+
+	if val, _ := d.Get(key); val != "xx existed" {
+		t.Errorf("Get() before expiration got = %v, want = %v", val, "xx existed")
 	}
 
-	err = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+	srv.FastForward(200 * time.Millisecond)
 
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+	if val, _ := d.Get(key); val != valNotExist {
+		t.Errorf("Get() after expiration got = %v, want = %v", val, valNotExist)
 	}
 
-	item, err = d.storage.Get(keyWithoutExpire)
-	if errors.Is(err, memcache.ErrCacheMiss) || item == nil {
-		t.Errorf("Set() doesn't set")
+	// expiration longer than 30 days is absolute
+	at := time.Now().Add(60 * 24 * time.Hour)
+
+	if ok, err := d.SetCond(key, key, &fs.SetOptions{ExpireAt: at}); !ok || err != nil {
+		t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, true, nil)
 	}
 
-	if string(item.Value) != valWithoutExpire {
-		t.Errorf("Set() wrong value = %s", item.Value)
+	if val, _ := d.Get(key); val != key {
+		t.Errorf("Get() got = %v, want = %v", val, key)
 	}
 }
 
-func TestDriverSetUpdateExpireOnKey(t *testing.T) {
-	err := d.Set(keyWithLongExpire, valWithLongExpire, shortExpire)
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
-	}
+func TestDriverClose(t *testing.T) {
+	d.Close()
+}
 
-	time.Sleep(time.Second)
+func TestNew(t *testing.T) {
+	d := New(&Options{Addr: testInstance})
 
-	err = d.Set(keyWithLongExpire, valWithLongExpire, shortExpire)
+	err := d.storage.Ping()
 	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+		t.Errorf("driver not created = %v", err)
 	}
+}
 
-	time.Sleep(time.Second)
-
-	item, err := d.storage.Get(keyWithLongExpire)
-	if errors.Is(err, memcache.ErrCacheMiss) || item == nil {
-		t.Errorf("Set() doesn't set")
+func TestDriverInvalidKey(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+	}{
+		{name: "too long", key: strings.Repeat("k", maxKeyLength+1)},
+		{name: "space", key: "key with spaces"},
+		{name: "control", key: "key\n"},
 	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var target *fs.ErrInvalidKey
 
-	if string(item.Value) != valWithLongExpire {
-		t.Errorf("Set() wrong value = %s", item.Value)
-	}
+			if _, err := d.Get(c.key); !errors.As(err, &target) {
+				t.Errorf("Get() error = %v, want = %T", err, target)
+			}
 
-	time.Sleep(time.Second)
+			if err := d.Set(c.key, valWithoutExpire, longExpire); !errors.As(err, &target) {
+				t.Errorf("Set() error = %v, want = %T", err, target)
+			}
 
-	item, err = d.storage.Get(keyWithLongExpire)
-	if !errors.Is(err, memcache.ErrCacheMiss) || item != nil {
-		t.Errorf("Set() doesn't update expiration")
+			if _, err := d.Delete(c.key); !errors.As(err, &target) {
+				t.Errorf("Delete() error = %v, want = %T", err, target)
+			}
+		})
 	}
 }
 
-func TestDriverSetNegativeTTL(t *testing.T) {
-	err := d.Set(keyWithoutExpire, valWithoutExpire, invalidExpire)
+func TestDriverTimeouts(t *testing.T) {
+	// accepts connections but never replies
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+		t.Fatalf("listen error = %v", err)
 	}
+	defer ln.Close()
+
+	d := New(&Options{Addr: ln.Addr().String(), Timeouts: Timeouts{Get: 50, Set: 50, Del: 50}})
 
-	item, err := d.storage.Get(keyWithoutExpire)
-	if !errors.Is(err, memcache.ErrCacheMiss) || item != nil {
-		t.Errorf("Set() set but doesn't")
+	if _, err := d.Get(valWithoutExpire); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get() error = %v, want = %v", err, context.DeadlineExceeded)
 	}
-}
 
-func TestDriverSetUnexpectedError(t *testing.T) {
-	err := d.Set(valNotExist, valWithoutExpire, invalidExpire)
+	if err := d.Set(valWithoutExpire, valWithoutExpire, longExpire); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Set() error = %v, want = %v", err, context.DeadlineExceeded)
+	}
 
-	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected response line from "set": "ERROR\r\n"`)) {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
+	if _, err := d.Delete(valWithoutExpire); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Delete() error = %v, want = %v", err, context.DeadlineExceeded)
 	}
 }
 
-func TestDriverDeleteKeyExists(t *testing.T) {
-	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+func TestNewWeightedServers(t *testing.T) {
+	light, heavy := memcachetest.NewServer(), memcachetest.NewServer()
+	defer light.Close()
+	defer heavy.Close()
 
-	ok, err := d.Delete(keyWithoutExpire)
-	if !ok {
-		t.Errorf("Delete() = %v, want = %v", ok, true)
-	}
+	d := New(&Options{
+		Servers: []*Server{
+			{Addr: light.Addr(), Weight: 1},
+			{Addr: heavy.Addr(), Weight: 3},
+		},
+		Timeout:      500,
+		MaxIdleConns: 4,
+	})
 
-	if err != nil {
-		t.Errorf("Delete() error = %v, want = %v", err, nil)
-	}
-}
+	const keys = 400
 
-func TestDriverDeleteKeyNotExists(t *testing.T) {
-	ok, err := d.Delete(valWithoutExpire)
-	if ok {
-		t.Errorf("Delete() = %v, want = %v", ok, false)
+	for i := 0; i < keys; i++ {
+		if err := d.Set("weighted-"+strconv.Itoa(i), valWithoutExpire, longExpire); err != nil {
+			t.Fatalf("Set() error = %v, want = %v", err, nil)
+		}
 	}
 
-	if err != nil {
-		t.Errorf("Delete() error = %v, want = %v", err, nil)
-	}
-}
+	lightOnly := New(&Options{Addr: light.Addr()})
+	heavyOnly := New(&Options{Addr: heavy.Addr()})
 
-func TestDriverDeleteUnexpectedError(t *testing.T) {
-	ok, err := d.Delete(valNotExist)
-	if ok {
-		t.Errorf("Delete() = %v, want = %v", ok, false)
+	var onLight, onHeavy int
+
+	for i := 0; i < keys; i++ {
+		key := "weighted-" + strconv.Itoa(i)
+
+		if val, _ := lightOnly.Get(key); val != valNotExist {
+			onLight++
+		}
+
+		if val, _ := heavyOnly.Get(key); val != valNotExist {
+			onHeavy++
+		}
 	}
 
-	if !reflect.DeepEqual(err, errors.New(`memcache: unexpected response line: "ERROR\r\n"`)) {
-		t.Errorf("Delete() error = %v, want = %v", err, nil)
+	if onLight+onHeavy != keys {
+		t.Errorf("keys got = %d, want = %d", onLight+onHeavy, keys)
 	}
-}
 
-func TestDriverClose(t *testing.T) {
-	d.Close()
+	// expected 100 and 300
+	if onLight < 60 || onLight > 140 {
+		t.Errorf("keys on light server got = %d, want = ~%d", onLight, keys/4)
+	}
 }
 
-func TestNew(t *testing.T) {
-	d := New(testInstance)
-
-	err := d.storage.Ping()
-	if err != nil {
-		t.Errorf("driver not created = %v", err)
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{}, "empty Servers"},
+		{&Options{Servers: []*Server{{Addr: testInstance, Weight: -1}}}, "negative Weight (" + testInstance + ")"},
+		{&Options{Servers: []*Server{{Addr: "localhost:port"}}}, "invalid server address (localhost:port)"},
+		{&Options{Addr: testInstance, Timeout: -1}, "negative Timeout"},
+		{&Options{Addr: testInstance, Timeouts: Timeouts{Set: -1}}, "negative Timeouts.Set"},
+		{&Options{Addr: testInstance, MaxIdleConns: -1}, "negative MaxIdleConns"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts)
+		})
 	}
 }
@@ -0,0 +1,116 @@
+package memcache
+
+import (
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// maxKeyLength is the longest key accepted by memcached.
+const maxKeyLength = 250
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Keys are distributed between `Servers` proportionally to their weights
+	// (`Addr` is used if `Servers` is empty).
+	// `Timeout` is a socket read/write timeout and `Timeouts` are per-operation deadlines,
+	// both in milliseconds, zero values fall back to `gomemcache` defaults and no deadline.
+	Options struct {
+		Addr         string        `json:"addr"`
+		Servers      []*Server     `json:"servers"`
+		Timeout      time.Duration `json:"timeout"`
+		Timeouts     Timeouts      `json:"timeouts"`
+		MaxIdleConns int           `json:"maxIdleConns"`
+	}
+	// Server is a memcached server with it's weight (1 if zero).
+	Server struct {
+		Addr   string `json:"addr"`
+		Weight int    `json:"weight"`
+	}
+	// Timeouts contains per-operation deadlines in milliseconds.
+	Timeouts struct {
+		Get time.Duration `json:"get"`
+		Set time.Duration `json:"set"`
+		Del time.Duration `json:"del"`
+	}
+)
+
+// servers returns resolved weighted servers of `opts`.
+// Panics if `opts` are invalid.
+func (opts *Options) servers() []weighted {
+	servers := opts.Servers
+	if len(servers) == 0 {
+		if opts.Addr == "" {
+			log.Panicf("empty Servers")
+		}
+
+		servers = []*Server{{Addr: opts.Addr}}
+	}
+
+	out := make([]weighted, 0, len(servers))
+
+	for _, s := range servers {
+		if s.Weight < 0 {
+			log.Panicf("negative Weight (%s)", s.Addr)
+		}
+
+		weight := s.Weight
+		if weight == 0 {
+			weight = 1
+		}
+
+		var (
+			addr net.Addr
+			err  error
+		)
+
+		if strings.Contains(s.Addr, "/") {
+			addr, err = net.ResolveUnixAddr("unix", s.Addr)
+		} else {
+			addr, err = net.ResolveTCPAddr("tcp", s.Addr)
+		}
+
+		if err != nil || s.Addr == "" {
+			log.Panicf("invalid server address (%s)", s.Addr)
+		}
+
+		out = append(out, weighted{addr: addr, weight: float64(weight)})
+	}
+
+	return out
+}
+
+// validate checks `opts` are consistent.
+// Panics if `opts` are invalid.
+func (opts *Options) validate() {
+	for _, p := range []struct {
+		name  string
+		value int64
+	}{
+		{"Timeout", int64(opts.Timeout)},
+		{"Timeouts.Get", int64(opts.Timeouts.Get)},
+		{"Timeouts.Set", int64(opts.Timeouts.Set)},
+		{"Timeouts.Del", int64(opts.Timeouts.Del)},
+		{"MaxIdleConns", int64(opts.MaxIdleConns)},
+	} {
+		if p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+}
+
+// validKey returns reason why memcached rejects `key` or empty string.
+func validKey(key string) string {
+	if len(key) > maxKeyLength {
+		return "longer than 250 bytes"
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return "contains whitespace or control characters"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,71 @@
+package memcache
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+type (
+	// weighted is a server address with it's weight.
+	weighted struct {
+		addr   net.Addr
+		weight float64
+	}
+	// selector picks server by weighted rendezvous hashing:
+	// every server gets a share of keys proportional to it's weight
+	// and only keys of the removed server are moved if the list changes.
+	selector struct {
+		servers []weighted
+	}
+)
+
+// score returns the rank of `server` for `key`.
+func score(key string, server weighted) float64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(server.addr.String()))
+	_, _ = h.Write([]byte(key))
+
+	// fnv leaves high bits poorly mixed for keys with common prefix,
+	// so the sum goes through murmur3 finalizer before mapping to uniform in (0, 1)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	u := (float64(x>>11) + 0.5) / (1 << 53)
+
+	return -server.weight / math.Log(u)
+}
+
+// PickServer returns the server address that a given item should be shared onto.
+func (s *selector) PickServer(key string) (net.Addr, error) {
+	if len(s.servers) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+
+	best, bestScore := s.servers[0].addr, score(key, s.servers[0])
+
+	for _, server := range s.servers[1:] {
+		if sc := score(key, server); sc > bestScore {
+			best, bestScore = server.addr, sc
+		}
+	}
+
+	return best, nil
+}
+
+// Each calls `fn` for every server.
+func (s *selector) Each(fn func(net.Addr) error) error {
+	for _, server := range s.servers {
+		if err := fn(server.addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// lease returns not expired lease of lock `name` at `now`, expired one is removed.
+// It must be called under `mu`.
+func (d *Driver) lease(name string, now time.Time) (*fs.Lease, bool) {
+	lease, ok := d.locks[name]
+	if ok && !lease.Expire.After(now) {
+		delete(d.locks, name)
+		return nil, false
+	}
+
+	return lease, ok
+}
+
+// Acquire grants lock `name` to `owner` for `ttl`, `ok` is false if it is held by another owner.
+// Leases are kept apart from keys, so they are never evicted.
+func (d *Driver) Acquire(name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	lease, ok := d.lease(name, now)
+
+	switch {
+	case ok && lease.Owner != owner:
+		return nil, false, nil
+	case !ok:
+		d.fences[name]++
+		lease = &fs.Lease{Name: name, Owner: owner, Token: d.fences[name]}
+		d.locks[name] = lease
+	}
+
+	lease.Expire = now.Add(ttl)
+	granted := *lease
+
+	return &granted, true, nil
+}
+
+// Renew extends lock `name` for `ttl`, `ok` is false if it is not held by `owner`.
+func (d *Driver) Renew(name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	lease, ok := d.lease(name, now)
+	if !ok || lease.Owner != owner {
+		return nil, false, nil
+	}
+
+	lease.Expire = now.Add(ttl)
+	renewed := *lease
+
+	return &renewed, true, nil
+}
+
+// Release releases lock `name`, `ok` is false if it is not held by `owner`.
+func (d *Driver) Release(name, owner string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	lease, ok := d.lease(name, time.Now())
+	if !ok || lease.Owner != owner {
+		return false, nil
+	}
+
+	delete(d.locks, name)
+
+	return true, nil
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` if `ctx` is not done.
+func (d *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	return d.Acquire(name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` if `ctx` is not done.
+func (d *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	return d.Renew(name, owner, ttl)
+}
+
+// ReleaseContext releases lock `name` held by `owner` if `ctx` is not done.
+func (d *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return d.Release(name, owner)
+}
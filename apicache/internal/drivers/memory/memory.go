@@ -0,0 +1,470 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	// entryOverhead approximates memory used by `entry` besides key and value.
+	entryOverhead = 64
+	defaultSweep  = 1
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	// Zero `MaxEntries` or `MaxMemory` means no limit.
+	Options struct {
+		MaxEntries int           `json:"maxEntries"`
+		MaxMemory  int64         `json:"maxMemory"`
+		Policy     string        `json:"policy"`
+		Sweep      time.Duration `json:"sweep"`
+	}
+	// Stats contains `Driver` usage statistics.
+	Stats struct {
+		Entries     int64 `json:"entries"`
+		Memory      int64 `json:"memory"`
+		Hits        int64 `json:"hits"`
+		Misses      int64 `json:"misses"`
+		Evictions   int64 `json:"evictions"`
+		Expirations int64 `json:"expirations"`
+		Rejections  int64 `json:"rejections"`
+	}
+	// entry is a single key-value pair with policy bookkeeping.
+	entry struct {
+		key    string
+		val    string
+		expire int64 // unix nanoseconds, zero means no expiration
+		size   int64
+		tags   []string
+		elem   *list.Element
+		index  int
+		freq   int64
+		tick   int64
+	}
+	// Driver implements Driver interface.
+	Driver struct {
+		mu     sync.Mutex
+		items  map[string]*entry
+		tags   map[string]map[string]struct{}
+		locks  map[string]*fs.Lease
+		fences map[string]int64
+		notify map[int]func(e *fs.Event)
+		next   int
+		policy policy
+		opts   *Options
+		stats  Stats
+		done   chan struct{}
+	}
+)
+
+// expired checks `e` is expired at `now`.
+func (e *entry) expired(now int64) bool {
+	return e.expire != 0 && e.expire <= now
+}
+
+// entrySize returns approximate memory used by key-value pair.
+func entrySize(key, val string) int64 {
+	return int64(len(key)+len(val)) + entryOverhead
+}
+
+// overflow checks that adding `size` bytes as a new entry exceeds limits.
+func (d *Driver) overflow(size int64) bool {
+	if d.opts.MaxEntries > 0 && len(d.items)+1 > d.opts.MaxEntries {
+		return true
+	}
+
+	return d.opts.MaxMemory > 0 && d.stats.Memory+size > d.opts.MaxMemory
+}
+
+// feasible checks that adding `size` bytes as a new entry fits limits after evicting all evictable entries.
+func (d *Driver) feasible(size int64) bool {
+	entries, evictable := d.policy.evictable()
+
+	if d.opts.MaxEntries > 0 && len(d.items)-entries+1 > d.opts.MaxEntries {
+		return false
+	}
+
+	return d.opts.MaxMemory <= 0 || d.stats.Memory-evictable+size <= d.opts.MaxMemory
+}
+
+// insert adds `e` to the storage and to the index of its tags.
+func (d *Driver) insert(e *entry) {
+	d.items[e.key] = e
+	d.stats.Memory += e.size
+	d.policy.add(e)
+
+	for _, tag := range e.tags {
+		keys, ok := d.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			d.tags[tag] = keys
+		}
+
+		keys[e.key] = struct{}{}
+	}
+}
+
+// remove removes `e` from the storage and from the index of its tags,
+// so the index is maintained on deletion, expiration and eviction.
+func (d *Driver) remove(e *entry) {
+	delete(d.items, e.key)
+	d.stats.Memory -= e.size
+	d.policy.remove(e)
+
+	for _, tag := range e.tags {
+		delete(d.tags[tag], e.key)
+
+		if len(d.tags[tag]) == 0 {
+			delete(d.tags, tag)
+		}
+	}
+}
+
+// sweep removes all expired entries.
+func (d *Driver) sweep() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UnixNano()
+
+	for _, e := range d.items {
+		if e.expired(now) {
+			d.expire(e)
+		}
+	}
+
+	for name, lease := range d.locks {
+		if !lease.Expire.After(time.Unix(0, now)) {
+			delete(d.locks, name)
+		}
+	}
+}
+
+// janitor deletes expired keys regardless of user requests.
+func (d *Driver) janitor() {
+	ticker := time.NewTicker(d.opts.Sweep * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.sweep()
+		}
+	}
+}
+
+// Get gets key from key-value storage.
+func (d *Driver) Get(key string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.items[key]
+	if ok && e.expired(time.Now().UnixNano()) {
+		d.expire(e)
+
+		ok = false
+	}
+
+	if !ok {
+		d.stats.Misses++
+		return "", nil
+	}
+
+	d.stats.Hits++
+	d.policy.touch(e)
+
+	return e.val, nil
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+// Storage deletes `key` if `ttl < 0` and keeps it forever if `ttl == 0`.
+// If there is no room for `key` and nothing can be evicted
+// the write is rejected with `fs.ErrInsufficientStorage`.
+func (d *Driver) Set(key, val string, ttl int) error {
+	return d.set(key, val, ttl, nil)
+}
+
+// SetTags sets key, value and "time-to-live" with `tags` to key-value storage.
+// Tags of the previous value of `key` are replaced.
+func (d *Driver) SetTags(key, val string, ttl int, tags []string) error {
+	return d.set(key, val, ttl, tags)
+}
+
+// SetCond sets key and value by `opts` to key-value storage, `ok` is false if `opts.Mode` condition is not met.
+func (d *Driver) SetCond(key, val string, opts *fs.SetOptions) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	old, exists := d.items[key]
+	if exists && old.expired(now.UnixNano()) {
+		d.expire(old)
+
+		exists = false
+	}
+
+	if opts.Mode == fs.ModeNX && exists || opts.Mode == fs.ModeXX && !exists {
+		return false, nil
+	}
+
+	var expire int64
+	if at := opts.Expire(now); !at.IsZero() {
+		expire = at.UnixNano()
+	}
+
+	return true, d.store(key, val, expire, nil)
+}
+
+// set sets key, value, "time-to-live" and `tags` to key-value storage.
+func (d *Driver) set(key, val string, ttl int, tags []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ttl < 0 {
+		if old, ok := d.items[key]; ok {
+			d.drop(old, time.Now().UnixNano())
+		}
+
+		return nil
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+
+	return d.store(key, val, expire, tags)
+}
+
+// store replaces key with value expired at `expire` (unix nanoseconds) and `tags`, it must be called under `mu`.
+func (d *Driver) store(key, val string, expire int64, tags []string) error {
+	old, ok := d.items[key]
+	if ok {
+		// protect replaced entry from eviction while looking for room
+		d.remove(old)
+	}
+
+	e := &entry{key: key, val: val, expire: expire, size: entrySize(key, val), tags: tags}
+
+	if ok {
+		e.freq = old.freq
+	}
+
+	// nothing is evicted for the write which is rejected anyway
+	if d.overflow(e.size) && !d.feasible(e.size) {
+		if ok {
+			d.insert(old)
+		}
+
+		d.stats.Rejections++
+
+		return fs.NewErrInsufficientStorage(key)
+	}
+
+	for d.overflow(e.size) {
+		v := d.policy.victim()
+		d.remove(v)
+		d.stats.Evictions++
+	}
+
+	d.insert(e)
+
+	return nil
+}
+
+// Delete deletes key from key-value storage.
+func (d *Driver) Delete(key string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.items[key]
+	if !ok {
+		return false, nil
+	}
+
+	return d.drop(e, time.Now().UnixNano()) == 1, nil
+}
+
+// DeleteTag deletes all keys with `tag` from key-value storage.
+func (d *Driver) DeleteTag(tag string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	n := 0
+
+	for key := range d.tags[tag] {
+		n += d.drop(d.items[key], now)
+	}
+
+	return n, nil
+}
+
+// DeletePattern deletes all keys matching glob-style `pattern` from key-value storage.
+func (d *Driver) DeletePattern(pattern string) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	n := 0
+
+	for key, e := range d.items {
+		if fs.Match(pattern, key) {
+			n += d.drop(e, now)
+		}
+	}
+
+	return n, nil
+}
+
+// drop removes `e` and returns 1 if it is not expired at `now`.
+func (d *Driver) drop(e *entry, now int64) int {
+	if e.expired(now) {
+		d.expire(e)
+		return 0
+	}
+
+	d.remove(e)
+	d.emit(fs.EventDeleted, e.key)
+
+	return 1
+}
+
+// expire removes expired `e`.
+func (d *Driver) expire(e *entry) {
+	d.remove(e)
+	d.stats.Expirations++
+	d.emit(fs.EventExpired, e.key)
+}
+
+// GetContext gets key from key-value storage if `ctx` is not done.
+func (d *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return d.Get(key)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage if `ctx` is not done.
+func (d *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return d.Set(key, val, ttl)
+}
+
+// DeleteContext deletes key from key-value storage if `ctx` is not done.
+func (d *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return d.Delete(key)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage if `ctx` is not done.
+func (d *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return d.SetTags(key, val, ttl, tags)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage if `ctx` is not done.
+func (d *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return d.SetCond(key, val, opts)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage if `ctx` is not done.
+func (d *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return d.DeleteTag(tag)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage if `ctx` is not done.
+func (d *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return d.DeletePattern(pattern)
+}
+
+// Close calls to release key-value storage resources.
+func (d *Driver) Close() {
+	close(d.done)
+}
+
+// Stats returns the snapshot of `Driver` usage statistics.
+func (d *Driver) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	stats.Entries = int64(len(d.items))
+
+	return stats
+}
+
+func init() {
+	fs.Register("memory", func() interface{} { return &Options{} }, func(config interface{}) fs.Driver {
+		return New(config.(*Options))
+	})
+}
+
+// New returns "ready-to-use" `Driver` with in-process inner storage.
+func New(opts *Options) *Driver {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	if opts.MaxEntries < 0 {
+		log.Panicf("negative MaxEntries")
+	}
+
+	if opts.MaxMemory < 0 {
+		log.Panicf("negative MaxMemory")
+	}
+
+	if opts.Sweep == 0 {
+		opts.Sweep = defaultSweep
+	}
+
+	if opts.Sweep < 0 {
+		log.Panicf("negative Sweep")
+	}
+
+	d := &Driver{
+		items:  make(map[string]*entry),
+		tags:   make(map[string]map[string]struct{}),
+		locks:  make(map[string]*fs.Lease),
+		fences: make(map[string]int64),
+		notify: make(map[int]func(e *fs.Event)),
+		policy: newPolicy(opts.Policy),
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+
+	go d.janitor()
+
+	return d
+}
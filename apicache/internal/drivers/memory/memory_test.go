@@ -0,0 +1,612 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+const (
+	keyWithoutExpire = "key-without-expire"
+	valWithoutExpire = "val-without-expire"
+	withoutExpire    = 0 // seconds
+
+	keyWithShortExpire = "key-with-short-expire"
+	valWithShortExpire = "val-with-short-expire"
+	shortExpire        = 1 // seconds
+
+	keyNotExist   = "key-not-exist"
+	valNotExist   = ""
+	invalidExpire = -10 // seconds
+)
+
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(nil) })
+}
+
+func TestDriverGet(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+
+	cases := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{
+			name: "key exists",
+			key:  keyWithoutExpire,
+			want: valWithoutExpire,
+		},
+		{
+			name: "key not exists",
+			key:  keyNotExist,
+			want: valNotExist,
+		},
+		{
+			name: "key with expire exists",
+			key:  keyWithShortExpire,
+			want: valWithShortExpire,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := d.Get(c.key)
+
+			if got != c.want {
+				t.Errorf("Get() = %s, want = %s", got, c.want)
+			}
+
+			if err != nil {
+				t.Errorf("Get() error = %v, want = %v", err, nil)
+			}
+		})
+	}
+}
+
+func TestDriverGetKeyExpired(t *testing.T) {
+	d := New(&Options{Sweep: 100})
+	defer d.Close()
+
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+
+	time.Sleep(shortExpire * time.Second)
+
+	val, err := d.Get(keyWithShortExpire)
+	if val != valNotExist || err != nil {
+		t.Errorf("Get() = %s, %v, want = %s, %v", val, err, valNotExist, nil)
+	}
+
+	if s := d.Stats(); s.Expirations != 1 || s.Misses != 1 || s.Entries != 0 {
+		t.Errorf("Stats() = %+v, want 1 expiration and 1 miss", s)
+	}
+}
+
+func TestDriverJanitor(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+
+	time.Sleep(2 * shortExpire * time.Second)
+
+	if s := d.Stats(); s.Entries != 0 || s.Memory != 0 || s.Expirations != 1 {
+		t.Errorf("Stats() = %+v, key not expired without access", s)
+	}
+}
+
+func TestDriverSetNegativeTTL(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+
+	err := d.Set(keyWithoutExpire, valWithoutExpire, invalidExpire)
+	if err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	val, _ := d.Get(keyWithoutExpire)
+	if val != valNotExist {
+		t.Errorf("Set() set but doesn't")
+	}
+}
+
+func TestDriverSetUpdateExpireOnKey(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, shortExpire)
+	_ = d.Set(keyWithShortExpire, valWithShortExpire, withoutExpire)
+
+	time.Sleep(2 * shortExpire * time.Second)
+
+	val, _ := d.Get(keyWithShortExpire)
+	if val != valWithShortExpire {
+		t.Errorf("Set() doesn't update expiration")
+	}
+}
+
+func TestDriverSetCond(t *testing.T) {
+	d := New(&Options{Sweep: 100})
+	defer d.Close()
+
+	cases := []struct {
+		name string
+		opts *fs.SetOptions
+		want bool
+		val  string
+	}{
+		{"xx missed", &fs.SetOptions{Mode: fs.ModeXX, NoExpire: true}, false, valNotExist},
+		{"nx missed", &fs.SetOptions{Mode: fs.ModeNX, TTL: 50 * time.Millisecond}, true, "nx"},
+		{"nx existed", &fs.SetOptions{Mode: fs.ModeNX, NoExpire: true}, false, "nx"},
+		{"xx existed", &fs.SetOptions{Mode: fs.ModeXX, TTL: 50 * time.Millisecond}, true, "xx existed"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			val := c.name
+			if c.opts.Mode == fs.ModeNX {
+				val = "nx"
+			}
+
+			if ok, err := d.SetCond(keyWithShortExpire, val, c.opts); ok != c.want || err != nil {
+				t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
+
+			if got, _ := d.Get(keyWithShortExpire); got != c.val {
+				t.Errorf("Get() got = %v, want = %v", got, c.val)
+			}
+		})
+	}
+
+	// milliseconds "time-to-live" is kept precisely, expired key is missed for "nx"
+	time.Sleep(60 * time.Millisecond)
+
+	opts := &fs.SetOptions{Mode: fs.ModeNX, ExpireAt: time.Now().Add(time.Hour)}
+	if ok, _ := d.SetCond(keyWithShortExpire, "at", opts); !ok {
+		t.Errorf("SetCond() of expired got = %v, want = %v", ok, true)
+	}
+
+	if s := d.Stats(); s.Expirations != 1 || s.Entries != 1 {
+		t.Errorf("Stats() = %+v, want 1 expiration and 1 entry", s)
+	}
+}
+
+func TestDriverLock(t *testing.T) {
+	d := New(&Options{MaxEntries: 1, Sweep: 100})
+	defer d.Close()
+
+	const name = "lock"
+
+	cases := []struct {
+		name  string
+		op    func() (*fs.Lease, bool, error)
+		want  bool
+		token int64
+	}{
+		{"acquire", func() (*fs.Lease, bool, error) { return d.Acquire(name, "a", time.Second) }, true, 1},
+		{"acquire held", func() (*fs.Lease, bool, error) { return d.Acquire(name, "b", time.Second) }, false, 0},
+		{"acquire again", func() (*fs.Lease, bool, error) { return d.Acquire(name, "a", time.Second) }, true, 1},
+		{"renew other", func() (*fs.Lease, bool, error) { return d.Renew(name, "b", time.Second) }, false, 0},
+		{"renew", func() (*fs.Lease, bool, error) { return d.Renew(name, "a", 50*time.Millisecond) }, true, 1},
+		{
+			"release other",
+			func() (*fs.Lease, bool, error) { ok, err := d.Release(name, "b"); return nil, ok, err },
+			false, 0,
+		},
+		{
+			"release",
+			func() (*fs.Lease, bool, error) { ok, err := d.Release(name, "a"); return nil, ok, err },
+			true, 0,
+		},
+		{"acquire released", func() (*fs.Lease, bool, error) { return d.Acquire(name, "b", 50*time.Millisecond) }, true, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			lease, ok, err := c.op()
+			if ok != c.want || err != nil {
+				t.Errorf("got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
+
+			if lease != nil && lease.Token != c.token {
+				t.Errorf("token got = %v, want = %v", lease.Token, c.token)
+			}
+		})
+	}
+
+	// lease is not evicted by keys
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, 0)
+
+	// expired lock is granted to another owner with the next token
+	time.Sleep(60 * time.Millisecond)
+
+	if lease, ok, _ := d.Acquire(name, "c", time.Second); !ok || lease.Token != 3 {
+		t.Errorf("Acquire() of expired got = %+v, %v, want token = %v", lease, ok, 3)
+	}
+
+	if s := d.Stats(); s.Entries != 1 || s.Evictions != 0 {
+		t.Errorf("Stats() = %+v, want 1 entry and no evictions", s)
+	}
+}
+
+func TestDriverNotify(t *testing.T) {
+	d := New(&Options{Sweep: 100})
+	defer d.Close()
+
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	_ = d.Notify(ctx, func(e *fs.Event) {
+		mu.Lock()
+		events = append(events, e.Type+":"+e.Key)
+		mu.Unlock()
+	})
+
+	_ = d.Set("deleted", valWithoutExpire, 0)
+	_ = d.Set("negative", valWithoutExpire, 0)
+	_ = d.SetTags("tagged", valWithoutExpire, 0, []string{"tag"})
+	_, _ = d.SetCond("expired", valWithoutExpire, &fs.SetOptions{TTL: time.Millisecond})
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, _ = d.Delete("deleted")
+	_ = d.Set("negative", valWithoutExpire, -1)
+	_, _ = d.DeleteTag("tag")
+	_, _ = d.Get("expired")
+	_, _ = d.Delete("missed")
+
+	// listener is removed when `ctx` is done
+	cancel()
+	time.Sleep(5 * time.Millisecond)
+
+	_ = d.Set("canceled", valWithoutExpire, 0)
+	_, _ = d.Delete("canceled")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{"deleted:deleted", "deleted:negative", "deleted:tagged", "expired:expired"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("Notify() got = %v, want = %v", events, want)
+	}
+}
+
+func TestDriverDelete(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+
+	ok, err := d.Delete(keyWithoutExpire)
+	if !ok || err != nil {
+		t.Errorf("Delete() = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	ok, err = d.Delete(keyWithoutExpire)
+	if ok || err != nil {
+		t.Errorf("Delete() = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	if s := d.Stats(); s.Entries != 0 || s.Memory != 0 {
+		t.Errorf("Stats() = %+v, want empty storage", s)
+	}
+}
+
+func TestDriverDeleteTag(t *testing.T) {
+	d := New(&Options{Sweep: 100})
+	defer d.Close()
+
+	_ = d.SetTags("user:1", "a", withoutExpire, []string{"users", "team"})
+	_ = d.SetTags("user:2", "b", withoutExpire, []string{"users"})
+	_ = d.SetTags("user:3", "c", shortExpire, []string{"users"})
+	_ = d.SetTags("team:1", "d", withoutExpire, []string{"team"})
+	// overwrite replaces tags
+	_ = d.SetTags("user:2", "b", withoutExpire, []string{"others"})
+
+	time.Sleep(2 * shortExpire * time.Second)
+
+	n, err := d.DeleteTag("users")
+	if n != 1 || err != nil {
+		t.Errorf("DeleteTag() = %d, %v, want = %d, %v", n, err, 1, nil)
+	}
+
+	for key, want := range map[string]string{"user:1": "", "user:2": "b", "user:3": "", "team:1": "d"} {
+		if val, _ := d.Get(key); val != want {
+			t.Errorf("Get(%s) = %s, want = %s", key, val, want)
+		}
+	}
+
+	// tag of deleted key is gone from the index
+	n, _ = d.DeleteTag("team")
+	if n != 1 {
+		t.Errorf("DeleteTag() = %d, want = %d", n, 1)
+	}
+
+	_, _ = d.Delete("user:2")
+
+	if len(d.tags) != 0 {
+		t.Errorf("DeleteTag() index is not cleaned = %v", d.tags)
+	}
+}
+
+func TestDriverDeletePattern(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	for _, key := range []string{"user:42:name", "user:42:mail", "user:420:name", "user:43:name"} {
+		_ = d.Set(key, "val", withoutExpire)
+	}
+
+	n, err := d.DeletePattern("user:42:*")
+	if n != 2 || err != nil {
+		t.Errorf("DeletePattern() = %d, %v, want = %d, %v", n, err, 2, nil)
+	}
+
+	if s := d.Stats(); s.Entries != 2 {
+		t.Errorf("Stats() = %+v, want 2 entries", s)
+	}
+}
+
+func TestDriverEviction(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  string
+		prepare func(d *Driver)
+		evicted string
+	}{
+		{
+			name:   "lru",
+			policy: PolicyLRU,
+			prepare: func(d *Driver) {
+				_, _ = d.Get("0")
+				_, _ = d.Get("2")
+			},
+			evicted: "1",
+		},
+		{
+			name:   "lfu",
+			policy: PolicyLFU,
+			prepare: func(d *Driver) {
+				_, _ = d.Get("0")
+				_, _ = d.Get("0")
+				_, _ = d.Get("1")
+				_, _ = d.Get("2")
+				_, _ = d.Get("2")
+			},
+			evicted: "1",
+		},
+		{
+			name:   "volatile-ttl",
+			policy: PolicyVolatileTTL,
+			prepare: func(d *Driver) {
+				_ = d.Set("0", "0", withoutExpire)
+				_ = d.Set("1", "1", 100)
+				_ = d.Set("2", "2", 10)
+			},
+			evicted: "2",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := New(&Options{MaxEntries: 3, Policy: c.policy})
+			defer d.Close()
+
+			for i := 0; i < 3; i++ {
+				_ = d.Set(strconv.Itoa(i), strconv.Itoa(i), withoutExpire)
+			}
+
+			c.prepare(d)
+
+			if err := d.Set("3", "3", withoutExpire); err != nil {
+				t.Errorf("Set() error = %v, want = %v", err, nil)
+			}
+
+			if val, _ := d.Get(c.evicted); val != valNotExist {
+				t.Errorf("Set() key (%s) not evicted", c.evicted)
+			}
+
+			if s := d.Stats(); s.Entries != 3 || s.Evictions != 1 {
+				t.Errorf("Stats() = %+v, want 3 entries and 1 eviction", s)
+			}
+		})
+	}
+}
+
+func TestDriverEvictionRandom(t *testing.T) {
+	d := New(&Options{MaxEntries: 10, Policy: PolicyRandom})
+	defer d.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := d.Set(strconv.Itoa(i), strconv.Itoa(i), withoutExpire); err != nil {
+			t.Errorf("Set() error = %v, want = %v", err, nil)
+		}
+	}
+
+	if s := d.Stats(); s.Entries != 10 || s.Evictions != 90 {
+		t.Errorf("Stats() = %+v, want 10 entries and 90 evictions", s)
+	}
+
+	if val, _ := d.Get("99"); val != "99" {
+		t.Errorf("Set() last key evicted")
+	}
+}
+
+func TestDriverEvictionMaxMemory(t *testing.T) {
+	size := entrySize("0", "0")
+	d := New(&Options{MaxMemory: 2 * size})
+	defer d.Close()
+
+	for i := 0; i < 3; i++ {
+		_ = d.Set(strconv.Itoa(i), strconv.Itoa(i), withoutExpire)
+	}
+
+	if s := d.Stats(); s.Entries != 2 || s.Memory != 2*size || s.Evictions != 1 {
+		t.Errorf("Stats() = %+v, want 2 entries and 1 eviction", s)
+	}
+
+	var eis *fs.ErrInsufficientStorage
+
+	err := d.Set(keyWithoutExpire, strings.Repeat(valWithoutExpire, int(size)), withoutExpire)
+	if !errors.As(err, &eis) {
+		t.Errorf("Set() error = %v, want = %v", err, fs.NewErrInsufficientStorage(keyWithoutExpire))
+	}
+
+	if s := d.Stats(); s.Entries != 2 || s.Rejections != 1 {
+		t.Errorf("Stats() = %+v, too large entry evicts storage", s)
+	}
+}
+
+func TestDriverEvictionInfeasible(t *testing.T) {
+	size := entrySize("0", "0")
+	d := New(&Options{MaxMemory: 3 * size, Policy: PolicyVolatileTTL})
+	defer d.Close()
+
+	_ = d.Set("0", "0", withoutExpire)
+	_ = d.Set("1", "1", 10)
+	_ = d.Set("2", "2", 10)
+
+	// fits only if non-volatile "0" is evicted
+	var eis *fs.ErrInsufficientStorage
+
+	err := d.Set("3", strings.Repeat("3", int(2*size)), 10)
+	if !errors.As(err, &eis) {
+		t.Errorf("Set() error = %v, want = %v", err, fs.NewErrInsufficientStorage("3"))
+	}
+
+	for _, key := range []string{"0", "1", "2"} {
+		if val, _ := d.Get(key); val != key {
+			t.Errorf("Get(%s) got = %s, want = %s", key, val, key)
+		}
+	}
+
+	if s := d.Stats(); s.Entries != 3 || s.Rejections != 1 || s.Evictions != 0 {
+		t.Errorf("Stats() = %+v, want 3 entries, 1 rejection and no evictions", s)
+	}
+}
+
+func TestDriverRejection(t *testing.T) {
+	cases := []struct {
+		name   string
+		policy string
+		ttl    int
+	}{
+		{
+			name:   "noeviction",
+			policy: PolicyNoEviction,
+			ttl:    100,
+		},
+		{
+			name:   "volatile-ttl without volatile keys",
+			policy: PolicyVolatileTTL,
+			ttl:    withoutExpire,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := New(&Options{MaxEntries: 1, Policy: c.policy})
+			defer d.Close()
+
+			_ = d.Set(keyWithoutExpire, valWithoutExpire, c.ttl)
+			_ = d.Set(keyWithoutExpire, valWithShortExpire, c.ttl)
+
+			var eis *fs.ErrInsufficientStorage
+
+			err := d.Set(keyNotExist, valWithoutExpire, withoutExpire)
+			if !errors.As(err, &eis) {
+				t.Errorf("Set() error = %v, want = %v", err, fs.NewErrInsufficientStorage(keyNotExist))
+			}
+
+			if c.policy == PolicyNoEviction {
+				// rejected write must not harm the replaced key
+				if val, _ := d.Get(keyWithoutExpire); val != valWithShortExpire {
+					t.Errorf("Set() replaced key lost = %s", val)
+				}
+			}
+
+			if s := d.Stats(); s.Entries != 1 || s.Rejections != 1 || s.Evictions != 0 {
+				t.Errorf("Stats() = %+v, want 1 entry and 1 rejection", s)
+			}
+		})
+	}
+}
+
+func TestDriverContextCanceled(t *testing.T) {
+	d := New(nil)
+	defer d.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := d.SetContext(ctx, keyWithoutExpire, valWithoutExpire, withoutExpire); !errors.Is(err, context.Canceled) {
+		t.Errorf("SetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if _, err := d.GetContext(ctx, keyWithoutExpire); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if _, err := d.DeleteContext(ctx, keyWithoutExpire); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	val, err := d.GetContext(context.Background(), keyWithoutExpire)
+	if val != valNotExist || err != nil {
+		t.Errorf("GetContext() = %s, %v, canceled Set() happened", val, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *Options
+		err  string
+	}{
+		{
+			name: "negative MaxEntries",
+			opts: &Options{MaxEntries: -1},
+			err:  "negative MaxEntries",
+		},
+		{
+			name: "negative MaxMemory",
+			opts: &Options{MaxMemory: -1},
+			err:  "negative MaxMemory",
+		},
+		{
+			name: "negative Sweep",
+			opts: &Options{Sweep: -1},
+			err:  "negative Sweep",
+		},
+		{
+			name: "unknown policy",
+			opts: &Options{Policy: "fifo"},
+			err:  "unknown eviction policy (fifo)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err.(string) != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// Notify calls `fn` for every expired or deleted key until `ctx` is done.
+// Expired key is reported as soon as it is accessed or swept by janitor (every `Options.Sweep` seconds),
+// evicted keys are not reported.
+func (d *Driver) Notify(ctx context.Context, fn func(e *fs.Event)) error {
+	d.mu.Lock()
+	id := d.next
+	d.next++
+	d.notify[id] = fn
+	d.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-d.done:
+		}
+
+		d.mu.Lock()
+		delete(d.notify, id)
+		d.mu.Unlock()
+	}()
+
+	return nil
+}
+
+// emit reports event of `typ` for `key` to listeners, it must be called under `mu`.
+func (d *Driver) emit(typ, key string) {
+	if len(d.notify) == 0 {
+		return
+	}
+
+	e := &fs.Event{Type: typ, Key: key, Time: time.Now()}
+
+	for _, fn := range d.notify {
+		fn(e)
+	}
+}
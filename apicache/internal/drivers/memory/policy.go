@@ -0,0 +1,263 @@
+package memory
+
+import (
+	"container/heap"
+	"container/list"
+	"log"
+	"math/rand"
+)
+
+// Eviction policies names available for `Options.Policy`.
+const (
+	PolicyLRU         = "lru"
+	PolicyLFU         = "lfu"
+	PolicyRandom      = "random"
+	PolicyVolatileTTL = "volatile-ttl"
+	PolicyNoEviction  = "noeviction"
+)
+
+// policy decides which entry must be evicted when `Driver` is full.
+// All methods are called under `Driver` lock.
+type policy interface {
+	// add starts tracking of `e`.
+	add(e *entry)
+	// touch marks `e` as accessed.
+	touch(e *entry)
+	// remove stops tracking of `e`.
+	remove(e *entry)
+	// victim returns the next entry to evict or `nil` if nothing can be evicted.
+	victim() *entry
+	// evictable returns the number and the size of entries which can be evicted.
+	evictable() (int, int64)
+}
+
+// usage counts entries tracked by `policy` as evictable.
+type usage struct {
+	entries int
+	size    int64
+}
+
+func (u *usage) inc(e *entry) {
+	u.entries++
+	u.size += e.size
+}
+
+func (u *usage) dec(e *entry) {
+	u.entries--
+	u.size -= e.size
+}
+
+func (u *usage) evictable() (int, int64) {
+	return u.entries, u.size
+}
+
+// newPolicy returns `policy` by it's `name`.
+// Panics if `name` is unknown.
+func newPolicy(name string) policy {
+	switch name {
+	case PolicyLRU, "":
+		return &lru{list: list.New()}
+	case PolicyLFU:
+		return &lfu{entries: entryHeap{less: byFrequency}}
+	case PolicyRandom:
+		return &random{}
+	case PolicyVolatileTTL:
+		return &volatileTTL{entries: entryHeap{less: byExpiration}}
+	case PolicyNoEviction:
+		return noEviction{}
+	default:
+		log.Panicf("unknown eviction policy (%s)", name)
+	}
+
+	return nil
+}
+
+// lru evicts the least recently used entry.
+type lru struct {
+	usage
+	list *list.List
+}
+
+func (p *lru) add(e *entry) {
+	p.inc(e)
+	e.elem = p.list.PushFront(e)
+}
+
+func (p *lru) touch(e *entry) {
+	p.list.MoveToFront(e.elem)
+}
+
+func (p *lru) remove(e *entry) {
+	p.dec(e)
+	p.list.Remove(e.elem)
+	e.elem = nil
+}
+
+func (p *lru) victim() *entry {
+	back := p.list.Back()
+	if back == nil {
+		return nil
+	}
+
+	return back.Value.(*entry)
+}
+
+// lfu evicts the least frequently used entry,
+// the oldest accessed one wins among entries with the same frequency.
+type lfu struct {
+	usage
+	entries entryHeap
+	tick    int64
+}
+
+func (p *lfu) add(e *entry) {
+	p.inc(e)
+	p.tick++
+	e.tick = p.tick
+	heap.Push(&p.entries, e)
+}
+
+func (p *lfu) touch(e *entry) {
+	p.tick++
+	e.tick = p.tick
+	e.freq++
+	heap.Fix(&p.entries, e.index)
+}
+
+func (p *lfu) remove(e *entry) {
+	p.dec(e)
+	heap.Remove(&p.entries, e.index)
+}
+
+func (p *lfu) victim() *entry {
+	if len(p.entries.items) == 0 {
+		return nil
+	}
+
+	return p.entries.items[0]
+}
+
+// random evicts any entry.
+type random struct {
+	usage
+	entries []*entry
+}
+
+func (p *random) add(e *entry) {
+	p.inc(e)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *random) touch(*entry) {}
+
+func (p *random) remove(e *entry) {
+	p.dec(e)
+
+	last := len(p.entries) - 1
+
+	p.entries[e.index] = p.entries[last]
+	p.entries[e.index].index = e.index
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+}
+
+func (p *random) victim() *entry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+
+	return p.entries[rand.Intn(len(p.entries))] // nolint:gosec
+}
+
+// volatileTTL evicts the entry with the nearest expiration.
+// Entries without expiration are never evicted.
+type volatileTTL struct {
+	usage
+	entries entryHeap
+}
+
+func (p *volatileTTL) add(e *entry) {
+	e.index = -1
+
+	if e.expire != 0 {
+		p.inc(e)
+		heap.Push(&p.entries, e)
+	}
+}
+
+func (p *volatileTTL) touch(*entry) {}
+
+func (p *volatileTTL) remove(e *entry) {
+	if e.index >= 0 {
+		p.dec(e)
+		heap.Remove(&p.entries, e.index)
+	}
+}
+
+func (p *volatileTTL) victim() *entry {
+	if len(p.entries.items) == 0 {
+		return nil
+	}
+
+	return p.entries.items[0]
+}
+
+// noEviction never evicts, so all writes over limits are rejected.
+type noEviction struct{}
+
+func (noEviction) add(*entry)              {}
+func (noEviction) touch(*entry)            {}
+func (noEviction) remove(*entry)           {}
+func (noEviction) victim() *entry          { return nil }
+func (noEviction) evictable() (int, int64) { return 0, 0 }
+
+// byFrequency orders entries by access frequency and then by last access.
+func byFrequency(a, b *entry) bool {
+	if a.freq != b.freq {
+		return a.freq < b.freq
+	}
+
+	return a.tick < b.tick
+}
+
+// byExpiration orders entries by expiration time.
+func byExpiration(a, b *entry) bool {
+	return a.expire < b.expire
+}
+
+// entryHeap implements `heap.Interface` ordered by `less`.
+type entryHeap struct {
+	items []*entry
+	less  func(a, b *entry) bool
+}
+
+func (h *entryHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *entryHeap) Less(i, j int) bool {
+	return h.less(h.items[i], h.items[j])
+}
+
+func (h *entryHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(h.items)
+	h.items = append(h.items, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	last := len(h.items) - 1
+	e := h.items[last]
+	h.items[last] = nil
+	h.items = h.items[:last]
+	e.index = -1
+
+	return e
+}
@@ -0,0 +1,148 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// lockPrefix is the prefix of lock keys, value of lock is "<token>:<owner>"
+// and it's fencing token counter is kept forever at the key with ":fence" suffix.
+// Name is the hash tag, so both keys are in the same cluster slot.
+const lockPrefix = "_lock:"
+
+// Lua scripts of locks, KEYS are the lock and it's fencing counter, ARGV are owner and "time-to-live" in milliseconds.
+// Every script returns fencing token of the lock or 0 if it is held by another owner.
+const (
+	acquireSrc = `
+local cur = redis.call('get', KEYS[1])
+local token
+if cur then
+	local sep = string.find(cur, ':', 1, true)
+	if string.sub(cur, sep + 1) ~= ARGV[1] then
+		return 0
+	end
+	token = tonumber(string.sub(cur, 1, sep - 1))
+else
+	token = redis.call('incr', KEYS[2])
+end
+redis.call('set', KEYS[1], token .. ':' .. ARGV[1], 'px', ARGV[2])
+return token
+`
+	renewSrc = `
+local cur = redis.call('get', KEYS[1])
+if not cur then
+	return 0
+end
+local sep = string.find(cur, ':', 1, true)
+if string.sub(cur, sep + 1) ~= ARGV[1] then
+	return 0
+end
+redis.call('set', KEYS[1], cur, 'px', ARGV[2])
+return tonumber(string.sub(cur, 1, sep - 1))
+`
+	releaseSrc = `
+local cur = redis.call('get', KEYS[1])
+if not cur then
+	return 0
+end
+local sep = string.find(cur, ':', 1, true)
+if string.sub(cur, sep + 1) ~= ARGV[1] then
+	return 0
+end
+redis.call('del', KEYS[1])
+return tonumber(string.sub(cur, 1, sep - 1))
+`
+)
+
+// script is Lua script with it's SHA1 digest.
+type script struct {
+	src string
+	sha string
+}
+
+var (
+	acquireScript = newScript(acquireSrc)
+	renewScript   = newScript(renewSrc)
+	releaseScript = newScript(releaseSrc)
+)
+
+// newScript returns `script` of `src`.
+func newScript(src string) *script {
+	return &script{src: src, sha: redis.NewScript(src).Hash()}
+}
+
+// lockKeys returns the key of lock `name` and it's fencing counter.
+func lockKeys(name string) []string {
+	key := lockPrefix + "{" + name + "}"
+	return []string{key, key + ":fence"}
+}
+
+// lock runs `script` on lock `name` and returns it's lease granted at `now` for `ttl`.
+func (r *Driver) lock(ctx context.Context, script *script, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	px := int64(ttl / time.Millisecond)
+	now := time.Now()
+
+	token, err := r.run(ctx, script, lockKeys(name), owner, px)
+	if err != nil || token == 0 {
+		return nil, false, err
+	}
+
+	return &fs.Lease{Name: name, Owner: owner, Token: token, Expire: now.Add(time.Duration(px) * time.Millisecond)}, true, nil
+}
+
+// run runs `script` with `keys` and `args` by `EVALSHA`,
+// it is sent by `EVAL` if server has no cached one (as `redis.Script.Run()` does, but until `ctx` is done).
+func (r *Driver) run(ctx context.Context, script *script, keys []string, args ...interface{}) (int64, error) {
+	argv := make([]interface{}, 0, len(keys)+len(args))
+	for _, key := range keys {
+		argv = append(argv, key)
+	}
+
+	argv = append(argv, args...)
+
+	cmd := redis.NewIntCmd(append([]interface{}{"evalsha", script.sha, len(keys)}, argv...)...)
+	_ = r.storage.ProcessContext(ctx, cmd)
+
+	if err := cmd.Err(); err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT ") {
+		cmd = redis.NewIntCmd(append([]interface{}{"eval", script.src, len(keys)}, argv...)...)
+		_ = r.storage.ProcessContext(ctx, cmd)
+	}
+
+	return cmd.Result()
+}
+
+// Acquire grants lock `name` to `owner` for `ttl`, `ok` is false if it is held by another owner.
+func (r *Driver) Acquire(name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return r.AcquireContext(context.Background(), name, owner, ttl)
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Lock is checked, granted and counted by Lua script atomically.
+func (r *Driver) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return r.lock(ctx, acquireScript, name, owner, ttl)
+}
+
+// Renew extends lock `name` for `ttl`, `ok` is false if it is not held by `owner`.
+func (r *Driver) Renew(name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return r.RenewContext(context.Background(), name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+func (r *Driver) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	return r.lock(ctx, renewScript, name, owner, ttl)
+}
+
+// Release releases lock `name`, `ok` is false if it is not held by `owner`.
+func (r *Driver) Release(name, owner string) (bool, error) {
+	return r.ReleaseContext(context.Background(), name, owner)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+func (r *Driver) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	token, err := r.run(ctx, releaseScript, lockKeys(name), owner)
+	return token != 0, err
+}
@@ -0,0 +1,174 @@
+package redis
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// realInstance is the env variable with address of real redis running lock Lua scripts (tests are skipped if unset).
+const realInstance = "APICACHE_TEST_REDIS"
+
+// parseLock splits lock value "<token>:<owner>" as Lua scripts do.
+func parseLock(val interface{}) (int64, string) {
+	parts := strings.SplitN(val.(string), ":", 2)
+	token, _ := strconv.ParseInt(parts[0], 10, 64)
+
+	return token, parts[1]
+}
+
+// emulateLocks registers Go emulation of lock Lua scripts on test server.
+// It tests only the driver side of scripts (keys, arguments and replies),
+// scripts themselves are tested by `TestDriverLockScripts` against real redis.
+func emulateLocks() {
+	srv.Script(acquireSrc, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		var token int64
+
+		if cur := call("get", keys[0]); cur != nil {
+			held, owner := parseLock(cur)
+			if owner != argv[0] {
+				return int64(0)
+			}
+
+			token = held
+		} else {
+			token = call("incr", keys[1]).(int64)
+		}
+
+		call("set", keys[0], strconv.FormatInt(token, 10)+":"+argv[0], "px", argv[1])
+
+		return token
+	})
+
+	srv.Script(renewSrc, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		cur := call("get", keys[0])
+		if cur == nil {
+			return int64(0)
+		}
+
+		token, owner := parseLock(cur)
+		if owner != argv[0] {
+			return int64(0)
+		}
+
+		call("set", keys[0], cur.(string), "px", argv[1])
+
+		return token
+	})
+
+	srv.Script(releaseSrc, func(call func(args ...string) interface{}, keys, argv []string) interface{} {
+		cur := call("get", keys[0])
+		if cur == nil {
+			return int64(0)
+		}
+
+		token, owner := parseLock(cur)
+		if owner != argv[0] {
+			return int64(0)
+		}
+
+		call("del", keys[0])
+
+		return token
+	})
+}
+
+func TestDriverLock(t *testing.T) {
+	emulateLocks()
+
+	r := New(&Options{Addr: testInstance})
+	defer r.Close()
+
+	testLocks(t, r, 10*time.Second, srv.FastForward)
+
+	// lock is not deleted by pattern
+	if n, _ := r.DeletePattern(lockPrefix + "*"); n != 0 {
+		t.Errorf("DeletePattern() got = %v, want = %v", n, 0)
+	}
+
+	srv.SetError("evalsha", "ERR injected")
+	defer srv.SetError("evalsha", "")
+
+	if _, err := r.Release("lock", "c"); err == nil || err.Error() != "ERR injected" {
+		t.Errorf("Release() error = %v, want = %v", err, "ERR injected")
+	}
+}
+
+func TestDriverLockScripts(t *testing.T) {
+	addr := os.Getenv(realInstance)
+	if addr == "" {
+		t.Skipf("%s is not set", realInstance)
+	}
+
+	r := New(&Options{Addr: addr})
+	defer r.Close()
+
+	if err := r.storage.Del(lockKeys("lock")...).Err(); err != nil {
+		t.Fatalf("Del() error = %v, want = %v", err, nil)
+	}
+
+	// scripts are loaded by `EVAL` fallback
+	if err := r.storage.ScriptFlush().Err(); err != nil {
+		t.Fatalf("ScriptFlush() error = %v, want = %v", err, nil)
+	}
+
+	testLocks(t, r, 500*time.Millisecond, time.Sleep)
+}
+
+// testLocks checks lease lifecycle of "lock" granted for `ttl`, `expire` waits until given duration is passed.
+func testLocks(t *testing.T, r *Driver, ttl time.Duration, expire func(time.Duration)) {
+	const name = "lock"
+
+	cases := []struct {
+		name  string
+		op    func() (bool, error)
+		want  bool
+		token int64
+	}{
+		{"acquire", func() (bool, error) { _, ok, err := r.Acquire(name, "a", ttl); return ok, err }, true, 1},
+		{"acquire held", func() (bool, error) { _, ok, err := r.Acquire(name, "b", ttl); return ok, err }, false, 1},
+		{"acquire again", func() (bool, error) { _, ok, err := r.Acquire(name, "a", ttl); return ok, err }, true, 1},
+		{"renew other", func() (bool, error) { _, ok, err := r.Renew(name, "b", ttl); return ok, err }, false, 1},
+		{"renew", func() (bool, error) { _, ok, err := r.Renew(name, "a", 2*ttl); return ok, err }, true, 1},
+		{"release other", func() (bool, error) { return r.Release(name, "b") }, false, 1},
+		{"release", func() (bool, error) { return r.Release(name, "a") }, true, 0},
+		{"release released", func() (bool, error) { return r.Release(name, "a") }, false, 0},
+		{"acquire released", func() (bool, error) { _, ok, err := r.Acquire(name, "b", ttl); return ok, err }, true, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ok, err := c.op(); ok != c.want || err != nil {
+				t.Errorf("got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
+
+			var token int64
+			if val, err := r.storage.Get(lockKeys(name)[0]).Result(); err == nil {
+				token, _ = parseLock(val)
+			}
+
+			if token != c.token {
+				t.Errorf("token got = %v, want = %v", token, c.token)
+			}
+		})
+	}
+
+	// expired lock is granted to another owner with the next token
+	expire(ttl + 10*time.Millisecond)
+
+	lease, ok, err := r.Acquire(name, "c", ttl)
+	if !ok || err != nil || lease.Token != 3 || lease.Owner != "c" {
+		t.Errorf("Acquire() got = %+v, %v, %v, want token = %v", lease, ok, err, 3)
+	}
+
+	want := int64(ttl / time.Millisecond)
+	if pttl, _ := r.storage.Do("pttl", lockKeys(name)[0]).Int64(); pttl > want || pttl < want-100 {
+		t.Errorf("PTTL() got = %v, want = %v", pttl, want)
+	}
+
+	// renewed lease keeps the token
+	if lease, ok, err := r.Renew(name, "c", ttl); !ok || err != nil || lease.Token != 3 {
+		t.Errorf("Renew() got = %+v, %v, %v, want token = %v", lease, ok, err, 3)
+	}
+}
@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	notifyKeyspaceEvents = "notify-keyspace-events"
+	// keyspaceClasses are required classes of keyspace notifications:
+	// "E" keyevent events, "g" generic commands (`DEL`) and "x" expired events.
+	keyspaceClasses = "Egx"
+)
+
+// subscriber is a `redis` client of the single node receiving keyspace notifications.
+type subscriber interface {
+	ConfigGet(parameter string) *redis.SliceCmd
+	ConfigSet(parameter, value string) *redis.StatusCmd
+	PSubscribe(channels ...string) *redis.PubSub
+}
+
+// keyspaceFlags returns `current` "notify-keyspace-events" flags extended by `keyspaceClasses`.
+func keyspaceFlags(current string) string {
+	flags := current
+
+	for _, class := range keyspaceClasses {
+		if !strings.ContainsRune(flags, class) && (class == 'E' || !strings.ContainsRune(flags, 'A')) {
+			flags += string(class)
+		}
+	}
+
+	return flags
+}
+
+// enable enables keyspace notifications of `node` keeping already enabled ones.
+func enable(node subscriber) error {
+	current, err := node.ConfigGet(notifyKeyspaceEvents).Result()
+	if err != nil {
+		return err
+	}
+
+	flags := ""
+	if len(current) == 2 {
+		flags, _ = current[1].(string)
+	}
+
+	if want := keyspaceFlags(flags); want != flags {
+		return node.ConfigSet(notifyKeyspaceEvents, want).Err()
+	}
+
+	return nil
+}
+
+// nodes returns clients of all masters (or the single node) to subscribe to.
+func (r *Driver) nodes() []subscriber {
+	cluster, ok := r.storage.(*redis.ClusterClient)
+	if !ok {
+		return []subscriber{r.storage}
+	}
+
+	var (
+		mu    sync.Mutex
+		nodes []subscriber
+	)
+
+	_ = cluster.ForEachMaster(func(client *redis.Client) error {
+		mu.Lock()
+		nodes = append(nodes, client)
+		mu.Unlock()
+
+		return nil
+	})
+
+	return nodes
+}
+
+// Notify calls `fn` for every expired or deleted key until `ctx` is done.
+// Keys are reported by redis keyspace notifications ("__keyevent@<db>__:expired" and "__keyevent@<db>__:del"),
+// they are enabled on server if it allows `CONFIG SET` (otherwise they must be enabled by "notify-keyspace-events").
+// Notifications are not persisted, so keys expired while subscription is broken are not reported.
+// In cluster mode masters known at the moment of the call are subscribed.
+func (r *Driver) Notify(ctx context.Context, fn func(e *fs.Event)) error {
+	channels := []string{
+		fmt.Sprintf("__keyevent@%d__:expired", r.db),
+		fmt.Sprintf("__keyevent@%d__:del", r.db),
+	}
+
+	subs := make([]*redis.PubSub, 0)
+
+	for _, node := range r.nodes() {
+		if err := enable(node); err != nil {
+			log.Printf("redis keyspace notifications config err = %v", err)
+		}
+
+		pubsub := node.PSubscribe(channels...)
+
+		// confirmation of subscription
+		if _, err := pubsub.Receive(); err != nil {
+			_ = pubsub.Close()
+
+			for _, sub := range subs {
+				_ = sub.Close()
+			}
+
+			return err
+		}
+
+		subs = append(subs, pubsub)
+	}
+
+	for _, pubsub := range subs {
+		go listen(ctx, pubsub, fn)
+	}
+
+	return nil
+}
+
+// listen calls `fn` for keys of `pubsub` messages until `ctx` is done.
+// Internal keys of tag indexes and locks are skipped.
+func listen(ctx context.Context, pubsub *redis.PubSub, fn func(e *fs.Event)) {
+	defer func() { _ = pubsub.Close() }()
+
+	messages := pubsub.Channel()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+
+			if internal(msg.Payload) {
+				continue
+			}
+
+			typ := fs.EventDeleted
+			if strings.HasSuffix(msg.Channel, ":expired") {
+				typ = fs.EventExpired
+			}
+
+			fn(&fs.Event{Type: typ, Key: msg.Payload, Time: time.Now()})
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+func TestKeyspaceFlags(t *testing.T) {
+	cases := []struct {
+		current string
+		want    string
+	}{
+		{"", "Egx"},
+		{"Egx", "Egx"},
+		{"KEA", "KEA"},
+		{"Kx", "KxEg"},
+		{"A", "AE"},
+	}
+	for _, c := range cases {
+		if got := keyspaceFlags(c.current); got != c.want {
+			t.Errorf("keyspaceFlags(%q) got = %v, want = %v", c.current, got, c.want)
+		}
+	}
+}
+
+func TestDriverNotify(t *testing.T) {
+	emulateLocks()
+
+	r := New(&Options{Addr: testInstance})
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan *fs.Event, 10)
+
+	if err := r.Notify(ctx, func(e *fs.Event) { events <- e }); err != nil {
+		t.Fatalf("Notify() error = %v, want = %v", err, nil)
+	}
+
+	if flags, _ := r.storage.ConfigGet(notifyKeyspaceEvents).Result(); len(flags) != 2 || flags[1] != keyspaceClasses {
+		t.Errorf("ConfigGet() got = %v, want = %v", flags, keyspaceClasses)
+	}
+
+	_ = r.Set("notify:deleted", valWithoutExpire, 0)
+	_, _ = r.Delete("notify:deleted")
+
+	// internal keys are skipped
+	_, _, _ = r.Acquire("notify", "a", time.Second)
+	_, _ = r.Release("notify", "a")
+
+	_ = r.Set("notify:expired", valWithoutExpire, 1)
+	srv.FastForward(time.Second)
+
+	for _, want := range []fs.Event{{Type: fs.EventDeleted, Key: "notify:deleted"}, {Type: fs.EventExpired, Key: "notify:expired"}} {
+		select {
+		case e := <-events:
+			if e.Type != want.Type || e.Key != want.Key {
+				t.Errorf("Notify() got = %s %s, want = %s %s", e.Type, e.Key, want.Type, want.Key)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Notify() got nothing, want = %s %s", want.Type, want.Key)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Deployment modes available for `Options.Mode`.
+const (
+	ModeSingle   = "single"
+	ModeSentinel = "sentinel"
+	ModeCluster  = "cluster"
+)
+
+type (
+	// Options contains `Driver` specific parameters.
+	//
+	// "single" (default) mode connects to `Addr`,
+	// "sentinel" mode asks `Addrs` sentinels for address of `MasterName` master,
+	// "cluster" mode discovers cluster nodes from `Addrs` seed nodes (only `DB` 0 is available).
+	//
+	// Zero pool and timeout parameters fall back to `go-redis` defaults,
+	// timeouts are in milliseconds.
+	//
+	// Expired keys are removed from tag indexes every `TagSweep` seconds.
+	Options struct {
+		Mode             string        `json:"mode"`
+		Addr             string        `json:"addr"`
+		Addrs            []string      `json:"addrs"`
+		MasterName       string        `json:"masterName"`
+		Password         string        `json:"password"`
+		SentinelPassword string        `json:"sentinelPassword"`
+		DB               int           `json:"db"`
+		TLS              *TLS          `json:"tls"`
+		MaxRetries       int           `json:"maxRetries"`
+		PoolSize         int           `json:"poolSize"`
+		MinIdleConns     int           `json:"minIdleConns"`
+		DialTimeout      time.Duration `json:"dialTimeout"`
+		ReadTimeout      time.Duration `json:"readTimeout"`
+		WriteTimeout     time.Duration `json:"writeTimeout"`
+		PoolTimeout      time.Duration `json:"poolTimeout"`
+		TagSweep         time.Duration `json:"tagSweep"`
+	}
+	// TLS contains parameters of TLS connections.
+	// Server certificate is verified by `CAFile` (or system roots if it is not set),
+	// `CertFile` and `KeyFile` contain client certificate.
+	TLS struct {
+		CAFile             string `json:"caFile"`
+		CertFile           string `json:"certFile"`
+		KeyFile            string `json:"keyFile"`
+		ServerName         string `json:"serverName"`
+		InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	}
+)
+
+// mode returns deployment mode of `opts`.
+func (opts *Options) mode() string {
+	if opts.Mode == "" {
+		return ModeSingle
+	}
+
+	return opts.Mode
+}
+
+// validate checks `opts` are consistent.
+// Panics if `opts` are invalid.
+func (opts *Options) validate() {
+	switch opts.mode() {
+	case ModeSingle:
+		if opts.Addr == "" {
+			log.Panicf("empty Addr")
+		}
+	case ModeSentinel:
+		if opts.MasterName == "" {
+			log.Panicf("empty MasterName")
+		}
+
+		if len(opts.Addrs) == 0 {
+			log.Panicf("empty Addrs")
+		}
+	case ModeCluster:
+		if len(opts.Addrs) == 0 {
+			log.Panicf("empty Addrs")
+		}
+
+		if opts.DB != 0 {
+			log.Panicf("non-zero DB in cluster mode")
+		}
+	default:
+		log.Panicf("unknown Mode (%s)", opts.Mode)
+	}
+
+	if opts.DB < 0 {
+		log.Panicf("negative DB")
+	}
+
+	for _, p := range []struct {
+		name  string
+		value int64
+	}{
+		{"MaxRetries", int64(opts.MaxRetries)},
+		{"PoolSize", int64(opts.PoolSize)},
+		{"MinIdleConns", int64(opts.MinIdleConns)},
+		{"DialTimeout", int64(opts.DialTimeout)},
+		{"ReadTimeout", int64(opts.ReadTimeout)},
+		{"WriteTimeout", int64(opts.WriteTimeout)},
+		{"PoolTimeout", int64(opts.PoolTimeout)},
+		{"TagSweep", int64(opts.TagSweep)},
+	} {
+		if p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+}
+
+// config returns `tls.Config` for `t` or `nil` if TLS is not used.
+// Panics if certificates cannot be loaded.
+func (t *TLS) config() *tls.Config {
+	if t == nil {
+		return nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify, // nolint:gosec
+	}
+
+	if t.CAFile != "" {
+		pem, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			log.Panicf("invalid TLS.CAFile (%v)", err)
+		}
+
+		cfg.RootCAs = x509.NewCertPool()
+
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			log.Panicf("invalid TLS.CAFile (no certificates in %s)", t.CAFile)
+		}
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			log.Panicf("invalid TLS.CertFile or TLS.KeyFile (%v)", err)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg
+}
@@ -1,14 +1,28 @@
 package redis
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-redis/redis/v7"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
 )
 
 // Get gets key from key-value storage.
 func (r *Driver) Get(key string) (string, error) {
-	val, err := r.storage.Get(key).Result()
+	return r.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+func (r *Driver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+
+	cmd := redis.NewStringCmd("get", key)
+	_ = r.storage.ProcessContext(ctx, cmd)
+
+	val, err := cmd.Result()
 
 	if err != nil {
 		if err == redis.Nil {
@@ -23,44 +37,171 @@ func (r *Driver) Get(key string) (string, error) {
 
 // Set sets key, value and "time-to-live" to key-value storage.
 func (r *Driver) Set(key, val string, ttl int) error {
+	return r.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+// Tags of the previous value of key are dropped, see `write()`.
+func (r *Driver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
 	// force `memcache` behaviour because `redis.Set()` ignores negative `ttl`.
 	if ttl < 0 {
-		r.storage.Del(key)
+		_, _ = r.del(ctx, key, nil)
 		return nil
 	}
 
-	_, err := r.storage.Set(key, val, time.Duration(ttl)*time.Second).Result()
+	args := []interface{}{"set", key, val}
+	if ttl > 0 {
+		args = append(args, "ex", ttl)
+	}
+
+	_, err := r.write(ctx, &mutation{key: key, apply: func(pipe redis.Pipeliner) {
+		_ = pipe.Process(redis.NewStatusCmd(args...))
+	}})
 
 	return err
 }
 
-// Delete deletes key from key-value storage.
-func (r *Driver) Delete(key string) (bool, error) {
-	val, err := r.storage.Del(key).Result()
-	if err != nil {
+// SetCond sets key and value by `opts` to key-value storage, `ok` is false if `opts.Mode` condition is not met.
+func (r *Driver) SetCond(key, val string, opts *fs.SetOptions) (bool, error) {
+	return r.SetCondContext(context.Background(), key, val, opts)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+// Expiration is passed in milliseconds ("PX"), mode is checked by `EXISTS` of watched key
+// and tags of the previous value are dropped, see `write()`.
+func (r *Driver) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if err := checkKey(key); err != nil {
 		return false, err
 	}
 
-	if val == 0 {
-		return false, nil
+	args := []interface{}{"set", key, val}
+
+	if now := time.Now(); !opts.NoExpire {
+		px := opts.Expire(now).Sub(now) / time.Millisecond
+		if px < 1 {
+			px = 1
+		}
+
+		args = append(args, "px", int64(px))
 	}
 
-	return true, nil
+	m := &mutation{key: key, apply: func(pipe redis.Pipeliner) {
+		_ = pipe.Process(redis.NewStatusCmd(args...))
+	}}
+
+	if opts.Mode != "" {
+		m.cond = func(tx *redis.Tx) (bool, error) {
+			n, err := tx.Exists(key).Result()
+			return (n > 0) == (opts.Mode == fs.ModeXX), err
+		}
+	}
+
+	return r.write(ctx, m)
+}
+
+// Delete deletes key from key-value storage.
+func (r *Driver) Delete(key string) (bool, error) {
+	return r.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key with its tags from key-value storage until `ctx` is done.
+func (r *Driver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := checkKey(key); err != nil {
+		return false, err
+	}
+
+	return r.del(ctx, key, nil)
 }
 
 // Close calls to release key-value storage resources.
 func (r *Driver) Close() {
+	r.stop()
 	_ = r.storage.Close()
 }
 
 // Driver implements Driver interface.
+// Inner storage is the single node, Sentinel-monitored master or Cluster.
+// Keys with prefixes of tag indexes, tags of keys and locks are rejected with `fs.ErrInvalidKey`.
 type Driver struct {
-	storage *redis.Client
+	storage redis.UniversalClient
+	db      int
+	stop    context.CancelFunc
+}
+
+func init() {
+	fs.Register("redis", func() interface{} { return &Options{} }, func(config interface{}) fs.Driver {
+		return New(config.(*Options))
+	})
 }
 
 // New returns "ready-to-use" `Driver` with `redis` inner storage.
-func New(addr string) *Driver {
-	return &Driver{
-		storage: redis.NewClient(&redis.Options{Addr: addr}),
+// Panics if `opts` are invalid.
+func New(opts *Options) *Driver {
+	opts.validate()
+
+	if opts.TagSweep == 0 {
+		opts.TagSweep = defaultTagSweep
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	r := &Driver{storage: newClient(opts), db: opts.DB, stop: stop}
+
+	go r.sweeper(ctx, opts.TagSweep*time.Second)
+
+	return r
+}
+
+// newClient returns `redis` client of `opts.Mode`.
+func newClient(opts *Options) redis.UniversalClient {
+	tlsConfig := opts.TLS.config()
+
+	switch opts.mode() {
+	case ModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			MaxRetries:       opts.MaxRetries,
+			DialTimeout:      opts.DialTimeout * time.Millisecond,
+			ReadTimeout:      opts.ReadTimeout * time.Millisecond,
+			WriteTimeout:     opts.WriteTimeout * time.Millisecond,
+			PoolSize:         opts.PoolSize,
+			MinIdleConns:     opts.MinIdleConns,
+			PoolTimeout:      opts.PoolTimeout * time.Millisecond,
+			TLSConfig:        tlsConfig,
+		})
+	case ModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        opts.Addrs,
+			Password:     opts.Password,
+			MaxRetries:   opts.MaxRetries,
+			DialTimeout:  opts.DialTimeout * time.Millisecond,
+			ReadTimeout:  opts.ReadTimeout * time.Millisecond,
+			WriteTimeout: opts.WriteTimeout * time.Millisecond,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			PoolTimeout:  opts.PoolTimeout * time.Millisecond,
+			TLSConfig:    tlsConfig,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         opts.Addr,
+			Password:     opts.Password,
+			DB:           opts.DB,
+			MaxRetries:   opts.MaxRetries,
+			DialTimeout:  opts.DialTimeout * time.Millisecond,
+			ReadTimeout:  opts.ReadTimeout * time.Millisecond,
+			WriteTimeout: opts.WriteTimeout * time.Millisecond,
+			PoolSize:     opts.PoolSize,
+			MinIdleConns: opts.MinIdleConns,
+			PoolTimeout:  opts.PoolTimeout * time.Millisecond,
+			TLSConfig:    tlsConfig,
+		})
 	}
 }
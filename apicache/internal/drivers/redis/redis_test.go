@@ -4,60 +4,32 @@ import (
 	"errors"
 	"log"
 	"os"
-
-	"github.com/go-redis/redis/v7"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+	"github.com/kxnes/go-interviews/apicache/test/redistest"
 )
 
 const (
-	testInstance = ":6379"
-
-	keyWithoutExpire = "key-without-expire"
 	valWithoutExpire = "val-without-expire"
-	withoutExpire    = 0 // seconds
-
-	keyWithLongExpire = "key-with-long-expire"
-	valWithLongExpire = "val-with-long-expire"
-	longExpire        = 100 // seconds
+	longExpire       = 100 // seconds
 
-	keyWithShortExpire = "key-with-short-expire"
-	valWithShortExpire = "val-with-short-expire"
-	shortExpire        = 2 // seconds
-
-	keyNotExist   = "key-not-exist"
-	valNotExist   = ""
-	invalidExpire = -10 // seconds
+	valNotExist = ""
 )
 
-var d = Driver{storage: redis.NewClient(&redis.Options{})}
+var (
+	srv          *redistest.Server
+	testInstance string
+	d            Driver
+)
 
 func TestMain(m *testing.M) {
-	for _, item := range []struct {
-		Key, Value string
-		Expiration int
-	}{
-		{
-			Key:   keyWithoutExpire,
-			Value: valWithoutExpire,
-		},
-		{
-			Key:        keyWithLongExpire,
-			Value:      valWithLongExpire,
-			Expiration: longExpire,
-		},
-		{
-			Key:        keyWithShortExpire,
-			Value:      valWithShortExpire,
-			Expiration: shortExpire,
-		},
-	} {
-		err := d.storage.Set(item.Key, item.Value, time.Duration(item.Expiration)*time.Second).Err()
-		if err != nil {
-			log.Fatalf("setup fixture %v error = %v\n", item, err)
-		}
-	}
+	srv = redistest.NewServer()
+	testInstance = srv.Addr()
+	d = *New(&Options{Addr: testInstance})
 
 	code := m.Run()
 
@@ -66,218 +38,281 @@ func TestMain(m *testing.M) {
 		log.Fatalf("teardown error = %v\n", err)
 	}
 
+	srv.Close()
 	os.Exit(code)
 }
 
-func TestDriverGetKeyExists(t *testing.T) {
-	val, err := d.Get(keyWithoutExpire)
-
-	if val != valWithoutExpire {
-		t.Errorf("Get() = %s, want = %s", val, valWithoutExpire)
-	}
-
-	if err != nil {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
-	}
+func TestDriverConformance(t *testing.T) {
+	drivertest.Run(t, func() fs.Driver { return New(&Options{Addr: testInstance}) })
 }
 
-func TestDriverGetKeyNotExists(t *testing.T) {
-	val, err := d.Get(keyNotExist)
+func TestDriverGetUnexpectedError(t *testing.T) {
+	_ = d.storage.Close()
+
+	val, err := d.Get(valNotExist)
 
 	if val != valNotExist {
 		t.Errorf("Get() = %s, want = %s", val, valNotExist)
 	}
 
-	if err != nil {
+	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
 		t.Errorf("Get() error = %v, want = %v", err, nil)
 	}
-}
 
-func TestDriverGetKeyExpired(t *testing.T) {
-	time.Sleep(shortExpire * time.Second)
+	d = *New(&Options{Addr: testInstance})
+}
 
-	val, err := d.Get(keyWithShortExpire)
+func TestDriverSetUnexpectedError(t *testing.T) {
+	_ = d.storage.Close()
 
-	if val != valNotExist {
-		t.Errorf("Get() = %s, want = %s", val, valNotExist)
-	}
+	err := d.Set(valNotExist, valWithoutExpire, longExpire)
 
-	if err != nil {
+	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
 		t.Errorf("Get() error = %v, want = %v", err, nil)
 	}
+
+	d = *New(&Options{Addr: testInstance})
 }
 
-func TestDriverGetUnexpectedError(t *testing.T) {
+func TestDriverDeleteUnexpectedError(t *testing.T) {
 	_ = d.storage.Close()
 
-	val, err := d.Get(valNotExist)
-
-	if val != valNotExist {
-		t.Errorf("Get() = %s, want = %s", val, valNotExist)
+	ok, err := d.Delete(valWithoutExpire)
+	if ok {
+		t.Errorf("Delete() = %v, want = %v", ok, false)
 	}
 
 	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
 		t.Errorf("Get() error = %v, want = %v", err, nil)
 	}
 
-	d = *New(testInstance)
+	d = *New(&Options{Addr: testInstance})
 }
 
-func TestDriverSetNotExistKey(t *testing.T) {
-	item, err := d.storage.Get(keyNotExist).Result()
-	if err != redis.Nil || item != "" {
-		t.Errorf("Key exists but doesn't")
-	}
+func TestDriverServerError(t *testing.T) {
+	srv.SetError("get", "ERR injected")
+	srv.SetError("set", "ERR injected")
+	srv.SetError("del", "ERR injected")
 
-	err = d.Set(keyNotExist, valWithoutExpire, withoutExpire)
+	defer func() {
+		srv.SetError("get", "")
+		srv.SetError("set", "")
+		srv.SetError("del", "")
+	}()
 
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
-	}
+	want := "ERR injected"
 
-	item, err = d.storage.Get(keyNotExist).Result()
-	if err == redis.Nil || item == "" {
-		t.Errorf("Set() doesn't set")
+	if _, err := d.Get(valWithoutExpire); err == nil || err.Error() != want {
+		t.Errorf("Get() error = %v, want = %v", err, want)
 	}
 
-	if item != valWithoutExpire {
-		t.Errorf("Set() wrong value = %s", item)
+	if err := d.Set(valWithoutExpire, valWithoutExpire, longExpire); err == nil || err.Error() != want {
+		t.Errorf("Set() error = %v, want = %v", err, want)
 	}
-}
 
-func TestDriverSetExistKey(t *testing.T) {
-	item, err := d.storage.Get(keyWithoutExpire).Result()
-	if err == redis.Nil || item == "" {
-		t.Errorf("Key not exists but doesn't")
+	if _, err := d.Delete(valWithoutExpire); err == nil || err.Error() != want {
+		t.Errorf("Delete() error = %v, want = %v", err, want)
 	}
+}
 
-	err = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
+func TestDriverInvalidKey(t *testing.T) {
+	emulateLocks()
 
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+	if _, ok, err := d.Acquire("reserved", "a", time.Minute); !ok || err != nil {
+		t.Fatalf("Acquire() got = %v, %v, want = %v, %v", ok, err, true, nil)
 	}
 
-	item, err = d.storage.Get(keyWithoutExpire).Result()
-	if err == redis.Nil || item == "" {
-		t.Errorf("Set() doesn't set")
-	}
+	keys := lockKeys("reserved")
 
-	if item != valWithoutExpire {
-		t.Errorf("Set() wrong value = %s", item)
+	cases := []struct {
+		name string
+		key  string
+	}{
+		{name: "lock", key: keys[0]},
+		{name: "fence", key: keys[1]},
+		{name: "tag index", key: tagKey("reserved")},
+		{name: "tags of key", key: keyTagsKey("reserved")},
 	}
-}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var target *fs.ErrInvalidKey
 
-func TestDriverSetUpdateExpireOnKey(t *testing.T) {
-	err := d.Set(keyWithLongExpire, valWithLongExpire, shortExpire)
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
-	}
+			if _, err := d.Get(c.key); !errors.As(err, &target) {
+				t.Errorf("Get() error = %v, want = %T", err, target)
+			}
 
-	time.Sleep(time.Second)
+			if err := d.Set(c.key, valWithoutExpire, longExpire); !errors.As(err, &target) {
+				t.Errorf("Set() error = %v, want = %T", err, target)
+			}
 
-	err = d.Set(keyWithLongExpire, valWithLongExpire, shortExpire)
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
-	}
+			if _, err := d.SetCond(c.key, valWithoutExpire, &fs.SetOptions{NoExpire: true}); !errors.As(err, &target) {
+				t.Errorf("SetCond() error = %v, want = %T", err, target)
+			}
 
-	time.Sleep(time.Second)
+			if err := d.SetTags(c.key, valWithoutExpire, longExpire, []string{"reserved"}); !errors.As(err, &target) {
+				t.Errorf("SetTags() error = %v, want = %T", err, target)
+			}
 
-	item, err := d.storage.Get(keyWithLongExpire).Result()
-	if err == redis.Nil || item == "" {
-		t.Errorf("Set() doesn't set")
+			if _, err := d.Delete(c.key); !errors.As(err, &target) {
+				t.Errorf("Delete() error = %v, want = %T", err, target)
+			}
+		})
 	}
 
-	if item != valWithLongExpire {
-		t.Errorf("Set() wrong value = %s", item)
+	// lock is not changed
+	if _, ok, err := d.Renew("reserved", "a", time.Minute); !ok || err != nil {
+		t.Errorf("Renew() got = %v, %v, want = %v, %v", ok, err, true, nil)
 	}
+}
 
-	time.Sleep(time.Second)
+func TestDriverExpire(t *testing.T) {
+	_ = d.Set(valWithoutExpire, valWithoutExpire, longExpire)
 
-	item, err = d.storage.Get(keyWithLongExpire).Result()
-	if err != redis.Nil || item != "" {
-		t.Errorf("Set() doesn't update expiration")
+	srv.FastForward(longExpire * time.Second)
+
+	if val, err := d.Get(valWithoutExpire); val != valNotExist || err != nil {
+		t.Errorf("Get() = %s, %v, want = %s, %v", val, err, valNotExist, nil)
 	}
 }
 
-func TestDriverSetNegativeTTL(t *testing.T) {
-	err := d.Set(keyWithoutExpire, valWithoutExpire, invalidExpire)
-	if err != nil {
-		t.Errorf("Set() error = %v, want = %v", err, nil)
+func TestDriverSetCond(t *testing.T) {
+	const key = "cond"
+
+	cases := []struct {
+		name string
+		opts *fs.SetOptions
+		want bool
+		pttl int64
+	}{
+		{"xx missed", &fs.SetOptions{Mode: fs.ModeXX, NoExpire: true}, false, -2},
+		{"nx missed", &fs.SetOptions{Mode: fs.ModeNX, TTL: 1500 * time.Millisecond}, true, 1500},
+		{"nx existed", &fs.SetOptions{Mode: fs.ModeNX, NoExpire: true}, false, 1500},
+		{"xx existed", &fs.SetOptions{Mode: fs.ModeXX, ExpireAt: time.Now().Add(time.Hour)}, true, 3600000},
+		{"no expire", &fs.SetOptions{NoExpire: true}, true, -1},
 	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ok, err := d.SetCond(key, c.name, c.opts); ok != c.want || err != nil {
+				t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
 
-	item, err := d.storage.Get(keyWithoutExpire).Result()
-	if err != redis.Nil || item != "" {
-		t.Errorf("Set() set but doesn't")
+			// PTTL is rounded down by passed time
+			if pttl, _ := d.storage.Do("pttl", key).Int64(); pttl > c.pttl || pttl < c.pttl-100 {
+				t.Errorf("PTTL() got = %v, want = %v", pttl, c.pttl)
+			}
+		})
+	}
+
+	srv.SetError("set", "ERR injected")
+	defer srv.SetError("set", "")
+
+	if _, err := d.SetCond(key, key, &fs.SetOptions{NoExpire: true}); err == nil || err.Error() != "ERR injected" {
+		t.Errorf("SetCond() error = %v, want = %v", err, "ERR injected")
 	}
 }
 
-func TestDriverSetUnexpectedError(t *testing.T) {
-	_ = d.storage.Close()
+func TestDriverClose(t *testing.T) {
+	d.Close()
 
 	err := d.Set(valNotExist, valWithoutExpire, longExpire)
-
 	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
 		t.Errorf("Get() error = %v, want = %v", err, nil)
 	}
 
-	d = *New(testInstance)
+	d = *New(&Options{Addr: testInstance})
 }
 
-func TestDriverDeleteKeyExists(t *testing.T) {
-	_ = d.Set(keyWithoutExpire, valWithoutExpire, withoutExpire)
-
-	ok, err := d.Delete(keyWithoutExpire)
-	if !ok {
-		t.Errorf("Delete() = %v, want = %v", ok, true)
-	}
+func TestNew(t *testing.T) {
+	d := New(&Options{Addr: testInstance})
 
+	err := d.storage.Ping().Err()
 	if err != nil {
-		t.Errorf("Delete() error = %v, want = %v", err, nil)
+		t.Errorf("driver not created = %v", err)
 	}
 }
 
-func TestDriverDeleteKeyNotExists(t *testing.T) {
-	ok, err := d.Delete(valWithoutExpire)
-	if ok {
-		t.Errorf("Delete() = %v, want = %v", ok, false)
-	}
+func TestNewModes(t *testing.T) {
+	auth := redistest.NewServer()
+	defer auth.Close()
 
-	if err != nil {
-		t.Errorf("Delete() error = %v, want = %v", err, nil)
-	}
-}
+	auth.RequireAuth("secret")
 
-func TestDriverDeleteUnexpectedError(t *testing.T) {
-	_ = d.storage.Close()
+	sentinel := redistest.NewServer()
+	defer sentinel.Close()
 
-	ok, err := d.Delete(valWithoutExpire)
-	if ok {
-		t.Errorf("Delete() = %v, want = %v", ok, false)
-	}
+	sentinel.SetMaster("master", auth.Addr())
 
-	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
-	}
+	cluster := redistest.NewServer()
+	defer cluster.Close()
 
-	d = *New(testInstance)
-}
+	cluster.EnableCluster()
 
-func TestDriverClose(t *testing.T) {
-	d.Close()
+	cases := []struct {
+		name string
+		opts *Options
+	}{
+		{
+			name: "single with password and db",
+			opts: &Options{Addr: auth.Addr(), Password: "secret", DB: 3, PoolSize: 2, ReadTimeout: 500},
+		},
+		{
+			name: "sentinel",
+			opts: &Options{Mode: ModeSentinel, MasterName: "master", Addrs: []string{sentinel.Addr()}, Password: "secret"},
+		},
+		{
+			name: "cluster",
+			opts: &Options{Mode: ModeCluster, Addrs: []string{cluster.Addr()}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := New(c.opts)
+			defer d.Close()
 
-	err := d.Set(valNotExist, valWithoutExpire, longExpire)
-	if !reflect.DeepEqual(err, errors.New(`redis: client is closed`)) {
-		t.Errorf("Get() error = %v, want = %v", err, nil)
+			if err := d.Set(valWithoutExpire, c.name, 0); err != nil {
+				t.Fatalf("Set() error = %v, want = %v", err, nil)
+			}
+
+			if val, err := d.Get(valWithoutExpire); val != c.name || err != nil {
+				t.Errorf("Get() = %s, %v, want = %s, %v", val, err, c.name, nil)
+			}
+		})
 	}
 
-	d = *New(testInstance)
-}
+	// wrong password is reported by storage
+	d := New(&Options{Addr: auth.Addr(), Password: "wrong"})
+	defer d.Close()
 
-func TestNew(t *testing.T) {
-	d := New(testInstance)
+	if _, err := d.Get(valWithoutExpire); err == nil {
+		t.Errorf("Get() error = %v, want auth error", err)
+	}
+}
 
-	err := d.storage.Ping().Err()
-	if err != nil {
-		t.Errorf("driver not created = %v", err)
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{}, "empty Addr"},
+		{&Options{Mode: "ring", Addr: testInstance}, "unknown Mode (ring)"},
+		{&Options{Mode: ModeSentinel, Addrs: []string{testInstance}}, "empty MasterName"},
+		{&Options{Mode: ModeSentinel, MasterName: "master"}, "empty Addrs"},
+		{&Options{Mode: ModeCluster}, "empty Addrs"},
+		{&Options{Mode: ModeCluster, Addrs: []string{testInstance}, DB: 1}, "non-zero DB in cluster mode"},
+		{&Options{Addr: testInstance, DB: -1}, "negative DB"},
+		{&Options{Addr: testInstance, PoolSize: -1}, "negative PoolSize"},
+		{&Options{Addr: testInstance, ReadTimeout: -1}, "negative ReadTimeout"},
+		{&Options{Addr: testInstance, TLS: &TLS{CAFile: "not-exist.pem"}}, "invalid TLS.CAFile (open not-exist.pem: no such file or directory)"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts)
+		})
 	}
 }
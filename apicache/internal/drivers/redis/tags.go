@@ -0,0 +1,457 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	// tagPrefix is the prefix of sorted sets indexing keys by tag,
+	// member is the key and score is it's expiration time (unix milliseconds, "+inf" if none).
+	tagPrefix = "_tag:"
+	// keyTagsPrefix is the prefix of sorted sets of tags of key (in the same cluster slot as the key),
+	// score is the expiration time as in tag index. Tags of key are authoritative, tag indexes may be stale.
+	keyTagsPrefix   = "_keytags:"
+	defaultTagSweep = 60
+	scanCount       = 1000
+	// maxWriteAttempts is the number of attempts of write whose watched keys are changed concurrently.
+	maxWriteAttempts = 64
+)
+
+// tagKey returns the key of `tag` index.
+func tagKey(tag string) string {
+	return tagPrefix + tag
+}
+
+// keyTagsKey returns the key of tags of `key`, hash tag of `key` is kept (or `key` becomes the hash tag).
+// Keys with braces but without hash tag (e.g. "a{}") are in other slot, so their writes fail in cluster mode.
+func keyTagsKey(key string) string {
+	if hashTagged(key) {
+		return keyTagsPrefix + key
+	}
+
+	return keyTagsPrefix + "{" + key + "}"
+}
+
+// hashTagged checks `key` has non-empty cluster hash tag.
+func hashTagged(key string) bool {
+	s := strings.IndexByte(key, '{')
+	if s < 0 {
+		return false
+	}
+
+	e := strings.IndexByte(key[s+1:], '}')
+
+	return e > 0
+}
+
+// internal checks `key` is tag index, tags of key or lock.
+func internal(key string) bool {
+	return strings.HasPrefix(key, tagPrefix) || strings.HasPrefix(key, keyTagsPrefix) || strings.HasPrefix(key, lockPrefix)
+}
+
+// checkKey returns `fs.ErrInvalidKey` if `key` is reserved for tag indexes, tags of keys or locks.
+func checkKey(key string) error {
+	if internal(key) {
+		return fs.NewErrInvalidKey(key, "prefix is reserved")
+	}
+
+	return nil
+}
+
+// unixMilli returns `t` as unix milliseconds.
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// SetTags sets key, value and "time-to-live" with `tags` to key-value storage.
+func (r *Driver) SetTags(key, val string, ttl int, tags []string) error {
+	return r.SetTagsContext(context.Background(), key, val, ttl, tags)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Tags of the previous value of `key` are replaced, see `write()`.
+func (r *Driver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	if ttl < 0 {
+		return r.SetContext(ctx, key, val, ttl)
+	}
+
+	args := []interface{}{"set", key, val}
+	m := &mutation{key: key, tags: tags, score: math.Inf(1)}
+
+	if ttl > 0 {
+		args = append(args, "ex", ttl)
+		m.score = float64(unixMilli(time.Now().Add(time.Duration(ttl) * time.Second)))
+	}
+
+	m.apply = func(pipe redis.Pipeliner) { _ = pipe.Process(redis.NewStatusCmd(args...)) }
+
+	_, err := r.write(ctx, m)
+
+	return err
+}
+
+// mutation is a write of `key` replacing its tags by `tags` expiring at `score`.
+type mutation struct {
+	key   string
+	tags  []string
+	score float64
+	// cond is checked before the transaction with `key` watched, the write is skipped if it's not met.
+	cond func(tx *redis.Tx) (bool, error)
+	// apply queues the write of `key` to the transaction.
+	apply func(pipe redis.Pipeliner)
+}
+
+// watcher is a `redis` client running optimistic transactions.
+type watcher interface {
+	WatchContext(ctx context.Context, fn func(*redis.Tx) error, keys ...string) error
+}
+
+// write runs `m` in one transaction with tags of key watched (and key itself if `m.cond` is set),
+// the transaction is retried if they are changed concurrently. Returns false if `m.cond` is not met.
+// The key and its tags are written in the transaction, tag indexes are updated in it too,
+// except in cluster mode where they are in other slots and updated after it.
+func (r *Driver) write(ctx context.Context, m *mutation) (bool, error) {
+	var (
+		keyTags    = keyTagsKey(m.key)
+		keys       = []string{keyTags}
+		_, cluster = r.storage.(*redis.ClusterClient)
+		ok         bool
+		old        []string
+	)
+
+	if m.cond != nil {
+		keys = append(keys, m.key)
+	}
+
+	fn := func(tx *redis.Tx) error {
+		ok = false
+
+		if m.cond != nil {
+			met, err := m.cond(tx)
+			if err != nil || !met {
+				return err
+			}
+		}
+
+		var err error
+
+		old, err = tx.ZRangeByScore(keyTags, &redis.ZRangeBy{Min: "-inf", Max: "+inf"}).Result()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			m.apply(pipe)
+
+			if len(old) > 0 {
+				pipe.Del(keyTags)
+			}
+
+			if len(m.tags) > 0 {
+				members := make([]*redis.Z, len(m.tags))
+				for i, tag := range m.tags {
+					members[i] = &redis.Z{Score: m.score, Member: tag}
+				}
+
+				pipe.ZAdd(keyTags, members...)
+			}
+
+			if !cluster {
+				index(pipe, m, old)
+			}
+
+			return nil
+		})
+
+		ok = err == nil
+
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := r.storage.(watcher).WatchContext(ctx, fn, keys...)
+		if err == redis.TxFailedErr && attempt < maxWriteAttempts {
+			continue
+		}
+
+		if err != nil {
+			return false, err
+		}
+
+		break
+	}
+
+	if !ok || !cluster || len(old)+len(m.tags) == 0 {
+		return ok, nil
+	}
+
+	pipe := r.storage.Pipeline()
+	index(pipe, m, old)
+
+	_, err := pipe.ExecContext(ctx)
+
+	return ok, err
+}
+
+// index adds key of `m` to indexes of its tags and removes it from indexes of `old` tags,
+// expired keys are pruned from the indexes.
+func index(pipe redis.Pipeliner, m *mutation, old []string) {
+	now := time.Now()
+	current := make(map[string]bool, len(m.tags))
+
+	for _, tag := range m.tags {
+		current[tag] = true
+
+		pipe.ZAdd(tagKey(tag), &redis.Z{Score: m.score, Member: m.key})
+		pipe.ZRemRangeByScore(tagKey(tag), "-inf", expired(now))
+	}
+
+	for _, tag := range old {
+		if !current[tag] {
+			pipe.ZRem(tagKey(tag), m.key)
+		}
+	}
+}
+
+// expired returns exclusive score bound of keys expired at `now`.
+func expired(now time.Time) string {
+	return "(" + strconv.FormatInt(unixMilli(now), 10)
+}
+
+// DeleteTag deletes all keys with `tag` from key-value storage.
+func (r *Driver) DeleteTag(tag string) (int, error) {
+	return r.DeleteTagContext(context.Background(), tag)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Keys are found by the tag index and deleted only if they still have `tag`, so stale index never deletes them.
+func (r *Driver) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	now := time.Now()
+	live := strconv.FormatInt(unixMilli(now), 10)
+
+	cmd := redis.NewStringSliceCmd("zrangebyscore", tagKey(tag), live, "+inf")
+	_ = r.storage.ProcessContext(ctx, cmd)
+
+	keys, err := cmd.Result()
+	if err != nil {
+		return 0, err
+	}
+
+	tagged := func(key string) func(tx *redis.Tx) (bool, error) {
+		return func(tx *redis.Tx) (bool, error) {
+			tags, err := tx.ZRangeByScore(keyTagsKey(key), &redis.ZRangeBy{Min: live, Max: "+inf"}).Result()
+			if err != nil {
+				return false, err
+			}
+
+			for _, t := range tags {
+				if t == tag {
+					return true, nil
+				}
+			}
+
+			return false, nil
+		}
+	}
+
+	n := 0
+
+	for _, key := range keys {
+		del, err := r.del(ctx, key, tagged(key))
+		if err != nil {
+			return n, err
+		}
+
+		if del {
+			n++
+		}
+	}
+
+	// keys tagged concurrently are kept in the index
+	pipe := r.storage.Pipeline()
+
+	if len(keys) > 0 {
+		members := make([]interface{}, len(keys))
+		for i, key := range keys {
+			members[i] = key
+		}
+
+		pipe.ZRem(tagKey(tag), members...)
+	}
+
+	pipe.ZRemRangeByScore(tagKey(tag), "-inf", expired(now))
+
+	_, err = pipe.ExecContext(ctx)
+
+	return n, err
+}
+
+// DeletePattern deletes all keys matching glob-style `pattern` from key-value storage.
+func (r *Driver) DeletePattern(pattern string) (int, error) {
+	return r.DeletePatternContext(context.Background(), pattern)
+}
+
+// DeletePatternContext deletes all keys matching glob-style `pattern` from key-value storage until `ctx` is done.
+// Keys are found by `SCAN` on every master, tag indexes and locks are not deleted.
+func (r *Driver) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	keys, err := r.scan(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+
+	live := keys[:0]
+
+	for _, key := range keys {
+		if !internal(key) {
+			live = append(live, key)
+		}
+	}
+
+	return r.delete(ctx, live)
+}
+
+// delete deletes `keys` with their tags one by one (so they may be in different cluster slots)
+// and returns the number of deleted ones.
+func (r *Driver) delete(ctx context.Context, keys []string) (int, error) {
+	n := 0
+
+	for _, key := range keys {
+		del, err := r.del(ctx, key, nil)
+		if err != nil {
+			return n, err
+		}
+
+		if del {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// del deletes `key` with its tags if `cond` is met (or nil) and reports whether it existed.
+func (r *Driver) del(ctx context.Context, key string, cond func(tx *redis.Tx) (bool, error)) (bool, error) {
+	var cmd *redis.IntCmd
+
+	m := &mutation{key: key, cond: cond, apply: func(pipe redis.Pipeliner) { cmd = pipe.Del(key) }}
+
+	ok, err := r.write(ctx, m)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return cmd.Val() > 0, nil
+}
+
+// processor is a `redis` client of the single node.
+type processor interface {
+	Process(cmd redis.Cmder) error
+	ProcessContext(ctx context.Context, cmd redis.Cmder) error
+}
+
+// scan returns all keys matching `pattern` of `client`.
+func scan(ctx context.Context, client processor, pattern string) ([]string, error) {
+	var (
+		keys   []string
+		cursor uint64
+	)
+
+	for {
+		cmd := redis.NewScanCmd(client.Process, "scan", cursor, "match", pattern, "count", scanCount)
+		_ = client.ProcessContext(ctx, cmd)
+
+		page, next, err := cmd.Result()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, page...)
+
+		if cursor = next; cursor == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// scan returns all keys matching `pattern` of all masters.
+func (r *Driver) scan(ctx context.Context, pattern string) ([]string, error) {
+	cluster, ok := r.storage.(*redis.ClusterClient)
+	if !ok {
+		return scan(ctx, r.storage, pattern)
+	}
+
+	var (
+		mu   sync.Mutex
+		keys []string
+	)
+
+	err := cluster.ForEachMaster(func(client *redis.Client) error {
+		page, err := scan(ctx, client, pattern)
+
+		mu.Lock()
+		keys = append(keys, page...)
+		mu.Unlock()
+
+		return err
+	})
+
+	return keys, err
+}
+
+// sweep removes expired keys from all tag indexes and tags of expired keys.
+func (r *Driver) sweep(ctx context.Context) error {
+	tags, err := r.scan(ctx, tagPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	keyTags, err := r.scan(ctx, keyTagsPrefix+"*")
+	if err != nil {
+		return err
+	}
+
+	if tags = append(tags, keyTags...); len(tags) == 0 {
+		return nil
+	}
+
+	now := expired(time.Now())
+	pipe := r.storage.Pipeline()
+
+	for _, tag := range tags {
+		pipe.ZRemRangeByScore(tag, "-inf", now)
+	}
+
+	_, err = pipe.ExecContext(ctx)
+
+	return err
+}
+
+// sweeper sweeps tag indexes every `interval` until `ctx` is done.
+func (r *Driver) sweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.sweep(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("redis tag sweep err = %v", err)
+			}
+		}
+	}
+}
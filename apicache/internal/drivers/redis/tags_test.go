@@ -0,0 +1,250 @@
+package redis
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/test/redistest"
+)
+
+func TestDriverDeleteTag(t *testing.T) {
+	_ = d.SetTags("tags:user:1", valWithoutExpire, 0, []string{"users", "team"})
+	_ = d.SetTags("tags:user:2", valWithoutExpire, longExpire, []string{"users"})
+	_ = d.SetTags("tags:team:1", valWithoutExpire, 0, []string{"team"})
+	_ = d.Set("tags:untagged", valWithoutExpire, 0)
+
+	n, err := d.DeleteTag("users")
+	if n != 2 || err != nil {
+		t.Errorf("DeleteTag() = %d, %v, want = %d, %v", n, err, 2, nil)
+	}
+
+	for key, want := range map[string]string{
+		"tags:user:1":   valNotExist,
+		"tags:user:2":   valNotExist,
+		"tags:team:1":   valWithoutExpire,
+		"tags:untagged": valWithoutExpire,
+	} {
+		if val, _ := d.Get(key); val != want {
+			t.Errorf("Get(%s) = %s, want = %s", key, val, want)
+		}
+	}
+
+	if n, _ := d.storage.ZCard(tagKey("users")).Result(); n != 0 {
+		t.Errorf("DeleteTag() index size = %d, want = %d", n, 0)
+	}
+
+	if n, _ := d.storage.Exists(keyTagsKey("tags:user:1")).Result(); n != 0 {
+		t.Errorf("DeleteTag() tags of key exist = %d, want = %d", n, 0)
+	}
+
+	// deleted key is counted once
+	if n, _ := d.DeleteTag("team"); n != 1 {
+		t.Errorf("DeleteTag() = %d, want = %d", n, 1)
+	}
+
+	if n, err := d.DeleteTag("not-exist"); n != 0 || err != nil {
+		t.Errorf("DeleteTag() = %d, %v, want = %d, %v", n, err, 0, nil)
+	}
+}
+
+func TestDriverTagExpire(t *testing.T) {
+	_ = d.SetTags("tags:expire", valWithoutExpire, 1, []string{"expire"})
+
+	time.Sleep(1100 * time.Millisecond)
+
+	if err := d.sweep(context.Background()); err != nil {
+		t.Fatalf("sweep() error = %v, want = %v", err, nil)
+	}
+
+	// empty index is removed by storage
+	if n, _ := d.storage.Exists(tagKey("expire"), keyTagsKey("tags:expire")).Result(); n != 0 {
+		t.Errorf("sweep() index exists = %d, want = %d", n, 0)
+	}
+
+	if n, _ := d.DeleteTag("expire"); n != 0 {
+		t.Errorf("DeleteTag() = %d, want = %d", n, 0)
+	}
+}
+
+func TestDriverSetTagsReplace(t *testing.T) {
+	_ = d.SetTags("tags:replace", valWithoutExpire, 0, []string{"replace:old", "replace:kept"})
+	_ = d.SetTags("tags:replace", valWithoutExpire, 0, []string{"replace:kept", "replace:new"})
+
+	for tag, want := range map[string]int64{"replace:old": 0, "replace:kept": 1, "replace:new": 1} {
+		if n, _ := d.storage.ZCard(tagKey(tag)).Result(); n != want {
+			t.Errorf("SetTags() index (%s) size = %d, want = %d", tag, n, want)
+		}
+	}
+
+	if n, _ := d.DeleteTag("replace:new"); n != 1 {
+		t.Errorf("DeleteTag() = %d, want = %d", n, 1)
+	}
+}
+
+func TestDriverSetTagsServerError(t *testing.T) {
+	srv.SetError("zadd", "ERR injected")
+	defer srv.SetError("zadd", "")
+
+	if err := d.SetTags("tags:error", valWithoutExpire, 0, []string{"error"}); err == nil || err.Error() != "ERR injected" {
+		t.Errorf("SetTags() error = %v, want = %v", err, "ERR injected")
+	}
+}
+
+func TestDriverDeletePattern(t *testing.T) {
+	cluster := redistest.NewServer()
+	defer cluster.Close()
+
+	cluster.EnableCluster()
+
+	cases := []struct {
+		name string
+		opts *Options
+	}{
+		{
+			name: "single",
+			opts: &Options{Addr: testInstance},
+		},
+		{
+			name: "cluster",
+			opts: &Options{Mode: ModeCluster, Addrs: []string{cluster.Addr()}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := New(c.opts)
+			defer d.Close()
+
+			for _, key := range []string{"user:42:name", "user:42:mail", "user:420:name", "user:43:name"} {
+				_ = d.SetTags(key, valWithoutExpire, 0, []string{"user:42:*"})
+			}
+
+			n, err := d.DeletePattern("user:42:*")
+			if n != 2 || err != nil {
+				t.Errorf("DeletePattern() = %d, %v, want = %d, %v", n, err, 2, nil)
+			}
+
+			if val, _ := d.Get("user:43:name"); val != valWithoutExpire {
+				t.Errorf("Get() = %s, want = %s", val, valWithoutExpire)
+			}
+
+			// tag indexes are not matched
+			if n, _ := d.DeletePattern("*42*"); n != 1 {
+				t.Errorf("DeletePattern() = %d, want = %d", n, 1)
+			}
+		})
+	}
+}
+
+func TestDriverWriteDropsTags(t *testing.T) {
+	cases := []struct {
+		name  string
+		write func(key string) error
+	}{
+		{
+			name:  "set",
+			write: func(key string) error { return d.Set(key, valWithoutExpire, 0) },
+		},
+		{
+			name:  "set negative",
+			write: func(key string) error { return d.Set(key, valWithoutExpire, -1) },
+		},
+		{
+			name: "set cond",
+			write: func(key string) error {
+				_, err := d.SetCond(key, valWithoutExpire, &fs.SetOptions{Mode: fs.ModeXX})
+				return err
+			},
+		},
+		{
+			name: "delete",
+			write: func(key string) error {
+				_, err := d.Delete(key)
+				return err
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key := "tags:drop:" + c.name
+			_ = d.SetTags(key, valWithoutExpire, 0, []string{"drop"})
+
+			if err := c.write(key); err != nil {
+				t.Fatalf("write() error = %v, want = %v", err, nil)
+			}
+
+			if n, _ := d.storage.ZCard(tagKey("drop")).Result(); n != 0 {
+				t.Errorf("write() index size = %d, want = %d", n, 0)
+			}
+
+			if n, _ := d.storage.Exists(keyTagsKey(key)).Result(); n != 0 {
+				t.Errorf("write() tags of key exist = %d, want = %d", n, 0)
+			}
+		})
+	}
+}
+
+func TestDriverWriteRetry(t *testing.T) {
+	key := "tags:retry"
+	attempts := 0
+
+	// tags of key are changed by another client after they are watched
+	m := &mutation{key: key, tags: []string{"retry:new"}, score: math.Inf(1)}
+	m.apply = func(pipe redis.Pipeliner) { pipe.Set(key, valWithoutExpire, 0) }
+	m.cond = func(tx *redis.Tx) (bool, error) {
+		if attempts++; attempts == 1 {
+			_ = d.SetTags(key, valWithoutExpire, 0, []string{"retry:old"})
+		}
+
+		return true, nil
+	}
+
+	if ok, err := d.write(context.Background(), m); !ok || err != nil {
+		t.Fatalf("write() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if attempts != 2 {
+		t.Errorf("write() attempts = %d, want = %d", attempts, 2)
+	}
+
+	for tag, want := range map[string]int64{"retry:old": 0, "retry:new": 1} {
+		if n, _ := d.storage.ZCard(tagKey(tag)).Result(); n != want {
+			t.Errorf("write() index (%s) size = %d, want = %d", tag, n, want)
+		}
+	}
+}
+
+func TestDriverDeleteTagStale(t *testing.T) {
+	_ = d.SetTags("tags:stale", valWithoutExpire, 0, []string{"stale"})
+
+	// stale index entry is left as by concurrent write in cluster mode
+	_ = d.Set("tags:stale", valWithoutExpire, 0)
+	_ = d.storage.ZAdd(tagKey("stale"), &redis.Z{Score: math.Inf(1), Member: "tags:stale"})
+
+	if n, err := d.DeleteTag("stale"); n != 0 || err != nil {
+		t.Errorf("DeleteTag() = %d, %v, want = %d, %v", n, err, 0, nil)
+	}
+
+	if val, _ := d.Get("tags:stale"); val != valWithoutExpire {
+		t.Errorf("Get() = %s, want = %s", val, valWithoutExpire)
+	}
+}
+
+func TestKeyTagsKey(t *testing.T) {
+	cases := []struct {
+		key  string
+		want string
+	}{
+		{key: "user:1", want: "_keytags:{user:1}"},
+		{key: "{user}:1", want: "_keytags:{user}:1"},
+		{key: "user:{1}", want: "_keytags:user:{1}"},
+	}
+	for _, c := range cases {
+		if got := keyTagsKey(c.key); got != c.want {
+			t.Errorf("keyTagsKey(%s) got = %v, want = %v", c.key, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package fs
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+// Adaptive limiting algorithms available for `Adaptive.Algorithm`.
+const (
+	AlgorithmAIMD     = "aimd"
+	AlgorithmGradient = "gradient"
+
+	defaultBackoff   = 0.9
+	defaultSmoothing = 0.2
+	// minRTTDrift lets no-load latency estimation to follow backend slowdown.
+	minRTTDrift = 1.001
+	// minGradient bounds limit decrease for a single observation.
+	minGradient = 0.5
+)
+
+type (
+	// Adaptive contains parameters of adaptive concurrency limiting.
+	// Pools limits start from `Options` values and are changed at runtime
+	// in `[Min, Max]` bounds according to observed `Driver` latency.
+	//
+	// "aimd" additively increases limit while latency is below `Latency` (milliseconds)
+	// and multiplicatively decreases it by `Backoff` on slow or failed calls.
+	// "gradient" changes limit by ratio of no-load latency to the current one.
+	Adaptive struct {
+		Algorithm string        `json:"algorithm"`
+		Min       int           `json:"min"`
+		Max       int           `json:"max"`
+		Latency   time.Duration `json:"latency"`
+		Backoff   float64       `json:"backoff"`
+	}
+	// Usage contains "connection pool" state.
+	Usage struct {
+		Limit   int `json:"limit"`
+		Used    int `json:"used"`
+		Waiting int `json:"waiting"`
+	}
+	// Limits contains state of read and write "connection pools"
+	// (the same if they are shared).
+	Limits struct {
+		Read  Usage `json:"read"`
+		Write Usage `json:"write"`
+	}
+	// Monitor is implemented by `Driver` which exposes it's concurrency limits.
+	Monitor interface {
+		Limits() Limits
+	}
+	// limiter calculates a new limit for every completed call.
+	limiter interface {
+		// update returns new limit by `rtt` of completed call,
+		// `dropped` is true if call is failed by `Driver`.
+		update(limit int, rtt time.Duration, dropped bool) int
+	}
+	// bounds keeps limit estimation in `[min, max]`.
+	bounds struct {
+		min float64
+		max float64
+	}
+	// aimd implements additive-increase/multiplicative-decrease `limiter`.
+	aimd struct {
+		bounds
+		estimate float64
+		latency  time.Duration
+		backoff  float64
+	}
+	// gradient implements `limiter` based on latency gradient.
+	gradient struct {
+		bounds
+		estimate float64
+		minRTT   float64
+	}
+)
+
+// bound returns `x` bounded by `b`.
+func (b bounds) bound(x float64) float64 {
+	return math.Max(b.min, math.Min(b.max, x))
+}
+
+func (a *aimd) update(limit int, rtt time.Duration, dropped bool) int {
+	if a.estimate == 0 {
+		a.estimate = a.bound(float64(limit))
+	}
+
+	if dropped || rtt > a.latency {
+		a.estimate = a.bound(a.estimate * a.backoff)
+	} else {
+		a.estimate = a.bound(a.estimate + 1/a.estimate)
+	}
+
+	return int(a.estimate)
+}
+
+func (g *gradient) update(limit int, rtt time.Duration, dropped bool) int {
+	if g.estimate == 0 {
+		g.estimate = g.bound(float64(limit))
+	}
+
+	sample := float64(rtt)
+
+	if g.minRTT == 0 || sample < g.minRTT {
+		g.minRTT = sample
+	} else {
+		g.minRTT *= minRTTDrift
+	}
+
+	grad := math.Max(minGradient, math.Min(1, g.minRTT/math.Max(sample, 1)))
+
+	// queue allowance lets limit grow while latency doesn't
+	next := g.estimate*grad + math.Sqrt(g.estimate)
+	if dropped {
+		next = g.estimate * minGradient
+	}
+	g.estimate = g.bound(g.estimate*(1-defaultSmoothing) + next*defaultSmoothing)
+
+	return int(g.estimate)
+}
+
+// newLimiter returns `limiter` for `opts`.
+// Panics if `opts` are invalid.
+func newLimiter(opts *Adaptive) limiter {
+	if opts.Min < minInt {
+		log.Panicf("non-positive Adaptive.Min")
+	}
+
+	if opts.Max < opts.Min {
+		log.Panicf("Adaptive.Max less than Adaptive.Min")
+	}
+
+	b := bounds{min: float64(opts.Min), max: float64(opts.Max)}
+
+	switch opts.Algorithm {
+	case AlgorithmAIMD:
+		if opts.Latency < minInt {
+			log.Panicf("non-positive Adaptive.Latency")
+		}
+
+		backoff := opts.Backoff
+		if backoff == 0 {
+			backoff = defaultBackoff
+		}
+
+		if backoff <= 0 || backoff >= 1 {
+			log.Panicf("Adaptive.Backoff out of (0, 1)")
+		}
+
+		return &aimd{bounds: b, latency: opts.Latency * time.Millisecond, backoff: backoff}
+	case AlgorithmGradient:
+		return &gradient{bounds: b}
+	default:
+		log.Panicf("unknown Adaptive.Algorithm (%s)", opts.Algorithm)
+	}
+
+	return nil
+}
+
+// clamp returns `n` bounded by `min` and `max`.
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+
+	if n > max {
+		return max
+	}
+
+	return n
+}
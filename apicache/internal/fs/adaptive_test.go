@@ -0,0 +1,177 @@
+package fs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestAIMD(t *testing.T) {
+	l := newLimiter(&Adaptive{Algorithm: AlgorithmAIMD, Min: 2, Max: 10, Latency: 10})
+
+	limit := 5
+
+	for i := 0; i < 100; i++ {
+		limit = l.update(limit, time.Millisecond, false)
+	}
+
+	if limit != 10 {
+		t.Errorf("update() fast calls limit = %d, want = %d", limit, 10)
+	}
+
+	if limit = l.update(limit, time.Millisecond, true); limit != 9 {
+		t.Errorf("update() dropped call limit = %d, want = %d", limit, 9)
+	}
+
+	for i := 0; i < 100; i++ {
+		limit = l.update(limit, time.Second, false)
+	}
+
+	if limit != 2 {
+		t.Errorf("update() slow calls limit = %d, want = %d", limit, 2)
+	}
+}
+
+func TestGradient(t *testing.T) {
+	l := newLimiter(&Adaptive{Algorithm: AlgorithmGradient, Min: 2, Max: 50})
+
+	limit := 5
+
+	for i := 0; i < 100; i++ {
+		limit = l.update(limit, 10*time.Millisecond, false)
+	}
+
+	if limit != 50 {
+		t.Errorf("update() stable latency limit = %d, want = %d", limit, 50)
+	}
+
+	for i := 0; i < 10; i++ {
+		limit = l.update(limit, 100*time.Millisecond, false)
+	}
+
+	if limit >= 30 {
+		t.Errorf("update() increased latency limit = %d, want < %d", limit, 30)
+	}
+
+	for i := 0; i < 100; i++ {
+		limit = l.update(limit, time.Second, true)
+	}
+
+	if limit != 2 {
+		t.Errorf("update() dropped calls limit = %d, want = %d", limit, 2)
+	}
+}
+
+func TestPoolObserve(t *testing.T) {
+	p := newPool(1, nil)
+	p.adapt = newLimiter(&Adaptive{Algorithm: AlgorithmAIMD, Min: 1, Max: 2, Latency: 10})
+
+	_ = p.acquire(context.Background(), opGet, time.Second)
+
+	acquired := make(chan struct{})
+
+	go func() {
+		if err := p.acquire(context.Background(), opGet, time.Minute); err == nil {
+			close(acquired)
+		}
+	}()
+
+	waitQueue(t, p, 1)
+
+	// 1 + 1/1 = 2 permits, so the waiting call gets one
+	p.observe(time.Millisecond, false)
+
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Errorf("observe() raised limit not dispatched")
+	}
+
+	if u := p.state(); u.Limit != 2 || u.Used != 2 || u.Waiting != 0 {
+		t.Errorf("state() = %+v, want = %+v", u, Usage{Limit: 2, Used: 2})
+	}
+}
+
+func TestFileSystemAdaptive(t *testing.T) {
+	fs := New(
+		&test.DriverMock{Storage: &sync.Map{}},
+		&Options{
+			MaxConn:  100,
+			ReadConn: 4,
+			Timeout:  1,
+			Adaptive: &Adaptive{Algorithm: AlgorithmAIMD, Min: 1, Max: 10, Latency: 100},
+		},
+	).(*fileSystem)
+
+	if l := fs.Limits(); l.Read.Limit != 4 || l.Write.Limit != 10 {
+		t.Errorf("Limits() = %+v, want bounded initial limits", l)
+	}
+
+	for i := 0; i < 10; i++ {
+		_, _ = fs.Get(test.KeyError)
+	}
+
+	if l := fs.Limits(); l.Read.Limit != 1 || l.Write.Limit != 10 {
+		t.Errorf("Limits() = %+v, read limit not decreased by errors", l)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// canceled calls are not observed
+	_ = fs.driver.SetContext(ctx, keyExist, valExist, ttlExist)
+	fs.observe(opSet, time.Now().Add(-time.Minute), ctx.Err())
+
+	if l := fs.Limits(); l.Write.Limit != 10 {
+		t.Errorf("Limits() = %+v, canceled call observed", l)
+	}
+}
+
+func TestNewLimiter(t *testing.T) {
+	cases := []struct {
+		name string
+		opts *Adaptive
+		err  string
+	}{
+		{
+			name: "non-positive min",
+			opts: &Adaptive{Algorithm: AlgorithmGradient},
+			err:  "non-positive Adaptive.Min",
+		},
+		{
+			name: "max less than min",
+			opts: &Adaptive{Algorithm: AlgorithmGradient, Min: 2, Max: 1},
+			err:  "Adaptive.Max less than Adaptive.Min",
+		},
+		{
+			name: "non-positive latency",
+			opts: &Adaptive{Algorithm: AlgorithmAIMD, Min: 1, Max: 1},
+			err:  "non-positive Adaptive.Latency",
+		},
+		{
+			name: "invalid backoff",
+			opts: &Adaptive{Algorithm: AlgorithmAIMD, Min: 1, Max: 1, Latency: 1, Backoff: 1.5},
+			err:  "Adaptive.Backoff out of (0, 1)",
+		},
+		{
+			name: "unknown algorithm",
+			opts: &Adaptive{Algorithm: "vegas", Min: 1, Max: 1},
+			err:  "unknown Adaptive.Algorithm (vegas)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err.(string) != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			newLimiter(c.opts)
+		})
+	}
+}
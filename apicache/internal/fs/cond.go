@@ -0,0 +1,200 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Modes of conditional set.
+const (
+	// ModeNX sets key only if it doesn't exist.
+	ModeNX = "nx"
+	// ModeXX sets key only if it exists.
+	ModeXX = "xx"
+)
+
+const opMode = "mode"
+
+type (
+	// SetOptions contains parameters of conditional set.
+	// `Mode` is empty (always set), `ModeNX` or `ModeXX`.
+	// Exactly one of relative `TTL` (with milliseconds precision), absolute `ExpireAt`
+	// or `NoExpire` (key is kept forever) must be set.
+	SetOptions struct {
+		Mode     string
+		TTL      time.Duration
+		ExpireAt time.Time
+		NoExpire bool
+	}
+	// CondSetter is implemented by `ContextDriver` which sets keys conditionally
+	// and expires them with milliseconds precision.
+	CondSetter interface {
+		// SetCondContext is `SetContext()` by `opts`, `ok` is false if `opts.Mode` condition is not met.
+		SetCondContext(ctx context.Context, key, val string, opts *SetOptions) (ok bool, err error)
+	}
+	// condFallback is `CondSetter` of `ContextDriver` which doesn't implement it.
+	condFallback struct {
+		driver ContextDriver
+	}
+	// ErrExist occurred if key exists and it is set with `ModeNX`.
+	ErrExist struct {
+		key string
+	}
+	// ErrInvalidMode occurred if mode of conditional set is unknown.
+	ErrInvalidMode struct {
+		key  string
+		mode string
+	}
+	// ErrInvalidExpiry occurred if expiration of conditional set is missed, ambiguous or passed.
+	ErrInvalidExpiry struct {
+		key    string
+		reason string
+	}
+)
+
+func (e *ErrExist) Error() string {
+	return fmt.Sprintf("key (%s) already exists", e.key)
+}
+
+func (e *ErrInvalidMode) Error() string {
+	return fmt.Sprintf("invalid mode (%s) for key (%s)", e.mode, e.key)
+}
+
+func (e *ErrInvalidExpiry) Error() string {
+	return fmt.Sprintf("invalid expiry for key (%s): %s", e.key, e.reason)
+}
+
+// NewErrInvalidExpiry returns `ErrInvalidExpiry` for `key` rejected by `reason`.
+// Uses by `Driver` consumers outside of the package.
+func NewErrInvalidExpiry(key, reason string) error {
+	return &ErrInvalidExpiry{key, reason}
+}
+
+// SetCondContext sets key and value by `SetContext()` with "time-to-live" rounded up to seconds,
+// conditional modes return `ErrNotSupported`.
+func (f condFallback) SetCondContext(ctx context.Context, key, val string, opts *SetOptions) (bool, error) {
+	if opts.Mode != "" {
+		return false, &ErrNotSupported{opMode}
+	}
+
+	if err := f.driver.SetContext(ctx, key, val, opts.TTLSeconds(time.Now())); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CondSetterOf returns `driver` as `CondSetter`, it falls back to `SetContext()` if it is not (see `condFallback`).
+// Uses by decorators which forward conditional sets to decorated `Driver`.
+func CondSetterOf(driver ContextDriver) CondSetter {
+	if setter, ok := driver.(CondSetter); ok {
+		return setter
+	}
+
+	return condFallback{driver}
+}
+
+// Expire returns expiration time of key set at `now` (zero time if it is never expired).
+func (o *SetOptions) Expire(now time.Time) time.Time {
+	switch {
+	case o.NoExpire:
+		return time.Time{}
+	case o.TTL != 0:
+		return now.Add(o.TTL)
+	default:
+		return o.ExpireAt
+	}
+}
+
+// TTLSeconds returns "time-to-live" of key set at `now` rounded up to seconds (zero if it is never expired).
+func (o *SetOptions) TTLSeconds(now time.Time) int {
+	expire := o.Expire(now)
+	if expire.IsZero() {
+		return 0
+	}
+
+	return int((expire.Sub(now) + time.Second - 1) / time.Second)
+}
+
+// validate checks `opts` of `key` at `now`.
+func (o *SetOptions) validate(key string, now time.Time) error {
+	if o.Mode != "" && o.Mode != ModeNX && o.Mode != ModeXX {
+		return &ErrInvalidMode{key, o.Mode}
+	}
+
+	passed := 0
+
+	for _, set := range []bool{o.TTL != 0, !o.ExpireAt.IsZero(), o.NoExpire} {
+		if set {
+			passed++
+		}
+	}
+
+	switch {
+	case passed == 0:
+		return &ErrInvalidExpiry{key, "ttl, expireAt or noExpire is required"}
+	case passed > 1:
+		return &ErrInvalidExpiry{key, "only one of ttl, expireAt or noExpire is allowed"}
+	case o.TTL != 0 && o.TTL < time.Millisecond:
+		return &ErrInvalidExpiry{key, fmt.Sprintf("ttl (%v) is less than 1ms", o.TTL)}
+	case !o.ExpireAt.IsZero() && !o.ExpireAt.After(now):
+		return &ErrInvalidExpiry{key, fmt.Sprintf("expireAt (%s) is passed", o.ExpireAt.Format(time.RFC3339Nano))}
+	}
+
+	return nil
+}
+
+// SetCond sets key and value by `opts` to key-value storage.
+func (d *fileSystem) SetCond(key, val string, opts *SetOptions) (bool, error) {
+	return d.SetCondContext(context.Background(), key, val, opts)
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage until `ctx` is done.
+// Returns `ErrExist` if `ModeNX` condition is not met and `ErrNotExist` if `ModeXX` one is not met.
+// If `Driver` is not `CondSetter` then `SetContext()` is used with "time-to-live" rounded up to seconds
+// and conditional modes return `ErrNotSupported`.
+func (d *fileSystem) SetCondContext(ctx context.Context, key, val string, opts *SetOptions) (bool, error) {
+	if key == "" {
+		return false, &ErrEmptyKey{}
+	}
+
+	if val == "" {
+		return false, &ErrEmptyVal{key}
+	}
+
+	now := time.Now()
+
+	if err := opts.validate(key, now); err != nil {
+		return false, err
+	}
+
+	if _, ok := d.driver.(CondSetter); !ok && opts.Mode != "" {
+		return false, &ErrNotSupported{opMode}
+	}
+
+	d.hot.touch(opSet, key)
+
+	if err := d.acquire(ctx, opSet); err != nil {
+		return false, err
+	}
+	defer d.release(opSet)
+
+	d.flight.forget(key)
+	defer d.flight.forget(key)
+
+	start := time.Now()
+	set, err := CondSetterOf(d.driver).SetCondContext(ctx, key, val, opts)
+	d.observe(opSet, start, err)
+
+	switch {
+	case err != nil:
+		return false, storageError(opSet, err)
+	case !set && opts.Mode == ModeNX:
+		return false, &ErrExist{key}
+	case !set:
+		return false, &ErrNotExist{key}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// condDriver is `DriverMock` implementing `CondSetter`.
+type condDriver struct {
+	*test.DriverMock
+	expire map[string]time.Time
+}
+
+func (d *condDriver) SetCondContext(ctx context.Context, key, val string, opts *SetOptions) (bool, error) {
+	_, exists := d.Storage.Load(key)
+
+	if opts.Mode == ModeNX && exists || opts.Mode == ModeXX && !exists {
+		return false, nil
+	}
+
+	if err := d.SetContext(ctx, key, val, 0); err != nil {
+		return false, err
+	}
+
+	d.expire[key] = opts.Expire(time.Now())
+
+	return true, nil
+}
+
+func TestFileSystemSetCond(t *testing.T) {
+	driver := &condDriver{DriverMock: &test.DriverMock{Storage: &sync.Map{}}, expire: make(map[string]time.Time)}
+	d := New(driver, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	at := time.Now().Add(time.Hour)
+	passed := time.Unix(1, 0)
+
+	cases := []struct {
+		name string
+		key  string
+		val  string
+		opts *SetOptions
+		err  error
+	}{
+		{"empty key", "", valExist, &SetOptions{NoExpire: true}, &ErrEmptyKey{}},
+		{"empty val", keyExist, "", &SetOptions{NoExpire: true}, &ErrEmptyVal{keyExist}},
+		{"invalid mode", keyExist, valExist, &SetOptions{Mode: "ex", NoExpire: true}, &ErrInvalidMode{keyExist, "ex"}},
+		{
+			"missed expiry",
+			keyExist, valExist,
+			&SetOptions{},
+			&ErrInvalidExpiry{keyExist, "ttl, expireAt or noExpire is required"},
+		},
+		{
+			"ambiguous expiry",
+			keyExist, valExist,
+			&SetOptions{TTL: time.Second, NoExpire: true},
+			&ErrInvalidExpiry{keyExist, "only one of ttl, expireAt or noExpire is allowed"},
+		},
+		{
+			"short ttl",
+			keyExist, valExist,
+			&SetOptions{TTL: time.Microsecond},
+			&ErrInvalidExpiry{keyExist, "ttl (1µs) is less than 1ms"},
+		},
+		{
+			"negative ttl",
+			keyExist, valExist,
+			&SetOptions{TTL: -time.Second},
+			&ErrInvalidExpiry{keyExist, "ttl (-1s) is less than 1ms"},
+		},
+		{
+			"passed expireAt",
+			keyExist, valExist,
+			&SetOptions{ExpireAt: passed},
+			&ErrInvalidExpiry{keyExist, fmt.Sprintf("expireAt (%s) is passed", passed.Format(time.RFC3339Nano))},
+		},
+		{"xx missed", "key:1", valExist, &SetOptions{Mode: ModeXX, TTL: 1500 * time.Millisecond}, &ErrNotExist{"key:1"}},
+		{"nx missed", "key:1", valExist, &SetOptions{Mode: ModeNX, TTL: 1500 * time.Millisecond}, nil},
+		{"nx existed", "key:1", valExist, &SetOptions{Mode: ModeNX, NoExpire: true}, &ErrExist{"key:1"}},
+		{"xx existed", "key:1", valExist, &SetOptions{Mode: ModeXX, ExpireAt: at}, nil},
+		{"upsert", "key:2", valExist, &SetOptions{NoExpire: true}, nil},
+		{
+			"storage error",
+			test.KeyError, valExist,
+			&SetOptions{NoExpire: true},
+			fmt.Errorf(ErrKVStorage, errors.New(test.InternalError)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, err := d.SetCond(c.key, c.val, c.opts)
+			if ok != (c.err == nil) || !reflect.DeepEqual(err, c.err) {
+				t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, c.err == nil, c.err)
+			}
+		})
+	}
+
+	if got := driver.expire["key:1"]; !got.Equal(at) {
+		t.Errorf("SetCond() expire got = %v, want = %v", got, at)
+	}
+
+	if got := driver.expire["key:2"]; !got.IsZero() {
+		t.Errorf("SetCond() expire got = %v, want = %v", got, time.Time{})
+	}
+}
+
+func TestFileSystemSetCondNotSupported(t *testing.T) {
+	d := New(&test.DriverMock{Storage: &sync.Map{}}, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	opts := &SetOptions{Mode: ModeNX, NoExpire: true}
+	if _, err := d.SetCond(keyExist, valExist, opts); !reflect.DeepEqual(err, &ErrNotSupported{opMode}) {
+		t.Errorf("SetCond() error = %v, want = %v", err, &ErrNotSupported{opMode})
+	}
+
+	// no mode is a plain set
+	if ok, err := d.SetCond(keyExist, valExist, &SetOptions{TTL: 1500 * time.Millisecond}); !ok || err != nil {
+		t.Errorf("SetCond() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+}
+
+func TestSetOptionsTTLSeconds(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	cases := []struct {
+		opts *SetOptions
+		want int
+	}{
+		{&SetOptions{NoExpire: true}, 0},
+		{&SetOptions{TTL: time.Millisecond}, 1},
+		{&SetOptions{TTL: 2 * time.Second}, 2},
+		{&SetOptions{TTL: 2001 * time.Millisecond}, 3},
+		{&SetOptions{ExpireAt: now.Add(time.Minute)}, 60},
+	}
+	for _, c := range cases {
+		if got := c.opts.TTLSeconds(now); got != c.want {
+			t.Errorf("TTLSeconds() got = %v, want = %v", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+// Package drivertest provides conformance test suite for `fs.Driver` implementations.
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	shortExpire = 1 // seconds
+	// expireWait covers storages with seconds granularity of expiration.
+	expireWait   = 2500 * time.Millisecond
+	closeTimeout = 5 * time.Second
+	workers      = 8
+	iterations   = 50
+)
+
+// Factory returns a new `fs.Driver` under test.
+// Drivers may share the same storage, keys are unique for every test.
+type Factory func() fs.Driver
+
+// suite keeps state of a single `Run`.
+type suite struct {
+	factory Factory
+	prefix  string
+}
+
+// key returns the key unique for the `Run`.
+// Keys contain no spaces and control characters to be valid for all storages.
+func (s *suite) key(name string) string {
+	return s.prefix + name
+}
+
+// driver returns a new driver closed after `t`.
+func (s *suite) driver(t *testing.T) (fs.Driver, func()) {
+	t.Helper()
+
+	d := s.factory()
+	if d == nil {
+		t.Fatalf("factory returned nil driver")
+	}
+
+	return d, d.Close
+}
+
+// Run runs the `fs.Driver` contract suite against drivers made by `factory`:
+//   - `Get()` returns empty value and nil error for missed key;
+//   - `Set()` keeps key forever if `ttl == 0`, expires it after `ttl` seconds
+//     if `ttl > 0` and deletes it if `ttl < 0`;
+//   - `Delete()` returns true only if key existed;
+//   - operations are safe for concurrent use;
+//   - `ContextDriver` operations are aborted by canceled context;
+//   - `Close()` doesn't block and operations after it don't panic;
+//   - `fs.Tagger` replaces tags of overwritten key (untagged overwrite drops them) and deletes keys by tag,
+//     `fs.Matcher` deletes keys by pattern (skipped if `fs.ErrNotSupported` is returned).
+func Run(t *testing.T, factory Factory) {
+	s := &suite{
+		factory: factory,
+		prefix:  "drivertest-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "-",
+	}
+
+	t.Run("GetMiss", s.testGetMiss)
+	t.Run("SetGet", s.testSetGet)
+	t.Run("SetOverwrite", s.testSetOverwrite)
+	t.Run("SetNegativeTTL", s.testSetNegativeTTL)
+	t.Run("Expire", s.testExpire)
+	t.Run("Delete", s.testDelete)
+	t.Run("Concurrency", s.testConcurrency)
+	t.Run("Context", s.testContext)
+	t.Run("Close", s.testClose)
+	t.Run("Tags", s.testTags)
+	t.Run("Pattern", s.testPattern)
+}
+
+func (s *suite) testGetMiss(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	if val, err := d.Get(s.key("miss")); val != "" || err != nil {
+		t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+}
+
+func (s *suite) testSetGet(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	cases := []struct {
+		name string
+		key  string
+		val  string
+		ttl  int
+	}{
+		{
+			name: "without expire",
+			key:  s.key("set-without-expire"),
+			val:  "val-without-expire",
+		},
+		{
+			name: "with expire",
+			key:  s.key("set-with-expire"),
+			val:  "val-with-expire",
+			ttl:  100,
+		},
+		{
+			name: "unicode value",
+			key:  s.key("set-unicode"),
+			val:  "значение with spaces\tand\nlines",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := d.Set(c.key, c.val, c.ttl); err != nil {
+				t.Fatalf("Set() error = %v, want = %v", err, nil)
+			}
+
+			if val, err := d.Get(c.key); val != c.val || err != nil {
+				t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, c.val, nil)
+			}
+		})
+	}
+}
+
+func (s *suite) testSetOverwrite(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	key := s.key("overwrite")
+
+	_ = d.Set(key, "old", 0)
+
+	if err := d.Set(key, "new", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if val, err := d.Get(key); val != "new" || err != nil {
+		t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, "new", nil)
+	}
+}
+
+func (s *suite) testSetNegativeTTL(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	key := s.key("negative-ttl")
+
+	_ = d.Set(key, "val", 0)
+
+	if err := d.Set(key, "val", -10); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if val, err := d.Get(key); val != "" || err != nil {
+		t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+}
+
+func (s *suite) testExpire(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	var (
+		expired   = s.key("expire-short")
+		forever   = s.key("expire-forever")
+		persisted = s.key("expire-persisted")
+	)
+
+	_ = d.Set(expired, "val", shortExpire)
+	_ = d.Set(forever, "val", 0)
+	// zero ttl removes previous expiration
+	_ = d.Set(persisted, "val", shortExpire)
+	_ = d.Set(persisted, "val", 0)
+
+	time.Sleep(expireWait)
+
+	cases := []struct {
+		key  string
+		want string
+	}{
+		{expired, ""},
+		{forever, "val"},
+		{persisted, "val"},
+	}
+	for _, c := range cases {
+		if val, err := d.Get(c.key); val != c.want || err != nil {
+			t.Errorf("Get(%s) got = %q, %v, want = %q, %v", c.key, val, err, c.want, nil)
+		}
+	}
+
+	if ok, err := d.Delete(expired); ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+}
+
+func (s *suite) testDelete(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	key := s.key("delete")
+
+	_ = d.Set(key, "val", 0)
+
+	if ok, err := d.Delete(key); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if val, err := d.Get(key); val != "" || err != nil {
+		t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+
+	if ok, err := d.Delete(key); ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+}
+
+// notSupported checks `err` is `fs.ErrNotSupported`.
+func notSupported(err error) bool {
+	var ens *fs.ErrNotSupported
+	return errors.As(err, &ens)
+}
+
+func (s *suite) testTags(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	tagger, ok := d.(fs.Tagger)
+	if !ok {
+		t.Skip("driver is not fs.Tagger")
+	}
+
+	ctx := context.Background()
+	first, second := s.key("tags-first"), s.key("tags-second")
+	tagA, tagB := s.key("tag-a"), s.key("tag-b")
+
+	err := tagger.SetTagsContext(ctx, first, "val", 0, []string{tagA, tagB})
+	if notSupported(err) {
+		t.Skip("tags are not supported")
+	}
+
+	if err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	_ = tagger.SetTagsContext(ctx, second, "val", 100, []string{tagA})
+
+	// tags of overwritten key are replaced
+	if err := tagger.SetTagsContext(ctx, first, "new", 0, []string{tagB}); err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	if n, err := tagger.DeleteTagContext(ctx, tagA); n != 1 || err != nil {
+		t.Errorf("DeleteTagContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+
+	if val, err := d.Get(first); val != "new" || err != nil {
+		t.Errorf("Get() of retagged got = %q, %v, want = %q, %v", val, err, "new", nil)
+	}
+
+	if val, err := d.Get(second); val != "" || err != nil {
+		t.Errorf("Get() of deleted got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+
+	if n, err := tagger.DeleteTagContext(ctx, tagB); n != 1 || err != nil {
+		t.Errorf("DeleteTagContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+
+	if val, err := d.Get(first); val != "" || err != nil {
+		t.Errorf("Get() of deleted got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+
+	// untagged overwrite drops tags
+	if err := tagger.SetTagsContext(ctx, first, "val", 0, []string{tagA}); err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	if err := d.Set(first, "untagged", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if n, err := tagger.DeleteTagContext(ctx, tagA); n != 0 || err != nil {
+		t.Errorf("DeleteTagContext() of untagged got = %v, %v, want = %v, %v", n, err, 0, nil)
+	}
+
+	if val, err := d.Get(first); val != "untagged" || err != nil {
+		t.Errorf("Get() of untagged got = %q, %v, want = %q, %v", val, err, "untagged", nil)
+	}
+}
+
+func (s *suite) testPattern(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	matcher, ok := d.(fs.Matcher)
+	if !ok {
+		t.Skip("driver is not fs.Matcher")
+	}
+
+	for _, name := range []string{"pattern-1", "pattern-2", "other"} {
+		_ = d.Set(s.key(name), "val", 0)
+	}
+
+	n, err := matcher.DeletePatternContext(context.Background(), s.key("pattern-*"))
+	if notSupported(err) {
+		t.Skip("patterns are not supported")
+	}
+
+	if n != 2 || err != nil {
+		t.Errorf("DeletePatternContext() got = %v, %v, want = %v, %v", n, err, 2, nil)
+	}
+
+	if val, err := d.Get(s.key("pattern-1")); val != "" || err != nil {
+		t.Errorf("Get() of deleted got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+
+	if val, err := d.Get(s.key("other")); val != "val" || err != nil {
+		t.Errorf("Get() of not matched got = %q, %v, want = %q, %v", val, err, "val", nil)
+	}
+}
+
+func (s *suite) testConcurrency(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	var (
+		wg     sync.WaitGroup
+		shared = s.key("concurrency-shared")
+		errs   = make(chan error, workers*iterations)
+	)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+
+		go func(w int) {
+			defer wg.Done()
+
+			own := s.key("concurrency-" + strconv.Itoa(w))
+
+			for i := 0; i < iterations; i++ {
+				val := fmt.Sprintf("%d-%d", w, i)
+
+				if err := d.Set(own, val, 0); err != nil {
+					errs <- fmt.Errorf("Set() error = %v", err)
+					return
+				}
+
+				if got, err := d.Get(own); got != val || err != nil {
+					errs <- fmt.Errorf("Get() got = %q, %v, want = %q, %v", got, err, val, nil)
+					return
+				}
+
+				if err := d.Set(shared, val, 0); err != nil {
+					errs <- fmt.Errorf("Set() error = %v", err)
+					return
+				}
+			}
+
+			if ok, err := d.Delete(own); !ok || err != nil {
+				errs <- fmt.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// the last write wins, whichever it was
+	if val, err := d.Get(shared); val == "" || err != nil {
+		t.Errorf("Get() got = %q, %v, want one of written values", val, err)
+	}
+}
+
+func (s *suite) testContext(t *testing.T) {
+	d, done := s.driver(t)
+	defer done()
+
+	cd, ok := d.(fs.ContextDriver)
+	if !ok {
+		t.Skip("driver doesn't implement fs.ContextDriver")
+	}
+
+	key := s.key("context")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := cd.SetContext(ctx, key, "val", 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("SetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if val, err := cd.GetContext(ctx, key); val != "" || !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext() got = %q, %v, want = %q, %v", val, err, "", context.Canceled)
+	}
+
+	if ok, err := cd.DeleteContext(ctx, key); ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteContext() got = %v, %v, want = %v, %v", ok, err, false, context.Canceled)
+	}
+
+	if err := cd.SetContext(context.Background(), key, "val", 0); err != nil {
+		t.Errorf("SetContext() error = %v, want = %v", err, nil)
+	}
+
+	if val, err := cd.GetContext(context.Background(), key); val != "val" || err != nil {
+		t.Errorf("GetContext() got = %q, %v, want = %q, %v", val, err, "val", nil)
+	}
+}
+
+func (s *suite) testClose(t *testing.T) {
+	d := s.factory()
+	closed := make(chan struct{})
+
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(closeTimeout):
+		t.Fatalf("Close() blocked for %v", closeTimeout)
+	}
+
+	// results are driver specific, but calls must not panic
+	defer func() {
+		if err := recover(); err != nil {
+			t.Errorf("call after Close() panic = %v", err)
+		}
+	}()
+
+	key := s.key("close")
+
+	_ = d.Set(key, "val", 0)
+	_, _ = d.Get(key)
+	_, _ = d.Delete(key)
+}
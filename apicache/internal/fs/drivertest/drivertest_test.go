@@ -0,0 +1,15 @@
+package drivertest
+
+import (
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+func TestFake(t *testing.T) {
+	Run(t, func() fs.Driver { return NewFake() })
+}
+
+func TestFlaky(t *testing.T) {
+	Run(t, func() fs.Driver { return NewFlaky() })
+}
@@ -0,0 +1,110 @@
+package drivertest
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// Fake is the minimal in-process `fs.ContextDriver`.
+	// It is a reference implementation of the `fs.Driver` contract.
+	Fake struct {
+		mu    sync.Mutex
+		items map[string]fakeItem
+	}
+	// fakeItem is a stored value with expiration time.
+	fakeItem struct {
+		val    string
+		expire time.Time
+	}
+)
+
+// lookup returns not expired item of `key`, expired one is removed.
+func (f *Fake) lookup(key string) (fakeItem, bool) {
+	item, ok := f.items[key]
+
+	if ok && !item.expire.IsZero() && !time.Now().Before(item.expire) {
+		delete(f.items, key)
+		return fakeItem{}, false
+	}
+
+	return item, ok
+}
+
+// Get gets key from key-value storage.
+func (f *Fake) Get(key string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	item, _ := f.lookup(key)
+
+	return item.val, nil
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+// Storage deletes `key` if `ttl < 0` and keeps it forever if `ttl == 0`.
+func (f *Fake) Set(key, val string, ttl int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if ttl < 0 {
+		delete(f.items, key)
+		return nil
+	}
+
+	item := fakeItem{val: val}
+	if ttl > 0 {
+		item.expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	f.items[key] = item
+
+	return nil
+}
+
+// Delete deletes key from key-value storage.
+func (f *Fake) Delete(key string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, ok := f.lookup(key)
+	delete(f.items, key)
+
+	return ok, nil
+}
+
+// GetContext gets key from key-value storage if `ctx` is not done.
+func (f *Fake) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return f.Get(key)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage if `ctx` is not done.
+func (f *Fake) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return f.Set(key, val, ttl)
+}
+
+// DeleteContext deletes key from key-value storage if `ctx` is not done.
+func (f *Fake) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return f.Delete(key)
+}
+
+// Close calls to release key-value storage resources.
+func (f *Fake) Close() {}
+
+// NewFake returns empty `Fake`.
+func NewFake() *Fake {
+	return &Fake{items: make(map[string]fakeItem)}
+}
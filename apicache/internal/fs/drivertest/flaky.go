@@ -0,0 +1,134 @@
+package drivertest
+
+import (
+	"sync"
+)
+
+// Flaky wraps `Fake` for tests of decorators: it counts calls and writes,
+// fails calls on demand and holds them until released.
+// It is not `fs.ContextDriver`, so decorated calls go through it's methods.
+type Flaky struct {
+	fake     *Fake
+	mu       sync.Mutex
+	gate     <-chan struct{}
+	failures int
+	err      error
+	calls    int
+	sets     map[string]int
+	ttls     map[string]int
+	closed   bool
+}
+
+// call waits for the gate, counts the call and returns it's injected error.
+func (f *Flaky) call() error {
+	f.mu.Lock()
+	gate := f.gate
+	f.mu.Unlock()
+
+	if gate != nil {
+		<-gate
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+
+	if f.failures == 0 {
+		return nil
+	}
+
+	// negative failures fail all calls
+	if f.failures > 0 {
+		f.failures--
+	}
+
+	return f.err
+}
+
+// Get gets key from key-value storage.
+func (f *Flaky) Get(key string) (string, error) {
+	if err := f.call(); err != nil {
+		return "", err
+	}
+
+	return f.fake.Get(key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (f *Flaky) Set(key, val string, ttl int) error {
+	if err := f.call(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.sets[key]++
+	f.ttls[key] = ttl
+	f.mu.Unlock()
+
+	return f.fake.Set(key, val, ttl)
+}
+
+// Delete deletes key from key-value storage.
+func (f *Flaky) Delete(key string) (bool, error) {
+	if err := f.call(); err != nil {
+		return false, err
+	}
+
+	return f.fake.Delete(key)
+}
+
+// Close calls to release key-value storage resources.
+func (f *Flaky) Close() {
+	f.mu.Lock()
+	f.closed = true
+	f.mu.Unlock()
+
+	f.fake.Close()
+}
+
+// Fail fails the next `n` calls with `err`, all of them if `n` is negative.
+// Zero `n` stops failing.
+func (f *Flaky) Fail(n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.failures, f.err = n, err
+}
+
+// Hold makes calls wait until `gate` is closed.
+func (f *Flaky) Hold(gate <-chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.gate = gate
+}
+
+// Calls returns the number of calls made.
+func (f *Flaky) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.calls
+}
+
+// Writes returns the number of successful writes of `key` and "time-to-live" of the last one.
+func (f *Flaky) Writes(key string) (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.sets[key], f.ttls[key]
+}
+
+// Closed checks `Close()` is called.
+func (f *Flaky) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.closed
+}
+
+// NewFlaky returns empty `Flaky`.
+func NewFlaky() *Flaky {
+	return &Flaky{fake: NewFake(), sets: make(map[string]int), ttls: make(map[string]int)}
+}
@@ -0,0 +1,89 @@
+package fs
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// call is in-flight or completed `Get()` shared by concurrent callers.
+	call struct {
+		done    chan struct{}
+		cancel  context.CancelFunc
+		waiters int
+		val     string
+		err     error
+	}
+	// flight coalesces concurrent `Get()` calls for the same key
+	// into the single `Driver` call ("single flight").
+	flight struct {
+		mu    sync.Mutex
+		calls map[string]*call
+	}
+)
+
+// do calls `fn` for `key` or joins the in-flight call and returns it's result.
+// `fn` is called with own context which is canceled only if all callers are gone.
+func (g *flight) do(ctx context.Context, key string, fn func(ctx context.Context) (string, error)) (string, error) {
+	g.mu.Lock()
+
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+
+	c, ok := g.calls[key]
+	if !ok {
+		fctx, cancel := context.WithCancel(context.Background())
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = c
+
+		go func() {
+			c.val, c.err = fn(fctx)
+
+			g.mu.Lock()
+			g.remove(key, c)
+			g.mu.Unlock()
+
+			cancel()
+			close(c.done)
+		}()
+	}
+
+	c.waiters++
+
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			g.remove(key, c)
+		}
+
+		g.mu.Unlock()
+
+		return "", &ErrCanceled{opGet, ctx.Err()}
+	}
+}
+
+// forget detaches in-flight call for `key`, so next callers don't join it.
+// Uses by mutations to avoid sharing results which started before them.
+func (g *flight) forget(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.calls, key)
+}
+
+// remove removes `c` for `key` if it is not replaced yet.
+// Must be called under lock.
+func (g *flight) remove(key string, c *call) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
@@ -0,0 +1,193 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// countingDriver counts `GetContext()` calls of inner `DriverMock`.
+type countingDriver struct {
+	*test.DriverMock
+	gets int64
+}
+
+func (d *countingDriver) GetContext(ctx context.Context, key string) (string, error) {
+	atomic.AddInt64(&d.gets, 1)
+	return d.DriverMock.GetContext(ctx, key)
+}
+
+// newCoalescing returns `fileSystem` over slow `countingDriver`.
+func newCoalescing() (*fileSystem, *countingDriver) {
+	cd := &countingDriver{
+		DriverMock: &test.DriverMock{
+			Storage:      &sync.Map{},
+			IsConcurrent: true,
+		},
+	}
+
+	cd.Storage.Store(keyExist, valExist)
+
+	return New(cd, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem), cd
+}
+
+func TestFileSystemCoalescing(t *testing.T) {
+	fs, cd := newCoalescing()
+
+	cases := []struct {
+		name string
+		key  string
+		want string
+		err  string
+	}{
+		{
+			name: "shared value",
+			key:  keyExist,
+			want: valExist,
+		},
+		{
+			name: "shared error",
+			key:  test.KeyError,
+			err:  fmt.Errorf(ErrKVStorage, errors.New(test.InternalError)).Error(),
+		},
+		{
+			name: "shared not exist",
+			key:  test.KeyNotExist,
+			err:  (&ErrNotExist{test.KeyNotExist}).Error(),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			atomic.StoreInt64(&cd.gets, 0)
+
+			var wg sync.WaitGroup
+
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					got, err := fs.Get(c.key)
+					if got != c.want {
+						t.Errorf("Get() got = %v, want = %v", got, c.want)
+					}
+
+					if err != nil && err.Error() != c.err || err == nil && c.err != "" {
+						t.Errorf("Get() error = %v, want = %v", err, c.err)
+					}
+				}()
+			}
+
+			time.Sleep(100 * time.Millisecond)
+
+			if used, _ := fs.read.usage(); used != 1 {
+				t.Errorf("Get() used permits = %d, want = %d", used, 1)
+			}
+
+			wg.Wait()
+
+			if gets := atomic.LoadInt64(&cd.gets); gets != 1 {
+				t.Errorf("Get() driver calls = %d, want = %d", gets, 1)
+			}
+		})
+	}
+}
+
+func TestFileSystemCoalescingMutation(t *testing.T) {
+	fs, cd := newCoalescing()
+
+	var wg sync.WaitGroup
+
+	wg.Add(2)
+
+	// started before Set, so returns the old value
+	go func() {
+		defer wg.Done()
+
+		if got, _ := fs.Get(keyExist); got != valExist {
+			t.Errorf("Get() got = %v, want = %v", got, valExist)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := fs.Set(keyExist, "new", ttlExist); err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	// started after Set, so must not join in-flight Get
+	go func() {
+		defer wg.Done()
+
+		if got, _ := fs.Get(keyExist); got != "new" {
+			t.Errorf("Get() got = %v, want = %v", got, "new")
+		}
+	}()
+
+	wg.Wait()
+
+	if gets := atomic.LoadInt64(&cd.gets); gets != 2 {
+		t.Errorf("Get() driver calls = %d, want = %d", gets, 2)
+	}
+}
+
+func TestFileSystemCoalescingCancel(t *testing.T) {
+	fs, cd := newCoalescing()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var ecl *ErrCanceled
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if _, err := fs.GetContext(ctx, keyExist); !errors.As(err, &ecl) {
+			t.Errorf("GetContext() error = %v, want = %v", err, &ErrCanceled{opGet, context.Canceled})
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// one of callers gone, the call is still alive for others
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	if got, err := fs.Get(keyExist); got != valExist || err != nil {
+		t.Errorf("Get() = %v, %v, want = %v, %v", got, err, valExist, nil)
+	}
+
+	<-done
+
+	if gets := atomic.LoadInt64(&cd.gets); gets != 1 {
+		t.Errorf("Get() driver calls = %d, want = %d", gets, 1)
+	}
+
+	// all callers gone, the call is aborted
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if _, err := fs.GetContext(ctx, keyExist); !errors.As(err, &ecl) {
+		t.Errorf("GetContext() error = %v, want = %v", err, &ErrCanceled{opGet, context.DeadlineExceeded})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if used, _ := fs.read.usage(); used != 0 || time.Since(start) > time.Second {
+		t.Errorf("GetContext() driver call not aborted")
+	}
+}
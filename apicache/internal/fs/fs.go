@@ -1,8 +1,13 @@
 package fs
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +40,21 @@ type (
 	ErrNotExist struct {
 		key string
 	}
+	// ErrInsufficientStorage occurred if `Driver` has no room for key
+	// and it's eviction policy rejects the write.
+	ErrInsufficientStorage struct {
+		key string
+	}
+	// ErrInvalidKey occurred if `Driver` doesn't accept the key (e.g. it is too long).
+	ErrInvalidKey struct {
+		key    string
+		reason string
+	}
+	// ErrCanceled occurred if operation context is canceled or it's deadline exceeded.
+	ErrCanceled struct {
+		op  string
+		err error
+	}
 )
 
 func (e *ErrConcurrentTimeout) Error() string {
@@ -61,6 +81,68 @@ func (e *ErrNotExist) Error() string {
 	return fmt.Sprintf("key (%s) not exist", e.key)
 }
 
+func (e *ErrInsufficientStorage) Error() string {
+	return fmt.Sprintf("insufficient storage for key (%s)", e.key)
+}
+
+func (e *ErrInvalidKey) Error() string {
+	return fmt.Sprintf("invalid key (%s): %s", e.key, e.reason)
+}
+
+func (e *ErrCanceled) Error() string {
+	return fmt.Sprintf("operation (%s) canceled: %v", e.op, e.err)
+}
+
+// Unwrap returns context error of canceled operation.
+func (e *ErrCanceled) Unwrap() error {
+	return e.err
+}
+
+// NewErrCanceled returns `ErrCanceled` for `op` ("get", "set" or "del") canceled with `err`.
+// Uses by `Driver` consumers outside of the package.
+func NewErrCanceled(op string, err error) error {
+	return &ErrCanceled{op, err}
+}
+
+// NewErrInsufficientStorage returns `ErrInsufficientStorage` for `key`.
+// Uses by `Driver` implementations outside of the package.
+func NewErrInsufficientStorage(key string) error {
+	return &ErrInsufficientStorage{key}
+}
+
+// NewErrInvalidKey returns `ErrInvalidKey` for `key` rejected by `reason`.
+// Uses by `Driver` implementations outside of the package.
+func NewErrInvalidKey(key, reason string) error {
+	return &ErrInvalidKey{key, reason}
+}
+
+// rejected checks `err` is typed rejection returned by `Driver` itself,
+// it is not a storage failure.
+func rejected(err error) bool {
+	var (
+		eis *ErrInsufficientStorage
+		eik *ErrInvalidKey
+		ens *ErrNotSupported
+	)
+
+	return errors.As(err, &eis) || errors.As(err, &eik) || errors.As(err, &ens)
+}
+
+// storageError wraps `Driver` error in `ErrKVStorage`
+// except typed errors that `Driver` may return by itself
+// and context errors that are returned as `ErrCanceled`.
+func storageError(op string, err error) error {
+	if rejected(err) {
+		return err
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &ErrCanceled{op, err}
+	}
+
+	return fmt.Errorf(ErrKVStorage, err)
+}
+
 const (
 	opGet = "get"
 	opSet = "set"
@@ -70,71 +152,257 @@ const (
 type (
 	// Driver represents a main interface that available
 	// for APICache to manipulate with inner key-value storage.
+	// Implementations must be safe for concurrent use,
+	// `drivertest.Run()` checks the contract.
 	Driver interface {
 		// Get gets key from key-value storage.
 		// Calling packages waits that `Get()` will not return error if key not exist.
 		// Otherwise `err` will be wrapped in `ErrKVStorage`.
 		Get(key string) (val string, err error)
 		// Set sets key, value and "time-to-live" to key-value storage.
+		// Key is kept forever if `ttl == 0` and deleted if `ttl < 0`.
 		Set(key, val string, ttl int) (err error)
 		// Delete deletes key from key-value storage.
+		// `ok` is false if key not exist.
 		Delete(key string) (ok bool, err error)
 		// Close calls to release key-value storage resources.
 		Close()
 	}
+	// ContextDriver is `Driver` which operations can be aborted
+	// by cancellation or deadline of `context.Context`.
+	ContextDriver interface {
+		Driver
+		// GetContext is `Get()` bounded by `ctx`.
+		GetContext(ctx context.Context, key string) (val string, err error)
+		// SetContext is `Set()` bounded by `ctx`.
+		SetContext(ctx context.Context, key, val string, ttl int) (err error)
+		// DeleteContext is `Delete()` bounded by `ctx`.
+		DeleteContext(ctx context.Context, key string) (ok bool, err error)
+	}
+	// Drainer is implemented by `Driver` which can stop accepting new calls
+	// and wait for the processed ones with a deadline.
+	Drainer interface {
+		// Drain rejects new calls and waits until processed (and queued) ones are finished or `ctx` is done.
+		// Returns the number of calls that are not finished.
+		Drain(ctx context.Context) (pending int)
+	}
+	// Wrapper is implemented by `Driver` which decorates another one (see `As()`).
+	Wrapper interface {
+		// Unwrap returns decorated `Driver`.
+		Unwrap() Driver
+	}
+	// contextDriver adapts `Driver` without context support to `ContextDriver`.
+	contextDriver struct {
+		Driver
+	}
 	// Options contains `Driver` specific parameters.
+	// By default all operations share `MaxConn` permits, if `ReadConn` or `WriteConn`
+	// is set then "get" and "set"/"del" operations use independent pools
+	// (the missed one falls back to `MaxConn`).
+	// `Priority` contains weights of operations ("get", "set", "del") inside a pool,
+	// calls are served in FIFO order if it is not set.
+	// If `Adaptive` is set then pools limits are changed at runtime.
+	// If `HotKeys` is set then the most frequently accessed keys are tracked.
 	Options struct {
-		MaxConn int           `json:"maxConn"`
-		Timeout time.Duration `json:"timeout"`
+		MaxConn   int            `json:"maxConn"`
+		ReadConn  int            `json:"readConn"`
+		WriteConn int            `json:"writeConn"`
+		Timeout   time.Duration  `json:"timeout"`
+		Timeouts  Timeouts       `json:"timeouts"`
+		Priority  map[string]int `json:"priority"`
+		Adaptive  *Adaptive      `json:"adaptive"`
+		HotKeys   *HotKeys       `json:"hotKeys"`
 	}
-	// fileSystem implements `Driver` interface.
+	// Timeouts contains per-operation queue waiting timeouts in seconds.
+	// Zero value falls back to `Options.Timeout`.
+	Timeouts struct {
+		Get time.Duration `json:"get"`
+		Set time.Duration `json:"set"`
+		Del time.Duration `json:"del"`
+	}
+	// fileSystem implements `ContextDriver` interface.
 	fileSystem struct {
-		driver Driver
-		opts   *Options
-		done   chan struct{}
-		queue  chan struct{}
+		driver  ContextDriver
+		opts    *Options
+		done    chan struct{}
+		closing sync.Once
+		drained int32
+		read    *pool
+		write   *pool
+		flight  flight
+		hot     *hotKeys
 	}
 )
 
-// acquire checks `Driver`'s availability to process incoming calls.
-func (d *fileSystem) acquire(op string) error {
-	ticker := time.NewTicker(d.opts.Timeout * time.Second)
-	defer ticker.Stop()
+// GetContext checks `ctx` before calling `Get()`.
+func (d contextDriver) GetContext(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	return d.Get(key)
+}
+
+// SetContext checks `ctx` before calling `Set()`.
+func (d contextDriver) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return d.Set(key, val, ttl)
+}
+
+// DeleteContext checks `ctx` before calling `Delete()`.
+func (d contextDriver) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return d.Delete(key)
+}
+
+// WithContext returns `driver` as `ContextDriver`.
+// If `driver` doesn't support context then it's operations
+// can be aborted only before they are started.
+func WithContext(driver Driver) ContextDriver {
+	if d, ok := driver.(ContextDriver); ok {
+		return d
+	}
+
+	return contextDriver{driver}
+}
+
+// Unwrap returns adapted `Driver`.
+func (d contextDriver) Unwrap() Driver {
+	return d.Driver
+}
+
+// As finds the first `Driver` in chain of `driver` and drivers decorated by it (see `Wrapper`)
+// that is assignable to value pointed by `target`, and if so, sets `target` to it.
+// Panics if `target` is not a non-nil pointer.
+func As(driver Driver, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if val.Kind() != reflect.Ptr || val.IsNil() {
+		log.Panicf("target must be a non-nil pointer")
+	}
+
+	typ := val.Type().Elem()
+
+	for driver != nil {
+		if reflect.TypeOf(driver).AssignableTo(typ) {
+			val.Elem().Set(reflect.ValueOf(driver))
+			return true
+		}
+
+		w, ok := driver.(Wrapper)
+		if !ok {
+			return false
+		}
+
+		driver = w.Unwrap()
+	}
+
+	return false
+}
+
+// pool returns "connection pool" for `op`.
+func (d *fileSystem) pool(op string) *pool {
+	if op == opGet {
+		return d.read
+	}
+
+	return d.write
+}
+
+// timeout returns queue waiting timeout for `op`.
+func (d *fileSystem) timeout(op string) time.Duration {
+	timeout := d.opts.Timeout
+
+	switch {
+	case op == opGet && d.opts.Timeouts.Get != 0:
+		timeout = d.opts.Timeouts.Get
+	case op == opSet && d.opts.Timeouts.Set != 0:
+		timeout = d.opts.Timeouts.Set
+	case op == opDel && d.opts.Timeouts.Del != 0:
+		timeout = d.opts.Timeouts.Del
+	}
+
+	return timeout * time.Second
+}
 
+// acquire checks `Driver`'s availability to process incoming calls.
+// Waiting is aborted if `ctx` is done.
+func (d *fileSystem) acquire(ctx context.Context, op string) error {
 	select {
 	case <-d.done:
 		return &ErrCloseDriver{}
 	default:
 	}
 
-	select {
-	case d.queue <- struct{}{}:
-		return nil
-	case <-ticker.C:
-		return &ErrConcurrentTimeout{op}
-	}
+	return d.pool(op).acquire(ctx, op, d.timeout(op))
 }
 
 // release releases one call from "connection pool"
 // to give availability to process another incoming calls.
-func (d *fileSystem) release() {
-	<-d.queue
+func (d *fileSystem) release(op string) {
+	d.pool(op).release()
+}
+
+// observe reports to "connection pool" latency of `Driver` call started at `start`.
+// Calls canceled by client are not taken into account.
+func (d *fileSystem) observe(op string, start time.Time, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return
+	case err == nil, rejected(err):
+		d.pool(op).observe(time.Since(start), false)
+	default:
+		d.pool(op).observe(time.Since(start), true)
+	}
+}
+
+// Unwrap returns decorated `Driver`.
+func (d *fileSystem) Unwrap() Driver {
+	return d.driver
+}
+
+// Limits returns current state of "connection pools".
+func (d *fileSystem) Limits() Limits {
+	return Limits{Read: d.read.state(), Write: d.write.state()}
 }
 
 // Get gets key from key-value storage.
 func (d *fileSystem) Get(key string) (string, error) {
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from key-value storage until `ctx` is done.
+// Concurrent calls for the same key share the single `Driver` call and it's result.
+func (d *fileSystem) GetContext(ctx context.Context, key string) (string, error) {
 	if key == "" {
 		return "", &ErrEmptyKey{}
 	}
 
-	if err := d.acquire(opGet); err != nil {
+	d.hot.touch(opGet, key)
+
+	return d.flight.do(ctx, key, func(ctx context.Context) (string, error) {
+		return d.get(ctx, key)
+	})
+}
+
+// get gets key from key-value storage under permit of "connection pool".
+func (d *fileSystem) get(ctx context.Context, key string) (string, error) {
+	if err := d.acquire(ctx, opGet); err != nil {
 		return "", err
 	}
-	defer d.release()
+	defer d.release(opGet)
+
+	start := time.Now()
+	val, err := d.driver.GetContext(ctx, key)
+	d.observe(opGet, start, err)
 
-	val, err := d.driver.Get(key)
 	if err != nil {
-		return "", fmt.Errorf(ErrKVStorage, err)
+		return "", storageError(opGet, err)
 	}
 
 	if val == "" {
@@ -146,6 +414,11 @@ func (d *fileSystem) Get(key string) (string, error) {
 
 // Set sets key, value and "time-to-live" to key-value storage.
 func (d *fileSystem) Set(key, val string, ttl int) error {
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *fileSystem) SetContext(ctx context.Context, key, val string, ttl int) error {
 	if key == "" {
 		return &ErrEmptyKey{}
 	}
@@ -158,14 +431,22 @@ func (d *fileSystem) Set(key, val string, ttl int) error {
 		return &ErrInvalidTTL{key, ttl}
 	}
 
-	if err := d.acquire(opSet); err != nil {
+	d.hot.touch(opSet, key)
+
+	if err := d.acquire(ctx, opSet); err != nil {
 		return err
 	}
-	defer d.release()
+	defer d.release(opSet)
+
+	d.flight.forget(key)
+	defer d.flight.forget(key)
+
+	start := time.Now()
+	err := d.driver.SetContext(ctx, key, val, ttl)
+	d.observe(opSet, start, err)
 
-	err := d.driver.Set(key, val, ttl)
 	if err != nil {
-		return fmt.Errorf(ErrKVStorage, err)
+		return storageError(opSet, err)
 	}
 
 	return nil
@@ -173,19 +454,31 @@ func (d *fileSystem) Set(key, val string, ttl int) error {
 
 // Delete deletes key from key-value storage.
 func (d *fileSystem) Delete(key string) (bool, error) {
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage until `ctx` is done.
+func (d *fileSystem) DeleteContext(ctx context.Context, key string) (bool, error) {
 	if key == "" {
 		return false, &ErrEmptyKey{}
 	}
 
-	if err := d.acquire(opDel); err != nil {
+	d.hot.touch(opDel, key)
+
+	if err := d.acquire(ctx, opDel); err != nil {
 		return false, err
 	}
-	defer d.release()
+	defer d.release(opDel)
 
-	ok, err := d.driver.Delete(key)
+	d.flight.forget(key)
+	defer d.flight.forget(key)
+
+	start := time.Now()
+	ok, err := d.driver.DeleteContext(ctx, key)
+	d.observe(opDel, start, err)
 
 	if err != nil {
-		return false, fmt.Errorf(ErrKVStorage, err)
+		return false, storageError(opDel, err)
 	}
 
 	if !ok {
@@ -195,33 +488,116 @@ func (d *fileSystem) Delete(key string) (bool, error) {
 	return true, nil
 }
 
+// pending returns the number of processed and queued calls.
+func (d *fileSystem) pending() int {
+	used, waiting := d.read.usage()
+
+	if d.write != d.read {
+		u, w := d.write.usage()
+		used, waiting = used+u, waiting+w
+	}
+
+	return used + waiting
+}
+
+// Drain rejects new calls with `ErrCloseDriver` and waits until processed ones are finished or `ctx` is done.
+// Returns the number of calls that are not finished.
+func (d *fileSystem) Drain(ctx context.Context) int {
+	atomic.StoreInt32(&d.drained, 1)
+	d.closing.Do(func() { close(d.done) })
+
+	ticker := time.NewTicker(queueDelay * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n := d.pending()
+		if n == 0 {
+			return 0
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return n
+		}
+	}
+}
+
 // Close calls to release key-value storage resources.
 // After `d` is `done` all processed will be blocking until `release()`.
+// If `d` is not drained before then it waits for processed calls (yes, for infinite time if needed).
 func (d *fileSystem) Close() {
 	defer d.driver.Close()
 
-	close(d.done)
-
-	// waiting (yes, for infinite time if needed)
-	for len(d.queue) != 0 {
-		time.Sleep(queueDelay * time.Millisecond)
+	if atomic.LoadInt32(&d.drained) == 0 {
+		d.Drain(context.Background())
 	}
 }
 
-// New returns "ready-to-use" `Driver`.
-func New(driver Driver, opts *Options) Driver {
-	if opts.Timeout < minInt {
-		log.Panicf("non-positive Timeout")
+// New returns "ready-to-use" `ContextDriver`.
+func New(driver Driver, opts *Options) ContextDriver {
+	d := &fileSystem{
+		driver: WithContext(driver),
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+
+	for _, op := range []string{opGet, opSet, opDel} {
+		if d.timeout(op) < minInt*time.Second {
+			log.Panicf("non-positive Timeout")
+		}
 	}
 
-	if opts.MaxConn < 0 {
+	if opts.MaxConn < 0 || opts.ReadConn < 0 || opts.WriteConn < 0 {
 		log.Panicf("non-positive MaxConn")
 	}
 
-	return &fileSystem{
-		driver: driver,
-		opts:   opts,
-		done:   make(chan struct{}),
-		queue:  make(chan struct{}, opts.MaxConn),
+	for op, weight := range opts.Priority {
+		if op != opGet && op != opSet && op != opDel {
+			log.Panicf("unknown operation (%s) in Priority", op)
+		}
+
+		if weight < minInt {
+			log.Panicf("non-positive Priority (%s)", op)
+		}
 	}
+
+	if opts.HotKeys != nil {
+		d.hot = newHotKeys(opts.HotKeys)
+	}
+
+	if opts.ReadConn == 0 && opts.WriteConn == 0 {
+		d.read = d.newPool(opts.MaxConn, opGet, opSet, opDel)
+		d.write = d.read
+
+		return d
+	}
+
+	read, write := opts.ReadConn, opts.WriteConn
+
+	if read == 0 {
+		read = opts.MaxConn
+	}
+
+	if write == 0 {
+		write = opts.MaxConn
+	}
+
+	d.read = d.newPool(read, opGet)
+	d.write = d.newPool(write, opSet, opDel)
+
+	return d
+}
+
+// newPool returns "connection pool" for `ops` with `limit` permits
+// and own `limiter` if adaptive limiting is enabled.
+func (d *fileSystem) newPool(limit int, ops ...string) *pool {
+	p := newPool(limit, d.opts.Priority, ops...)
+
+	if a := d.opts.Adaptive; a != nil {
+		p.adapt = newLimiter(a)
+		p.limit = clamp(limit, a.Min, a.Max)
+	}
+
+	return p
 }
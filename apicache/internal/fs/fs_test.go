@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -21,14 +22,21 @@ const (
 	timeout  = 10
 )
 
-var f = fileSystem{
+var f = shared(&fileSystem{
 	driver: &test.DriverMock{Storage: &sync.Map{}},
 	opts: &Options{
 		MaxConn: maxConn,
 		Timeout: timeout,
 	},
-	done:  make(chan struct{}),
-	queue: make(chan struct{}, maxConn),
+	done: make(chan struct{}),
+}, maxConn)
+
+// shared sets to `fs` a single "connection pool" with `limit` permits for all operations.
+func shared(fs *fileSystem, limit int) *fileSystem {
+	fs.read = newPool(limit, nil)
+	fs.write = fs.read
+
+	return fs
 }
 
 func TestMain(m *testing.M) {
@@ -218,6 +226,50 @@ func TestFileSystemClose(t *testing.T) {
 
 	f.driver.(*test.DriverMock).Closed = 0
 	f.done = make(chan struct{})
+	f.closing = sync.Once{}
+	f.drained = 0
+}
+
+func TestFileSystemDrain(t *testing.T) {
+	dm := &test.DriverMock{Storage: &sync.Map{}, IsConcurrent: true}
+	fs := New(dm, &Options{MaxConn: 1, Timeout: timeout}).(*fileSystem)
+
+	// the first call is processed and the second one is queued
+	for i := 0; i < 2; i++ {
+		go func(i int) { _ = fs.Set(strconv.Itoa(i), valExist, ttlExist) }(i)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if pending := fs.Drain(ctx); pending != 2 {
+		t.Errorf("Drain() got = %d, want = %d", pending, 2)
+	}
+
+	var ecd *ErrCloseDriver
+
+	if err := fs.Set(keyExist, valExist, ttlExist); !errors.As(err, &ecd) {
+		t.Errorf("Set() after Drain() error = %v, want = %v", err, ecd)
+	}
+
+	if pending := fs.Drain(context.Background()); pending != 0 {
+		t.Errorf("Drain() got = %d, want = %d", pending, 0)
+	}
+
+	start := time.Now()
+
+	fs.Close()
+
+	// only the mock closing time
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Close() after Drain() took = %v", elapsed)
+	}
+
+	if dm.Closed != 1 {
+		t.Errorf("Close() storage not closed")
+	}
 }
 
 func TestNew(t *testing.T) {
@@ -238,11 +290,11 @@ func TestNew(t *testing.T) {
 	}
 
 	if !reflect.DeepEqual(got.driver, want.driver) {
-		t.Errorf("New() = %v, want = %v", got, *want)
+		t.Errorf("New() = %v, want = %v", got, want)
 	}
 
-	if cap(got.queue) != maxConn {
-		t.Errorf("New() queue = %d, want = %d", cap(got.queue), maxConn)
+	if got.read.limit != maxConn || got.read != got.write {
+		t.Errorf("New() pool = %d, want = %d shared", got.read.limit, maxConn)
 	}
 
 	if cap(got.done) != 0 {
@@ -250,7 +302,7 @@ func TestNew(t *testing.T) {
 	}
 
 	if !reflect.DeepEqual(got.opts, want.opts) {
-		t.Errorf("New() = %v, want = %v", got, *want)
+		t.Errorf("New() = %v, want = %v", got, want)
 	}
 }
 
@@ -296,12 +348,51 @@ func TestFileSystemErrors(t *testing.T) {
 	if ene.Error() != eneW {
 		t.Errorf("ErrNotExist.Error() = %s, want = %s", ene.Error(), eneW)
 	}
+
+	ecl := &ErrCanceled{op: "get", err: context.Canceled}
+	eclW := "operation (get) canceled: context canceled"
+
+	if ecl.Error() != eclW {
+		t.Errorf("ErrCanceled.Error() = %s, want = %s", ecl.Error(), eclW)
+	}
+
+	if !errors.Is(ecl, context.Canceled) {
+		t.Errorf("ErrCanceled.Unwrap() = %v, want = %v", ecl.Unwrap(), context.Canceled)
+	}
+
+	eis := NewErrInsufficientStorage(test.KeyError)
+	eisW := "insufficient storage for key (" + test.KeyError + ")"
+
+	if eis.Error() != eisW {
+		t.Errorf("ErrInsufficientStorage.Error() = %s, want = %s", eis.Error(), eisW)
+	}
+
+	if err := storageError(opSet, eis); err != eis {
+		t.Errorf("storageError() = %v, want = %v", err, eis)
+	}
+
+	eik := NewErrInvalidKey(test.KeyError, "too long")
+	eikW := "invalid key (" + test.KeyError + "): too long"
+
+	if eik.Error() != eikW {
+		t.Errorf("ErrInvalidKey.Error() = %s, want = %s", eik.Error(), eikW)
+	}
+
+	if err := storageError(opGet, eik); err != eik {
+		t.Errorf("storageError() = %v, want = %v", err, eik)
+	}
+
+	ens := NewErrNotSupported(opTag)
+
+	if err := storageError(opSet, ens); err != ens {
+		t.Errorf("storageError() = %v, want = %v", err, ens)
+	}
 }
 
 func TestFileSystemConcurrent(t *testing.T) {
 	// Check acquire and release on operations
 
-	fs := &fileSystem{
+	fs := shared(&fileSystem{
 		driver: &test.DriverMock{
 			Storage:      &sync.Map{},
 			IsConcurrent: true,
@@ -309,9 +400,8 @@ func TestFileSystemConcurrent(t *testing.T) {
 		opts: &Options{
 			Timeout: 10,
 		},
-		done:  make(chan struct{}),
-		queue: make(chan struct{}, 3),
-	}
+		done: make(chan struct{}),
+	}, 3)
 
 	go func() { _, _ = fs.Get(test.KeyError) }()
 	go func() { _ = fs.Set(test.KeyError, valExist, ttlExist) }()
@@ -319,12 +409,12 @@ func TestFileSystemConcurrent(t *testing.T) {
 
 	time.Sleep(time.Second)
 
-	if len(fs.queue) != 3 {
+	if used, _ := fs.read.usage(); used != 3 {
 		t.Errorf("acquare not happened")
 	}
 
 	time.Sleep(2 * time.Second)
-	if len(fs.queue) != 0 {
+	if fs.read.busy() {
 		t.Errorf("release not happened")
 	}
 
@@ -362,7 +452,7 @@ func TestFileSystemConcurrent(t *testing.T) {
 		}
 	}
 
-	if len(fs.queue) != 0 {
+	if fs.read.busy() {
 		fmt.Println("queue not empty")
 	}
 }
@@ -370,7 +460,7 @@ func TestFileSystemConcurrent(t *testing.T) {
 func TestFileSystemConcurrentErrors(t *testing.T) {
 	// Timeout if queue is full
 
-	fs := &fileSystem{
+	fs := shared(&fileSystem{
 		driver: &test.DriverMock{
 			Storage:      &sync.Map{},
 			IsConcurrent: true,
@@ -378,12 +468,11 @@ func TestFileSystemConcurrentErrors(t *testing.T) {
 		opts: &Options{
 			Timeout: 1,
 		},
-		done:  make(chan struct{}),
-		queue: make(chan struct{}, 2),
-	}
+		done: make(chan struct{}),
+	}, 2)
 
-	fs.queue <- struct{}{}
-	fs.queue <- struct{}{}
+	_ = fs.acquire(context.Background(), opGet)
+	_ = fs.acquire(context.Background(), opGet)
 
 	var ect *ErrConcurrentTimeout
 
@@ -402,8 +491,8 @@ func TestFileSystemConcurrentErrors(t *testing.T) {
 		t.Errorf("Delete() timeout not happened")
 	}
 
-	<-fs.queue
-	<-fs.queue
+	fs.release(opGet)
+	fs.release(opGet)
 
 	// Error after closed
 
@@ -416,3 +505,127 @@ func TestFileSystemConcurrentErrors(t *testing.T) {
 		t.Errorf("oparation happened")
 	}
 }
+
+func TestFileSystemContext(t *testing.T) {
+	fs := shared(&fileSystem{
+		driver: &test.DriverMock{
+			Storage:      &sync.Map{},
+			IsConcurrent: true,
+		},
+		opts: &Options{
+			Timeout: 10,
+		},
+		done: make(chan struct{}),
+	}, 1)
+
+	var ecl *ErrCanceled
+
+	// Driver call is aborted by deadline
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	err := fs.SetContext(ctx, keyExist, valExist, ttlExist)
+	if !errors.As(err, &ecl) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetContext() error = %v, want = %v", err, &ErrCanceled{opSet, context.DeadlineExceeded})
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("SetContext() driver call not aborted")
+	}
+
+	if fs.read.busy() {
+		t.Errorf("release not happened")
+	}
+
+	// Queue waiting is aborted by cancel
+
+	_ = fs.acquire(context.Background(), opGet)
+
+	ctx, cancel = context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+
+	start = time.Now()
+
+	_, err = fs.GetContext(ctx, keyExist)
+	if !errors.As(err, &ecl) || !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext() error = %v, want = %v", err, &ErrCanceled{opGet, context.Canceled})
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("GetContext() queue waiting not aborted")
+	}
+
+	fs.release(opGet)
+
+	// Already canceled context
+
+	_, err = fs.DeleteContext(ctx, keyExist)
+	if !errors.As(err, &ecl) {
+		t.Errorf("DeleteContext() error = %v, want = %v", err, &ErrCanceled{opDel, context.Canceled})
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	dm := &test.DriverMock{Storage: &sync.Map{}}
+
+	if got := WithContext(dm); got != dm {
+		t.Errorf("WithContext() = %v, want = %v", got, dm)
+	}
+
+	// hide context methods of the mock
+	d := WithContext(struct{ Driver }{dm})
+
+	if err := d.SetContext(context.Background(), keyExist, valExist, ttlExist); err != nil {
+		t.Errorf("SetContext() error = %v, want = %v", err, nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := d.GetContext(ctx, keyExist); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if err := d.SetContext(ctx, keyExist, valExist, ttlExist); !errors.Is(err, context.Canceled) {
+		t.Errorf("SetContext() error = %v, want = %v", err, context.Canceled)
+	}
+
+	if _, err := d.DeleteContext(ctx, keyExist); !errors.Is(err, context.Canceled) {
+		t.Errorf("DeleteContext() error = %v, want = %v", err, context.Canceled)
+	}
+}
+
+func TestAs(t *testing.T) {
+	dm := &test.DriverMock{Storage: &sync.Map{}}
+	d := New(WithContext(struct{ Driver }{dm}), &Options{MaxConn: maxConn, Timeout: timeout})
+
+	var mock *test.DriverMock
+	if As(d, &mock) {
+		t.Errorf("As() got = %v, want = %v (hidden by adapter)", mock, nil)
+	}
+
+	var drainer Drainer
+	if !As(d, &drainer) || drainer != d.(Drainer) {
+		t.Errorf("As() got = %v, want = %v", drainer, d)
+	}
+
+	d = New(dm, &Options{MaxConn: maxConn, Timeout: timeout})
+	if !As(d, &mock) || mock != dm {
+		t.Errorf("As() got = %v, want = %v", mock, dm)
+	}
+
+	defer func() {
+		if err := recover(); err != "target must be a non-nil pointer" {
+			t.Errorf("panic got = %v, want = %v", err, "target must be a non-nil pointer")
+		}
+	}()
+
+	As(d, nil)
+}
@@ -0,0 +1,383 @@
+package fs
+
+import (
+	"container/heap"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTopK     = 10
+	defaultWidth    = 2048
+	defaultDepth    = 4
+	defaultHalfLife = 10
+	// shardBits is the number of hash bits selecting shard of `sketch`.
+	shardBits = 4
+	shards    = 1 << shardBits
+	// maxWeight is the weight of access after which counters of shard are rescaled.
+	maxWeight = 1 << 32
+	// coolDown is the part of `HotKeys.Threshold` below which hot key is reported again.
+	coolDown = 0.5
+)
+
+type (
+	// HotKeys contains parameters of hot keys detection.
+	// Access frequency of keys is estimated by count-min sketch of `Depth` rows by `Width` counters,
+	// counters are halved every `HalfLife` seconds, so rates follow the recent traffic.
+	// The most frequent `TopK` read ("get") and write ("set", "del") keys are tracked,
+	// key is logged when it's rate (calls per second) crosses `Threshold` (never if zero).
+	HotKeys struct {
+		TopK      int           `json:"topK"`
+		Width     int           `json:"width"`
+		Depth     int           `json:"depth"`
+		HalfLife  time.Duration `json:"halfLife"`
+		Threshold float64       `json:"threshold"`
+	}
+	// KeyRate contains approximate access rate of key in calls per second.
+	KeyRate struct {
+		Key  string  `json:"key"`
+		Rate float64 `json:"rate"`
+	}
+	// TopKeys contains the most frequent read and write keys from the hottest one.
+	TopKeys struct {
+		Read  []KeyRate `json:"read"`
+		Write []KeyRate `json:"write"`
+	}
+	// Profiler is implemented by `Driver` which tracks the most frequently accessed keys.
+	Profiler interface {
+		TopKeys() TopKeys
+	}
+	// sketch estimates decayed frequencies of keys and keeps the heavy hitters.
+	// Keys are spread by hash over `shards` locked separately, every shard keeps the part
+	// of counters and it's own `TopK` heavy hitters, so concurrent touches don't wait for each other.
+	sketch struct {
+		kind   string
+		opts   *HotKeys
+		width  int
+		shards [shards]*shard
+		// halfLife is `HotKeys.HalfLife` in seconds.
+		halfLife float64
+		now      func() time.Time
+	}
+	// shard is the part of `sketch`. Counters are decayed forward: access at `t` adds
+	// weight 2^((t - start) / HalfLife) and decayed count is counter divided by the weight of now,
+	// so counters are scaled only when weight exceeds `maxWeight` (then `start` is moved to now).
+	shard struct {
+		mu     sync.Mutex
+		counts [][]float64
+		top    map[string]*hitter
+		heap   hitters
+		hot    map[string]struct{}
+		start  time.Time
+	}
+	// hitter is heavy hitter `key` with not decayed `count` at `index` of heap.
+	hitter struct {
+		key   string
+		count float64
+		index int
+	}
+	// hitters is min-heap of heavy hitters by count, so the coldest one is the first.
+	hitters []*hitter
+	// hotKeys tracks read and write keys separately.
+	hotKeys struct {
+		read  *sketch
+		write *sketch
+	}
+)
+
+func (h hitters) Len() int {
+	return len(h)
+}
+
+func (h hitters) Less(i, j int) bool {
+	return h[i].count < h[j].count
+}
+
+func (h hitters) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *hitters) Push(x interface{}) {
+	e := x.(*hitter)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hitters) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return e
+}
+
+// newSketch returns empty `sketch` of `kind` keys decayed by `now` time.
+func newSketch(kind string, opts *HotKeys, now func() time.Time) *sketch {
+	s := &sketch{
+		kind:     kind,
+		opts:     opts,
+		width:    (opts.Width + shards - 1) / shards,
+		halfLife: (opts.HalfLife * time.Second).Seconds(),
+		now:      now,
+	}
+
+	for i := range s.shards {
+		sh := &shard{
+			counts: make([][]float64, opts.Depth),
+			top:    make(map[string]*hitter, opts.TopK),
+			heap:   make(hitters, 0, opts.TopK),
+			hot:    make(map[string]struct{}),
+			start:  now(),
+		}
+
+		for j := range sh.counts {
+			sh.counts[j] = make([]float64, s.width)
+		}
+
+		s.shards[i] = sh
+	}
+
+	return s
+}
+
+// hash returns 64-bit hash of `key`.
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+
+	return h.Sum64()
+}
+
+// shard returns shard of `key` by the highest bits of it's hash `sum`.
+func (s *sketch) shard(sum uint64) *shard {
+	return s.shards[sum>>(64-shardBits)]
+}
+
+// cells returns indexes of counters in every row of key with hash `sum`.
+// Row hashes are derived from the single 64-bit hash (Kirsch-Mitzenmacher).
+func (s *sketch) cells(sum uint64) []int {
+	h1, h2 := uint32(sum), uint32(sum>>32)
+	cells := make([]int, s.opts.Depth)
+
+	for i := range cells {
+		cells[i] = int((h1 + uint32(i)*h2) % uint32(s.width))
+	}
+
+	return cells
+}
+
+// rate converts decayed count to calls per second:
+// steady count of constant rate is `rate * HalfLife / ln(2)`.
+func (s *sketch) rate(count float64) float64 {
+	return count * math.Ln2 / s.halfLife
+}
+
+// weight returns weight of access to `sh` at `now`. Counters are rescaled to `now` if it exceeds `maxWeight`,
+// then cooled keys are forgotten. It must be called under `sh.mu`.
+func (s *sketch) weight(sh *shard, now time.Time) float64 {
+	w := math.Exp2(now.Sub(sh.start).Seconds() / s.halfLife)
+	if w <= maxWeight {
+		return w
+	}
+
+	sh.scale(1 / w)
+	sh.start = now
+
+	for key := range sh.hot {
+		if s.rate(sh.estimate(s.cells(hash(key)))) < s.opts.Threshold*coolDown {
+			delete(sh.hot, key)
+		}
+	}
+
+	return 1
+}
+
+// estimate returns the minimal counter of `cells`.
+func (sh *shard) estimate(cells []int) float64 {
+	est := math.Inf(1)
+
+	for i, j := range cells {
+		est = math.Min(est, sh.counts[i][j])
+	}
+
+	return est
+}
+
+// scale multiplies all counters by `factor`, order of heavy hitters is kept.
+func (sh *shard) scale(factor float64) {
+	for _, row := range sh.counts {
+		for j := range row {
+			row[j] *= factor
+		}
+	}
+
+	for _, h := range sh.heap {
+		h.count *= factor
+	}
+}
+
+// offer adds `key` with `count` to up to `k` heavy hitters if it is hotter than the coldest of them.
+func (sh *shard) offer(key string, count float64, k int) {
+	if h, ok := sh.top[key]; ok {
+		h.count = count
+		heap.Fix(&sh.heap, h.index)
+
+		return
+	}
+
+	if len(sh.heap) < k {
+		h := &hitter{key: key, count: count}
+		heap.Push(&sh.heap, h)
+		sh.top[key] = h
+
+		return
+	}
+
+	if coldest := sh.heap[0]; count > coldest.count {
+		delete(sh.top, coldest.key)
+		coldest.key, coldest.count = key, count
+		sh.top[key] = coldest
+		heap.Fix(&sh.heap, 0)
+	}
+}
+
+// touch counts access to `key` and logs it if it becomes hot.
+func (s *sketch) touch(key string) {
+	sum := hash(key)
+	cells := s.cells(sum)
+	sh := s.shard(sum)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	w := s.weight(sh, s.now())
+
+	for i, j := range cells {
+		sh.counts[i][j] += w
+	}
+
+	count := sh.estimate(cells)
+	sh.offer(key, count, s.opts.TopK)
+
+	if s.opts.Threshold == 0 {
+		return
+	}
+
+	rate := s.rate(count / w)
+
+	if _, ok := sh.hot[key]; ok {
+		if rate >= s.opts.Threshold*coolDown {
+			return
+		}
+
+		delete(sh.hot, key)
+	}
+
+	if rate >= s.opts.Threshold {
+		sh.hot[key] = struct{}{}
+		log.Printf("hot %s key (%s) rate = %.1f/s, threshold = %.1f/s", s.kind, key, rate, s.opts.Threshold)
+	}
+}
+
+// shardKeys returns heavy hitters of `sh` at `now`.
+func (s *sketch) shardKeys(sh *shard, now time.Time) []KeyRate {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	w := s.weight(sh, now)
+	out := make([]KeyRate, 0, len(sh.heap))
+
+	for _, h := range sh.heap {
+		out = append(out, KeyRate{Key: h.key, Rate: s.rate(h.count / w)})
+	}
+
+	return out
+}
+
+// keys returns heavy hitters of all shards from the hottest one.
+func (s *sketch) keys() []KeyRate {
+	now := s.now()
+	out := make([]KeyRate, 0, shards*s.opts.TopK)
+
+	for _, sh := range s.shards {
+		out = append(out, s.shardKeys(sh, now)...)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Rate != out[j].Rate {
+			return out[i].Rate > out[j].Rate
+		}
+
+		return out[i].Key < out[j].Key
+	})
+
+	if len(out) > s.opts.TopK {
+		out = out[:s.opts.TopK]
+	}
+
+	return out
+}
+
+// touch counts access to `key` by `op`, it does nothing if detection is disabled.
+func (h *hotKeys) touch(op, key string) {
+	if h == nil {
+		return
+	}
+
+	if op == opGet {
+		h.read.touch(key)
+		return
+	}
+
+	h.write.touch(key)
+}
+
+// TopKeys returns the most frequent read and write keys (empty if `Options.HotKeys` is not set).
+func (d *fileSystem) TopKeys() TopKeys {
+	if d.hot == nil {
+		return TopKeys{Read: []KeyRate{}, Write: []KeyRate{}}
+	}
+
+	return TopKeys{Read: d.hot.read.keys(), Write: d.hot.write.keys()}
+}
+
+// newHotKeys returns hot keys detection by `opts`.
+// Panics if `opts` are invalid.
+func newHotKeys(opts *HotKeys) *hotKeys {
+	for _, p := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"TopK", &opts.TopK, defaultTopK},
+		{"Width", &opts.Width, defaultWidth},
+		{"Depth", &opts.Depth, defaultDepth},
+	} {
+		if *p.value == 0 {
+			*p.value = p.def
+		}
+
+		if *p.value < 0 {
+			log.Panicf("negative HotKeys.%s", p.name)
+		}
+	}
+
+	if opts.HalfLife == 0 {
+		opts.HalfLife = defaultHalfLife
+	}
+
+	if opts.HalfLife < 0 {
+		log.Panicf("negative HotKeys.HalfLife")
+	}
+
+	if opts.Threshold < 0 {
+		log.Panicf("negative HotKeys.Threshold")
+	}
+
+	return &hotKeys{read: newSketch("read", opts, time.Now), write: newSketch("write", opts, time.Now)}
+}
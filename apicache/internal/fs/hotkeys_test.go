@@ -0,0 +1,219 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// clock is manually moved time of `sketch`.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// touches touches `key` of `s` `n` times.
+func touches(s *sketch, key string, n int) {
+	for i := 0; i < n; i++ {
+		s.touch(key)
+	}
+}
+
+// rates returns keys and rates rounded to 0.1 of `keys`.
+func rates(keys []KeyRate) []KeyRate {
+	out := make([]KeyRate, len(keys))
+
+	for i, k := range keys {
+		out[i] = KeyRate{Key: k.Key, Rate: math.Round(k.Rate*10) / 10}
+	}
+
+	return out
+}
+
+func TestSketchTopK(t *testing.T) {
+	c := &clock{now: time.Now()}
+	s := newSketch("read", &HotKeys{TopK: 2, Width: 1024, Depth: 4, HalfLife: 10}, c.Now)
+
+	touches(s, "cold", 10)
+	touches(s, "warm", 50)
+	touches(s, "hot", 100)
+
+	// rate = count * ln(2) / HalfLife
+	want := []KeyRate{{"hot", 6.9}, {"warm", 3.5}}
+	if got := rates(s.keys()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want = %v", got, want)
+	}
+
+	c.now = c.now.Add(10 * time.Second)
+
+	want = []KeyRate{{"hot", 3.5}, {"warm", 1.7}}
+	if got := rates(s.keys()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want halved = %v", got, want)
+	}
+
+	// the new hot key replaces the coldest one
+	touches(s, "new", 60)
+
+	want = []KeyRate{{"new", 4.2}, {"hot", 3.5}}
+	if got := rates(s.keys()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want = %v", got, want)
+	}
+}
+
+func TestSketchRescale(t *testing.T) {
+	c := &clock{now: time.Now()}
+	s := newSketch("read", &HotKeys{TopK: 1, Width: 1024, Depth: 4, HalfLife: 10}, c.Now)
+
+	touches(s, "key", 100)
+
+	// weight of access exceeds `maxWeight` after 32 half-lives, so counters are rescaled
+	c.now = c.now.Add(330 * time.Second)
+	touches(s, "key", 100)
+
+	want := []KeyRate{{"key", 6.9}}
+	if got := rates(s.keys()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want = %v", got, want)
+	}
+
+	c.now = c.now.Add(10 * time.Second)
+
+	want = []KeyRate{{"key", 3.5}}
+	if got := rates(s.keys()); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want halved = %v", got, want)
+	}
+}
+
+func TestSketchHeavyHitters(t *testing.T) {
+	s := newSketch("read", &HotKeys{TopK: 3, Width: 1 << 16, Depth: 4, HalfLife: 1000}, time.Now)
+
+	var wg sync.WaitGroup
+
+	// every key is touched as many times as it's number, so the hottest ones win over the shards
+	for i := 1; i <= 50; i++ {
+		wg.Add(1)
+
+		go func(n int) {
+			defer wg.Done()
+			touches(s, fmt.Sprintf("key%d", n), n)
+		}(i)
+	}
+
+	wg.Wait()
+
+	var got []string
+
+	for _, k := range s.keys() {
+		got = append(got, k.Key)
+	}
+
+	if want := []string{"key50", "key49", "key48"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("keys() got = %v, want = %v", got, want)
+	}
+}
+
+func TestSketchThreshold(t *testing.T) {
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := &clock{now: time.Now()}
+	s := newSketch("write", &HotKeys{TopK: 1, Width: 1024, Depth: 4, HalfLife: 10, Threshold: 5}, c.Now)
+
+	// 5/s needs count of 5 * 10 / ln(2) = 72.1
+	touches(s, "key", 72)
+
+	if buf.Len() != 0 {
+		t.Errorf("touch() logged below threshold = %s", buf.String())
+	}
+
+	touches(s, "key", 100)
+
+	if n := strings.Count(buf.String(), "hot write key (key)"); n != 1 {
+		t.Errorf("touch() logged %d times, want once = %s", n, buf.String())
+	}
+
+	// cooled key is reported again
+	c.now = c.now.Add(time.Minute)
+	touches(s, "key", 100)
+
+	if n := strings.Count(buf.String(), "hot write key (key)"); n != 2 {
+		t.Errorf("touch() logged %d times, want twice = %s", n, buf.String())
+	}
+}
+
+func TestFileSystemTopKeys(t *testing.T) {
+	d := New(&test.DriverMock{Storage: &sync.Map{}}, &Options{
+		MaxConn: maxConn,
+		Timeout: timeout,
+		HotKeys: &HotKeys{TopK: 2},
+	}).(*fileSystem)
+
+	for i := 0; i < 3; i++ {
+		_, _ = d.Get(test.KeyNotExist)
+		_ = d.Set("write", valExist, ttlExist)
+	}
+
+	_, _ = d.Delete("delete")
+
+	got := d.TopKeys()
+
+	if len(got.Read) != 1 || got.Read[0].Key != test.KeyNotExist {
+		t.Errorf("TopKeys() read = %v, want = %s", got.Read, test.KeyNotExist)
+	}
+
+	if len(got.Write) != 2 || got.Write[0].Key != "write" || got.Write[1].Key != "delete" {
+		t.Errorf("TopKeys() write = %v, want = %s, %s", got.Write, "write", "delete")
+	}
+
+	// disabled detection
+	d = New(&test.DriverMock{Storage: &sync.Map{}}, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+	_, _ = d.Get(test.KeyNotExist)
+
+	if got := d.TopKeys(); len(got.Read) != 0 || len(got.Write) != 0 {
+		t.Errorf("TopKeys() = %v, want empty", got)
+	}
+}
+
+func TestNewHotKeys(t *testing.T) {
+	h := newHotKeys(&HotKeys{})
+
+	if want := (HotKeys{TopK: defaultTopK, Width: defaultWidth, Depth: defaultDepth, HalfLife: defaultHalfLife}); *h.read.opts != want {
+		t.Errorf("newHotKeys() opts = %+v, want = %+v", *h.read.opts, want)
+	}
+
+	cases := []struct {
+		opts *HotKeys
+		err  string
+	}{
+		{&HotKeys{TopK: -1}, "negative HotKeys.TopK"},
+		{&HotKeys{Width: -1}, "negative HotKeys.Width"},
+		{&HotKeys{Depth: -1}, "negative HotKeys.Depth"},
+		{&HotKeys{HalfLife: -1}, "negative HotKeys.HalfLife"},
+		{&HotKeys{Threshold: -1}, "negative HotKeys.Threshold"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if err.(string) != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			newHotKeys(c.opts)
+		})
+	}
+}
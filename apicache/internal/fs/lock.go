@@ -0,0 +1,266 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	opLock = "lock"
+	// lockRetryMin and lockRetryMax bound the delay between attempts of blocking acquire.
+	lockRetryMin = 5 * time.Millisecond
+	lockRetryMax = 200 * time.Millisecond
+)
+
+type (
+	// Lease is the lock `Name` granted to `Owner` until `Expire`.
+	// `Token` is the fencing token, it increases with every grant of the lock
+	// (renew and re-acquire by the same owner keep it).
+	Lease struct {
+		Name   string    `json:"name"`
+		Owner  string    `json:"owner"`
+		Token  int64     `json:"token"`
+		Expire time.Time `json:"expire"`
+	}
+	// Locker is implemented by `ContextDriver` which grants leases atomically.
+	// Lock is expired after "time-to-live" unless it is renewed,
+	// fencing tokens must not be reset by expiration or release.
+	Locker interface {
+		// AcquireContext grants lock `name` to `owner` for `ttl`, `ok` is false if it is held by another owner.
+		// Lock held by `owner` is extended.
+		AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (lease *Lease, ok bool, err error)
+		// RenewContext extends lock `name` for `ttl`, `ok` is false if it is not held by `owner`.
+		RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (lease *Lease, ok bool, err error)
+		// ReleaseContext releases lock `name`, `ok` is false if it is not held by `owner`.
+		ReleaseContext(ctx context.Context, name, owner string) (ok bool, err error)
+	}
+	// Leaser is implemented by `Driver` which grants leases of `Locker` with blocking acquire
+	// and returns `ErrLocked` or `ErrNotOwner` instead of `ok` flags.
+	Leaser interface {
+		AcquireContext(ctx context.Context, name, owner string, ttl, wait time.Duration) (*Lease, error)
+		RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*Lease, error)
+		ReleaseContext(ctx context.Context, name, owner string) error
+	}
+	// lockFallback is `Locker` of `Driver` which doesn't implement it.
+	lockFallback struct{}
+	// ErrLocked occurred if lock is held by another owner.
+	ErrLocked struct {
+		name string
+	}
+	// ErrNotOwner occurred if lock is expired, released or held by another owner.
+	ErrNotOwner struct {
+		name  string
+		owner string
+	}
+	// ErrInvalidLease occurred if lock parameters are missed or out of range.
+	ErrInvalidLease struct {
+		name   string
+		reason string
+	}
+)
+
+func (e *ErrLocked) Error() string {
+	return fmt.Sprintf("lock (%s) is held by another owner", e.name)
+}
+
+func (e *ErrNotOwner) Error() string {
+	return fmt.Sprintf("lock (%s) is not held by owner (%s)", e.name, e.owner)
+}
+
+func (e *ErrInvalidLease) Error() string {
+	return fmt.Sprintf("invalid lease of lock (%s): %s", e.name, e.reason)
+}
+
+func (lockFallback) AcquireContext(context.Context, string, string, time.Duration) (*Lease, bool, error) {
+	return nil, false, &ErrNotSupported{opLock}
+}
+
+func (lockFallback) RenewContext(context.Context, string, string, time.Duration) (*Lease, bool, error) {
+	return nil, false, &ErrNotSupported{opLock}
+}
+
+func (lockFallback) ReleaseContext(context.Context, string, string) (bool, error) {
+	return false, &ErrNotSupported{opLock}
+}
+
+// LockerOf returns `driver` as `Locker`, calls of which return `ErrNotSupported` if it is not.
+// Uses by decorators which forward locks to decorated `Driver`.
+func LockerOf(driver Driver) Locker {
+	if locker, ok := driver.(Locker); ok {
+		return locker
+	}
+
+	return lockFallback{}
+}
+
+// NewErrInvalidLease returns `ErrInvalidLease` for lock `name` rejected by `reason`.
+// Uses by `Driver` consumers outside of the package.
+func NewErrInvalidLease(name, reason string) error {
+	return &ErrInvalidLease{name, reason}
+}
+
+// validateLease checks parameters of lock `name`.
+func validateLease(name, owner string, ttl time.Duration) error {
+	switch {
+	case name == "":
+		return &ErrEmptyKey{}
+	case owner == "":
+		return &ErrInvalidLease{name, "empty owner"}
+	case ttl < time.Millisecond:
+		return &ErrInvalidLease{name, fmt.Sprintf("ttl (%v) is less than 1ms", ttl)}
+	}
+
+	return nil
+}
+
+// locker returns `Driver` as `Locker` or `ErrNotSupported`.
+func (d *fileSystem) locker() (Locker, error) {
+	locker, ok := d.driver.(Locker)
+	if !ok {
+		return nil, &ErrNotSupported{opLock}
+	}
+
+	return locker, nil
+}
+
+// lock calls `fn` on lock under permit of "connection pool" of `op`.
+func (d *fileSystem) lock(ctx context.Context, op string, fn func() (*Lease, bool, error)) (*Lease, bool, error) {
+	if err := d.acquire(ctx, op); err != nil {
+		return nil, false, err
+	}
+	defer d.release(op)
+
+	start := time.Now()
+	lease, ok, err := fn()
+	d.observe(op, start, err)
+
+	if err != nil {
+		return nil, false, storageError(op, err)
+	}
+
+	return lease, ok, nil
+}
+
+// Acquire grants lock `name` to `owner` for `ttl` waiting up to `wait` while it is held by another owner.
+func (d *fileSystem) Acquire(name, owner string, ttl, wait time.Duration) (*Lease, error) {
+	return d.AcquireContext(context.Background(), name, owner, ttl, wait)
+}
+
+// AcquireContext grants lock `name` to `owner` for `ttl` until `ctx` is done.
+// Lock held by another owner is retried with growing delay up to `wait`, then `ErrLocked` is returned.
+// Returns `ErrNotSupported` if `Driver` is not `Locker`.
+func (d *fileSystem) AcquireContext(ctx context.Context, name, owner string, ttl, wait time.Duration) (*Lease, error) {
+	if err := validateLease(name, owner, ttl); err != nil {
+		return nil, err
+	}
+
+	if wait < 0 {
+		return nil, &ErrInvalidLease{name, fmt.Sprintf("negative wait (%v)", wait)}
+	}
+
+	locker, err := d.locker()
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+
+	for delay := lockRetryMin; ; delay *= 2 {
+		lease, ok, err := d.lock(ctx, opSet, func() (*Lease, bool, error) {
+			return locker.AcquireContext(ctx, name, owner, ttl)
+		})
+
+		switch {
+		case err != nil:
+			return nil, err
+		case ok:
+			return lease, nil
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return nil, &ErrLocked{name}
+		}
+
+		if delay > lockRetryMax {
+			delay = lockRetryMax
+		}
+
+		if delay > left {
+			delay = left
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &ErrCanceled{opSet, ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
+
+// Renew extends lock `name` held by `owner` for `ttl`.
+func (d *fileSystem) Renew(name, owner string, ttl time.Duration) (*Lease, error) {
+	return d.RenewContext(context.Background(), name, owner, ttl)
+}
+
+// RenewContext extends lock `name` held by `owner` for `ttl` until `ctx` is done.
+// Returns `ErrNotOwner` if lock is not held by `owner` and `ErrNotSupported` if `Driver` is not `Locker`.
+func (d *fileSystem) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*Lease, error) {
+	if err := validateLease(name, owner, ttl); err != nil {
+		return nil, err
+	}
+
+	locker, err := d.locker()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, ok, err := d.lock(ctx, opSet, func() (*Lease, bool, error) {
+		return locker.RenewContext(ctx, name, owner, ttl)
+	})
+
+	switch {
+	case err != nil:
+		return nil, err
+	case !ok:
+		return nil, &ErrNotOwner{name, owner}
+	}
+
+	return lease, nil
+}
+
+// Release releases lock `name` held by `owner`.
+func (d *fileSystem) Release(name, owner string) error {
+	return d.ReleaseContext(context.Background(), name, owner)
+}
+
+// ReleaseContext releases lock `name` held by `owner` until `ctx` is done.
+// Returns `ErrNotOwner` if lock is not held by `owner` and `ErrNotSupported` if `Driver` is not `Locker`.
+func (d *fileSystem) ReleaseContext(ctx context.Context, name, owner string) error {
+	if err := validateLease(name, owner, time.Millisecond); err != nil {
+		return err
+	}
+
+	locker, err := d.locker()
+	if err != nil {
+		return err
+	}
+
+	_, ok, err := d.lock(ctx, opDel, func() (*Lease, bool, error) {
+		ok, err := locker.ReleaseContext(ctx, name, owner)
+		return nil, ok, err
+	})
+
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return &ErrNotOwner{name, owner}
+	}
+
+	return nil
+}
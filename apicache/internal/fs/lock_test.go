@@ -0,0 +1,149 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// lockDriver is `DriverMock` implementing `Locker`, leases are never expired.
+type lockDriver struct {
+	*test.DriverMock
+	mu     sync.Mutex
+	owners map[string]string
+	token  int64
+}
+
+func (d *lockDriver) AcquireContext(_ context.Context, name, owner string, ttl time.Duration) (*Lease, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name == test.KeyError {
+		return nil, false, errors.New(test.InternalError)
+	}
+
+	if held, ok := d.owners[name]; ok && held != owner {
+		return nil, false, nil
+	}
+
+	d.token++
+	d.owners[name] = owner
+
+	return &Lease{Name: name, Owner: owner, Token: d.token, Expire: time.Now().Add(ttl)}, true, nil
+}
+
+func (d *lockDriver) RenewContext(_ context.Context, name, owner string, ttl time.Duration) (*Lease, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.owners[name] != owner {
+		return nil, false, nil
+	}
+
+	return &Lease{Name: name, Owner: owner, Token: d.token, Expire: time.Now().Add(ttl)}, true, nil
+}
+
+func (d *lockDriver) ReleaseContext(_ context.Context, name, owner string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.owners[name] != owner {
+		return false, nil
+	}
+
+	delete(d.owners, name)
+
+	return true, nil
+}
+
+func TestFileSystemLock(t *testing.T) {
+	driver := &lockDriver{DriverMock: &test.DriverMock{Storage: &sync.Map{}}, owners: make(map[string]string)}
+	d := New(driver, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	const (
+		name = "lock"
+		ttl  = time.Second
+	)
+
+	cases := []struct {
+		name string
+		op   func() error
+		err  error
+	}{
+		{"empty name", func() error { _, err := d.Acquire("", "a", ttl, 0); return err }, &ErrEmptyKey{}},
+		{"empty owner", func() error { _, err := d.Acquire(name, "", ttl, 0); return err }, &ErrInvalidLease{name, "empty owner"}},
+		{
+			"short ttl",
+			func() error { _, err := d.Acquire(name, "a", time.Microsecond, 0); return err },
+			&ErrInvalidLease{name, "ttl (1µs) is less than 1ms"},
+		},
+		{
+			"negative wait",
+			func() error { _, err := d.Acquire(name, "a", ttl, -time.Second); return err },
+			&ErrInvalidLease{name, "negative wait (-1s)"},
+		},
+		{"acquire", func() error { _, err := d.Acquire(name, "a", ttl, 0); return err }, nil},
+		{"acquire held", func() error { _, err := d.Acquire(name, "b", ttl, 0); return err }, &ErrLocked{name}},
+		{"acquire wait", func() error { _, err := d.Acquire(name, "b", ttl, 20*time.Millisecond); return err }, &ErrLocked{name}},
+		{"renew other", func() error { _, err := d.Renew(name, "b", ttl); return err }, &ErrNotOwner{name, "b"}},
+		{"renew", func() error { _, err := d.Renew(name, "a", ttl); return err }, nil},
+		{"release other", func() error { return d.Release(name, "b") }, &ErrNotOwner{name, "b"}},
+		{"release", func() error { return d.Release(name, "a") }, nil},
+		{
+			"storage error",
+			func() error { _, err := d.Acquire(test.KeyError, "a", ttl, 0); return err },
+			fmt.Errorf(ErrKVStorage, errors.New(test.InternalError)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.op(); !reflect.DeepEqual(err, c.err) {
+				t.Errorf("error got = %v, want = %v", err, c.err)
+			}
+		})
+	}
+}
+
+func TestFileSystemAcquireBlocking(t *testing.T) {
+	driver := &lockDriver{DriverMock: &test.DriverMock{Storage: &sync.Map{}}, owners: make(map[string]string)}
+	d := New(driver, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	if _, err := d.Acquire("lock", "a", time.Second, 0); err != nil {
+		t.Fatalf("Acquire() error = %v, want = %v", err, nil)
+	}
+
+	// canceled waiting is not retried
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var ecl *ErrCanceled
+
+	if _, err := d.AcquireContext(ctx, "lock", "b", time.Second, time.Minute); !errors.As(err, &ecl) {
+		t.Errorf("AcquireContext() error = %v, want = %v", err, &ErrCanceled{opSet, context.DeadlineExceeded})
+	}
+
+	// waiting owner gets lock as soon as it is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = d.Release("lock", "a")
+	}()
+
+	lease, err := d.Acquire("lock", "b", time.Second, time.Minute)
+	if err != nil || lease.Owner != "b" || lease.Token != 2 {
+		t.Errorf("Acquire() got = %+v, %v, want owner = %v, token = %v", lease, err, "b", 2)
+	}
+}
+
+func TestFileSystemLockNotSupported(t *testing.T) {
+	d := New(&test.DriverMock{Storage: &sync.Map{}}, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	if _, err := d.Acquire("lock", "a", time.Second, 0); !reflect.DeepEqual(err, &ErrNotSupported{opLock}) {
+		t.Errorf("Acquire() error = %v, want = %v", err, &ErrNotSupported{opLock})
+	}
+}
@@ -0,0 +1,27 @@
+package fs
+
+import (
+	"context"
+	"time"
+)
+
+// Types of `Event`.
+const (
+	EventExpired = "expired"
+	EventDeleted = "deleted"
+)
+
+type (
+	// Event reports that `Key` is expired or deleted at `Time`.
+	Event struct {
+		Type string    `json:"type"`
+		Key  string    `json:"key"`
+		Time time.Time `json:"time"`
+	}
+	// Notifier is implemented by `Driver` which reports expired and deleted keys.
+	Notifier interface {
+		// Notify calls `fn` for every expired or deleted key until `ctx` is done.
+		// `fn` may be called concurrently and under `Driver` locks, so it must not block.
+		Notify(ctx context.Context, fn func(e *Event)) error
+	}
+)
@@ -0,0 +1,209 @@
+package fs
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// fifo is the name of the single waiting queue used by `pool` without priorities.
+const fifo = ""
+
+type (
+	// waiter is a call waiting for a permit in `pool`.
+	waiter struct {
+		ready   chan struct{}
+		granted bool
+	}
+	// pool is a limited set of permits ("connection pool").
+	// Waiting calls are served in FIFO order within their operation queue,
+	// and queues are served by weighted round-robin `schedule`,
+	// so operation with the lowest weight still gets permits.
+	// If `adapt` is set then `limit` is changed by observed calls latency.
+	pool struct {
+		mu       sync.Mutex
+		limit    int
+		used     int
+		queues   map[string]*list.List
+		schedule []string
+		next     int
+		adapt    limiter
+	}
+)
+
+// newPool returns `pool` with `limit` permits for `ops`.
+// Without `weights` all calls are waiting in a single FIFO queue,
+// otherwise each of `ops` has own queue and missed weight is treated as 1.
+func newPool(limit int, weights map[string]int, ops ...string) *pool {
+	p := &pool{
+		limit:  limit,
+		queues: make(map[string]*list.List),
+	}
+
+	if len(weights) == 0 {
+		p.queues[fifo] = list.New()
+		p.schedule = []string{fifo}
+
+		return p
+	}
+
+	for _, op := range ops {
+		weight := weights[op]
+		if weight == 0 {
+			weight = 1
+		}
+
+		p.queues[op] = list.New()
+
+		for i := 0; i < weight; i++ {
+			p.schedule = append(p.schedule, op)
+		}
+	}
+
+	return p
+}
+
+// queue returns waiting queue for `op`.
+func (p *pool) queue(op string) *list.List {
+	q, ok := p.queues[op]
+	if !ok {
+		q = p.queues[fifo]
+	}
+
+	return q
+}
+
+// waiting returns the number of waiting calls.
+func (p *pool) waiting() int {
+	n := 0
+
+	for _, q := range p.queues {
+		n += q.Len()
+	}
+
+	return n
+}
+
+// dispatch grants free permits to waiting calls.
+// Must be called under lock.
+func (p *pool) dispatch() {
+	for p.used < p.limit {
+		granted := false
+
+		for i := 0; i < len(p.schedule); i++ {
+			op := p.schedule[(p.next+i)%len(p.schedule)]
+
+			front := p.queues[op].Front()
+			if front == nil {
+				continue
+			}
+
+			p.queues[op].Remove(front)
+			p.next = (p.next + i + 1) % len(p.schedule)
+
+			w := front.Value.(*waiter)
+			w.granted = true
+			close(w.ready)
+
+			p.used++
+			granted = true
+
+			break
+		}
+
+		if !granted {
+			return
+		}
+	}
+}
+
+// acquire waits for a permit for `op` no longer than `timeout`.
+// Returns `ErrConcurrentTimeout` or `ErrCanceled` if permit is not granted.
+func (p *pool) acquire(ctx context.Context, op string, timeout time.Duration) error {
+	p.mu.Lock()
+
+	if p.used < p.limit && p.waiting() == 0 {
+		p.used++
+		p.mu.Unlock()
+
+		return nil
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	elem := p.queue(op).PushBack(w)
+
+	p.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+		err = &ErrConcurrentTimeout{op}
+	case <-ctx.Done():
+		err = &ErrCanceled{op, ctx.Err()}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if w.granted {
+		// permit is granted concurrently with giving up, pass it on
+		p.used--
+		p.dispatch()
+	} else {
+		p.queue(op).Remove(elem)
+	}
+
+	return err
+}
+
+// release returns permit to `pool`.
+func (p *pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.used--
+	p.dispatch()
+}
+
+// observe reports `rtt` of call made under permit to adapt `limit`.
+func (p *pool) observe(rtt time.Duration, dropped bool) {
+	if p.adapt == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.limit = p.adapt.update(p.limit, rtt, dropped)
+	p.dispatch()
+}
+
+// state returns current `Usage` of `pool`.
+func (p *pool) state() Usage {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Usage{Limit: p.limit, Used: p.used, Waiting: p.waiting()}
+}
+
+// usage returns the number of used permits and waiting calls.
+func (p *pool) usage() (used, waiting int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.used, p.waiting()
+}
+
+// busy checks that `pool` has used permits or waiting calls.
+func (p *pool) busy() bool {
+	used, waiting := p.usage()
+
+	return used != 0 || waiting != 0
+}
@@ -0,0 +1,241 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+func TestPoolFIFO(t *testing.T) {
+	p := newPool(1, nil, opGet, opSet, opDel)
+
+	_ = p.acquire(context.Background(), opGet, time.Second)
+
+	ops := []string{opSet, opGet, opDel, opGet, opSet}
+	order := make(chan string, len(ops))
+
+	for i, op := range ops {
+		go func(op string) {
+			if err := p.acquire(context.Background(), op, time.Minute); err == nil {
+				order <- op
+			}
+		}(op)
+
+		waitQueue(t, p, i+1)
+	}
+
+	for _, want := range ops {
+		p.release()
+
+		if got := <-order; got != want {
+			t.Errorf("acquire() order = %s, want = %s", got, want)
+		}
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	p := newPool(1, map[string]int{opGet: 2, opSet: 1}, opGet, opSet, opDel)
+
+	_ = p.acquire(context.Background(), opGet, time.Second)
+
+	ops := []string{opDel, opSet, opDel, opSet, opGet, opGet, opGet, opGet}
+	order := make(chan string, len(ops))
+
+	for i, op := range ops {
+		go func(op string) {
+			if err := p.acquire(context.Background(), op, time.Minute); err == nil {
+				order <- op
+			}
+		}(op)
+
+		waitQueue(t, p, i+1)
+	}
+
+	// weighted round-robin: get, get, set, del, ...
+	want := []string{opGet, opGet, opSet, opDel, opGet, opGet, opSet, opDel}
+
+	for i := range want {
+		p.release()
+
+		if got := <-order; got != want[i] {
+			t.Errorf("acquire() order[%d] = %s, want = %s", i, got, want[i])
+		}
+	}
+}
+
+func TestPoolGiveUp(t *testing.T) {
+	p := newPool(1, nil)
+
+	_ = p.acquire(context.Background(), opGet, time.Second)
+
+	var ect *ErrConcurrentTimeout
+
+	if err := p.acquire(context.Background(), opSet, 100*time.Millisecond); !errors.As(err, &ect) {
+		t.Errorf("acquire() error = %v, want = %v", err, &ErrConcurrentTimeout{opSet})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var ecl *ErrCanceled
+
+	if err := p.acquire(ctx, opDel, time.Second); !errors.As(err, &ecl) {
+		t.Errorf("acquire() error = %v, want = %v", err, &ErrCanceled{opDel, context.Canceled})
+	}
+
+	if used, waiting := p.usage(); used != 1 || waiting != 0 {
+		t.Errorf("usage() = %d, %d, want = %d, %d", used, waiting, 1, 0)
+	}
+
+	p.release()
+
+	if p.busy() {
+		t.Errorf("busy() = %v, want = %v", true, false)
+	}
+}
+
+func TestFileSystemSplitPools(t *testing.T) {
+	fs := New(
+		&test.DriverMock{
+			Storage:      &sync.Map{},
+			IsConcurrent: true,
+		},
+		&Options{
+			ReadConn:  1,
+			WriteConn: 1,
+			Timeout:   10,
+			Timeouts:  Timeouts{Set: 1},
+		},
+	).(*fileSystem)
+
+	go func() { _ = fs.Set(keyExist, valExist, ttlExist) }()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// write pool is busy, but reads are not blocked
+
+	start := time.Now()
+
+	if _, err := fs.Get(keyExist); err != nil {
+		t.Errorf("Get() error = %v, want = %v", err, nil)
+	}
+
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Get() blocked by write")
+	}
+
+	// per-operation timeout
+
+	go func() { _ = fs.Set(keyExist, valExist, ttlExist) }()
+
+	time.Sleep(100 * time.Millisecond)
+
+	var ect *ErrConcurrentTimeout
+
+	start = time.Now()
+
+	if err := fs.Set(keyExist, valExist, ttlExist); !errors.As(err, &ect) {
+		t.Errorf("Set() error = %v, want = %v", err, &ErrConcurrentTimeout{opSet})
+	}
+
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Set() per-operation timeout not happened")
+	}
+
+	fs.Close()
+}
+
+func TestNewPools(t *testing.T) {
+	cases := []struct {
+		name  string
+		opts  *Options
+		read  int
+		write int
+		err   string
+	}{
+		{
+			name:  "shared",
+			opts:  &Options{MaxConn: 3, Timeout: 1},
+			read:  3,
+			write: 3,
+		},
+		{
+			name:  "split",
+			opts:  &Options{MaxConn: 3, ReadConn: 5, Timeout: 1},
+			read:  5,
+			write: 3,
+		},
+		{
+			name: "per-operation timeouts",
+			opts: &Options{MaxConn: 3, Timeouts: Timeouts{Get: 1, Set: 1, Del: 1}},
+			read: 3,
+		},
+		{
+			name: "missed per-operation timeout",
+			opts: &Options{MaxConn: 3, Timeouts: Timeouts{Get: 1, Set: 1}},
+			err:  "non-positive Timeout",
+		},
+		{
+			name: "negative ReadConn",
+			opts: &Options{ReadConn: -1, Timeout: 1},
+			err:  "non-positive MaxConn",
+		},
+		{
+			name: "unknown priority",
+			opts: &Options{Timeout: 1, Priority: map[string]int{"put": 1}},
+			err:  "unknown operation (put) in Priority",
+		},
+		{
+			name: "non-positive priority",
+			opts: &Options{Timeout: 1, Priority: map[string]int{opDel: 0}},
+			err:  "non-positive Priority (del)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+
+				if c.err == "" {
+					if err != nil {
+						t.Errorf("panic = %v not expected", err)
+					}
+					return
+				}
+
+				if err.(string) != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			fs := New(&test.DriverMock{Storage: &sync.Map{}}, c.opts).(*fileSystem)
+
+			if fs.read.limit != c.read {
+				t.Errorf("New() read = %d, want = %d", fs.read.limit, c.read)
+			}
+
+			if c.write != 0 && fs.write.limit != c.write {
+				t.Errorf("New() write = %d, want = %d", fs.write.limit, c.write)
+			}
+		})
+	}
+}
+
+// waitQueue waits until `n` calls are waiting in `p`.
+func waitQueue(t *testing.T, p *pool, n int) {
+	t.Helper()
+
+	for i := 0; i < 1000; i++ {
+		if _, waiting := p.usage(); waiting == n {
+			return
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("waiting calls not reached %d", n)
+}
@@ -0,0 +1,181 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type (
+	// Config returns pointer to zero settings that driver (or decorator) settings are decoded to.
+	Config func() interface{}
+	// Factory returns `Driver` created with decoded `config`.
+	// May panic if `config` is invalid as drivers constructors do.
+	Factory func(config interface{}) Driver
+	// DecoratorFactory returns `driver` wrapped by decorator created with decoded `config`.
+	// May panic if `config` is invalid as drivers constructors do.
+	DecoratorFactory func(driver Driver, config interface{}) Driver
+	// ErrUnknownDriver occurred if driver (or decorator) is not registered.
+	ErrUnknownDriver struct {
+		kind      string
+		name      string
+		available []string
+	}
+	// ErrDriverConfig occurred if driver (or decorator) settings cannot be decoded.
+	ErrDriverConfig struct {
+		name string
+		err  error
+	}
+	// registration is a registered driver or decorator.
+	registration struct {
+		config    Config
+		factory   Factory
+		decorator DecoratorFactory
+	}
+	// registry is a set of registrations by name.
+	registry struct {
+		mu      sync.RWMutex
+		kind    string
+		entries map[string]*registration
+	}
+)
+
+var (
+	drivers    = &registry{kind: "driver", entries: make(map[string]*registration)}
+	decorators = &registry{kind: "decorator", entries: make(map[string]*registration)}
+)
+
+func (e *ErrUnknownDriver) Error() string {
+	return fmt.Sprintf("unknown %s (%s), available: %s", e.kind, e.name, strings.Join(e.available, ", "))
+}
+
+func (e *ErrDriverConfig) Error() string {
+	return fmt.Sprintf("invalid settings of (%s): %v", e.name, e.err)
+}
+
+func (e *ErrDriverConfig) Unwrap() error {
+	return e.err
+}
+
+// add registers `r` by `name`.
+// Panics if `name` is empty or already registered.
+func (reg *registry) add(name string, r *registration) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if name == "" {
+		log.Panicf("empty %s name", reg.kind)
+	}
+
+	if _, ok := reg.entries[name]; ok {
+		log.Panicf("%s (%s) already registered", reg.kind, name)
+	}
+
+	reg.entries[name] = r
+}
+
+// names returns sorted names of registrations.
+func (reg *registry) names() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	names := make([]string, 0, len(reg.entries))
+	for name := range reg.entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// lookup returns registration of `name` with `settings` decoded in order,
+// so values of the later ones override the earlier ones.
+func (reg *registry) lookup(name string, settings []json.RawMessage) (*registration, interface{}, error) {
+	reg.mu.RLock()
+	r, ok := reg.entries[name]
+	reg.mu.RUnlock()
+
+	if !ok {
+		return nil, nil, &ErrUnknownDriver{kind: reg.kind, name: name, available: reg.names()}
+	}
+
+	if r.config == nil {
+		return r, nil, nil
+	}
+
+	config := r.config()
+
+	for _, s := range settings {
+		if len(s) == 0 {
+			continue
+		}
+
+		if err := json.Unmarshal(s, config); err != nil {
+			return nil, nil, &ErrDriverConfig{name: name, err: err}
+		}
+	}
+
+	return r, config, nil
+}
+
+// Register makes driver available by `name` for `Open`.
+// `config` may be `nil` if driver has no settings.
+// It is intended to be called from `init` of driver package.
+// Panics if `name` is empty or already registered or `factory` is `nil`.
+func Register(name string, config Config, factory Factory) {
+	if factory == nil {
+		log.Panicf("nil factory of driver (%s)", name)
+	}
+
+	drivers.add(name, &registration{config: config, factory: factory})
+}
+
+// RegisterDecorator makes decorator available by `name` for `Decorate`.
+// `config` may be `nil` if decorator has no settings.
+// It is intended to be called from `init` of decorator package.
+// Panics if `name` is empty or already registered or `factory` is `nil`.
+func RegisterDecorator(name string, config Config, factory DecoratorFactory) {
+	if factory == nil {
+		log.Panicf("nil factory of decorator (%s)", name)
+	}
+
+	decorators.add(name, &registration{config: config, decorator: factory})
+}
+
+// Drivers returns sorted names of registered drivers.
+func Drivers() []string {
+	return drivers.names()
+}
+
+// Decorators returns sorted names of registered decorators.
+func Decorators() []string {
+	return decorators.names()
+}
+
+// Open returns driver registered by `name` created with JSON `settings`
+// (decoded in order, empty ones are skipped).
+// Returns `ErrUnknownDriver` or `ErrDriverConfig` if driver cannot be created.
+func Open(name string, settings ...json.RawMessage) (Driver, error) {
+	r, config, err := drivers.lookup(name, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.factory(config), nil
+}
+
+// Decorate returns `driver` wrapped by decorator registered by `name` created with JSON `settings`
+// (decoded in order, empty ones are skipped).
+// Returns `ErrUnknownDriver` or `ErrDriverConfig` if decorator cannot be created.
+func Decorate(driver Driver, name string, settings ...json.RawMessage) (Driver, error) {
+	r, config, err := decorators.lookup(name, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.decorator(driver, config), nil
+}
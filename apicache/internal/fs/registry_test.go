@@ -0,0 +1,163 @@
+package fs
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+type (
+	registryConfig struct {
+		Addr string `json:"addr"`
+		Size int    `json:"size"`
+	}
+	registryDriver struct {
+		*test.DriverMock
+		config *registryConfig
+	}
+	registryDecorator struct {
+		Driver
+		name string
+	}
+)
+
+func init() {
+	Register("registry-test", func() interface{} { return &registryConfig{Size: 1} }, func(config interface{}) Driver {
+		return &registryDriver{DriverMock: &test.DriverMock{Storage: &sync.Map{}}, config: config.(*registryConfig)}
+	})
+	Register("registry-test-plain", nil, func(config interface{}) Driver {
+		return &test.DriverMock{Storage: &sync.Map{}}
+	})
+	RegisterDecorator("registry-test-named", func() interface{} { return new(string) }, func(driver Driver, config interface{}) Driver {
+		return &registryDecorator{Driver: driver, name: *config.(*string)}
+	})
+}
+
+func TestOpen(t *testing.T) {
+	cases := []struct {
+		name     string
+		settings []json.RawMessage
+		want     *registryConfig
+	}{
+		{
+			name: "defaults",
+			want: &registryConfig{Size: 1},
+		},
+		{
+			name:     "settings",
+			settings: []json.RawMessage{json.RawMessage(`{"addr":"a","size":2}`)},
+			want:     &registryConfig{Addr: "a", Size: 2},
+		},
+		{
+			name:     "override and skip empty",
+			settings: []json.RawMessage{json.RawMessage(`{"addr":"a"}`), nil, json.RawMessage(`{"size":3}`)},
+			want:     &registryConfig{Addr: "a", Size: 3},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d, err := Open("registry-test", c.settings...)
+			if err != nil {
+				t.Fatalf("Open() error = %v, want = %v", err, nil)
+			}
+
+			if got := d.(*registryDriver).config; !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Open() config got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+
+	if _, err := Open("registry-test-plain", json.RawMessage(`not json`)); err != nil {
+		t.Errorf("Open() error = %v, want = %v", err, nil)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	_, err := Open("not-registered")
+
+	var eud *ErrUnknownDriver
+	if !errors.As(err, &eud) {
+		t.Fatalf("Open() error = %v, want = %T", err, eud)
+	}
+
+	want := "unknown driver (not-registered), available: registry-test, registry-test-plain"
+	if err.Error() != want {
+		t.Errorf("Open() error got = %v, want = %v", err, want)
+	}
+
+	_, err = Open("registry-test", json.RawMessage(`{"size":"big"}`))
+
+	var edc *ErrDriverConfig
+	if !errors.As(err, &edc) {
+		t.Fatalf("Open() error = %v, want = %T", err, edc)
+	}
+
+	var ute *json.UnmarshalTypeError
+	if !errors.As(err, &ute) {
+		t.Errorf("ErrDriverConfig.Unwrap() = %v, want = %T", edc.Unwrap(), ute)
+	}
+
+	_, err = Decorate(&test.DriverMock{}, "not-registered")
+
+	want = "unknown decorator (not-registered), available: registry-test-named"
+	if err == nil || err.Error() != want {
+		t.Errorf("Decorate() error got = %v, want = %v", err, want)
+	}
+}
+
+func TestDecorate(t *testing.T) {
+	driver := &test.DriverMock{Storage: &sync.Map{}}
+
+	inner, err := Decorate(driver, "registry-test-named", json.RawMessage(`"inner"`))
+	if err != nil {
+		t.Fatalf("Decorate() error = %v, want = %v", err, nil)
+	}
+
+	outer, err := Decorate(inner, "registry-test-named", json.RawMessage(`"outer"`))
+	if err != nil {
+		t.Fatalf("Decorate() error = %v, want = %v", err, nil)
+	}
+
+	d := outer.(*registryDecorator)
+	if d.name != "outer" || d.Driver.(*registryDecorator).name != "inner" || d.Driver.(*registryDecorator).Driver != driver {
+		t.Errorf("Decorate() got = %v, want = outer(inner(driver))", d)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	factory := func(config interface{}) Driver { return nil }
+
+	cases := []struct {
+		name string
+		fn   func()
+		err  string
+	}{
+		{name: "empty name", fn: func() { Register("", nil, factory) }, err: "empty driver name"},
+		{name: "duplicate", fn: func() { Register("registry-test", nil, factory) }, err: "driver (registry-test) already registered"},
+		{name: "nil factory", fn: func() { Register("nil", nil, nil) }, err: "nil factory of driver (nil)"},
+		{name: "nil decorator", fn: func() { RegisterDecorator("nil", nil, nil) }, err: "nil factory of decorator (nil)"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			c.fn()
+		})
+	}
+
+	if got, want := Drivers(), []string{"registry-test", "registry-test-plain"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Drivers() got = %v, want = %v", got, want)
+	}
+
+	if got, want := Decorators(), []string{"registry-test-named"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Decorators() got = %v, want = %v", got, want)
+	}
+}
@@ -0,0 +1,299 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	opTag     = "tag"
+	opPattern = "pattern"
+)
+
+type (
+	// Tagger is implemented by `ContextDriver` which groups keys by tags.
+	// Index of tag must not keep keys after they are expired or deleted,
+	// tags of overwritten key are replaced (see `drivertest.Run()`).
+	Tagger interface {
+		// SetTagsContext is `SetContext()` which attaches `tags` to key.
+		SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) (err error)
+		// DeleteTagContext deletes all keys with `tag` and returns the number of deleted ones.
+		DeleteTagContext(ctx context.Context, tag string) (n int, err error)
+	}
+	// Matcher is implemented by `ContextDriver` which deletes keys by glob-style pattern
+	// ("*", "?", "[...]" and "\" escape, see `Match()`).
+	Matcher interface {
+		// DeletePatternContext deletes all keys matching `pattern` and returns the number of deleted ones.
+		DeletePatternContext(ctx context.Context, pattern string) (n int, err error)
+	}
+	// ErrNotSupported occurred if `Driver` doesn't implement operation.
+	// Decorator returns it if decorated `Driver` doesn't implement operation.
+	ErrNotSupported struct {
+		op string
+	}
+	// unsupported is `Tagger` and `Matcher` of `Driver` which doesn't implement them.
+	unsupported struct{}
+	// ErrEmptySelector occurred if tag or pattern is not passed.
+	ErrEmptySelector struct {
+		selector string
+	}
+)
+
+func (e *ErrNotSupported) Error() string {
+	return fmt.Sprintf("operation (%s) is not supported by driver", e.op)
+}
+
+func (e *ErrEmptySelector) Error() string {
+	return "empty " + e.selector
+}
+
+// NewErrNotSupported returns `ErrNotSupported` of `op`.
+func NewErrNotSupported(op string) error {
+	return &ErrNotSupported{op}
+}
+
+func (unsupported) SetTagsContext(context.Context, string, string, int, []string) error {
+	return &ErrNotSupported{opTag}
+}
+
+func (unsupported) DeleteTagContext(context.Context, string) (int, error) {
+	return 0, &ErrNotSupported{opTag}
+}
+
+func (unsupported) DeletePatternContext(context.Context, string) (int, error) {
+	return 0, &ErrNotSupported{opPattern}
+}
+
+// TaggerOf returns `driver` as `Tagger`, calls of which return `ErrNotSupported` if it is not.
+// Uses by decorators which forward tags to decorated `Driver`.
+func TaggerOf(driver Driver) Tagger {
+	if tagger, ok := driver.(Tagger); ok {
+		return tagger
+	}
+
+	return unsupported{}
+}
+
+// MatcherOf returns `driver` as `Matcher`, calls of which return `ErrNotSupported` if it is not.
+// Uses by decorators which forward patterns to decorated `Driver`.
+func MatcherOf(driver Driver) Matcher {
+	if matcher, ok := driver.(Matcher); ok {
+		return matcher
+	}
+
+	return unsupported{}
+}
+
+// SetTags sets key, value and "time-to-live" with `tags` to key-value storage.
+func (d *fileSystem) SetTags(key, val string, ttl int, tags []string) error {
+	return d.SetTagsContext(context.Background(), key, val, ttl, tags)
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage until `ctx` is done.
+// Returns `ErrNotSupported` if `Driver` is not `Tagger`, `SetContext()` is used if `tags` are empty.
+func (d *fileSystem) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if len(tags) == 0 {
+		return d.SetContext(ctx, key, val, ttl)
+	}
+
+	tagger, ok := d.driver.(Tagger)
+	if !ok {
+		return &ErrNotSupported{opTag}
+	}
+
+	if key == "" {
+		return &ErrEmptyKey{}
+	}
+
+	if val == "" {
+		return &ErrEmptyVal{key}
+	}
+
+	if ttl < minInt {
+		return &ErrInvalidTTL{key, ttl}
+	}
+
+	for _, tag := range tags {
+		if tag == "" {
+			return &ErrEmptySelector{opTag}
+		}
+	}
+
+	d.hot.touch(opSet, key)
+
+	if err := d.acquire(ctx, opSet); err != nil {
+		return err
+	}
+	defer d.release(opSet)
+
+	d.flight.forget(key)
+	defer d.flight.forget(key)
+
+	start := time.Now()
+	err := tagger.SetTagsContext(ctx, key, val, ttl, tags)
+	d.observe(opSet, start, err)
+
+	if err != nil {
+		return storageError(opSet, err)
+	}
+
+	return nil
+}
+
+// DeleteTag deletes all keys with `tag` from key-value storage.
+func (d *fileSystem) DeleteTag(tag string) (int, error) {
+	return d.DeleteTagContext(context.Background(), tag)
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage until `ctx` is done.
+// Returns `ErrNotSupported` if `Driver` is not `Tagger`.
+func (d *fileSystem) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	tagger, ok := d.driver.(Tagger)
+	if !ok {
+		return 0, &ErrNotSupported{opTag}
+	}
+
+	return d.bulk(ctx, opTag, tag, func() (int, error) {
+		return tagger.DeleteTagContext(ctx, tag)
+	})
+}
+
+// DeletePattern deletes all keys matching `pattern` from key-value storage.
+func (d *fileSystem) DeletePattern(pattern string) (int, error) {
+	return d.DeletePatternContext(context.Background(), pattern)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage until `ctx` is done.
+// Returns `ErrNotSupported` if `Driver` is not `Matcher`.
+func (d *fileSystem) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	matcher, ok := d.driver.(Matcher)
+	if !ok {
+		return 0, &ErrNotSupported{opPattern}
+	}
+
+	return d.bulk(ctx, opPattern, pattern, func() (int, error) {
+		return matcher.DeletePatternContext(ctx, pattern)
+	})
+}
+
+// bulk calls `fn` deleting keys selected by `kind` of `selector` under permit of "connection pool".
+func (d *fileSystem) bulk(ctx context.Context, kind, selector string, fn func() (int, error)) (int, error) {
+	if selector == "" {
+		return 0, &ErrEmptySelector{kind}
+	}
+
+	if err := d.acquire(ctx, opDel); err != nil {
+		return 0, err
+	}
+	defer d.release(opDel)
+
+	start := time.Now()
+	n, err := fn()
+	d.observe(opDel, start, err)
+
+	if err != nil {
+		return n, storageError(opDel, err)
+	}
+
+	return n, nil
+}
+
+// Match checks `s` matches glob-style `pattern` as redis `KEYS` does:
+// "*" matches any sequence, "?" matches any character, "[...]" matches characters class
+// ("^" negates, "a-z" ranges) and "\" escapes the next character.
+func Match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for pattern = pattern[1:]; len(pattern) > 0 && pattern[0] == '*'; pattern = pattern[1:] {
+			}
+
+			if pattern == "" {
+				return true
+			}
+
+			for i := 0; i <= len(s); i++ {
+				if Match(pattern, s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+
+			pattern, s = pattern[1:], s[1:]
+		case '[':
+			if s == "" {
+				return false
+			}
+
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				end++
+			}
+
+			if end == len(pattern) {
+				// unclosed class is matched literally
+				if s[0] != '[' {
+					return false
+				}
+
+				pattern, s = pattern[1:], s[1:]
+
+				continue
+			}
+
+			if !matchClass(pattern[1:end], s[0]) {
+				return false
+			}
+
+			pattern, s = pattern[end+1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+		default:
+			if s == "" || pattern[0] != s[0] {
+				return false
+			}
+
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+
+	return s == ""
+}
+
+// matchClass checks `b` matches characters `class` of "[...]" ("^" negates, "a-z" ranges).
+func matchClass(class string, b byte) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+
+	matched := false
+
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			if class[i] <= b && b <= class[i+2] {
+				matched = true
+			}
+
+			i += 2
+
+			continue
+		}
+
+		if class[i] == b {
+			matched = true
+		}
+	}
+
+	return matched != negate
+}
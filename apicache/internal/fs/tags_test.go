@@ -0,0 +1,165 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/test"
+)
+
+// tagDriver is `DriverMock` implementing `Tagger` and `Matcher`.
+type tagDriver struct {
+	*test.DriverMock
+	tags map[string][]string
+}
+
+func (d *tagDriver) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if err := d.SetContext(ctx, key, val, ttl); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		d.tags[tag] = append(d.tags[tag], key)
+	}
+
+	return nil
+}
+
+func (d *tagDriver) DeleteTagContext(_ context.Context, tag string) (int, error) {
+	if tag == test.KeyError {
+		return 0, errors.New(test.InternalError)
+	}
+
+	n := 0
+
+	for _, key := range d.tags[tag] {
+		if _, ok := d.Storage.Load(key); ok {
+			d.Storage.Delete(key)
+			n++
+		}
+	}
+
+	delete(d.tags, tag)
+
+	return n, nil
+}
+
+func (d *tagDriver) DeletePatternContext(_ context.Context, pattern string) (int, error) {
+	n := 0
+
+	d.Storage.Range(func(key, _ interface{}) bool {
+		if Match(pattern, key.(string)) {
+			d.Storage.Delete(key)
+			n++
+		}
+
+		return true
+	})
+
+	return n, nil
+}
+
+func TestFileSystemTags(t *testing.T) {
+	driver := &tagDriver{DriverMock: &test.DriverMock{Storage: &sync.Map{}}, tags: make(map[string][]string)}
+	d := New(driver, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	cases := []struct {
+		name string
+		key  string
+		val  string
+		ttl  int
+		tags []string
+		err  error
+	}{
+		{"empty key", "", valExist, ttlExist, []string{"tag"}, &ErrEmptyKey{}},
+		{"empty val", keyExist, "", ttlExist, []string{"tag"}, &ErrEmptyVal{keyExist}},
+		{"invalid ttl", keyExist, valExist, 0, []string{"tag"}, &ErrInvalidTTL{keyExist, 0}},
+		{"empty tag", keyExist, valExist, ttlExist, []string{"tag", ""}, &ErrEmptySelector{opTag}},
+		{"tagged", "user:1", valExist, ttlExist, []string{"users"}, nil},
+		{"tagged twice", "user:2", valExist, ttlExist, []string{"users", "admins"}, nil},
+		{"untagged", "user:3", valExist, ttlExist, nil, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := d.SetTags(c.key, c.val, c.ttl, c.tags)
+			if !reflect.DeepEqual(err, c.err) {
+				t.Errorf("SetTags() error = %v, want = %v", err, c.err)
+			}
+		})
+	}
+
+	if n, err := d.DeleteTag("users"); n != 2 || err != nil {
+		t.Errorf("DeleteTag() got = %v, %v, want = %v, %v", n, err, 2, nil)
+	}
+
+	if _, err := d.DeleteTag(""); !reflect.DeepEqual(err, &ErrEmptySelector{opTag}) {
+		t.Errorf("DeleteTag() error = %v, want = %v", err, &ErrEmptySelector{opTag})
+	}
+
+	if _, err := d.DeletePattern(""); !reflect.DeepEqual(err, &ErrEmptySelector{opPattern}) {
+		t.Errorf("DeletePattern() error = %v, want = %v", err, &ErrEmptySelector{opPattern})
+	}
+
+	want := fmt.Errorf(ErrKVStorage, errors.New(test.InternalError))
+	if _, err := d.DeleteTag(test.KeyError); !reflect.DeepEqual(err, want) {
+		t.Errorf("DeleteTag() error = %v, want = %v", err, want)
+	}
+
+	if n, err := d.DeletePattern("user:*"); n != 1 || err != nil {
+		t.Errorf("DeletePattern() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+}
+
+func TestFileSystemTagsNotSupported(t *testing.T) {
+	d := New(&test.DriverMock{Storage: &sync.Map{}}, &Options{MaxConn: maxConn, Timeout: timeout}).(*fileSystem)
+
+	if err := d.SetTags(keyExist, valExist, ttlExist, []string{"tag"}); !reflect.DeepEqual(err, &ErrNotSupported{opTag}) {
+		t.Errorf("SetTags() error = %v, want = %v", err, &ErrNotSupported{opTag})
+	}
+
+	if _, err := d.DeleteTag("tag"); !reflect.DeepEqual(err, &ErrNotSupported{opTag}) {
+		t.Errorf("DeleteTag() error = %v, want = %v", err, &ErrNotSupported{opTag})
+	}
+
+	if _, err := d.DeletePattern("*"); !reflect.DeepEqual(err, &ErrNotSupported{opPattern}) {
+		t.Errorf("DeletePattern() error = %v, want = %v", err, &ErrNotSupported{opPattern})
+	}
+
+	// no tags is a plain set
+	if err := d.SetTags(keyExist, valExist, ttlExist, nil); err != nil {
+		t.Errorf("SetTags() error = %v, want = %v", err, nil)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"user:42:*", "user:42:name", true},
+		{"user:42:*", "user:420:name", false},
+		{"user:?", "user:1", true},
+		{"user:?", "user:12", false},
+		{"user:[0-4]", "user:3", true},
+		{"user:[^0-4]", "user:3", false},
+		{"user:[abc]x", "user:bx", true},
+		{`user:\*`, "user:*", true},
+		{`user:\*`, "user:1", false},
+		{"user:[", "user:[", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXbY", false},
+	}
+	for _, c := range cases {
+		t.Run(c.pattern+" "+c.s, func(t *testing.T) {
+			if got := Match(c.pattern, c.s); got != c.want {
+				t.Errorf("Match() got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
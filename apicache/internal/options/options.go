@@ -10,12 +10,93 @@ import (
 	"github.com/kxnes/go-interviews/apicache/internal/fs"
 )
 
-// Optional contains optional parameters, like key-value storage
-// name from `internal/drivers` package.
-// The list of drivers can be expanded with putting `fs.Driver` directly.
-type Optional struct {
-	Name string `json:"name"`
-	Addr string `json:"addr"`
+type (
+	// Optional contains optional parameters, like key-value storage
+	// name registered in `fs` (by `internal/drivers` packages) and it's settings
+	// under the key of the same name, `Addr` is the default address for drivers that have it.
+	// `Decorators` wrap the driver in order, so the last one is the outermost.
+	// The list of drivers can be expanded with `fs.Register`.
+	Optional struct {
+		Name       string          `json:"name"`
+		Addr       string          `json:"addr"`
+		Decorators []*Decorator    `json:"decorators"`
+		Settings   json.RawMessage `json:"-"`
+	}
+	// Decorator contains decorator name registered in `fs` and it's settings
+	// under the key of the same name.
+	Decorator struct {
+		Name     string          `json:"name"`
+		Settings json.RawMessage `json:"-"`
+	}
+)
+
+// settings returns value of `name` key of JSON object `data` or `nil`.
+func settings(data []byte, name string) (json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields[name], nil
+}
+
+// UnmarshalJSON decodes `o` and settings of it's driver.
+func (o *Optional) UnmarshalJSON(data []byte) error {
+	type optional Optional
+
+	if err := json.Unmarshal(data, (*optional)(o)); err != nil {
+		return err
+	}
+
+	var err error
+
+	o.Settings, err = settings(data, o.Name)
+
+	return err
+}
+
+// UnmarshalJSON decodes `d` and it's settings.
+func (d *Decorator) UnmarshalJSON(data []byte) error {
+	type decorator Decorator
+
+	if err := json.Unmarshal(data, (*decorator)(d)); err != nil {
+		return err
+	}
+
+	var err error
+
+	d.Settings, err = settings(data, d.Name)
+
+	return err
+}
+
+// Open returns registered driver of `o` decorated by `o.Decorators`.
+// Returns `fs.ErrUnknownDriver` (with the list of available ones)
+// or `fs.ErrDriverConfig` if something cannot be created.
+func (o *Optional) Open() (fs.Driver, error) {
+	var defaults json.RawMessage
+
+	if o.Addr != "" {
+		defaults, _ = json.Marshal(map[string]string{"addr": o.Addr})
+	}
+
+	driver, err := fs.Open(o.Name, defaults, o.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range o.Decorators {
+		decorated, err := fs.Decorate(driver, d.Name, d.Settings)
+		if err != nil {
+			driver.Close()
+			return nil, err
+		}
+
+		driver = decorated
+	}
+
+	return driver, nil
 }
 
 // Options contains `Driver` must have parameters.
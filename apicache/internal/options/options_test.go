@@ -1,11 +1,23 @@
 package options
 
 import (
+	"encoding/json"
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/kxnes/go-interviews/apicache/internal/apicache"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/cache"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/encrypt"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/retry"
+	_ "github.com/kxnes/go-interviews/apicache/internal/decorators/writebehind"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/disk"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/memcache"
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	_ "github.com/kxnes/go-interviews/apicache/internal/drivers/redis"
 	"github.com/kxnes/go-interviews/apicache/internal/fs"
 )
 
@@ -68,3 +80,117 @@ func TestLoad(t *testing.T) {
 		})
 	}
 }
+
+func TestOptionalOpen(t *testing.T) {
+	var opts Optional
+
+	err := json.Unmarshal([]byte(`{
+		"name": "memory",
+		"addr": "127.0.0.1:6379",
+		"memory": {"maxEntries": 10},
+		"redis": {"addr": "ignored"},
+		"decorators": [
+			{"name": "retry", "retry": {"attempts": 5}},
+			{"name": "cache"}
+		]
+	}`), &opts)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v, want = %v", err, nil)
+	}
+
+	if string(opts.Settings) != `{"maxEntries": 10}` {
+		t.Errorf("Settings got = %s, want = %s", opts.Settings, `{"maxEntries": 10}`)
+	}
+
+	driver, err := opts.Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v, want = %v", err, nil)
+	}
+	defer driver.Close()
+
+	// the last decorator is the outermost
+	if _, ok := driver.(*cache.Driver); !ok {
+		t.Errorf("Open() got = %T, want = %T", driver, &cache.Driver{})
+	}
+
+	if err := driver.Set("key", "val", 0); err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if val, err := driver.Get("key"); val != "val" || err != nil {
+		t.Errorf("Get() got = %s, %v, want = %s, %v", val, err, "val", nil)
+	}
+
+	// `memory.Driver` has no address, default one is ignored
+	d, err := (&Optional{Name: "memory", Addr: "127.0.0.1:6379"}).Open()
+	if err != nil {
+		t.Fatalf("Open() error = %v, want = %v", err, nil)
+	}
+
+	if _, ok := d.(*memory.Driver); !ok {
+		t.Errorf("Open() got = %T, want = %T", d, &memory.Driver{})
+	}
+
+	d.Close()
+}
+
+func TestOptionalOpenErrors(t *testing.T) {
+	// lists of available ones grow with new drivers and decorators, so only known names are checked
+	cases := []struct {
+		name     string
+		opts     *Optional
+		err      string
+		contains []string
+	}{
+		{
+			name:     "unknown driver",
+			opts:     &Optional{Name: "mongo"},
+			err:      "unknown driver (mongo), available: ",
+			contains: []string{"disk", "memcache", "memory", "redis"},
+		},
+		{
+			name:     "unknown decorator",
+			opts:     &Optional{Name: "memory", Decorators: []*Decorator{{Name: "compress"}}},
+			err:      "unknown decorator (compress), available: ",
+			contains: []string{"cache", "encrypt", "retry", "writebehind"},
+		},
+		{
+			name: "invalid settings",
+			opts: &Optional{Name: "memory", Settings: json.RawMessage(`{"maxEntries": "many"}`)},
+			err:  "invalid settings of (memory)",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := c.opts.Open()
+			if err == nil || !strings.HasPrefix(err.Error(), c.err) {
+				t.Fatalf("Open() error got = %v, want = %v", err, c.err)
+			}
+
+			available := strings.Split(strings.TrimPrefix(err.Error(), c.err), ", ")
+
+			for _, name := range c.contains {
+				if !contains(available, name) {
+					t.Errorf("Open() error got = %v, want %s available", err, name)
+				}
+			}
+		})
+	}
+
+	var eud *fs.ErrUnknownDriver
+
+	if _, err := (&Optional{Name: "mongo"}).Open(); !errors.As(err, &eud) {
+		t.Errorf("Open() error got = %v, want = %T", err, eud)
+	}
+}
+
+// contains checks `names` contain `name`.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,839 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// electionTimeout returns random timeout in [`Election`, 2 * `Election`).
+func (n *Node) electionTimeout() time.Duration {
+	election := n.opts.Election * time.Millisecond
+	return election + time.Duration(n.rand.Int63n(int64(election)))
+}
+
+// last returns the last entry of log.
+func (n *Node) last() *Entry {
+	return n.log[len(n.log)-1]
+}
+
+// base returns the first entry of log, it is the last one of snapshot (if it is taken).
+func (n *Node) base() *Entry {
+	return n.log[0]
+}
+
+// entry returns entry `index` of log, it must be neither compacted nor missed.
+func (n *Node) entry(index uint64) *Entry {
+	return n.log[index-n.base().Index]
+}
+
+// member returns member `id` of the current membership or `nil`.
+func (n *Node) member(id string) *Member {
+	for _, m := range n.members {
+		if m.ID == id {
+			return m
+		}
+	}
+
+	return nil
+}
+
+// peers returns members except `n`.
+func (n *Node) peers() []*Member {
+	peers := make([]*Member, 0, len(n.members))
+
+	for _, m := range n.members {
+		if m.ID != n.opts.ID {
+			peers = append(peers, m)
+		}
+	}
+
+	return peers
+}
+
+// quorum returns the number of members which is majority.
+func (n *Node) quorum() int {
+	return len(n.members)/2 + 1
+}
+
+// config updates membership by the last "config" entry of log.
+// Membership takes effect as soon as it is appended to log.
+func (n *Node) config() {
+	n.members = n.membersAt(n.last().Index)
+}
+
+// membersAt returns membership by the last "config" entry up to `index`
+// (membership of snapshot or initial `Members` if there is no one).
+func (n *Node) membersAt(index uint64) []*Member {
+	for i := len(n.log) - 1; i > 0; i-- {
+		if e := n.log[i]; e.Index <= index && e.Command.Op == opConfig {
+			return e.Command.Members
+		}
+	}
+
+	if n.snapshot != nil {
+		return n.snapshot.Members
+	}
+
+	return n.opts.Members
+}
+
+// pendingConfig checks the last membership change is not committed.
+func (n *Node) pendingConfig() bool {
+	for i := len(n.log) - 1; i > 0 && n.log[i].Index > n.committed; i-- {
+		if n.log[i].Command.Op == opConfig {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signal notifies `ch` without blocking.
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// stepDown makes `n` follower of `term`, it must be called under `mu`.
+func (n *Node) stepDown(term uint64) {
+	if term > n.term {
+		n.term, n.votedFor, n.leader, n.leaderAddr = term, "", "", ""
+		must(n.store.saveState(n.term, n.votedFor))
+	}
+
+	if n.state != StateFollower {
+		n.state = StateFollower
+		n.heard = time.Now()
+	}
+}
+
+// becomeLeader makes `n` leader of the current term and appends "noop" entry,
+// so entries of previous terms are committed and reads can be served.
+// It must be called under `mu`.
+func (n *Node) becomeLeader() {
+	n.state, n.leader = StateLeader, n.opts.ID
+
+	if m := n.member(n.opts.ID); m != nil {
+		n.leaderAddr = m.Addr
+	}
+
+	for _, p := range n.peers() {
+		n.next[p.ID], n.match[p.ID] = n.last().Index+1, 0
+	}
+
+	n.append(&Command{Op: opNoop})
+	log.Printf("raft node (%s) is leader of term (%d)\n", n.opts.ID, n.term)
+}
+
+// append appends `cmd` to log of leader and returns it's entry, it must be called under `mu`.
+func (n *Node) append(cmd *Command) *Entry {
+	e := &Entry{Term: n.term, Index: n.last().Index + 1, Command: cmd}
+	n.log = append(n.log, e)
+	must(n.store.append([]*Entry{e}))
+
+	if cmd.Op == opConfig {
+		n.config()
+
+		for _, p := range n.peers() {
+			if _, ok := n.next[p.ID]; !ok {
+				n.next[p.ID], n.match[p.ID] = e.Index, 0
+			}
+		}
+	}
+
+	n.advance()
+	signal(n.kick)
+
+	return e
+}
+
+// advance commits the last entry of the current term replicated to majority, it must be called under `mu`.
+// Leader which is not a member anymore steps down as soon as it's removal is committed.
+func (n *Node) advance() {
+	for i := len(n.log) - 1; i > 0 && n.log[i].Index > n.committed && n.log[i].Term == n.term; i-- {
+		acks := 0
+
+		for _, m := range n.members {
+			if m.ID == n.opts.ID || n.match[m.ID] >= n.log[i].Index {
+				acks++
+			}
+		}
+
+		if acks >= n.quorum() {
+			n.committed = n.log[i].Index
+			signal(n.commit)
+
+			break
+		}
+	}
+
+	if n.member(n.opts.ID) == nil && !n.pendingConfig() {
+		n.stepDown(n.term)
+	}
+}
+
+// run sends heartbeats while `n` is leader or starts election if leader is not heard until `n` is stopped.
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.opts.Heartbeat * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.kick:
+		}
+
+		n.mu.Lock()
+
+		switch {
+		case n.state == StateLeader:
+			n.broadcast()
+		case n.member(n.opts.ID) != nil && time.Since(n.heard) >= n.timeout:
+			n.campaign()
+		}
+
+		n.mu.Unlock()
+	}
+}
+
+// campaign starts election of the next term, it must be called under `mu`.
+func (n *Node) campaign() {
+	n.term++
+	n.state, n.votedFor, n.leader, n.leaderAddr = StateCandidate, n.opts.ID, "", ""
+	n.heard, n.timeout = time.Now(), n.electionTimeout()
+	must(n.store.saveState(n.term, n.votedFor))
+
+	if n.quorum() == 1 {
+		n.becomeLeader()
+		return
+	}
+
+	req := &voteRequest{Term: n.term, Candidate: n.opts.ID, LastIndex: n.last().Index, LastTerm: n.last().Term}
+	votes := 1
+
+	for _, p := range n.peers() {
+		go func(p *Member) {
+			var resp voteResponse
+
+			if err := n.call(n.ctx, p.Addr, routeVote, req, &resp); err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+
+			if n.state != StateCandidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+
+			if votes++; votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+// broadcast replicates log to peers that are not replicating now, it must be called under `mu`.
+func (n *Node) broadcast() {
+	for _, p := range n.peers() {
+		if n.inflight[p.ID] {
+			continue
+		}
+
+		n.inflight[p.ID] = true
+
+		go func(p *Member) {
+			n.replicate(n.ctx, p)
+
+			n.mu.Lock()
+			n.inflight[p.ID] = false
+			n.mu.Unlock()
+		}(p)
+	}
+}
+
+// replicate sends entries that `p` doesn't have (heartbeat if there are no ones),
+// snapshot is sent instead of compacted entries.
+// Returns whether `p` acknowledged `n` as leader.
+func (n *Node) replicate(ctx context.Context, p *Member) bool {
+	n.mu.Lock()
+
+	if n.state != StateLeader {
+		n.mu.Unlock()
+		return false
+	}
+
+	next := n.next[p.ID]
+	if next == 0 || next > n.last().Index+1 {
+		next = n.last().Index + 1
+	}
+
+	if next <= n.base().Index {
+		return n.install(ctx, p)
+	}
+
+	start := next - n.base().Index
+
+	end := uint64(len(n.log))
+	if end > start+maxBatch {
+		end = start + maxBatch
+	}
+
+	req := &appendRequest{
+		Term:       n.term,
+		Leader:     n.opts.ID,
+		LeaderAddr: n.leaderAddr,
+		PrevIndex:  next - 1,
+		PrevTerm:   n.entry(next - 1).Term,
+		Entries:    append([]*Entry{}, n.log[start:end]...),
+		Commit:     n.committed,
+	}
+
+	n.mu.Unlock()
+
+	var resp appendResponse
+
+	if err := n.call(ctx, p.Addr, routeAppend, req, &resp); err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false
+	}
+
+	if n.state != StateLeader || n.term != req.Term {
+		return false
+	}
+
+	if !resp.Success {
+		if resp.Conflict < n.next[p.ID] || n.next[p.ID] == 0 {
+			n.next[p.ID] = resp.Conflict
+		}
+
+		if n.next[p.ID] <= n.match[p.ID] {
+			n.next[p.ID] = n.match[p.ID] + 1
+		}
+
+		signal(n.kick)
+
+		return true
+	}
+
+	if match := req.PrevIndex + uint64(len(req.Entries)); match > n.match[p.ID] {
+		n.match[p.ID], n.next[p.ID] = match, match+1
+		n.advance()
+	}
+
+	if n.next[p.ID] <= n.last().Index {
+		signal(n.kick)
+	}
+
+	return true
+}
+
+// vote handles vote request of candidate.
+// Node which hears from leader ignores candidates, so removed members don't disrupt the cluster.
+func (n *Node) vote(req *voteRequest) *voteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term || n.store.closed {
+		return &voteResponse{Term: n.term}
+	}
+
+	if n.state == StateLeader || n.leader != "" && time.Since(n.heard) < n.opts.Election*time.Millisecond {
+		return &voteResponse{Term: n.term}
+	}
+
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+
+	last := n.last()
+	upToDate := req.LastTerm > last.Term || req.LastTerm == last.Term && req.LastIndex >= last.Index
+
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor, n.heard = req.Candidate, time.Now()
+		must(n.store.saveState(n.term, n.votedFor))
+
+		return &voteResponse{Term: n.term, Granted: true}
+	}
+
+	return &voteResponse{Term: n.term}
+}
+
+// appendEntries handles entries (or heartbeat) of leader.
+func (n *Node) appendEntries(req *appendRequest) *appendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term || n.store.closed {
+		return &appendResponse{Term: n.term}
+	}
+
+	n.stepDown(req.Term)
+	n.leader, n.leaderAddr, n.heard = req.Leader, req.LeaderAddr, time.Now()
+
+	if req.PrevIndex > n.last().Index {
+		return &appendResponse{Term: n.term, Conflict: n.last().Index + 1}
+	}
+
+	// entries up to snapshot are committed, so they match leader's ones
+	if base := n.base().Index; req.PrevIndex >= base {
+		if term := n.entry(req.PrevIndex).Term; term != req.PrevTerm {
+			conflict := req.PrevIndex
+			for conflict > base+1 && n.entry(conflict-1).Term == term {
+				conflict--
+			}
+
+			return &appendResponse{Term: n.term, Conflict: conflict}
+		}
+	}
+
+	var (
+		appended  []*Entry
+		truncated bool
+	)
+
+	for _, e := range req.Entries {
+		if e.Index <= n.base().Index {
+			continue
+		}
+
+		if e.Index <= n.last().Index {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+
+			n.log = n.log[:e.Index-n.base().Index]
+			truncated = true
+		}
+
+		n.log = append(n.log, e)
+		appended = append(appended, e)
+	}
+
+	// entries are persisted before they are acknowledged
+	if truncated {
+		must(n.store.rewrite(nil, n.log[1:]))
+	} else {
+		must(n.store.append(appended))
+	}
+
+	if len(appended) > 0 {
+		n.config()
+	}
+
+	if last := req.PrevIndex + uint64(len(req.Entries)); req.Commit > n.committed && last > n.committed {
+		n.committed = req.Commit
+		if last < n.committed {
+			n.committed = last
+		}
+
+		signal(n.commit)
+	}
+
+	return &appendResponse{Term: n.term, Success: true}
+}
+
+// apply applies committed entries to inner driver in log order until `n` is stopped.
+func (n *Node) apply() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-n.commit:
+		}
+
+		for {
+			// snapshot is not installed while entry is applied
+			n.applying.Lock()
+			n.mu.Lock()
+
+			if n.applied >= n.committed {
+				n.mu.Unlock()
+				n.applying.Unlock()
+
+				break
+			}
+
+			e := n.entry(n.applied + 1)
+			n.mu.Unlock()
+
+			res := n.execute(e.Command)
+
+			n.mu.Lock()
+			n.applied = e.Index
+
+			if w, ok := n.waiters[e.Index]; ok {
+				delete(n.waiters, e.Index)
+
+				if w.term != e.Term {
+					res = newResult(false, &ErrLeadershipLost{})
+				}
+
+				w.ch <- res
+			}
+
+			close(n.progress)
+			n.progress = make(chan struct{})
+
+			if n.applied-n.base().Index >= uint64(n.opts.Snapshot) {
+				n.compact()
+			}
+
+			n.mu.Unlock()
+			n.applying.Unlock()
+		}
+	}
+}
+
+// execute applies `cmd` to inner driver.
+// Value expired before it is applied (e.g. by lagging or joined node) deletes the key,
+// so the previous value is not kept. It must be called under `applying`.
+func (n *Node) execute(cmd *Command) *result {
+	ctx := context.Background()
+
+	switch cmd.Op {
+	case opSet:
+		if cmd.Expire != 0 && cmd.Expire <= millis(time.Now()) {
+			return n.expire(ctx, cmd)
+		}
+
+		if len(cmd.Tags) > 0 {
+			n.values[cmd.Key] = cmd
+			return newResult(true, fs.TaggerOf(n.driver).SetTagsContext(ctx, cmd.Key, cmd.Val, cmd.ttl(), cmd.Tags))
+		}
+
+		ok, err := fs.CondSetterOf(n.driver).SetCondContext(ctx, cmd.Key, cmd.Val, cmd.options())
+		if ok {
+			// snapshot keeps the value, not the condition
+			value := *cmd
+			value.Mode = ""
+			n.values[cmd.Key] = &value
+		}
+
+		return newResult(ok, err)
+	case opDel:
+		delete(n.values, cmd.Key)
+		ok, err := n.driver.DeleteContext(ctx, cmd.Key)
+
+		return newResult(ok, err)
+	case opDelTag, opDelPattern:
+		return n.deleteSelected(ctx, cmd)
+	case opAcquire, opRenew, opRelease:
+		return n.lock(cmd)
+	default:
+		return newResult(true, nil)
+	}
+}
+
+// expire applies "set" command `cmd` expired before it is applied: it deletes the key
+// if the condition of `cmd` is met. It must be called under `applying`.
+func (n *Node) expire(ctx context.Context, cmd *Command) *result {
+	if cmd.Mode != "" {
+		val, err := n.driver.GetContext(ctx, cmd.Key)
+		if err != nil {
+			return newResult(false, err)
+		}
+
+		if (val == "") != (cmd.Mode == fs.ModeNX) {
+			return newResult(false, nil)
+		}
+	}
+
+	delete(n.values, cmd.Key)
+	_, err := n.driver.DeleteContext(ctx, cmd.Key)
+
+	return newResult(true, err)
+}
+
+// deleteSelected deletes keys of applied values selected by tag or pattern of `cmd`
+// and returns the number of deleted ones. It must be called under `applying`.
+func (n *Node) deleteSelected(ctx context.Context, cmd *Command) *result {
+	deleted := 0
+
+	for key, value := range n.values {
+		if !value.selected(cmd) {
+			continue
+		}
+
+		delete(n.values, key)
+
+		ok, err := n.driver.DeleteContext(ctx, key)
+		if err != nil {
+			res := newResult(true, err)
+			res.N = deleted
+
+			return res
+		}
+
+		if ok {
+			deleted++
+		}
+	}
+
+	res := newResult(true, nil)
+	res.N = deleted
+
+	return res
+}
+
+// lock changes lease of lock by `cmd` at the time stamped by the leader, so all nodes agree on it.
+// Leases are replaced instead of being changed, so snapshot may keep them. It must be called under `applying`.
+func (n *Node) lock(cmd *Command) *result {
+	now := time.Unix(0, cmd.Time*int64(time.Millisecond))
+
+	lease, ok := n.leases[cmd.Key]
+	if ok && !lease.Expire.After(now) {
+		delete(n.leases, cmd.Key)
+		lease, ok = nil, false
+	}
+
+	switch {
+	case cmd.Op == opAcquire && ok && lease.Owner != cmd.Owner:
+		return newResult(false, nil)
+	case cmd.Op == opAcquire && !ok:
+		n.fences[cmd.Key]++
+		lease = &fs.Lease{Name: cmd.Key, Owner: cmd.Owner, Token: n.fences[cmd.Key]}
+	case !ok || lease.Owner != cmd.Owner:
+		return newResult(false, nil)
+	case cmd.Op == opRelease:
+		delete(n.leases, cmd.Key)
+		return newResult(true, nil)
+	}
+
+	granted := *lease
+	granted.Expire = now.Add(time.Duration(cmd.TTL) * time.Millisecond)
+	n.leases[cmd.Key] = &granted
+
+	returned := granted
+	res := newResult(true, nil)
+	res.Lease = &returned
+
+	return res
+}
+
+// propose commits `cmd` to log and returns the result of it's applying until `ctx` is done.
+// Follower forwards `cmd` to the leader.
+func (n *Node) propose(ctx context.Context, cmd *Command) (*result, error) {
+	if err := n.check(ctx); err != nil {
+		return newResult(false, err), err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout*time.Millisecond)
+	defer cancel()
+
+	n.mu.Lock()
+
+	if n.state != StateLeader {
+		addr := n.leaderAddr
+		n.mu.Unlock()
+
+		if addr == "" {
+			return newResult(false, &ErrNoLeader{}), &ErrNoLeader{}
+		}
+
+		var res result
+
+		if err := n.call(ctx, addr, routePropose, cmd, &res); err != nil {
+			return newResult(false, err), err
+		}
+
+		return &res, res.error()
+	}
+
+	w, err := n.lead(cmd)
+	n.mu.Unlock()
+
+	if err != nil {
+		return newResult(false, err), err
+	}
+
+	select {
+	case res := <-w.ch:
+		return res, res.error()
+	case <-ctx.Done():
+		return newResult(false, ctx.Err()), ctx.Err()
+	case <-n.ctx.Done():
+		return newResult(false, &ErrClosed{}), &ErrClosed{}
+	}
+}
+
+// check returns error if `ctx` is done or `n` is closed.
+func (n *Node) check(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if n.ctx.Err() != nil {
+		return &ErrClosed{}
+	}
+
+	return nil
+}
+
+// lead appends `cmd` of leader to log and returns waiter of it's result, it must be called under `mu`.
+// Membership changes are converted to "config" command.
+func (n *Node) lead(cmd *Command) (*waiter, error) {
+	switch cmd.Op {
+	case opAdd, opRemove:
+		if cmd.Member == nil || cmd.Member.ID == "" || cmd.Op == opAdd && cmd.Member.Addr == "" {
+			return nil, &ErrInvalidMember{}
+		}
+
+		if n.pendingConfig() {
+			return nil, &ErrConfigChange{}
+		}
+
+		exists := n.member(cmd.Member.ID) != nil
+
+		switch {
+		case cmd.Op == opAdd && exists:
+			return nil, &ErrMember{cmd.Member.ID, "already exists"}
+		case cmd.Op == opRemove && !exists:
+			return nil, &ErrMember{cmd.Member.ID, "not exist"}
+		}
+
+		members := make([]*Member, 0, len(n.members)+1)
+
+		for _, m := range n.members {
+			if m.ID != cmd.Member.ID {
+				members = append(members, m)
+			}
+		}
+
+		if cmd.Op == opAdd {
+			members = append(members, cmd.Member)
+		}
+
+		cmd = &Command{Op: opConfig, Members: members}
+	case opAcquire, opRenew, opRelease:
+		// leases are expired by the leader clock only
+		cmd.Time = millis(time.Now())
+	case opSet, opDel, opDelTag, opDelPattern:
+	default:
+		return nil, fmt.Errorf("unknown operation (%s)", cmd.Op)
+	}
+
+	w := &waiter{term: n.term, ch: make(chan *result, 1)}
+	e := n.append(cmd)
+	n.waiters[e.Index] = w
+
+	return w, nil
+}
+
+// readIndex returns commit index which must be applied before read, so read is linearizable.
+// Leader confirms it is still the leader by heartbeat to majority, follower asks the leader.
+func (n *Node) readIndex(ctx context.Context) (uint64, error) {
+	n.mu.Lock()
+
+	if n.state != StateLeader {
+		addr := n.leaderAddr
+		n.mu.Unlock()
+
+		if addr == "" {
+			return 0, &ErrNoLeader{}
+		}
+
+		var resp readIndexResponse
+
+		if err := n.call(ctx, addr, routeReadIndex, struct{}{}, &resp); err != nil {
+			return 0, err
+		}
+
+		return resp.Index, resp.error()
+	}
+
+	// leader knows the commit index after it's first entry is committed
+	for n.entry(n.committed).Term != n.term {
+		progress := n.progress
+		n.mu.Unlock()
+
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+
+		n.mu.Lock()
+
+		if n.state != StateLeader {
+			n.mu.Unlock()
+			return 0, &ErrNoLeader{}
+		}
+	}
+
+	index, peers, quorum := n.committed, n.peers(), n.quorum()
+
+	confirmed := 0
+	if n.member(n.opts.ID) != nil {
+		confirmed++
+	}
+
+	n.mu.Unlock()
+
+	acks := make(chan bool, len(peers))
+
+	for _, p := range peers {
+		go func(p *Member) { acks <- n.replicate(ctx, p) }(p)
+	}
+
+	for range peers {
+		if confirmed >= quorum {
+			break
+		}
+
+		if <-acks {
+			confirmed++
+		}
+	}
+
+	if confirmed < quorum {
+		return 0, &ErrNoLeader{}
+	}
+
+	return index, nil
+}
+
+// wait waits until entry `index` is applied or `ctx` is done.
+func (n *Node) wait(ctx context.Context, index uint64) error {
+	for {
+		n.mu.Lock()
+		applied, progress := n.applied, n.progress
+		n.mu.Unlock()
+
+		if applied >= index {
+			return nil
+		}
+
+		select {
+		case <-progress:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.ctx.Done():
+			return &ErrClosed{}
+		}
+	}
+}
@@ -0,0 +1,622 @@
+// Package raft provides `fs.Driver` decorator replicating mutations of APICache nodes through Raft log,
+// so the cluster is strongly consistent: writes are forwarded to the leader and reads are linearizable.
+package raft
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultHeartbeat = 50
+	defaultElection  = 500
+	defaultTimeout   = 5000
+	defaultSnapshot  = 10000
+	// maxBatch is the maximal number of entries sent to follower at once.
+	maxBatch = 100
+)
+
+// States of `Node`.
+const (
+	StateFollower  = "follower"
+	StateCandidate = "candidate"
+	StateLeader    = "leader"
+)
+
+// Operations of log commands, membership changes are proposed as "add" and "remove"
+// and replicated as "config" with the whole new membership.
+const (
+	opNoop   = "noop"
+	opSet    = "set"
+	opDel    = "del"
+	opConfig = "config"
+	opAdd    = "add"
+	opRemove = "remove"
+
+	// opDelTag and opDelPattern delete keys selected by tag and pattern passed as `Command.Key`.
+	opDelTag     = "deltag"
+	opDelPattern = "delpattern"
+
+	// opAcquire, opRenew and opRelease change lease of lock passed as `Command.Key`.
+	opAcquire = "acquire"
+	opRenew   = "renew"
+	opRelease = "release"
+)
+
+type (
+	// Options contains `Node` specific parameters.
+	// Node is identified by unique `ID`, `Members` is the initial cluster (including the node itself),
+	// the node started without them joins existing cluster when it is added by `Node.AddMember()`.
+	// Leader sends heartbeats every `Heartbeat` milliseconds, follower starts election
+	// if it doesn't hear from leader for random timeout in [`Election`, 2 * `Election`) milliseconds.
+	// Proposals and requests to other nodes are timed out after `Timeout` milliseconds.
+	// Log is compacted every `Snapshot` applied entries: they are replaced by snapshot of values,
+	// which is sent to the node lagging behind them (or joined later).
+	// Term, vote, log and snapshot are persisted in `Dir`, so the node is restarted with the same `ID`.
+	Options struct {
+		ID        string        `json:"id"`
+		Dir       string        `json:"dir"`
+		Members   []*Member     `json:"members"`
+		Heartbeat time.Duration `json:"heartbeat"`
+		Election  time.Duration `json:"election"`
+		Timeout   time.Duration `json:"timeout"`
+		Snapshot  int           `json:"snapshot"`
+	}
+	// Member is the node of cluster with base URL `Addr` (like "http://10.0.0.1:8080").
+	Member struct {
+		ID   string `json:"id"`
+		Addr string `json:"addr"`
+	}
+	// Status contains the state of `Node`, `Snapshot` is the index of the last entry of snapshot.
+	Status struct {
+		ID       string    `json:"id"`
+		State    string    `json:"state"`
+		Term     uint64    `json:"term"`
+		Leader   string    `json:"leader"`
+		Commit   uint64    `json:"commit"`
+		Applied  uint64    `json:"applied"`
+		Snapshot uint64    `json:"snapshot"`
+		Members  []*Member `json:"members"`
+	}
+	// Command is the mutation of key-value storage, locks or membership.
+	// `Expire` is expiration time of value (unix milliseconds, zero if never), so all nodes agree on it,
+	// `Mode` is the condition of set (see `fs.SetOptions`).
+	// Lock of `Owner` is changed at `Time` (unix milliseconds stamped by the leader) for `TTL` milliseconds.
+	Command struct {
+		Op      string    `json:"op"`
+		Key     string    `json:"key,omitempty"`
+		Val     string    `json:"val,omitempty"`
+		Expire  int64     `json:"expire,omitempty"`
+		Tags    []string  `json:"tags,omitempty"`
+		Mode    string    `json:"mode,omitempty"`
+		Owner   string    `json:"owner,omitempty"`
+		Time    int64     `json:"time,omitempty"`
+		TTL     int64     `json:"ttl,omitempty"`
+		Member  *Member   `json:"member,omitempty"`
+		Members []*Member `json:"members,omitempty"`
+	}
+	// Entry is the command of log proposed at `Term`.
+	Entry struct {
+		Term    uint64   `json:"term"`
+		Index   uint64   `json:"index"`
+		Command *Command `json:"command"`
+	}
+	// Node implements Driver interface.
+	// Mutations are committed to Raft log by majority of members and then applied to inner driver
+	// of every node in the log order. Reads wait until the node applies all mutations committed
+	// before they are started (confirmed by the leader), so they see all acknowledged writes.
+	// State is persisted before it is acted on, so restarted node keeps it's votes and acknowledged entries.
+	// Values are kept in memory to be snapshotted, locks are granted by the state machine itself.
+	// `Node` is `http.Handler` of the nodes protocol under `Prefix`.
+	Node struct {
+		driver fs.ContextDriver
+		opts   *Options
+		client *http.Client
+		ctx    context.Context
+		stop   context.CancelFunc
+		wg     sync.WaitGroup
+		once   sync.Once
+		kick   chan struct{}
+		commit chan struct{}
+		// applying serializes applying of entries and loading of snapshot,
+		// `values` are the applied "set" commands of live keys,
+		// `leases` and `fences` are the granted leases and the last fencing tokens of locks.
+		applying sync.Mutex
+		values   map[string]*Command
+		leases   map[string]*fs.Lease
+		fences   map[string]int64
+
+		mu         sync.Mutex
+		state      string
+		term       uint64
+		votedFor   string
+		leader     string
+		leaderAddr string
+		heard      time.Time
+		timeout    time.Duration
+		store      *store
+		log        []*Entry
+		snapshot   *snapshot
+		committed  uint64
+		applied    uint64
+		members    []*Member
+		next       map[string]uint64
+		match      map[string]uint64
+		inflight   map[string]bool
+		waiters    map[uint64]*waiter
+		progress   chan struct{}
+		rand       *rand.Rand
+	}
+	// waiter waits for result of entry proposed at `term`.
+	waiter struct {
+		term uint64
+		ch   chan *result
+	}
+	// result is the result of applied command, `Kind` identifies typed error (and `ID` is it's member).
+	// `N` is the number of keys deleted by tag or pattern, `Lease` is the lease granted or renewed.
+	result struct {
+		OK    bool      `json:"ok"`
+		N     int       `json:"n,omitempty"`
+		Lease *fs.Lease `json:"lease,omitempty"`
+		Err   string    `json:"err,omitempty"`
+		Kind  string    `json:"kind,omitempty"`
+		ID    string    `json:"id,omitempty"`
+		// Reason is the reason of `ErrMember`.
+		Reason string `json:"reason,omitempty"`
+	}
+	// ErrNoLeader occurred if the cluster has no leader (e.g. election is in progress).
+	ErrNoLeader struct{}
+	// ErrLeadershipLost occurred if proposed command is overwritten by the new leader.
+	ErrLeadershipLost struct{}
+	// ErrConfigChange occurred if membership is changed while the previous change is not committed.
+	ErrConfigChange struct{}
+	// ErrMember occurred if membership change conflicts with the current membership.
+	ErrMember struct {
+		id     string
+		reason string
+	}
+	// ErrInvalidMember occurred if added member has empty ID or address.
+	ErrInvalidMember struct{}
+	// ErrClosed occurred if `Node` is used after `Close()`.
+	ErrClosed struct{}
+)
+
+func (e *ErrNoLeader) Error() string {
+	return "cluster has no leader"
+}
+
+func (e *ErrLeadershipLost) Error() string {
+	return "leadership is lost before commit"
+}
+
+func (e *ErrConfigChange) Error() string {
+	return "membership change is in progress"
+}
+
+func (e *ErrMember) Error() string {
+	return fmt.Sprintf("member (%s) %s", e.id, e.reason)
+}
+
+func (e *ErrInvalidMember) Error() string {
+	return "empty member ID or address"
+}
+
+func (e *ErrClosed) Error() string {
+	return "node is closed"
+}
+
+// Kinds of typed errors passed between nodes.
+const (
+	kindNoLeader = "noleader"
+	kindLost     = "lost"
+	kindConfig   = "config"
+	kindMember   = "member"
+	kindInvalid  = "invalid"
+)
+
+// newResult returns `result` of `err`.
+func newResult(ok bool, err error) *result {
+	r := &result{OK: ok}
+
+	if err == nil {
+		return r
+	}
+
+	r.Err = err.Error()
+
+	switch e := err.(type) {
+	case *ErrNoLeader:
+		r.Kind = kindNoLeader
+	case *ErrLeadershipLost:
+		r.Kind = kindLost
+	case *ErrConfigChange:
+		r.Kind = kindConfig
+	case *ErrMember:
+		r.Kind, r.ID, r.Reason = kindMember, e.id, e.reason
+	case *ErrInvalidMember:
+		r.Kind = kindInvalid
+	}
+
+	return r
+}
+
+// error returns typed error of `r` or `nil`.
+func (r *result) error() error {
+	switch {
+	case r.Err == "":
+		return nil
+	case r.Kind == kindNoLeader:
+		return &ErrNoLeader{}
+	case r.Kind == kindLost:
+		return &ErrLeadershipLost{}
+	case r.Kind == kindConfig:
+		return &ErrConfigChange{}
+	case r.Kind == kindMember:
+		return &ErrMember{r.ID, r.Reason}
+	case r.Kind == kindInvalid:
+		return &ErrInvalidMember{}
+	default:
+		return fmt.Errorf("%s", r.Err)
+	}
+}
+
+// ttl returns "time-to-live" of `c` value in seconds left now, zero if it never expires.
+func (c *Command) ttl() int {
+	if c.Expire == 0 {
+		return 0
+	}
+
+	return int((c.Expire - millis(time.Now()) + 999) / 1000)
+}
+
+// options returns options of `c` value set to inner driver, expiration time keeps milliseconds precision.
+func (c *Command) options() *fs.SetOptions {
+	opts := &fs.SetOptions{Mode: c.Mode, NoExpire: c.Expire == 0}
+	if c.Expire != 0 {
+		opts.ExpireAt = time.Unix(0, c.Expire*int64(time.Millisecond))
+	}
+
+	return opts
+}
+
+// selected checks value of "set" command `c` is selected by tag or pattern of `cmd`.
+func (c *Command) selected(cmd *Command) bool {
+	if cmd.Op == opDelPattern {
+		return fs.Match(cmd.Key, c.Key)
+	}
+
+	for _, tag := range c.Tags {
+		if tag == cmd.Key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// millis returns `t` in unix milliseconds.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// Get gets key from key-value storage.
+func (n *Node) Get(key string) (string, error) {
+	return n.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from local key-value storage after all mutations committed before the call
+// are applied, until `ctx` is done.
+func (n *Node) GetContext(ctx context.Context, key string) (string, error) {
+	if err := n.check(ctx); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout*time.Millisecond)
+	defer cancel()
+
+	index, err := n.readIndex(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if err := n.wait(ctx, index); err != nil {
+		return "", err
+	}
+
+	return n.driver.GetContext(ctx, key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (n *Node) Set(key, val string, ttl int) error {
+	return n.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext commits key, value and "time-to-live" to the cluster until `ctx` is done.
+// Key with negative `ttl` is deleted.
+func (n *Node) SetContext(ctx context.Context, key, val string, ttl int) error {
+	cmd := &Command{Op: opSet, Key: key, Val: val}
+
+	switch {
+	case ttl < 0:
+		cmd = &Command{Op: opDel, Key: key}
+	case ttl > 0:
+		cmd.Expire = millis(time.Now().Add(time.Duration(ttl) * time.Second))
+	}
+
+	_, err := n.propose(ctx, cmd)
+
+	return err
+}
+
+// Delete deletes key from key-value storage.
+func (n *Node) Delete(key string) (bool, error) {
+	return n.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext commits deletion of key to the cluster until `ctx` is done.
+func (n *Node) DeleteContext(ctx context.Context, key string) (bool, error) {
+	res, err := n.propose(ctx, &Command{Op: opDel, Key: key})
+
+	return res.OK, err
+}
+
+// SetCondContext commits key and value by `opts` to the cluster until `ctx` is done.
+// Condition is checked by every node against the applied values, expiration keeps milliseconds precision.
+// Conditional modes return `fs.ErrNotSupported` if inner driver is not `fs.CondSetter`.
+func (n *Node) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if _, ok := n.driver.(fs.CondSetter); !ok && opts.Mode != "" {
+		return fs.CondSetterOf(n.driver).SetCondContext(ctx, key, val, opts)
+	}
+
+	cmd := &Command{Op: opSet, Key: key, Val: val, Mode: opts.Mode}
+	if expire := opts.Expire(time.Now()); !expire.IsZero() {
+		cmd.Expire = millis(expire)
+	}
+
+	res, err := n.propose(ctx, cmd)
+
+	return res.OK, err
+}
+
+// SetTagsContext commits key, value and "time-to-live" with `tags` to the cluster until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (n *Node) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if _, ok := n.driver.(fs.Tagger); !ok {
+		return fs.TaggerOf(n.driver).SetTagsContext(ctx, key, val, ttl, tags)
+	}
+
+	if ttl < 0 {
+		return n.SetContext(ctx, key, val, ttl)
+	}
+
+	cmd := &Command{Op: opSet, Key: key, Val: val, Tags: tags}
+	if ttl > 0 {
+		cmd.Expire = millis(time.Now().Add(time.Duration(ttl) * time.Second))
+	}
+
+	_, err := n.propose(ctx, cmd)
+
+	return err
+}
+
+// DeleteTagContext commits deletion of all keys with `tag` to the cluster until `ctx` is done.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (n *Node) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	if _, ok := n.driver.(fs.Tagger); !ok {
+		return fs.TaggerOf(n.driver).DeleteTagContext(ctx, tag)
+	}
+
+	res, err := n.propose(ctx, &Command{Op: opDelTag, Key: tag})
+
+	return res.N, err
+}
+
+// DeletePatternContext commits deletion of all keys matching `pattern` to the cluster until `ctx` is done.
+// Keys are selected by applied values, so inner driver doesn't have to be `fs.Matcher`.
+func (n *Node) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	res, err := n.propose(ctx, &Command{Op: opDelPattern, Key: pattern})
+
+	return res.N, err
+}
+
+// AcquireContext commits grant of lock `name` to `owner` for `ttl` to the cluster until `ctx` is done.
+// Leases are granted by applied commands, so inner driver doesn't have to be `fs.Locker`.
+func (n *Node) AcquireContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	res, err := n.propose(ctx, &Command{Op: opAcquire, Key: name, Owner: owner, TTL: int64(ttl / time.Millisecond)})
+
+	return res.Lease, res.OK, err
+}
+
+// RenewContext commits extension of lock `name` held by `owner` for `ttl` to the cluster until `ctx` is done.
+func (n *Node) RenewContext(ctx context.Context, name, owner string, ttl time.Duration) (*fs.Lease, bool, error) {
+	res, err := n.propose(ctx, &Command{Op: opRenew, Key: name, Owner: owner, TTL: int64(ttl / time.Millisecond)})
+
+	return res.Lease, res.OK, err
+}
+
+// ReleaseContext commits release of lock `name` held by `owner` to the cluster until `ctx` is done.
+func (n *Node) ReleaseContext(ctx context.Context, name, owner string) (bool, error) {
+	res, err := n.propose(ctx, &Command{Op: opRelease, Key: name, Owner: owner})
+
+	return res.OK, err
+}
+
+// AddMember adds `m` to the cluster until `ctx` is done.
+// Members are changed one by one, so it returns `ErrConfigChange` if the previous change is not committed.
+func (n *Node) AddMember(ctx context.Context, m *Member) error {
+	if m == nil || m.ID == "" || m.Addr == "" {
+		return &ErrInvalidMember{}
+	}
+
+	_, err := n.propose(ctx, &Command{Op: opAdd, Member: &Member{ID: m.ID, Addr: strings.TrimSuffix(m.Addr, "/")}})
+
+	return err
+}
+
+// RemoveMember removes member `id` from the cluster until `ctx` is done, the leader may remove itself.
+// Members are changed one by one, so it returns `ErrConfigChange` if the previous change is not committed.
+func (n *Node) RemoveMember(ctx context.Context, id string) error {
+	_, err := n.propose(ctx, &Command{Op: opRemove, Member: &Member{ID: id}})
+	return err
+}
+
+// Status returns the current state of `n`.
+func (n *Node) Status() *Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return &Status{
+		ID:       n.opts.ID,
+		State:    n.state,
+		Term:     n.term,
+		Leader:   n.leader,
+		Commit:   n.committed,
+		Applied:  n.applied,
+		Snapshot: n.base().Index,
+		Members:  append([]*Member{}, n.members...),
+	}
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (n *Node) Unwrap() fs.Driver {
+	return n.driver
+}
+
+// Close stops `n` and calls to release key-value storage resources.
+func (n *Node) Close() {
+	n.once.Do(func() {
+		n.stop()
+		n.wg.Wait()
+
+		// requests handled concurrently don't reply by state which is not persisted
+		n.mu.Lock()
+		_ = n.store.close()
+		n.mu.Unlock()
+
+		n.driver.Close()
+	})
+}
+
+func init() {
+	fs.RegisterDecorator("raft", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with Raft replication and starts it with state persisted in `opts.Dir`.
+// Panics if `opts` are invalid or the state can't be recovered.
+func New(driver fs.Driver, opts *Options) *Node {
+	n := newNode(driver, opts)
+	n.start()
+
+	return n
+}
+
+// newNode returns not started `Node`.
+func newNode(driver fs.Driver, opts *Options) *Node {
+	if opts == nil || opts.ID == "" {
+		log.Panicf("empty ID")
+	}
+
+	for _, p := range []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"Heartbeat", &opts.Heartbeat, defaultHeartbeat},
+		{"Election", &opts.Election, defaultElection},
+		{"Timeout", &opts.Timeout, defaultTimeout},
+	} {
+		if *p.value == 0 {
+			*p.value = p.def
+		}
+
+		if *p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+
+	if opts.Snapshot == 0 {
+		opts.Snapshot = defaultSnapshot
+	}
+
+	if opts.Snapshot < 0 {
+		log.Panicf("negative Snapshot")
+	}
+
+	if opts.Election <= opts.Heartbeat {
+		log.Panicf("Election is not greater than Heartbeat")
+	}
+
+	seen := make(map[string]bool)
+
+	for _, m := range opts.Members {
+		if m == nil || m.ID == "" || m.Addr == "" {
+			log.Panicf("empty Members ID or address")
+		}
+
+		if seen[m.ID] {
+			log.Panicf("duplicated Members ID (%s)", m.ID)
+		}
+
+		seen[m.ID] = true
+		m.Addr = strings.TrimSuffix(m.Addr, "/")
+	}
+
+	if len(opts.Members) > 0 && !seen[opts.ID] {
+		log.Panicf("ID (%s) is not in Members", opts.ID)
+	}
+
+	if opts.Dir == "" {
+		log.Panicf("empty Dir")
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	n := &Node{
+		driver:   fs.WithContext(driver),
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout * time.Millisecond},
+		ctx:      ctx,
+		stop:     stop,
+		kick:     make(chan struct{}, 1),
+		commit:   make(chan struct{}, 1),
+		values:   make(map[string]*Command),
+		leases:   make(map[string]*fs.Lease),
+		fences:   make(map[string]int64),
+		state:    StateFollower,
+		heard:    time.Now(),
+		log:      []*Entry{{}},
+		members:  opts.Members,
+		next:     make(map[string]uint64),
+		match:    make(map[string]uint64),
+		inflight: make(map[string]bool),
+		waiters:  make(map[uint64]*waiter),
+		progress: make(chan struct{}),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	n.timeout = n.electionTimeout()
+
+	if err := n.recover(); err != nil {
+		log.Panicf("recover error (%v)", err)
+	}
+
+	return n
+}
+
+// start starts election timer and applying of committed entries.
+func (n *Node) start() {
+	n.wg.Add(2)
+
+	go n.run()
+	go n.apply()
+}
@@ -0,0 +1,828 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+const (
+	testHeartbeat = 10
+	testElection  = 100
+)
+
+// cluster is a group of nodes serving nodes protocol on loopback.
+// Node neither responds nor sends requests while it's `offline` flag is set.
+// Nodes take snapshot every `snapshot` applied entries (by default if zero).
+// Inner drivers are `memory.Driver` if `memory` is set, `drivertest.Fake` otherwise.
+// State of nodes is persisted in subdirectories of `dir` named by their IDs.
+type cluster struct {
+	mu       sync.Mutex
+	servers  []*httptest.Server
+	nodes    []*Node
+	offline  []int32
+	snapshot int
+	memory   bool
+	dir      string
+}
+
+// link is the transport of node which fails while the node is offline.
+type link struct {
+	cluster *cluster
+	from    int
+}
+
+func (l *link) RoundTrip(r *http.Request) (*http.Response, error) {
+	if !l.cluster.online(l.from) {
+		return nil, errors.New("offline")
+	}
+
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// tempDir returns a new temporary directory and it's cleanup function.
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "apicache-raft")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+
+	return dir, func() { _ = os.RemoveAll(dir) }
+}
+
+// newCluster returns `size` listening servers, nodes are started by `start()`.
+func newCluster(size int) *cluster {
+	dir, err := ioutil.TempDir("", "apicache-raft")
+	if err != nil {
+		panic(err)
+	}
+
+	c := &cluster{servers: make([]*httptest.Server, size), nodes: make([]*Node, size), offline: make([]int32, size), dir: dir}
+
+	for i := range c.servers {
+		i := i
+
+		c.servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !c.online(i) {
+				http.Error(w, "offline", http.StatusServiceUnavailable)
+				return
+			}
+
+			c.node(i).ServeHTTP(w, r)
+		}))
+	}
+
+	return c
+}
+
+// id returns ID of `i`-th node.
+func id(i int) string {
+	return string(rune('a' + i))
+}
+
+// member returns `i`-th node as member.
+func (c *cluster) member(i int) *Member {
+	return &Member{ID: id(i), Addr: "http://" + c.servers[i].Listener.Addr().String()}
+}
+
+// node returns `i`-th node, it may be replaced by `restart()` while requests are served.
+func (c *cluster) node(i int) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nodes[i]
+}
+
+// start starts `i`-th node with initial `members` (indexes of nodes).
+func (c *cluster) start(i int, members ...int) *Node {
+	n := c.newNode(i, members...)
+	c.servers[i].Start()
+	n.start()
+
+	return n
+}
+
+// restart closes `i`-th node and starts it again with the same ID and state directory,
+// inner `memory.Driver` is replaced by empty one.
+func (c *cluster) restart(i int, members ...int) *Node {
+	c.nodes[i].Close()
+
+	n := c.newNode(i, members...)
+	n.start()
+
+	return n
+}
+
+// newNode returns not started `i`-th node with initial `members` (indexes of nodes).
+func (c *cluster) newNode(i int, members ...int) *Node {
+	var ms []*Member
+	for _, j := range members {
+		ms = append(ms, c.member(j))
+	}
+
+	var driver fs.Driver = drivertest.NewFake()
+	if c.memory {
+		driver = memory.New(nil)
+	}
+
+	n := newNode(driver, &Options{
+		ID:        id(i),
+		Dir:       filepath.Join(c.dir, id(i)),
+		Members:   ms,
+		Heartbeat: testHeartbeat,
+		Election:  testElection,
+		Snapshot:  c.snapshot,
+	})
+	n.client.Transport = &link{cluster: c, from: i}
+
+	c.mu.Lock()
+	c.nodes[i] = n
+	c.mu.Unlock()
+
+	return n
+}
+
+func (c *cluster) close() {
+	for i, ts := range c.servers {
+		if c.nodes[i] != nil {
+			ts.Close()
+			c.nodes[i].Close()
+		}
+	}
+
+	_ = os.RemoveAll(c.dir)
+}
+
+// online checks `i`-th node is not offline.
+func (c *cluster) online(i int) bool {
+	return atomic.LoadInt32(&c.offline[i]) == 0
+}
+
+// leader waits until online nodes agree on the leader and returns it's index.
+func (c *cluster) leader(t *testing.T) int {
+	t.Helper()
+
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		leaders := make(map[string]int)
+
+		for i, n := range c.nodes {
+			if n != nil && c.online(i) {
+				leaders[n.Status().Leader]++
+			}
+		}
+
+		for i, n := range c.nodes {
+			if len(leaders) == 1 && leaders[id(i)] > 0 && c.online(i) && n.Status().State == StateLeader {
+				return i
+			}
+		}
+	}
+
+	t.Fatalf("leader is not elected")
+
+	return -1
+}
+
+// eventually checks `cond` becomes true in 3 seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+
+	t.Errorf("%s is not reached", what)
+}
+
+// check checks every node of `nodes` reads `want` for `key`.
+func check(t *testing.T, key, want string, nodes ...*Node) {
+	t.Helper()
+
+	for _, n := range nodes {
+		if val, err := n.Get(key); val != want || err != nil {
+			t.Errorf("Get() of node (%s) got = %q, %v, want = %q, %v", n.opts.ID, val, err, want, nil)
+		}
+	}
+}
+
+// checkLease checks lock `name` is held by `owner` with `token` on every node of `nodes`.
+func checkLease(t *testing.T, name, owner string, token int64, nodes ...*Node) {
+	t.Helper()
+
+	for _, n := range nodes {
+		n.applying.Lock()
+		lease := n.leases[name]
+		n.applying.Unlock()
+
+		if lease == nil || lease.Owner != owner || lease.Token != token {
+			t.Errorf("%s lease got = %v, want = %v (%d)", n.opts.ID, lease, owner, token)
+		}
+	}
+}
+
+func TestNodeDriver(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	var i int
+
+	drivertest.Run(t, func() fs.Driver {
+		i++
+		n := New(drivertest.NewFake(), &Options{
+			ID:        "a",
+			Dir:       filepath.Join(dir, strconv.Itoa(i)),
+			Members:   []*Member{{ID: "a", Addr: "http://127.0.0.1:0"}},
+			Heartbeat: testHeartbeat,
+			Election:  testElection,
+		})
+
+		for n.Status().State != StateLeader {
+			time.Sleep(time.Millisecond)
+		}
+
+		return n
+	})
+}
+
+func TestClusterElection(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	leader := c.nodes[c.leader(t)].Status()
+
+	eventually(t, "agreed leader", func() bool {
+		for _, n := range c.nodes {
+			if s := n.Status(); s.Leader != leader.ID || s.Term != leader.Term {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	for i, n := range c.nodes {
+		if s := n.Status(); s.ID != leader.ID && s.State != StateFollower {
+			t.Errorf("Status() of node (%s) got = %v, want = %v", id(i), s.State, StateFollower)
+		}
+	}
+}
+
+func TestClusterLinearizable(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	leader := c.leader(t)
+	follower := c.nodes[(leader+1)%3]
+
+	// writes to follower are forwarded to the leader
+	if err := follower.Set("key", "val", 10); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	// acknowledged write is visible on every node at once
+	check(t, "key", "val", c.nodes...)
+
+	if ok, err := c.nodes[leader].Delete("key"); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	check(t, "key", "", c.nodes...)
+
+	if ok, err := follower.Delete("key"); ok || err != nil {
+		t.Errorf("Delete() of missed got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	// committed expiration time is the same for every node
+	if err := follower.Set("expired", "val", 1); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	check(t, "expired", "", c.nodes...)
+}
+
+func TestClusterTags(t *testing.T) {
+	c := newCluster(3)
+	c.memory = true
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	ctx := context.Background()
+	leader := c.leader(t)
+	follower := c.nodes[(leader+1)%3]
+
+	if err := follower.SetTagsContext(ctx, "user:1:name", "alice", 10, []string{"user:1"}); err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	_ = follower.Set("user:1:mail", "alice@example.com", 10)
+	_ = follower.Set("user:2:name", "bob", 10)
+
+	check(t, "user:1:name", "alice", c.nodes...)
+
+	if n, err := follower.DeleteTagContext(ctx, "user:1"); n != 1 || err != nil {
+		t.Errorf("DeleteTagContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+
+	check(t, "user:1:name", "", c.nodes...)
+	check(t, "user:1:mail", "alice@example.com", c.nodes...)
+
+	if n, err := c.nodes[leader].DeletePatternContext(ctx, "user:*"); n != 2 || err != nil {
+		t.Errorf("DeletePatternContext() got = %v, %v, want = %v, %v", n, err, 2, nil)
+	}
+
+	check(t, "user:1:mail", "", c.nodes...)
+	check(t, "user:2:name", "", c.nodes...)
+}
+
+func TestClusterRestart(t *testing.T) {
+	cases := []struct {
+		name     string
+		snapshot int
+	}{
+		{name: "log"},
+		{name: "snapshot", snapshot: 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newCluster(3)
+			c.memory = true
+			c.snapshot = tc.snapshot
+			defer c.close()
+
+			for i := range c.nodes {
+				c.start(i, 0, 1, 2)
+			}
+
+			leader := c.leader(t)
+
+			for i := 0; i < 5; i++ {
+				if err := c.nodes[leader].Set("key"+strconv.Itoa(i), "val", 0); err != nil {
+					t.Fatalf("Set() error = %v, want = %v", err, nil)
+				}
+			}
+
+			if _, ok, err := c.nodes[leader].AcquireContext(context.Background(), "job", "a", time.Minute); !ok || err != nil {
+				t.Fatalf("AcquireContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+			}
+
+			term := c.nodes[leader].Status().Term
+
+			// the whole cluster is restarted with the same IDs and members, inner drivers are empty
+			for _, n := range c.nodes {
+				n.Close()
+			}
+
+			for i := range c.nodes {
+				c.restart(i, 0, 1, 2)
+			}
+
+			if s := c.nodes[0].Status(); tc.snapshot > 0 && s.Snapshot == 0 {
+				t.Errorf("Status() snapshot got = %v, want > %v", s.Snapshot, 0)
+			}
+
+			leader = c.leader(t)
+
+			if got := c.nodes[leader].Status().Term; got <= term {
+				t.Errorf("Status() term got = %v, want > %v", got, term)
+			}
+
+			for i := 0; i < 5; i++ {
+				check(t, "key"+strconv.Itoa(i), "val", c.nodes...)
+			}
+
+			checkLease(t, "job", "a", 1, c.nodes...)
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	s, _, _, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("openStore() error = %v, want = %v", err, nil)
+	}
+
+	entry := func(index uint64) *Entry {
+		return &Entry{Term: 2, Index: index, Command: &Command{Op: opSet, Key: "key" + strconv.Itoa(int(index))}}
+	}
+
+	must(s.saveState(2, "b"))
+	must(s.append([]*Entry{entry(1), entry(2), entry(3)}))
+	// compacted up to the second entry and the third one is replaced
+	must(s.rewrite(&snapshot{Index: 2, Term: 2}, nil))
+	must(s.append([]*Entry{entry(3), entry(4)}))
+	must(s.close())
+
+	// torn tail of crashed append
+	f, _ := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+	_, _ = f.WriteString(`{"term":2,"index":5,"comm`)
+	_ = f.Close()
+
+	s, snap, entries, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("openStore() error = %v, want = %v", err, nil)
+	}
+	defer func() { _ = s.close() }()
+
+	if s.state != (hardState{Term: 2, VotedFor: "b"}) {
+		t.Errorf("openStore() state got = %+v, want = %+v", s.state, hardState{Term: 2, VotedFor: "b"})
+	}
+
+	if snap == nil || snap.Index != 2 {
+		t.Errorf("openStore() snapshot got = %+v, want index = %v", snap, 2)
+	}
+
+	if len(entries) != 2 || entries[0].Index != 3 || entries[1].Index != 4 {
+		t.Errorf("openStore() entries got = %v, want indexes = %v", len(entries), []uint64{3, 4})
+	}
+
+	// appends after truncated tail are read
+	must(s.append([]*Entry{entry(5)}))
+
+	reopened, _, entries, _ := openStore(dir)
+	defer func() { _ = reopened.close() }()
+
+	if len(entries) != 3 {
+		t.Errorf("openStore() entries got = %v, want = %v", len(entries), 3)
+	}
+}
+
+func TestClusterCond(t *testing.T) {
+	c := newCluster(3)
+	c.memory = true
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	ctx := context.Background()
+	leader := c.leader(t)
+	follower := c.nodes[(leader+1)%3]
+	opts := &fs.SetOptions{Mode: fs.ModeNX, TTL: 200 * time.Millisecond}
+
+	if ok, err := follower.SetCondContext(ctx, "lock", "a", opts); !ok || err != nil {
+		t.Fatalf("SetCondContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if ok, err := c.nodes[leader].SetCondContext(ctx, "lock", "b", opts); ok || err != nil {
+		t.Errorf("SetCondContext() of existed got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	check(t, "lock", "a", c.nodes...)
+
+	// expiration keeps milliseconds precision
+	time.Sleep(300 * time.Millisecond)
+	check(t, "lock", "", c.nodes...)
+}
+
+func TestClusterLocks(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	ctx := context.Background()
+	leader := c.leader(t)
+	follower := c.nodes[(leader+1)%3]
+	other := c.nodes[(leader+2)%3]
+
+	// inner drivers are not `fs.Locker`, leases are granted by the cluster
+	lease, ok, err := follower.AcquireContext(ctx, "job", "a", 100*time.Millisecond)
+	if !ok || err != nil || lease.Token != 1 || lease.Owner != "a" {
+		t.Fatalf("AcquireContext() got = %v, %v, %v, want = token %v, %v, %v", lease, ok, err, 1, true, nil)
+	}
+
+	if _, ok, err := other.AcquireContext(ctx, "job", "b", time.Second); ok || err != nil {
+		t.Errorf("AcquireContext() of held got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	if _, ok, err := other.RenewContext(ctx, "job", "b", time.Second); ok || err != nil {
+		t.Errorf("RenewContext() of other got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	if lease, ok, err := c.nodes[leader].RenewContext(ctx, "job", "a", 100*time.Millisecond); !ok || err != nil || lease.Token != 1 {
+		t.Errorf("RenewContext() got = %v, %v, %v, want = token %v, %v, %v", lease, ok, err, 1, true, nil)
+	}
+
+	if ok, err := other.ReleaseContext(ctx, "job", "a"); !ok || err != nil {
+		t.Errorf("ReleaseContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if lease, ok, err := other.AcquireContext(ctx, "job", "b", 100*time.Millisecond); !ok || err != nil || lease.Token != 2 {
+		t.Errorf("AcquireContext() of released got = %v, %v, %v, want = token %v, %v, %v", lease, ok, err, 2, true, nil)
+	}
+
+	// fencing token is not reset by expiration
+	time.Sleep(200 * time.Millisecond)
+
+	if lease, ok, err := follower.AcquireContext(ctx, "job", "c", time.Second); !ok || err != nil || lease.Token != 3 {
+		t.Errorf("AcquireContext() of expired got = %v, %v, %v, want = token %v, %v, %v", lease, ok, err, 3, true, nil)
+	}
+}
+
+func TestNodeTagsNotSupported(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	n := newNode(drivertest.NewFake(), &Options{ID: "a", Dir: dir})
+
+	var ens *fs.ErrNotSupported
+
+	if err := n.SetTagsContext(context.Background(), "key", "val", 0, []string{"tag"}); !errors.As(err, &ens) {
+		t.Errorf("SetTagsContext() error = %v, want = %v", err, fs.NewErrNotSupported("tag"))
+	}
+
+	if _, err := n.DeleteTagContext(context.Background(), "tag"); !errors.As(err, &ens) {
+		t.Errorf("DeleteTagContext() error = %v, want = %v", err, fs.NewErrNotSupported("tag"))
+	}
+
+	opts := &fs.SetOptions{Mode: fs.ModeNX, NoExpire: true}
+	if _, err := n.SetCondContext(context.Background(), "key", "val", opts); !errors.As(err, &ens) {
+		t.Errorf("SetCondContext() error = %v, want = %v", err, fs.NewErrNotSupported("mode"))
+	}
+}
+
+func TestNodeExecuteExpired(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	fake := drivertest.NewFake()
+	n := newNode(fake, &Options{ID: "a", Dir: dir})
+
+	if err := fake.Set("key", "old", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	// the entry is expired before lagging node applies it, so the old value must not stay
+	res := n.execute(&Command{Op: opSet, Key: "key", Val: "new", Expire: millis(time.Now().Add(-time.Second))})
+	if !res.OK || res.error() != nil {
+		t.Errorf("execute() got = %v, %v, want = %v, %v", res.OK, res.error(), true, nil)
+	}
+
+	if val, err := fake.Get("key"); val != "" || err != nil {
+		t.Errorf("Get() got = %q, %v, want = %q, %v", val, err, "", nil)
+	}
+}
+
+func TestClusterFailover(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 0, 1, 2)
+	}
+
+	old := c.leader(t)
+
+	if err := c.nodes[old].Set("before", "val", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	// isolated leader can't reach majority, so it can't serve reads and writes
+	atomic.StoreInt32(&c.offline[old], 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if _, err := c.nodes[old].GetContext(ctx, "before"); err == nil {
+		t.Errorf("GetContext() of isolated leader error = %v, want error", err)
+	}
+
+	leader := c.leader(t)
+	if leader == old {
+		t.Fatalf("leader got = %v, want another one", id(leader))
+	}
+
+	if err := c.nodes[leader].Set("after", "val", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	check(t, "before", "val", c.nodes[leader], c.nodes[3-old-leader])
+
+	// the old leader steps down and catches up
+	atomic.StoreInt32(&c.offline[old], 0)
+
+	eventually(t, "stepped down", func() bool { return c.nodes[old].Status().State == StateFollower })
+	check(t, "after", "val", c.nodes...)
+}
+
+func TestClusterMembership(t *testing.T) {
+	c := newCluster(4)
+	defer c.close()
+
+	for i := 0; i < 3; i++ {
+		c.start(i, 0, 1, 2)
+	}
+
+	leader := c.leader(t)
+
+	if err := c.nodes[leader].Set("key", "val", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	// the new node joins without members and receives the whole log
+	joined := c.start(3)
+	follower := c.nodes[(leader+1)%3]
+
+	if err := follower.AddMember(context.Background(), c.member(3)); err != nil {
+		t.Fatalf("AddMember() error = %v, want = %v", err, nil)
+	}
+
+	check(t, "key", "val", joined)
+
+	if got := len(joined.Status().Members); got != 4 {
+		t.Errorf("Status() members got = %v, want = %v", got, 4)
+	}
+
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"duplicated", follower.AddMember(context.Background(), c.member(3)), &ErrMember{"d", "already exists"}},
+		{"missed", follower.RemoveMember(context.Background(), "z"), &ErrMember{"z", "not exist"}},
+		{"invalid", follower.AddMember(context.Background(), &Member{ID: "e"}), &ErrInvalidMember{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.err == nil || c.err.Error() != c.want.Error() {
+				t.Errorf("error got = %v, want = %v", c.err, c.want)
+			}
+		})
+	}
+
+	// the leader removes itself and the rest elect the new one
+	if err := c.nodes[leader].RemoveMember(context.Background(), id(leader)); err != nil {
+		t.Fatalf("RemoveMember() error = %v, want = %v", err, nil)
+	}
+
+	atomic.StoreInt32(&c.offline[leader], 1)
+
+	next := c.leader(t)
+	if next == leader {
+		t.Fatalf("leader got = %v, want another one", id(next))
+	}
+
+	if err := joined.Set("removed", "val", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	for i, n := range c.nodes {
+		if i != leader {
+			check(t, "removed", "val", n)
+		}
+	}
+
+	if got := len(c.nodes[next].Status().Members); got != 3 {
+		t.Errorf("Status() members got = %v, want = %v", got, 3)
+	}
+}
+
+func TestClusterSnapshot(t *testing.T) {
+	c := newCluster(4)
+	c.snapshot = 5
+	defer c.close()
+
+	for i := 0; i < 3; i++ {
+		c.start(i, 0, 1, 2)
+	}
+
+	leader := c.leader(t)
+	lagging := (leader + 1) % 3
+
+	if err := c.nodes[leader].Set("deleted", "val", 0); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	check(t, "deleted", "val", c.nodes[:3]...)
+
+	// entries made while the node is offline are compacted, so it receives snapshot
+	atomic.StoreInt32(&c.offline[lagging], 1)
+
+	if _, err := c.nodes[leader].Delete("deleted"); err != nil {
+		t.Fatalf("Delete() error = %v, want = %v", err, nil)
+	}
+
+	if _, ok, err := c.nodes[leader].AcquireContext(context.Background(), "job", "a", time.Minute); !ok || err != nil {
+		t.Fatalf("AcquireContext() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := c.nodes[leader].Set(fmt.Sprintf("key%d", i), "val", 0); err != nil {
+			t.Fatalf("Set() error = %v, want = %v", err, nil)
+		}
+	}
+
+	s := c.nodes[leader].Status()
+	if s.Snapshot == 0 || s.Applied-s.Snapshot >= 5 {
+		t.Errorf("Status() snapshot got = %v of applied %v, want = last 5 entries", s.Snapshot, s.Applied)
+	}
+
+	atomic.StoreInt32(&c.offline[lagging], 0)
+
+	eventually(t, "installed snapshot", func() bool { return c.nodes[lagging].Status().Snapshot >= s.Snapshot })
+	check(t, "deleted", "", c.nodes[lagging])
+	check(t, "key19", "val", c.nodes[lagging])
+
+	// the new member receives snapshot too
+	joined := c.start(3)
+
+	if err := c.nodes[leader].AddMember(context.Background(), c.member(3)); err != nil {
+		t.Fatalf("AddMember() error = %v, want = %v", err, nil)
+	}
+
+	check(t, "key0", "val", joined)
+	check(t, "deleted", "", joined)
+	checkLease(t, "job", "a", 1, c.nodes[lagging], joined)
+
+	if got := len(joined.Status().Members); got != 4 {
+		t.Errorf("Status() members got = %v, want = %v", got, 4)
+	}
+}
+
+func TestNodeNoLeader(t *testing.T) {
+	c := newCluster(2)
+	defer c.close()
+
+	// the second member is never started, so the first can't be elected
+	n := c.start(0, 0, 1)
+
+	time.Sleep(3 * testElection * time.Millisecond)
+
+	var enl *ErrNoLeader
+
+	if err := n.Set("key", "val", 0); !errors.As(err, &enl) {
+		t.Errorf("Set() error = %v, want = %v", err, &ErrNoLeader{})
+	}
+
+	if s := n.Status(); s.State != StateCandidate || s.Term == 0 {
+		t.Errorf("Status() got = %v (%d), want = %v (> 0)", s.State, s.Term, StateCandidate)
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	a := &Member{ID: "a", Addr: "http://a"}
+
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{nil, "empty ID"},
+		{&Options{}, "empty ID"},
+		{&Options{ID: "a", Heartbeat: -1}, "negative Heartbeat"},
+		{&Options{ID: "a", Election: -1}, "negative Election"},
+		{&Options{ID: "a", Timeout: -1}, "negative Timeout"},
+		{&Options{ID: "a", Snapshot: -1}, "negative Snapshot"},
+		{&Options{ID: "a", Heartbeat: 100, Election: 100}, "Election is not greater than Heartbeat"},
+		{&Options{ID: "a", Members: []*Member{{ID: "a"}}}, "empty Members ID or address"},
+		{&Options{ID: "a", Members: []*Member{a, a}}, "duplicated Members ID (a)"},
+		{&Options{ID: "b", Members: []*Member{a}}, "ID (b) is not in Members"},
+		{&Options{ID: "a"}, "empty Dir"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Prefix is the path prefix of nodes protocol routes.
+const Prefix = "/_raft/"
+
+// Routes of nodes protocol.
+const (
+	routeVote      = Prefix + "vote"
+	routeAppend    = Prefix + "append"
+	routePropose   = Prefix + "propose"
+	routeReadIndex = Prefix + "readindex"
+	routeSnapshot  = Prefix + "snapshot"
+)
+
+type (
+	// voteRequest is the request of candidate for vote.
+	voteRequest struct {
+		Term      uint64 `json:"term"`
+		Candidate string `json:"candidate"`
+		LastIndex uint64 `json:"last_index"`
+		LastTerm  uint64 `json:"last_term"`
+	}
+	// voteResponse is the response of vote route.
+	voteResponse struct {
+		Term    uint64 `json:"term"`
+		Granted bool   `json:"granted"`
+	}
+	// appendRequest is the request of leader to append entries (heartbeat if there are no ones).
+	appendRequest struct {
+		Term       uint64   `json:"term"`
+		Leader     string   `json:"leader"`
+		LeaderAddr string   `json:"leader_addr"`
+		PrevIndex  uint64   `json:"prev_index"`
+		PrevTerm   uint64   `json:"prev_term"`
+		Entries    []*Entry `json:"entries"`
+		Commit     uint64   `json:"commit"`
+	}
+	// appendResponse is the response of append route,
+	// `Conflict` is the index leader should continue from if entries are not appended.
+	appendResponse struct {
+		Term     uint64 `json:"term"`
+		Success  bool   `json:"success"`
+		Conflict uint64 `json:"conflict,omitempty"`
+	}
+	// snapshotRequest is the request of leader to install snapshot instead of compacted entries.
+	snapshotRequest struct {
+		Term       uint64    `json:"term"`
+		Leader     string    `json:"leader"`
+		LeaderAddr string    `json:"leader_addr"`
+		Snapshot   *snapshot `json:"snapshot"`
+	}
+	// snapshotResponse is the response of snapshot route.
+	snapshotResponse struct {
+		Term uint64 `json:"term"`
+	}
+	// readIndexResponse is the response of readindex route.
+	readIndexResponse struct {
+		Index uint64 `json:"index"`
+		result
+	}
+	// errorResponse is the response of failed request.
+	errorResponse struct {
+		Err string `json:"error"`
+	}
+	// ErrNode occurred if node responds with unexpected status.
+	ErrNode struct {
+		addr   string
+		status int
+		body   string
+	}
+)
+
+func (e *ErrNode) Error() string {
+	return fmt.Sprintf("node (%s) responded with status (%d): %s", e.addr, e.status, e.body)
+}
+
+// call sends POST request of `route` to node at `addr` with `in` as JSON body and decodes response to `out`.
+func (n *Node) call(ctx context.Context, addr, route string, in, out interface{}) error {
+	body, _ := json.Marshal(in)
+
+	req, err := http.NewRequest(http.MethodPost, addr+route, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := n.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &ErrNode{addr, resp.StatusCode, strings.TrimSpace(string(data))}
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// respond writes `v` as JSON response with `status`.
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("response write err = %v\n", err)
+	}
+}
+
+// ServeHTTP serves nodes protocol, all routes are POST:
+// "vote", "append" and "snapshot" are Raft RPCs, "propose" commits command through the leader
+// and "readindex" returns commit index confirmed by the leader.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case routeVote, routeAppend, routeSnapshot, routePropose, routeReadIndex:
+	default:
+		respond(w, http.StatusNotFound, &errorResponse{"not found"})
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		respond(w, http.StatusMethodNotAllowed, &errorResponse{"method not allowed"})
+		return
+	}
+
+	switch r.URL.Path {
+	case routeVote:
+		var req voteRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, &errorResponse{"invalid JSON"})
+			return
+		}
+
+		respond(w, http.StatusOK, n.vote(&req))
+	case routeAppend:
+		var req appendRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, &errorResponse{"invalid JSON"})
+			return
+		}
+
+		respond(w, http.StatusOK, n.appendEntries(&req))
+	case routeSnapshot:
+		var req snapshotRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(w, http.StatusBadRequest, &errorResponse{"invalid JSON"})
+			return
+		}
+
+		respond(w, http.StatusOK, n.restore(&req))
+	case routePropose:
+		var cmd Command
+
+		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+			respond(w, http.StatusBadRequest, &errorResponse{"invalid JSON"})
+			return
+		}
+
+		res, _ := n.propose(r.Context(), &cmd)
+		respond(w, http.StatusOK, res)
+	case routeReadIndex:
+		index, err := n.readIndex(r.Context())
+		respond(w, http.StatusOK, &readIndexResponse{index, *newResult(err == nil, err)})
+	}
+}
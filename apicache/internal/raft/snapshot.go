@@ -0,0 +1,185 @@
+package raft
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// snapshot is the state after entry `Index` of `Term` is applied: membership, values of keys
+// as "set" commands, so they are applied by the same way as log entries, and leases with fencing tokens of locks.
+type snapshot struct {
+	Index   uint64           `json:"index"`
+	Term    uint64           `json:"term"`
+	Members []*Member        `json:"members"`
+	Values  []*Command       `json:"values"`
+	Leases  []*fs.Lease      `json:"leases,omitempty"`
+	Fences  map[string]int64 `json:"fences,omitempty"`
+}
+
+// compact replaces applied entries of log by snapshot, it must be called under `mu` and `applying`.
+// Expired values are not kept, leases are kept until they are replaced, since they are expired by the leader clock.
+func (n *Node) compact() {
+	now := millis(time.Now())
+	snap := &snapshot{
+		Index:   n.applied,
+		Term:    n.entry(n.applied).Term,
+		Members: n.membersAt(n.applied),
+		Fences:  make(map[string]int64, len(n.fences)),
+	}
+
+	for _, lease := range n.leases {
+		snap.Leases = append(snap.Leases, lease)
+	}
+
+	for name, token := range n.fences {
+		snap.Fences[name] = token
+	}
+
+	for key, cmd := range n.values {
+		if cmd.Expire != 0 && cmd.Expire <= now {
+			delete(n.values, key)
+			continue
+		}
+
+		snap.Values = append(snap.Values, cmd)
+	}
+
+	n.log = append([]*Entry{{Term: snap.Term, Index: snap.Index}}, n.log[snap.Index-n.base().Index+1:]...)
+	n.snapshot = snap
+	must(n.store.rewrite(snap, n.log[1:]))
+}
+
+// load replaces values of inner driver and locks by ones of `snap`, it must be called under `applying`.
+func (n *Node) load(snap *snapshot) {
+	values := make(map[string]*Command, len(snap.Values))
+	for _, cmd := range snap.Values {
+		values[cmd.Key] = cmd
+	}
+
+	for key := range n.values {
+		if _, ok := values[key]; ok {
+			continue
+		}
+
+		delete(n.values, key)
+
+		if _, err := n.driver.DeleteContext(context.Background(), key); err != nil {
+			log.Printf("raft snapshot of key (%s) err = %v\n", key, err)
+		}
+	}
+
+	for _, cmd := range snap.Values {
+		if err := n.execute(cmd).error(); err != nil {
+			log.Printf("raft snapshot of key (%s) err = %v\n", cmd.Key, err)
+		}
+	}
+
+	n.leases = make(map[string]*fs.Lease, len(snap.Leases))
+	for _, lease := range snap.Leases {
+		n.leases[lease.Name] = lease
+	}
+
+	n.fences = make(map[string]int64, len(snap.Fences))
+	for name, token := range snap.Fences {
+		n.fences[name] = token
+	}
+}
+
+// install sends snapshot to `p` which next entries are compacted, it must be called under `mu` and releases it.
+// Returns whether `p` acknowledged `n` as leader.
+func (n *Node) install(ctx context.Context, p *Member) bool {
+	req := &snapshotRequest{Term: n.term, Leader: n.opts.ID, LeaderAddr: n.leaderAddr, Snapshot: n.snapshot}
+	n.mu.Unlock()
+
+	var resp snapshotResponse
+
+	if err := n.call(ctx, p.Addr, routeSnapshot, req, &resp); err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if resp.Term > n.term {
+		n.stepDown(resp.Term)
+		return false
+	}
+
+	if n.state != StateLeader || n.term != req.Term {
+		return false
+	}
+
+	if index := req.Snapshot.Index; index > n.match[p.ID] {
+		n.match[p.ID], n.next[p.ID] = index, index+1
+		n.advance()
+	}
+
+	if n.next[p.ID] <= n.last().Index {
+		signal(n.kick)
+	}
+
+	return true
+}
+
+// restore handles snapshot of leader: values of inner driver are replaced by it's ones
+// and log keeps only entries after it (if they don't conflict).
+func (n *Node) restore(req *snapshotRequest) *snapshotResponse {
+	// entries are not applied while snapshot is loaded, so reads wait for it
+	n.applying.Lock()
+	defer n.applying.Unlock()
+
+	n.mu.Lock()
+
+	if req.Term < n.term || n.store.closed {
+		defer n.mu.Unlock()
+		return &snapshotResponse{Term: n.term}
+	}
+
+	n.stepDown(req.Term)
+	n.leader, n.leaderAddr, n.heard = req.Leader, req.LeaderAddr, time.Now()
+
+	snap := req.Snapshot
+	if snap == nil || snap.Index <= n.applied {
+		defer n.mu.Unlock()
+		return &snapshotResponse{Term: n.term}
+	}
+
+	n.mu.Unlock()
+
+	n.load(snap)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if snap.Index <= n.last().Index && n.entry(snap.Index).Term == snap.Term {
+		n.log = append([]*Entry{{Term: snap.Term, Index: snap.Index}}, n.log[snap.Index-n.base().Index+1:]...)
+	} else {
+		n.log = []*Entry{{Term: snap.Term, Index: snap.Index}}
+	}
+
+	n.snapshot = snap
+	must(n.store.rewrite(snap, n.log[1:]))
+	n.config()
+
+	if snap.Index > n.committed {
+		n.committed = snap.Index
+	}
+
+	n.applied = snap.Index
+
+	// results of proposals made while `n` was leader are lost in snapshot
+	for index, w := range n.waiters {
+		if index <= snap.Index {
+			delete(n.waiters, index)
+			w.ch <- newResult(false, &ErrLeadershipLost{})
+		}
+	}
+
+	close(n.progress)
+	n.progress = make(chan struct{})
+
+	return &snapshotResponse{Term: n.term}
+}
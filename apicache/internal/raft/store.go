@@ -0,0 +1,294 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Files of `Options.Dir`.
+const (
+	stateFile    = "state.json"
+	snapshotFile = "snapshot.json"
+	logFile      = "log.json"
+)
+
+type (
+	// hardState is the state that `Node` must not forget on restart: the current term and the vote in it.
+	hardState struct {
+		Term     uint64 `json:"term"`
+		VotedFor string `json:"votedFor,omitempty"`
+	}
+	// store persists hard state, snapshot and entries after it (JSON lines) of `Node` in `dir`.
+	// Every change is synced to disk before `Node` acts on it, so vote is not granted twice in a term
+	// and acknowledged entries are not lost by restart. Closed store is not changed, since stopped node
+	// doesn't act on it anymore.
+	store struct {
+		dir    string
+		log    *os.File
+		state  hardState
+		closed bool
+	}
+)
+
+// openStore opens (or creates) store in `dir` and returns it with the persisted state.
+// Torn tail of log (e.g. after crash) is truncated, entries not following snapshot are dropped.
+func openStore(dir string) (*store, *snapshot, []*Entry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, nil, err
+	}
+
+	s := &store{dir: dir}
+
+	if err := readFile(filepath.Join(dir, stateFile), &s.state); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var snap *snapshot
+	if err := readFile(filepath.Join(dir, snapshotFile), &snap); err != nil {
+		return nil, nil, nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	entries, size, err := readEntries(f)
+	if err == nil {
+		err = f.Truncate(size)
+	}
+
+	if err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, nil, err
+	}
+
+	s.log = f
+
+	var next uint64 = 1
+	if snap != nil {
+		next = snap.Index + 1
+	}
+
+	// entries compacted to snapshot are dropped (they are left if crashed before log is rewritten)
+	for len(entries) > 0 && entries[0].Index < next {
+		entries = entries[1:]
+	}
+
+	for i, e := range entries {
+		if e.Index != next+uint64(i) {
+			entries = entries[:i]
+			break
+		}
+	}
+
+	return s, snap, entries, nil
+}
+
+// readFile decodes JSON file `name` to `v`, missed file is not decoded.
+func readFile(name string, v interface{}) error {
+	b, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+// readEntries reads entries of `f` and returns them with the size of complete ones.
+func readEntries(f *os.File) ([]*Entry, int64, error) {
+	var (
+		entries []*Entry
+		size    int64
+		rd      = bufio.NewReader(f)
+	)
+
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			return entries, size, nil
+		}
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		var e Entry
+		if json.Unmarshal(line, &e) != nil || e.Command == nil {
+			return entries, size, nil
+		}
+
+		entries = append(entries, &e)
+		size += int64(len(line))
+	}
+}
+
+// writeFile replaces file `name` of `dir` by JSON of `v` atomically.
+func writeFile(dir, name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return replace(dir, name, func(f *os.File) error {
+		_, err := f.Write(b)
+		return err
+	})
+}
+
+// replace replaces file `name` of `dir` by one written by `fn` atomically.
+func replace(dir, name string, fn func(f *os.File) error) error {
+	tmp, err := ioutil.TempFile(dir, name+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = fn(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+	_ = d.Close()
+
+	return err
+}
+
+// encode writes `entries` to `w` as JSON lines.
+func encode(w io.Writer, entries []*Entry) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// saveState persists `term` and `votedFor` if they are changed.
+func (s *store) saveState(term uint64, votedFor string) error {
+	state := hardState{Term: term, VotedFor: votedFor}
+	if s.closed || state == s.state {
+		return nil
+	}
+
+	if err := writeFile(s.dir, stateFile, &state); err != nil {
+		return err
+	}
+
+	s.state = state
+
+	return nil
+}
+
+// append appends `entries` to log.
+func (s *store) append(entries []*Entry) error {
+	if s.closed || len(entries) == 0 {
+		return nil
+	}
+
+	if err := encode(s.log, entries); err != nil {
+		return err
+	}
+
+	return s.log.Sync()
+}
+
+// rewrite replaces snapshot by `snap` (if it is not nil) and log by `entries`.
+// Snapshot is replaced first, so entries compacted to it are dropped on restart if log is not replaced.
+func (s *store) rewrite(snap *snapshot, entries []*Entry) error {
+	if s.closed {
+		return nil
+	}
+
+	if snap != nil {
+		if err := writeFile(s.dir, snapshotFile, snap); err != nil {
+			return err
+		}
+	}
+
+	err := replace(s.dir, logFile, func(f *os.File) error { return encode(f, entries) })
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, logFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_ = s.log.Close()
+	s.log = f
+
+	return nil
+}
+
+// close releases log file.
+func (s *store) close() error {
+	s.closed = true
+	return s.log.Close()
+}
+
+// must stops the process if state of `Node` is not persisted, since it can't go on without it.
+func must(err error) {
+	if err != nil {
+		log.Panicf("raft persist error (%v)", err)
+	}
+}
+
+// recover restores state of `n` persisted in `Dir`: snapshot is loaded to inner driver
+// and entries after it are applied again when they are committed.
+func (n *Node) recover() error {
+	s, snap, entries, err := openStore(n.opts.Dir)
+	if err != nil {
+		return err
+	}
+
+	n.store, n.term, n.votedFor = s, s.state.Term, s.state.VotedFor
+
+	if snap != nil {
+		n.load(snap)
+		n.snapshot = snap
+		n.log = []*Entry{{Term: snap.Term, Index: snap.Index}}
+		n.committed, n.applied = snap.Index, snap.Index
+	}
+
+	n.log = append(n.log, entries...)
+	n.config()
+
+	return nil
+}
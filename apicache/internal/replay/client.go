@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+type (
+	// Client implements `fs.ContextDriver` by calls of APICache HTTP API at `addr`,
+	// so recorded workload can be replayed against the running server.
+	Client struct {
+		addr   string
+		client *http.Client
+	}
+	// ErrStatus occurred if APICache responds with unexpected status.
+	ErrStatus struct {
+		status int
+		body   string
+	}
+	// body is APICache request and response.
+	body struct {
+		Key string `json:"key,omitempty"`
+		Val string `json:"val,omitempty"`
+		TTL int    `json:"ttl,omitempty"`
+		// Value is the value of GET response.
+		Value string `json:"value,omitempty"`
+	}
+)
+
+func (e *ErrStatus) Error() string {
+	return fmt.Sprintf("unexpected status (%d): %s", e.status, e.body)
+}
+
+// do sends `method` request of `path` with `in` as JSON body and returns response status and body.
+func (c *Client) do(ctx context.Context, method, path string, in *body) (int, []byte, error) {
+	var payload []byte
+
+	if in != nil {
+		payload, _ = json.Marshal(in)
+	}
+
+	req, err := http.NewRequest(method, c.addr+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	return resp.StatusCode, data, err
+}
+
+// Get gets key from key-value storage.
+func (c *Client) Get(key string) (string, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from APICache until `ctx` is done, missing key is not an error.
+func (c *Client) GetContext(ctx context.Context, key string) (string, error) {
+	status, data, err := c.do(ctx, http.MethodGet, "/"+key, nil)
+
+	switch {
+	case err != nil:
+		return "", err
+	case status == http.StatusNotFound:
+		return "", nil
+	case status != http.StatusOK:
+		return "", &ErrStatus{status, strings.TrimSpace(string(data))}
+	}
+
+	var out body
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", err
+	}
+
+	return out.Value, nil
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (c *Client) Set(key, val string, ttl int) error {
+	return c.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to APICache until `ctx` is done.
+func (c *Client) SetContext(ctx context.Context, key, val string, ttl int) error {
+	status, data, err := c.do(ctx, http.MethodPost, "/", &body{Key: key, Val: val, TTL: ttl})
+
+	switch {
+	case err != nil:
+		return err
+	case status != http.StatusCreated:
+		return &ErrStatus{status, strings.TrimSpace(string(data))}
+	}
+
+	return nil
+}
+
+// Delete deletes key from key-value storage.
+func (c *Client) Delete(key string) (bool, error) {
+	return c.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from APICache until `ctx` is done.
+func (c *Client) DeleteContext(ctx context.Context, key string) (bool, error) {
+	status, data, err := c.do(ctx, http.MethodDelete, "/"+key, nil)
+
+	switch {
+	case err != nil:
+		return false, err
+	case status == http.StatusNotFound:
+		return false, nil
+	case status != http.StatusNoContent:
+		return false, &ErrStatus{status, strings.TrimSpace(string(data))}
+	}
+
+	return true, nil
+}
+
+// Close closes idle connections to APICache.
+func (c *Client) Close() {
+	c.client.CloseIdleConnections()
+}
+
+// NewClient returns `Client` of APICache at `addr` (like "http://127.0.0.1:8080").
+// `http.DefaultClient` is used if `client` is nil.
+func NewClient(addr string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Client{addr: strings.TrimSuffix(addr, "/"), client: client}
+}
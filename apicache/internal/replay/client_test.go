@@ -0,0 +1,106 @@
+package replay
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kxnes/go-interviews/apicache/internal/apicache"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+// newServer returns APICache test server storing keys in `driver`.
+func newServer(driver fs.Driver) *httptest.Server {
+	srv := apicache.NewServer(
+		&apicache.Dependencies{Driver: fs.New(driver, &fs.Options{MaxConn: 10, Timeout: 10})},
+		&apicache.Options{},
+	)
+
+	return httptest.NewServer(srv.Handler)
+}
+
+func TestClient(t *testing.T) {
+	ts := newServer(drivertest.NewFake())
+	defer ts.Close()
+
+	c := NewClient(ts.URL+"/", nil)
+	defer c.Close()
+
+	if err := c.Set("key", "val", 10); err != nil {
+		t.Errorf("Set() error = %v, want = %v", err, nil)
+	}
+
+	if val, err := c.Get("key"); val != "val" || err != nil {
+		t.Errorf("Get() got = %v, %v, want = %v, %v", val, err, "val", nil)
+	}
+
+	if ok, err := c.Delete("key"); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	if ok, err := c.Delete("key"); ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, false, nil)
+	}
+
+	if val, err := c.Get("key"); val != "" || err != nil {
+		t.Errorf("Get() got = %v, %v, want = %v, %v", val, err, "", nil)
+	}
+
+	want := `unexpected status (400): {"error":"invalid ttl (0) for key (key)"}`
+	if err := c.Set("key", "val", 0); err == nil || err.Error() != want {
+		t.Errorf("Set() error = %v, want = %v", err, want)
+	}
+
+	if _, err := NewClient("http://127.0.0.1:0", nil).Get("key"); err == nil {
+		t.Errorf("Get() error = %v, want connection error", err)
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("temp dir error = %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file := filepath.Join(dir, "record.jsonl")
+
+	recorded := newServer(record.New(drivertest.NewFake(), &record.Options{File: file}))
+	c := NewClient(recorded.URL, nil)
+
+	_ = c.Set("users:1", "alice", 10)
+	_, _ = c.Get("users:1")
+	_, _ = c.Get("users:2")
+	_, _ = c.Delete("users:1")
+
+	recorded.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := Read(record.NewReader(f))
+	if err != nil || len(entries) != 4 {
+		t.Fatalf("Read() got = %d, %v, want = %d, %v", len(entries), err, 4, nil)
+	}
+
+	target := newServer(drivertest.NewFake())
+	defer target.Close()
+
+	r := Run(context.Background(), NewClient(target.URL, &http.Client{}), entries, &Options{Slowdown: 1000})
+
+	if r.Total != 4 || r.Results != 0 || r.Slow != 0 {
+		var b strings.Builder
+		r.Print(&b)
+		t.Errorf("Run() got = %v", b.String())
+	}
+}
@@ -0,0 +1,349 @@
+// Package replay drives key-value storage with the workload recorded by `decorators/record`.
+package replay
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultSpeed          = 1
+	defaultSlowdown       = 2
+	defaultMaxDivergences = 100
+	// latencySlack is the minimal difference of latencies reported as divergence.
+	latencySlack = time.Millisecond
+)
+
+// Kinds of `Divergence`.
+const (
+	KindResult  = "result"
+	KindLatency = "latency"
+)
+
+type (
+	// Options contains parameters of replay.
+	// Entries are replayed at the recorded pace scaled by `Speed` (2 is twice as fast),
+	// so concurrent calls are replayed concurrently, but calls of the same key keep the recorded order.
+	// Call is reported if it's result differs from the recorded one
+	// or it is `Slowdown` times slower than recorded one (by 1ms at least).
+	// Up to `MaxDivergences` divergences are kept in `Report`, all of them are counted.
+	Options struct {
+		Speed          float64 `json:"speed"`
+		Slowdown       float64 `json:"slowdown"`
+		MaxDivergences int     `json:"maxDivergences"`
+	}
+	// Divergence is a replayed call which differs from the recorded one by `Kind`.
+	Divergence struct {
+		Kind     string        `json:"kind"`
+		Recorded *record.Entry `json:"recorded"`
+		Replayed *record.Entry `json:"replayed"`
+	}
+	// Stats contains latency distribution of calls.
+	Stats struct {
+		Count int           `json:"count"`
+		P50   time.Duration `json:"p50"`
+		P90   time.Duration `json:"p90"`
+		P99   time.Duration `json:"p99"`
+		Max   time.Duration `json:"max"`
+	}
+	// Comparison contains recorded and replayed latencies of operation.
+	Comparison struct {
+		Recorded Stats `json:"recorded"`
+		Replayed Stats `json:"replayed"`
+	}
+	// Report is the result of replay.
+	Report struct {
+		Total       int                    `json:"total"`
+		Results     int                    `json:"results"`
+		Slow        int                    `json:"slow"`
+		Divergences []*Divergence          `json:"divergences"`
+		Latency     map[string]*Comparison `json:"latency"`
+		Elapsed     time.Duration          `json:"elapsed"`
+	}
+)
+
+// newStats returns distribution of `latencies` (they are sorted).
+func newStats(latencies []time.Duration) Stats {
+	if len(latencies) == 0 {
+		return Stats{}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	at := func(q float64) time.Duration {
+		return latencies[int(q*float64(len(latencies)-1))]
+	}
+
+	return Stats{Count: len(latencies), P50: at(0.5), P90: at(0.9), P99: at(0.99), Max: latencies[len(latencies)-1]}
+}
+
+// failed checks `e` is failed.
+func failed(e *record.Entry) bool {
+	return e.Err != ""
+}
+
+// same checks `replayed` call has the same result as `recorded` one.
+// Error messages are not compared since they depend on the target.
+func same(recorded, replayed *record.Entry) bool {
+	if failed(recorded) || failed(replayed) {
+		return failed(recorded) == failed(replayed)
+	}
+
+	return recorded.Val == replayed.Val && recorded.OK == replayed.OK && recorded.N == replayed.N
+}
+
+// condOptions returns options of conditional set `e`.
+// Only explicit `NoExpire` is replayed as one, any other expiry is replayed as 1ms at least.
+func condOptions(e *record.Entry) *fs.SetOptions {
+	if e.NoExpire {
+		return &fs.SetOptions{Mode: e.Mode, NoExpire: true}
+	}
+
+	pttl := e.PTTL
+	if pttl < 1 {
+		pttl = 1
+	}
+
+	return &fs.SetOptions{Mode: e.Mode, TTL: time.Duration(pttl) * time.Millisecond}
+}
+
+// call makes call of `e` to `target` and returns it's result.
+func call(ctx context.Context, target fs.ContextDriver, e *record.Entry) *record.Entry {
+	out := &record.Entry{Time: time.Now(), Op: e.Op, Key: e.Key}
+
+	var err error
+
+	switch e.Op {
+	case record.OpGet:
+		out.Val, err = target.GetContext(ctx, e.Key)
+	case record.OpSet:
+		out.Val, out.TTL, out.Tags = e.Val, e.TTL, e.Tags
+		if len(e.Tags) > 0 {
+			err = fs.TaggerOf(target).SetTagsContext(ctx, e.Key, e.Val, e.TTL, e.Tags)
+		} else {
+			err = target.SetContext(ctx, e.Key, e.Val, e.TTL)
+		}
+	case record.OpSetCond:
+		out.Val, out.Mode, out.PTTL, out.NoExpire = e.Val, e.Mode, e.PTTL, e.NoExpire
+		out.OK, err = fs.CondSetterOf(target).SetCondContext(ctx, e.Key, e.Val, condOptions(e))
+	case record.OpDel:
+		out.OK, err = target.DeleteContext(ctx, e.Key)
+	case record.OpDelTag:
+		out.N, err = fs.TaggerOf(target).DeleteTagContext(ctx, e.Key)
+	case record.OpDelPattern:
+		out.N, err = fs.MatcherOf(target).DeletePatternContext(ctx, e.Key)
+	default:
+		err = fmt.Errorf("unknown operation (%s)", e.Op)
+	}
+
+	out.Latency = time.Since(out.Time)
+
+	if err != nil {
+		out.Err = err.Error()
+	}
+
+	return out
+}
+
+// Read returns all entries of `r` ordered by start time.
+func Read(r *record.Reader) ([]*record.Entry, error) {
+	var entries []*record.Entry
+
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+
+	return entries, nil
+}
+
+// Run replays `entries` (ordered by start time) against `target` until they are over or `ctx` is done,
+// calls that are not started before `ctx` is done are not reported.
+// Panics if `opts` are invalid.
+func Run(ctx context.Context, target fs.ContextDriver, entries []*record.Entry, opts *Options) *Report {
+	validate(opts)
+
+	replayed := make([]*record.Entry, len(entries))
+	start := time.Now()
+	// last contains channels closed when the last started call of key is finished
+	last := make(map[string]chan struct{})
+
+	var wg sync.WaitGroup
+
+	for i, e := range entries {
+		offset := time.Duration(float64(e.Time.Sub(entries[0].Time)) / opts.Speed)
+		if err := sleep(ctx, offset-time.Since(start)); err != nil {
+			break
+		}
+
+		prev, done := last[e.Key], make(chan struct{})
+		last[e.Key] = done
+
+		wg.Add(1)
+
+		go func(i int, e *record.Entry) {
+			defer wg.Done()
+			defer close(done)
+
+			if prev != nil {
+				<-prev
+			}
+
+			replayed[i] = call(ctx, target, e)
+		}(i, e)
+	}
+
+	wg.Wait()
+
+	return report(entries, replayed, time.Since(start), opts)
+}
+
+// report compares `replayed` calls with `recorded` ones, not started calls are nil.
+func report(recorded, replayed []*record.Entry, elapsed time.Duration, opts *Options) *Report {
+	r := &Report{Divergences: []*Divergence{}, Latency: make(map[string]*Comparison), Elapsed: elapsed}
+
+	was := make(map[string][]time.Duration)
+	now := make(map[string][]time.Duration)
+
+	diverge := func(kind string, i int) {
+		if len(r.Divergences) < opts.MaxDivergences {
+			r.Divergences = append(r.Divergences, &Divergence{kind, recorded[i], replayed[i]})
+		}
+	}
+
+	for i, got := range replayed {
+		if got == nil {
+			continue
+		}
+
+		want := recorded[i]
+		r.Total++
+
+		was[want.Op] = append(was[want.Op], want.Latency)
+		now[got.Op] = append(now[got.Op], got.Latency)
+
+		switch {
+		case !same(want, got):
+			r.Results++
+			diverge(KindResult, i)
+		case float64(got.Latency) > float64(want.Latency)*opts.Slowdown && got.Latency-want.Latency >= latencySlack:
+			r.Slow++
+			diverge(KindLatency, i)
+		}
+	}
+
+	for op := range was {
+		r.Latency[op] = &Comparison{Recorded: newStats(was[op]), Replayed: newStats(now[op])}
+	}
+
+	return r
+}
+
+// Print writes `r` in human readable form to `w`.
+func (r *Report) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "replayed %d calls in %v: %d result divergences, %d slow calls\n", r.Total, r.Elapsed, r.Results, r.Slow)
+
+	ops := make([]string, 0, len(r.Latency))
+	for op := range r.Latency {
+		ops = append(ops, op)
+	}
+
+	sort.Strings(ops)
+
+	for _, op := range ops {
+		c := r.Latency[op]
+		_, _ = fmt.Fprintf(w, "%s (%d): p50 %v -> %v, p90 %v -> %v, p99 %v -> %v, max %v -> %v\n",
+			op, c.Recorded.Count,
+			c.Recorded.P50, c.Replayed.P50, c.Recorded.P90, c.Replayed.P90,
+			c.Recorded.P99, c.Replayed.P99, c.Recorded.Max, c.Replayed.Max,
+		)
+	}
+
+	for _, d := range r.Divergences {
+		_, _ = fmt.Fprintf(w, "%s divergence of %s key (%s) at %s: %s -> %s\n",
+			d.Kind, d.Recorded.Op, d.Recorded.Key, d.Recorded.Time.Format(time.RFC3339Nano),
+			describe(d.Kind, d.Recorded), describe(d.Kind, d.Replayed),
+		)
+	}
+}
+
+// describe returns `kind` of `e` result in human readable form.
+func describe(kind string, e *record.Entry) string {
+	switch {
+	case kind == KindLatency:
+		return e.Latency.String()
+	case failed(e):
+		return fmt.Sprintf("error %q", e.Err)
+	case e.Op == record.OpGet:
+		return fmt.Sprintf("%q", e.Val)
+	case e.Op == record.OpDel, e.Op == record.OpSetCond:
+		return fmt.Sprintf("ok %v", e.OK)
+	case e.Op == record.OpDelTag, e.Op == record.OpDelPattern:
+		return fmt.Sprintf("deleted %d", e.N)
+	default:
+		return "ok"
+	}
+}
+
+// sleep waits `delay` or until `ctx` is done.
+func sleep(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// validate sets defaults of `opts`.
+// Panics if `opts` are invalid.
+func validate(opts *Options) {
+	if opts.Speed == 0 {
+		opts.Speed = defaultSpeed
+	}
+
+	if opts.Speed < 0 {
+		log.Panicf("negative Speed")
+	}
+
+	if opts.Slowdown == 0 {
+		opts.Slowdown = defaultSlowdown
+	}
+
+	if opts.Slowdown < 0 {
+		log.Panicf("negative Slowdown")
+	}
+
+	if opts.MaxDivergences == 0 {
+		opts.MaxDivergences = defaultMaxDivergences
+	}
+
+	if opts.MaxDivergences < 0 {
+		log.Panicf("negative MaxDivergences")
+	}
+}
@@ -0,0 +1,223 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/faults"
+	"github.com/kxnes/go-interviews/apicache/internal/decorators/record"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+// workload returns entries recorded `step` apart from each other.
+func workload(step time.Duration) []*record.Entry {
+	start := time.Now()
+
+	entries := []*record.Entry{
+		{Op: record.OpSet, Key: "users:1", Val: "alice", TTL: 10},
+		{Op: record.OpGet, Key: "users:1", Val: "alice"},
+		{Op: record.OpGet, Key: "users:2"},
+		{Op: record.OpDel, Key: "users:1", OK: true},
+		{Op: record.OpGet, Key: "users:1"},
+	}
+
+	for i, e := range entries {
+		e.Time = start.Add(time.Duration(i) * step)
+		e.Latency = time.Millisecond
+	}
+
+	return entries
+}
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name    string
+		target  func() fs.ContextDriver
+		opts    *Options
+		total   int
+		results int
+		slow    int
+		kinds   string
+	}{
+		{
+			name:   "same",
+			target: func() fs.ContextDriver { return drivertest.NewFake() },
+			opts:   &Options{},
+			total:  5,
+		},
+		{
+			name: "stale state",
+			target: func() fs.ContextDriver {
+				d := drivertest.NewFake()
+				_ = d.Set("users:2", "bob", 10)
+
+				return d
+			},
+			opts:    &Options{},
+			total:   5,
+			results: 1,
+			kinds:   KindResult,
+		},
+		{
+			name: "outage",
+			target: func() fs.ContextDriver {
+				return faults.New(drivertest.NewFake(), &faults.Options{
+					Enabled: true,
+					Rules:   []*faults.Rule{{Ops: []string{"del"}, Outage: true}},
+				})
+			},
+			opts:    &Options{},
+			total:   5,
+			results: 2,
+			kinds:   KindResult + "," + KindResult,
+		},
+		{
+			name: "slow reads",
+			target: func() fs.ContextDriver {
+				return faults.New(drivertest.NewFake(), &faults.Options{
+					Enabled: true,
+					Rules:   []*faults.Rule{{Ops: []string{"get"}, Latency: &faults.Latency{Mean: 5}}},
+				})
+			},
+			opts:  &Options{MaxDivergences: 2},
+			total: 5,
+			slow:  3,
+			kinds: KindLatency + "," + KindLatency,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := Run(context.Background(), c.target(), workload(10*time.Millisecond), c.opts)
+
+			if r.Total != c.total || r.Results != c.results || r.Slow != c.slow {
+				t.Errorf("Run() got = %d/%d/%d, want = %d/%d/%d", r.Total, r.Results, r.Slow, c.total, c.results, c.slow)
+			}
+
+			kinds := make([]string, 0, len(r.Divergences))
+			for _, d := range r.Divergences {
+				kinds = append(kinds, d.Kind)
+			}
+
+			if got := strings.Join(kinds, ","); got != c.kinds {
+				t.Errorf("Run() divergences got = %v, want = %v", got, c.kinds)
+			}
+
+			if c := r.Latency[record.OpGet]; c == nil || c.Recorded.Count != 3 || c.Replayed.Count != 3 {
+				t.Errorf("Run() latency got = %+v, want 3 recorded and replayed gets", c)
+			}
+		})
+	}
+}
+
+func TestRunSpeed(t *testing.T) {
+	entries := workload(50 * time.Millisecond)
+
+	cases := []struct {
+		name     string
+		speed    float64
+		min, max time.Duration
+	}{
+		{name: "original", speed: 1, min: 200 * time.Millisecond, max: 400 * time.Millisecond},
+		{name: "scaled", speed: 4, min: 50 * time.Millisecond, max: 150 * time.Millisecond},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := Run(context.Background(), drivertest.NewFake(), entries, &Options{Speed: c.speed})
+
+			if r.Elapsed < c.min || r.Elapsed > c.max {
+				t.Errorf("Run() elapsed = %v, want in [%v, %v]", r.Elapsed, c.min, c.max)
+			}
+		})
+	}
+
+	// canceled replay reports only started calls
+	ctx, cancel := context.WithTimeout(context.Background(), 75*time.Millisecond)
+	defer cancel()
+
+	if r := Run(ctx, drivertest.NewFake(), entries, &Options{}); r.Total != 2 {
+		t.Errorf("Run() total got = %d, want = %d", r.Total, 2)
+	}
+}
+
+func TestRead(t *testing.T) {
+	data := `{"time":"2020-01-01T00:00:02Z","op":"get","key":"b"}
+{"time":"2020-01-01T00:00:01Z","op":"set","key":"a","val":"1"}
+`
+
+	entries, err := Read(record.NewReader(strings.NewReader(data)))
+	if err != nil || len(entries) != 2 || entries[0].Key != "a" || entries[1].Key != "b" {
+		t.Errorf("Read() got = %v, %v, want ordered by time", entries, err)
+	}
+
+	if _, err := Read(record.NewReader(strings.NewReader("{"))); err == nil {
+		t.Errorf("Read() error = %v, want decode error", err)
+	}
+}
+
+func TestCondOptions(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry *record.Entry
+		want  fs.SetOptions
+	}{
+		{"ttl", &record.Entry{Mode: fs.ModeNX, PTTL: 1500}, fs.SetOptions{Mode: fs.ModeNX, TTL: 1500 * time.Millisecond}},
+		{"no expire", &record.Entry{Mode: fs.ModeXX, NoExpire: true}, fs.SetOptions{Mode: fs.ModeXX, NoExpire: true}},
+		{"zero ttl", &record.Entry{}, fs.SetOptions{TTL: time.Millisecond}},
+		{"negative ttl", &record.Entry{PTTL: -5}, fs.SetOptions{TTL: time.Millisecond}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := condOptions(c.entry); *got != c.want {
+				t.Errorf("condOptions() got = %+v, want = %+v", *got, c.want)
+			}
+		})
+	}
+}
+
+func TestReportPrint(t *testing.T) {
+	entries := workload(0)
+	r := Run(context.Background(), drivertest.NewFake(), entries[2:], &Options{})
+
+	var buf bytes.Buffer
+	r.Print(&buf)
+
+	got := buf.String()
+
+	for _, want := range []string{
+		"replayed 3 calls",
+		"1 result divergences",
+		"get (2): p50",
+		`result divergence of del key (users:1)`,
+		"ok true -> ok false",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Print() got = %v, want to contain = %v", got, want)
+		}
+	}
+}
+
+func TestRunInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{Speed: -1}, "negative Speed"},
+		{&Options{Slowdown: -1}, "negative Slowdown"},
+		{&Options{MaxDivergences: -1}, "negative MaxDivergences"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			Run(context.Background(), drivertest.NewFake(), nil, c.opts)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix is the path prefix of peers protocol routes.
+const Prefix = "/_replication/"
+
+// Routes of peers protocol.
+const (
+	routePush   = Prefix + "push"
+	routeDigest = Prefix + "digest"
+	routePull   = Prefix + "pull"
+)
+
+type (
+	// peer streams mutations to the other node.
+	peer struct {
+		addr   string
+		client *http.Client
+		queue  chan *Mutation
+		batch  int
+	}
+	// digest is the response of digest route.
+	digest struct {
+		Buckets []uint64 `json:"buckets"`
+	}
+	// pushed is the response of push route.
+	pushed struct {
+		Applied int `json:"applied"`
+	}
+	// errorResponse is the response of failed request.
+	errorResponse struct {
+		Err string `json:"error"`
+	}
+	// ErrPeer occurred if peer responds with unexpected status.
+	ErrPeer struct {
+		addr   string
+		status int
+		body   string
+	}
+)
+
+func (e *ErrPeer) Error() string {
+	return fmt.Sprintf("peer (%s) responded with status (%d): %s", e.addr, e.status, e.body)
+}
+
+// enqueue adds `m` to the queue, it is dropped if the queue is full.
+func (p *peer) enqueue(m *Mutation) {
+	select {
+	case p.queue <- m:
+	default:
+		log.Printf("replication queue of peer (%s) is full, key (%s) is left to anti-entropy\n", p.addr, m.Key)
+	}
+}
+
+// send pushes queued mutations by batches until `ctx` is done, then it pushes the rest.
+func (p *peer) send(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case m := <-p.queue:
+			p.flush(context.Background(), m)
+		case <-ctx.Done():
+			for {
+				select {
+				case m := <-p.queue:
+					p.flush(context.Background(), m)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush pushes `first` with up to `batch` queued mutations, failed batch is left to anti-entropy.
+func (p *peer) flush(ctx context.Context, first *Mutation) {
+	batch := []*Mutation{first}
+
+collect:
+	for len(batch) < p.batch {
+		select {
+		case m := <-p.queue:
+			batch = append(batch, m)
+		default:
+			break collect
+		}
+	}
+
+	if err := p.push(ctx, batch); err != nil {
+		log.Printf("replication to peer (%s) err = %v\n", p.addr, err)
+	}
+}
+
+// do sends `method` request of `route` with `in` as JSON body and decodes response to `out`.
+func (p *peer) do(ctx context.Context, method, route string, in, out interface{}) error {
+	var body []byte
+
+	if in != nil {
+		body, _ = json.Marshal(in)
+	}
+
+	req, err := http.NewRequest(method, p.addr+route, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return &ErrPeer{p.addr, resp.StatusCode, strings.TrimSpace(string(data))}
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+// push sends `ms` to the peer.
+func (p *peer) push(ctx context.Context, ms []*Mutation) error {
+	return p.do(ctx, http.MethodPost, routePush, ms, &pushed{})
+}
+
+// digests returns digests of the peer buckets.
+func (p *peer) digests(ctx context.Context) ([]uint64, error) {
+	var d digest
+
+	err := p.do(ctx, http.MethodGet, routeDigest, nil, &d)
+
+	return d.Buckets, err
+}
+
+// pull returns mutations of the peer `selected` buckets.
+func (p *peer) pull(ctx context.Context, selected map[int]bool) ([]*Mutation, error) {
+	ids := make([]int, 0, len(selected))
+	for i := range selected {
+		ids = append(ids, i)
+	}
+
+	sort.Ints(ids)
+
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(id)
+	}
+
+	var ms []*Mutation
+
+	err := p.do(ctx, http.MethodGet, routePull+"?buckets="+strings.Join(parts, ","), nil, &ms)
+
+	return ms, err
+}
+
+// newPeer returns `peer` at `addr`.
+func newPeer(addr string, client *http.Client, opts *Options) *peer {
+	return &peer{addr: addr, client: client, queue: make(chan *Mutation, opts.QueueSize), batch: opts.BatchSize}
+}
+
+// respond writes `v` as JSON response with `status`.
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("response write err = %v\n", err)
+	}
+}
+
+// ServeHTTP serves peers protocol:
+// POST "push" applies mutations, GET "digest" returns digests of buckets
+// and GET "pull?buckets=1,2" returns mutations of selected buckets.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == routePush && r.Method == http.MethodPost:
+		var ms []*Mutation
+
+		if err := json.NewDecoder(r.Body).Decode(&ms); err != nil {
+			respond(w, http.StatusBadRequest, &errorResponse{"invalid JSON"})
+			return
+		}
+
+		respond(w, http.StatusOK, &pushed{n.apply(r.Context(), ms)})
+	case r.URL.Path == routeDigest && r.Method == http.MethodGet:
+		respond(w, http.StatusOK, &digest{n.store.digests()})
+	case r.URL.Path == routePull && r.Method == http.MethodGet:
+		selected := make(map[int]bool)
+
+		for _, part := range strings.Split(r.URL.Query().Get("buckets"), ",") {
+			id, err := strconv.Atoi(part)
+			if err != nil || id < 0 || id >= buckets {
+				respond(w, http.StatusBadRequest, &errorResponse{fmt.Sprintf("invalid bucket (%s)", part)})
+				return
+			}
+
+			selected[id] = true
+		}
+
+		respond(w, http.StatusOK, n.store.mutations(selected))
+	case r.URL.Path == routePush || r.URL.Path == routeDigest || r.URL.Path == routePull:
+		respond(w, http.StatusMethodNotAllowed, &errorResponse{"method not allowed"})
+	default:
+		respond(w, http.StatusNotFound, &errorResponse{"not found"})
+	}
+}
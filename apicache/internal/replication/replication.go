@@ -0,0 +1,418 @@
+// Package replication provides `fs.Driver` decorator replicating mutations between APICache nodes.
+package replication
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	defaultInterval  = 10000
+	defaultBatchSize = 100
+	defaultQueueSize = 10000
+	defaultTombstone = 3600
+	defaultTimeout   = 5000
+)
+
+type (
+	// Options contains `Node` specific parameters.
+	// Node is identified by unique `Node` ID, `Peers` are base URLs of the other nodes (like "http://10.0.0.2:8080").
+	// Local mutations are streamed to every peer by batches of up to `BatchSize`,
+	// up to `QueueSize` mutations wait for each peer (the newer ones are dropped and repaired later).
+	// Every `Interval` milliseconds (and at start) the node compares digests of data with peers
+	// and exchanges differing mutations (anti-entropy), so offline node catches up.
+	// Deletions and expired values are kept as tombstones for `Tombstone` seconds (since deletion or expiration),
+	// so node offline for longer may resurrect deleted or expired keys.
+	// Requests to peers are timed out after `Timeout` milliseconds.
+	Options struct {
+		Node      string        `json:"node"`
+		Peers     []string      `json:"peers"`
+		Interval  time.Duration `json:"interval"`
+		BatchSize int           `json:"batchSize"`
+		QueueSize int           `json:"queueSize"`
+		Tombstone time.Duration `json:"tombstone"`
+		Timeout   time.Duration `json:"timeout"`
+	}
+	// Node implements Driver interface.
+	// Mutations are applied to inner driver and replicated to peers with versions,
+	// the last writer wins on conflicts (clocks of nodes are expected to be synchronized).
+	// Reads are served by inner driver. `Node` is `http.Handler` of the peers protocol under `Prefix`.
+	Node struct {
+		driver fs.ContextDriver
+		opts   *Options
+		store  *store
+		peers  []*peer
+		client *http.Client
+		stop   context.CancelFunc
+		wg     sync.WaitGroup
+		once   sync.Once
+	}
+)
+
+// write applies `m` to inner driver, expired value deletes the key.
+func (n *Node) write(ctx context.Context) func(m *Mutation) error {
+	return func(m *Mutation) error {
+		if m.Deleted || m.expired(millis(time.Now())) {
+			_, err := n.driver.DeleteContext(ctx, m.Key)
+			return err
+		}
+
+		if len(m.Tags) > 0 {
+			return fs.TaggerOf(n.driver).SetTagsContext(ctx, m.Key, m.Val, m.ttl(millis(time.Now())), m.Tags)
+		}
+
+		_, err := fs.CondSetterOf(n.driver).SetCondContext(ctx, m.Key, m.Val, m.options())
+
+		return err
+	}
+}
+
+// deleteKeys deletes `keys` from key-value storage of all nodes until `ctx` is done
+// and returns the number of deleted ones.
+func (n *Node) deleteKeys(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+
+	for _, key := range keys {
+		ok, err := n.DeleteContext(ctx, key)
+		if err != nil {
+			return deleted, err
+		}
+
+		if ok {
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// apply applies remote mutations which win over the known ones.
+// Expired values delete keys as deletions do, so older values of lagging nodes don't stay.
+// Outdated tombstones are ignored, so forgotten mutations are not restored.
+func (n *Node) apply(ctx context.Context, ms []*Mutation) int {
+	now := time.Now()
+	applied := 0
+
+	for _, m := range ms {
+		if m.Key == "" || m.forgotten(now, n.opts.Tombstone*time.Second) {
+			continue
+		}
+
+		ok, err := n.store.update(m, "", n.write(ctx))
+		if err != nil {
+			log.Printf("replication of key (%s) err = %v\n", m.Key, err)
+			continue
+		}
+
+		if ok {
+			applied++
+		}
+	}
+
+	return applied
+}
+
+// local applies local mutation by `write` and streams it to peers.
+func (n *Node) local(m *Mutation, write func(m *Mutation) error) error {
+	if _, err := n.store.update(m, n.opts.Node, write); err != nil {
+		return err
+	}
+
+	for _, p := range n.peers {
+		p.enqueue(m)
+	}
+
+	return nil
+}
+
+// Get gets key from key-value storage.
+func (n *Node) Get(key string) (string, error) {
+	return n.GetContext(context.Background(), key)
+}
+
+// GetContext gets key from local key-value storage until `ctx` is done.
+func (n *Node) GetContext(ctx context.Context, key string) (string, error) {
+	return n.driver.GetContext(ctx, key)
+}
+
+// Set sets key, value and "time-to-live" to key-value storage.
+func (n *Node) Set(key, val string, ttl int) error {
+	return n.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext sets key, value and "time-to-live" to key-value storage of all nodes until `ctx` is done.
+// Key with negative `ttl` is deleted.
+func (n *Node) SetContext(ctx context.Context, key, val string, ttl int) error {
+	m := &Mutation{Key: key, Val: val}
+
+	switch {
+	case ttl < 0:
+		m.Val, m.Deleted = "", true
+	case ttl > 0:
+		m.Expire = millis(time.Now().Add(time.Duration(ttl) * time.Second))
+	}
+
+	return n.local(m, n.write(ctx))
+}
+
+// Delete deletes key from key-value storage.
+func (n *Node) Delete(key string) (bool, error) {
+	return n.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext deletes key from key-value storage of all nodes until `ctx` is done.
+// `ok` reports whether key existed locally, deletion of missing key is replicated anyway.
+func (n *Node) DeleteContext(ctx context.Context, key string) (bool, error) {
+	var ok bool
+
+	err := n.local(&Mutation{Key: key, Deleted: true}, func(m *Mutation) (err error) {
+		ok, err = n.driver.DeleteContext(ctx, m.Key)
+		return err
+	})
+
+	return ok, err
+}
+
+// SetCondContext sets key and value by `opts` to key-value storage of all nodes until `ctx` is done.
+// Expiration keeps milliseconds precision, conditional modes return `fs.ErrNotSupported`
+// since nodes don't agree on the current value of key.
+func (n *Node) SetCondContext(ctx context.Context, key, val string, opts *fs.SetOptions) (bool, error) {
+	if opts.Mode != "" {
+		return false, fs.NewErrNotSupported("mode")
+	}
+
+	m := &Mutation{Key: key, Val: val}
+	if expire := opts.Expire(time.Now()); !expire.IsZero() {
+		m.Expire = millis(expire)
+	}
+
+	if err := n.local(m, n.write(ctx)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SetTagsContext sets key, value and "time-to-live" with `tags` to key-value storage of all nodes
+// until `ctx` is done. Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (n *Node) SetTagsContext(ctx context.Context, key, val string, ttl int, tags []string) error {
+	if _, ok := n.driver.(fs.Tagger); !ok {
+		return fs.TaggerOf(n.driver).SetTagsContext(ctx, key, val, ttl, tags)
+	}
+
+	if ttl < 0 {
+		return n.SetContext(ctx, key, val, ttl)
+	}
+
+	m := &Mutation{Key: key, Val: val, Tags: tags}
+	if ttl > 0 {
+		m.Expire = millis(time.Now().Add(time.Duration(ttl) * time.Second))
+	}
+
+	return n.local(m, n.write(ctx))
+}
+
+// DeleteTagContext deletes all keys with `tag` from key-value storage of all nodes until `ctx` is done.
+// Keys are selected by the known mutations and their deletions are replicated one by one.
+// Returns `fs.ErrNotSupported` if inner driver is not `fs.Tagger`.
+func (n *Node) DeleteTagContext(ctx context.Context, tag string) (int, error) {
+	if _, ok := n.driver.(fs.Tagger); !ok {
+		return fs.TaggerOf(n.driver).DeleteTagContext(ctx, tag)
+	}
+
+	keys := n.store.keys(millis(time.Now()), func(m *Mutation) bool {
+		for _, t := range m.Tags {
+			if t == tag {
+				return true
+			}
+		}
+
+		return false
+	})
+
+	return n.deleteKeys(ctx, keys)
+}
+
+// DeletePatternContext deletes all keys matching `pattern` from key-value storage of all nodes
+// until `ctx` is done. Keys are selected by the known mutations and their deletions are replicated one by one.
+func (n *Node) DeletePatternContext(ctx context.Context, pattern string) (int, error) {
+	keys := n.store.keys(millis(time.Now()), func(m *Mutation) bool {
+		return fs.Match(pattern, m.Key)
+	})
+
+	return n.deleteKeys(ctx, keys)
+}
+
+// Unwrap returns decorated `fs.Driver`.
+func (n *Node) Unwrap() fs.Driver {
+	return n.driver
+}
+
+// Close stops anti-entropy, sends queued mutations and calls to release key-value storage resources.
+func (n *Node) Close() {
+	n.once.Do(func() {
+		n.stop()
+		n.wg.Wait()
+		n.driver.Close()
+	})
+}
+
+// antiEntropy syncs with peers at start and every `Interval` until `ctx` is done.
+func (n *Node) antiEntropy(ctx context.Context) {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.opts.Interval * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		n.store.gc(time.Now(), n.opts.Tombstone*time.Second)
+
+		for _, p := range n.peers {
+			if err := n.sync(ctx, p); err != nil && ctx.Err() == nil {
+				log.Printf("anti-entropy with peer (%s) err = %v\n", p.addr, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync exchanges mutations of buckets which digests differ with `p`.
+func (n *Node) sync(ctx context.Context, p *peer) error {
+	theirs, err := p.digests(ctx)
+	if err != nil {
+		return err
+	}
+
+	ours := n.store.digests()
+	differ := make(map[int]bool)
+
+	for i := range ours {
+		if i >= len(theirs) || ours[i] != theirs[i] {
+			differ[i] = true
+		}
+	}
+
+	if len(differ) == 0 {
+		return nil
+	}
+
+	remote, err := p.pull(ctx, differ)
+	if err != nil {
+		return err
+	}
+
+	n.apply(ctx, remote)
+
+	known := make(map[string]Version, len(remote))
+	for _, m := range remote {
+		known[m.Key] = m.Version
+	}
+
+	var newer []*Mutation
+
+	for _, m := range n.store.mutations(differ) {
+		if v, ok := known[m.Key]; !ok || m.Version.newer(v) {
+			newer = append(newer, m)
+		}
+	}
+
+	if len(newer) == 0 {
+		return nil
+	}
+
+	return p.push(ctx, newer)
+}
+
+// millis returns `t` in unix milliseconds.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func init() {
+	fs.RegisterDecorator("replication", func() interface{} { return &Options{} }, func(driver fs.Driver, config interface{}) fs.Driver {
+		return New(driver, config.(*Options))
+	})
+}
+
+// New returns `driver` decorated with replication to peers and starts it.
+// Panics if `opts` are invalid.
+func New(driver fs.Driver, opts *Options) *Node {
+	if opts == nil || opts.Node == "" {
+		log.Panicf("empty Node")
+	}
+
+	for _, p := range []struct {
+		name  string
+		value *time.Duration
+		def   time.Duration
+	}{
+		{"Interval", &opts.Interval, defaultInterval},
+		{"Tombstone", &opts.Tombstone, defaultTombstone},
+		{"Timeout", &opts.Timeout, defaultTimeout},
+	} {
+		if *p.value == 0 {
+			*p.value = p.def
+		}
+
+		if *p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+
+	for _, p := range []struct {
+		name  string
+		value *int
+		def   int
+	}{
+		{"BatchSize", &opts.BatchSize, defaultBatchSize},
+		{"QueueSize", &opts.QueueSize, defaultQueueSize},
+	} {
+		if *p.value == 0 {
+			*p.value = p.def
+		}
+
+		if *p.value < 0 {
+			log.Panicf("negative %s", p.name)
+		}
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+
+	n := &Node{
+		driver: fs.WithContext(driver),
+		opts:   opts,
+		store:  newStore(),
+		client: &http.Client{Timeout: opts.Timeout * time.Millisecond},
+		stop:   stop,
+	}
+
+	for _, addr := range opts.Peers {
+		if addr == "" {
+			log.Panicf("empty Peers address")
+		}
+
+		p := newPeer(strings.TrimSuffix(addr, "/"), n.client, opts)
+		n.peers = append(n.peers, p)
+
+		n.wg.Add(1)
+
+		go p.send(ctx, &n.wg)
+	}
+
+	n.wg.Add(1)
+
+	go n.antiEntropy(ctx)
+
+	return n
+}
@@ -0,0 +1,349 @@
+package replication
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/drivers/memory"
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+	"github.com/kxnes/go-interviews/apicache/internal/fs/drivertest"
+)
+
+// cluster is a group of nodes serving peers protocol on loopback.
+// Peers address node by URL with the ID of sender (like "http://127.0.0.1:8080/a"),
+// so node neither responds nor reaches peers (503 status) while it's `offline` flag is set.
+// Inner drivers are `memory.Driver` if `memory` is set, `drivertest.Fake` otherwise.
+type cluster struct {
+	servers []*httptest.Server
+	nodes   []*Node
+	offline []int32
+	memory  bool
+}
+
+// newCluster returns `size` listening servers, nodes are started by `start()`.
+func newCluster(size int) *cluster {
+	c := &cluster{servers: make([]*httptest.Server, size), nodes: make([]*Node, size), offline: make([]int32, size)}
+
+	for i := range c.servers {
+		i := i
+
+		c.servers[i] = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			from := int(r.URL.Path[1] - 'a')
+			r.URL.Path = r.URL.Path[2:]
+
+			if atomic.LoadInt32(&c.offline[i]) == 1 || atomic.LoadInt32(&c.offline[from]) == 1 {
+				http.Error(w, "offline", http.StatusServiceUnavailable)
+				return
+			}
+
+			c.nodes[i].ServeHTTP(w, r)
+		}))
+	}
+
+	return c
+}
+
+// start starts `i`-th node with `interval` of anti-entropy.
+func (c *cluster) start(i int, interval time.Duration) *Node {
+	var peers []string
+
+	id := string(rune('a' + i))
+
+	for j, ts := range c.servers {
+		if j != i {
+			peers = append(peers, "http://"+ts.Listener.Addr().String()+"/"+id)
+		}
+	}
+
+	var driver fs.Driver = drivertest.NewFake()
+	if c.memory {
+		driver = memory.New(nil)
+	}
+
+	n := New(driver, &Options{Node: id, Peers: peers, Interval: interval})
+	c.nodes[i] = n
+	c.servers[i].Start()
+
+	return n
+}
+
+func (c *cluster) close() {
+	for i, ts := range c.servers {
+		if c.nodes[i] != nil {
+			ts.Close()
+			c.nodes[i].Close()
+		}
+	}
+}
+
+// eventually checks `cond` becomes true in a second.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+
+	t.Errorf("%s is not reached", what)
+}
+
+// value returns value of `key` in `n` or error message.
+func value(n *Node, key string) string {
+	val, err := n.Get(key)
+	if err != nil {
+		return err.Error()
+	}
+
+	return val
+}
+
+func TestNodeReplicate(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 60000)
+	}
+
+	a, b, cc := c.nodes[0], c.nodes[1], c.nodes[2]
+
+	if err := a.Set("key", "val", 10); err != nil {
+		t.Fatalf("Set() error = %v, want = %v", err, nil)
+	}
+
+	eventually(t, "replicated set", func() bool { return value(b, "key") == "val" && value(cc, "key") == "val" })
+
+	if ok, err := b.Delete("key"); !ok || err != nil {
+		t.Errorf("Delete() got = %v, %v, want = %v, %v", ok, err, true, nil)
+	}
+
+	eventually(t, "replicated delete", func() bool { return value(a, "key") == "" && value(cc, "key") == "" })
+
+	// conflicting writes converge to the last one
+	_ = a.Set("conflict", "a", 10)
+	_ = cc.Set("conflict", "c", 10)
+
+	// the later local write is always newer
+	want := "c"
+
+	eventually(t, "converged conflict", func() bool {
+		return value(a, "conflict") == want && value(b, "conflict") == want && value(cc, "conflict") == want
+	})
+}
+
+func TestNodeTags(t *testing.T) {
+	c := newCluster(3)
+	c.memory = true
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 60000)
+	}
+
+	ctx := context.Background()
+	a, b, cc := c.nodes[0], c.nodes[1], c.nodes[2]
+
+	if err := a.SetTagsContext(ctx, "user:1:name", "alice", 10, []string{"user:1"}); err != nil {
+		t.Fatalf("SetTagsContext() error = %v, want = %v", err, nil)
+	}
+
+	_ = a.Set("user:2:name", "bob", 10)
+
+	eventually(t, "replicated set", func() bool { return value(b, "user:1:name") == "alice" && value(cc, "user:2:name") == "bob" })
+
+	// tags are replicated, so any node deletes by them
+	if n, err := b.DeleteTagContext(ctx, "user:1"); n != 1 || err != nil {
+		t.Errorf("DeleteTagContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+
+	eventually(t, "replicated tag delete", func() bool { return value(a, "user:1:name") == "" && value(cc, "user:1:name") == "" })
+
+	if n, err := cc.DeletePatternContext(ctx, "user:*"); n != 1 || err != nil {
+		t.Errorf("DeletePatternContext() got = %v, %v, want = %v, %v", n, err, 1, nil)
+	}
+
+	eventually(t, "replicated pattern delete", func() bool { return value(a, "user:2:name") == "" && value(b, "user:2:name") == "" })
+}
+
+func TestNodeCatchUp(t *testing.T) {
+	c := newCluster(2)
+	defer c.close()
+
+	a := c.start(0, 20)
+
+	_ = a.Set("early", "val", 10)
+	_ = a.Set("forever", "val", 0)
+	_ = a.Set("deleted", "val", 10)
+	_, _ = a.Delete("deleted")
+
+	// the second node was offline, so it catches up by anti-entropy
+	b := c.start(1, 20)
+
+	eventually(t, "caught up", func() bool { return value(b, "early") == "val" && value(b, "forever") == "val" })
+
+	if val := value(b, "deleted"); val != "" {
+		t.Errorf("Get() of deleted got = %v, want = %v", val, "")
+	}
+
+	// writes made while peer is unreachable are repaired too
+	atomic.StoreInt32(&c.offline[0], 1)
+	_ = b.Set("while-offline", "val", 10)
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&c.offline[0], 0)
+
+	eventually(t, "repaired", func() bool { return value(a, "while-offline") == "val" })
+}
+
+func TestNodeExpiredTombstone(t *testing.T) {
+	c := newCluster(3)
+	defer c.close()
+
+	for i := range c.nodes {
+		c.start(i, 20)
+	}
+
+	a, b, cc := c.nodes[0], c.nodes[1], c.nodes[2]
+
+	_ = a.Set("key", "old", 0)
+
+	eventually(t, "replicated set", func() bool { return value(b, "key") == "old" && value(cc, "key") == "old" })
+
+	// the third node keeps the old value while the newer one expires
+	atomic.StoreInt32(&c.offline[2], 1)
+	_ = b.Set("key", "new", 1)
+
+	eventually(t, "replicated set", func() bool { return value(a, "key") == "new" })
+	time.Sleep(1100 * time.Millisecond)
+	atomic.StoreInt32(&c.offline[2], 0)
+
+	// the expired value wins over the old one and deletes it
+	eventually(t, "replicated expiration", func() bool { return value(cc, "key") == "" })
+
+	// several anti-entropy rounds pass
+	time.Sleep(100 * time.Millisecond)
+
+	for i, n := range c.nodes {
+		if val := value(n, "key"); val != "" {
+			t.Errorf("Get() of node %d got = %v, want = %v", i, val, "")
+		}
+	}
+}
+
+func TestNodeServeHTTP(t *testing.T) {
+	n := New(drivertest.NewFake(), &Options{Node: "a"})
+	defer n.Close()
+
+	ts := httptest.NewServer(n)
+	defer ts.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		code   int
+		want   string
+	}{
+		{
+			name:   "push",
+			method: http.MethodPost,
+			path:   routePush,
+			body:   `[{"key":"key","val":"val","version":{"time":1,"node":"b"}},{"key":"key","val":"old","version":{"time":0,"node":"b"}}]`,
+			code:   http.StatusOK,
+			want:   `{"applied":1}`,
+		},
+		{
+			name:   "pull",
+			method: http.MethodGet,
+			path:   routePull + "?buckets=" + strconv.Itoa(bucket("key")),
+			code:   http.StatusOK,
+			want:   `[{"key":"key","val":"val","version":{"time":1,"node":"b"}}]`,
+		},
+		{
+			name:   "invalid JSON",
+			method: http.MethodPost,
+			path:   routePush,
+			body:   `{`,
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid JSON"}`,
+		},
+		{
+			name:   "invalid bucket",
+			method: http.MethodGet,
+			path:   routePull + "?buckets=64",
+			code:   http.StatusBadRequest,
+			want:   `{"error":"invalid bucket (64)"}`,
+		},
+		{
+			name:   "method",
+			method: http.MethodDelete,
+			path:   routeDigest,
+			code:   http.StatusMethodNotAllowed,
+			want:   `{"error":"method not allowed"}`,
+		},
+		{
+			name:   "route",
+			method: http.MethodGet,
+			path:   Prefix + "unknown",
+			code:   http.StatusNotFound,
+			want:   `{"error":"not found"}`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("%s unexpected error = %v", c.method, err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if got := strings.TrimSpace(string(body)); resp.StatusCode != c.code || got != c.want {
+				t.Errorf("%s got = %d %s, want = %d %s", c.method, resp.StatusCode, got, c.code, c.want)
+			}
+		})
+	}
+
+	if val := value(n, "key"); val != "val" {
+		t.Errorf("Get() got = %v, want = %v", val, "val")
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{nil, "empty Node"},
+		{&Options{}, "empty Node"},
+		{&Options{Node: "a", Interval: -1}, "negative Interval"},
+		{&Options{Node: "a", Tombstone: -1}, "negative Tombstone"},
+		{&Options{Node: "a", Timeout: -1}, "negative Timeout"},
+		{&Options{Node: "a", BatchSize: -1}, "negative BatchSize"},
+		{&Options{Node: "a", QueueSize: -1}, "negative QueueSize"},
+		{&Options{Node: "a", Peers: []string{""}}, "empty Peers address"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(drivertest.NewFake(), c.opts)
+		})
+	}
+}
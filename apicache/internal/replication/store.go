@@ -0,0 +1,211 @@
+package replication
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// buckets is the number of key ranges compared by anti-entropy.
+const buckets = 64
+
+type (
+	// Version orders mutations of key: the later `Time` (unix nanoseconds) wins,
+	// ties are broken by `Node` ID.
+	Version struct {
+		Time int64  `json:"time"`
+		Node string `json:"node"`
+	}
+	// Mutation is the last write of key: value with expiration time (unix milliseconds, zero if never)
+	// and tags or deletion. Deletion and expired value are kept as tombstones to win over older writes.
+	Mutation struct {
+		Key     string   `json:"key"`
+		Val     string   `json:"val,omitempty"`
+		Expire  int64    `json:"expire,omitempty"`
+		Tags    []string `json:"tags,omitempty"`
+		Deleted bool     `json:"deleted,omitempty"`
+		Version Version  `json:"version"`
+	}
+	// store keeps the last mutations of keys and digests of their buckets.
+	// Digest of bucket is XOR of it's mutations hashes, so it is updated incrementally.
+	store struct {
+		mu     sync.Mutex
+		items  map[string]*Mutation
+		digest [buckets]uint64
+	}
+)
+
+// newer checks `v` wins over `o`.
+func (v Version) newer(o Version) bool {
+	return v.Time > o.Time || v.Time == o.Time && v.Node > o.Node
+}
+
+// bucket returns index of bucket of `key`.
+func bucket(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return int(h.Sum32() % buckets)
+}
+
+// hash returns hash of `m` key and version.
+func (m *Mutation) hash() uint64 {
+	var buf [9]byte
+
+	binary.BigEndian.PutUint64(buf[:8], uint64(m.Version.Time))
+
+	if m.Deleted {
+		buf[8] = 1
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(m.Key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(m.Version.Node))
+	_, _ = h.Write(buf[:])
+
+	return h.Sum64()
+}
+
+// expired checks `m` value is expired at `now` (unix milliseconds).
+func (m *Mutation) expired(now int64) bool {
+	return !m.Deleted && m.Expire != 0 && m.Expire <= now
+}
+
+// forgotten checks `m` is deletion or expired value which is kept as tombstone longer than `tombstone` at `now`.
+func (m *Mutation) forgotten(now time.Time, tombstone time.Duration) bool {
+	oldest := now.Add(-tombstone)
+
+	if m.Deleted {
+		return m.Version.Time < oldest.UnixNano()
+	}
+
+	return m.Expire != 0 && m.Expire < millis(oldest)
+}
+
+// options returns options of `m` value set to inner driver, expiration time keeps milliseconds precision.
+func (m *Mutation) options() *fs.SetOptions {
+	if m.Expire == 0 {
+		return &fs.SetOptions{NoExpire: true}
+	}
+
+	return &fs.SetOptions{ExpireAt: time.Unix(0, m.Expire*int64(time.Millisecond))}
+}
+
+// ttl returns "time-to-live" of `m` value in seconds left at `now` (unix milliseconds), zero if it never expires.
+func (m *Mutation) ttl(now int64) int {
+	if m.Expire == 0 {
+		return 0
+	}
+
+	return int((m.Expire - now + 999) / 1000)
+}
+
+// update applies `m` by `write` if it wins over the known mutation of key.
+// Version of `local` mutation is assigned to win, otherwise outdated `m` is ignored.
+// Returns whether `m` is applied, `m` is not remembered if `write` fails.
+func (s *store) update(m *Mutation, local string, write func(m *Mutation) error) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.items[m.Key]
+
+	if local != "" {
+		m.Version = Version{Time: time.Now().UnixNano(), Node: local}
+
+		if ok && !m.Version.newer(old.Version) {
+			m.Version.Time = old.Version.Time + 1
+		}
+	} else if ok && !m.Version.newer(old.Version) {
+		return false, nil
+	}
+
+	if err := write(m); err != nil {
+		return false, err
+	}
+
+	b := bucket(m.Key)
+
+	if ok {
+		s.digest[b] ^= old.hash()
+	}
+
+	s.digest[b] ^= m.hash()
+	s.items[m.Key] = m
+
+	return true, nil
+}
+
+// keys returns keys of not deleted and not expired at `now` (unix milliseconds) values selected by `fn`.
+func (s *store) keys(now int64, fn func(m *Mutation) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []string
+
+	for key, m := range s.items {
+		if !m.Deleted && !m.expired(now) && fn(m) {
+			out = append(out, key)
+		}
+	}
+
+	return out
+}
+
+// digests returns digests of all buckets.
+func (s *store) digests() []uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]uint64(nil), s.digest[:]...)
+}
+
+// mutations returns mutations of keys in `selected` buckets.
+func (s *store) mutations(selected map[int]bool) []*Mutation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*Mutation, 0)
+
+	for key, m := range s.items {
+		if selected[bucket(key)] {
+			out = append(out, m)
+		}
+	}
+
+	return out
+}
+
+// version returns version of the known mutation of `key`.
+func (s *store) version(key string) (Version, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, ok := s.items[key]
+	if !ok {
+		return Version{}, false
+	}
+
+	return m.Version, true
+}
+
+// gc forgets deletions and expired values kept as tombstones longer than `tombstone` at `now`.
+func (s *store) gc(now time.Time, tombstone time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, m := range s.items {
+		if m.forgotten(now, tombstone) {
+			s.digest[bucket(key)] ^= m.hash()
+			delete(s.items, key)
+		}
+	}
+}
+
+// newStore returns empty `store`.
+func newStore() *store {
+	return &store{items: make(map[string]*Mutation)}
+}
@@ -0,0 +1,168 @@
+package replication
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVersionNewer(t *testing.T) {
+	cases := []struct {
+		name string
+		v, o Version
+		want bool
+	}{
+		{name: "later", v: Version{2, "a"}, o: Version{1, "b"}, want: true},
+		{name: "earlier", v: Version{1, "b"}, o: Version{2, "a"}, want: false},
+		{name: "tie by node", v: Version{1, "b"}, o: Version{1, "a"}, want: true},
+		{name: "same", v: Version{1, "a"}, o: Version{1, "a"}, want: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.v.newer(c.o); got != c.want {
+				t.Errorf("newer() got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestStoreUpdate(t *testing.T) {
+	s := newStore()
+	writes := 0
+	write := func(m *Mutation) error {
+		writes++
+		return nil
+	}
+
+	cases := []struct {
+		name  string
+		m     *Mutation
+		local string
+		want  bool
+	}{
+		{name: "remote new", m: &Mutation{Key: "key", Val: "1", Version: Version{10, "b"}}, want: true},
+		{name: "remote outdated", m: &Mutation{Key: "key", Val: "0", Version: Version{5, "c"}}, want: false},
+		{name: "remote tie lost", m: &Mutation{Key: "key", Val: "0", Version: Version{10, "a"}}, want: false},
+		{name: "remote newer", m: &Mutation{Key: "key", Val: "2", Version: Version{20, "a"}}, want: true},
+		{name: "local", m: &Mutation{Key: "key", Val: "3"}, local: "a", want: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ok, err := s.update(c.m, c.local, write)
+			if ok != c.want || err != nil {
+				t.Errorf("update() got = %v, %v, want = %v, %v", ok, err, c.want, nil)
+			}
+		})
+	}
+
+	if writes != 3 {
+		t.Errorf("writes got = %d, want = %d", writes, 3)
+	}
+
+	// local version wins even if the known one is from the future
+	future := time.Now().Add(time.Hour).UnixNano()
+	_, _ = s.update(&Mutation{Key: "future", Version: Version{future, "z"}}, "", write)
+	_, _ = s.update(&Mutation{Key: "future", Val: "local"}, "a", write)
+
+	if v, _ := s.version("future"); v != (Version{future + 1, "a"}) {
+		t.Errorf("version() got = %v, want = %v", v, Version{future + 1, "a"})
+	}
+
+	// failed write is not remembered
+	fail := func(m *Mutation) error { return errors.New("storage error") }
+
+	if ok, err := s.update(&Mutation{Key: "failed", Version: Version{1, "a"}}, "", fail); ok || err == nil {
+		t.Errorf("update() got = %v, %v, want = %v, error", ok, err, false)
+	}
+
+	if _, ok := s.version("failed"); ok {
+		t.Errorf("version() of failed write is known")
+	}
+}
+
+func TestStoreDigests(t *testing.T) {
+	ms := []*Mutation{
+		{Key: "a", Val: "1", Version: Version{1, "x"}},
+		{Key: "b", Val: "1", Version: Version{2, "x"}},
+		{Key: "a", Val: "2", Version: Version{3, "y"}},
+		{Key: "c", Deleted: true, Version: Version{4, "y"}},
+	}
+	write := func(m *Mutation) error { return nil }
+
+	forward, backward := newStore(), newStore()
+
+	for i := range ms {
+		_, _ = forward.update(ms[i], "", write)
+		_, _ = backward.update(ms[len(ms)-1-i], "", write)
+	}
+
+	f, b := forward.digests(), backward.digests()
+	for i := range f {
+		if f[i] != b[i] {
+			t.Fatalf("digests() of bucket %d got = %d, want = %d", i, b[i], f[i])
+		}
+	}
+
+	if got := len(forward.mutations(map[int]bool{bucket("a"): true, bucket("b"): true, bucket("c"): true})); got != 3 {
+		t.Errorf("mutations() got = %d, want = %d", got, 3)
+	}
+
+	// tombstone of "c" is outdated, value of "b" is expired
+	_, _ = forward.update(&Mutation{Key: "b", Val: "2", Expire: 1, Version: Version{5, "x"}}, "", write)
+	forward.gc(time.Unix(1, 0), 0)
+
+	empty := newStore()
+	_, _ = empty.update(ms[2], "", write)
+
+	f, e := forward.digests(), empty.digests()
+	for i := range f {
+		if f[i] != e[i] {
+			t.Fatalf("digests() after gc of bucket %d got = %d, want = %d", i, f[i], e[i])
+		}
+	}
+}
+
+func TestMutationTTL(t *testing.T) {
+	cases := []struct {
+		name   string
+		expire int64
+		now    int64
+		want   int
+	}{
+		{name: "never", expire: 0, now: 1000, want: 0},
+		{name: "rounded up", expire: 2500, now: 1000, want: 2},
+		{name: "exact", expire: 3000, now: 1000, want: 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			m := &Mutation{Expire: c.expire}
+			if got := m.ttl(c.now); got != c.want {
+				t.Errorf("ttl() got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestMutationForgotten(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	cases := []struct {
+		name string
+		m    *Mutation
+		want bool
+	}{
+		{name: "live", m: &Mutation{Expire: 200000, Version: Version{Time: 1}}, want: false},
+		{name: "forever", m: &Mutation{Version: Version{Time: 1}}, want: false},
+		{name: "expired tombstone", m: &Mutation{Expire: 95000, Version: Version{Time: 1}}, want: false},
+		{name: "outdated expired", m: &Mutation{Expire: 85000, Version: Version{Time: 1}}, want: true},
+		{name: "deleted tombstone", m: &Mutation{Deleted: true, Version: Version{Time: time.Unix(95, 0).UnixNano()}}, want: false},
+		{name: "outdated deleted", m: &Mutation{Deleted: true, Version: Version{Time: time.Unix(85, 0).UnixNano()}}, want: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.m.forgotten(now, 10*time.Second); got != c.want {
+				t.Errorf("forgotten() got = %v, want = %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Package webhook delivers key expiration and deletion events to HTTP receivers.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const (
+	// SignatureHeader contains "sha256=" and hex encoded HMAC-SHA256 of request body keyed by `Hook.Secret`.
+	SignatureHeader = "X-APICache-Signature"
+	// EventHeader contains type of the event (`fs.EventExpired` or `fs.EventDeleted`).
+	EventHeader = "X-APICache-Event"
+
+	defaultAttempts   = 5
+	defaultBackoff    = 100
+	defaultMaxBackoff = 10000
+	defaultTimeout    = 5000
+	defaultQueueSize  = 1000
+)
+
+type (
+	// Options contains `Dispatcher` specific parameters.
+	// Up to `QueueSize` events are queued for every hook, the newer ones are dropped while the queue is full.
+	Options struct {
+		Hooks     []*Hook `json:"hooks"`
+		QueueSize int     `json:"queueSize"`
+	}
+	// Hook posts events of keys with `Prefix` (all keys if empty) and types of `Events` (all if empty) to `URL`.
+	// Body is signed by `Secret` if it is set (see `SignatureHeader`).
+	// Failed delivery is made up to `Attempts` times, waiting `Backoff` milliseconds before the second one
+	// and doubling it up to `MaxBackoff` then. `Timeout` is the deadline of every attempt in milliseconds.
+	Hook struct {
+		URL        string        `json:"url"`
+		Prefix     string        `json:"prefix"`
+		Events     []string      `json:"events"`
+		Secret     string        `json:"secret"`
+		Attempts   int           `json:"attempts"`
+		Backoff    time.Duration `json:"backoff"`
+		MaxBackoff time.Duration `json:"maxBackoff"`
+		Timeout    time.Duration `json:"timeout"`
+	}
+	// Dispatcher delivers events to hooks matching them.
+	// Every hook has it's own queue, so slow receiver doesn't delay others and events are delivered in order.
+	Dispatcher struct {
+		opts   *Options
+		client *http.Client
+		mu     sync.RWMutex
+		queues []chan *fs.Event
+		closed bool
+		ctx    context.Context
+		cancel context.CancelFunc
+		wg     sync.WaitGroup
+	}
+	// ErrDelivery occurred if receiver doesn't accept the event.
+	ErrDelivery struct {
+		url    string
+		status int
+	}
+)
+
+func (e *ErrDelivery) Error() string {
+	return fmt.Sprintf("webhook (%s) responded with status %d", e.url, e.status)
+}
+
+// Sign returns value of `SignatureHeader` for `body` keyed by `secret`.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// match checks `e` is subscribed by `h`.
+func (h *Hook) match(e *fs.Event) bool {
+	if !strings.HasPrefix(e.Key, h.Prefix) {
+		return false
+	}
+
+	if len(h.Events) == 0 {
+		return true
+	}
+
+	for _, typ := range h.Events {
+		if typ == e.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retryable checks delivery failed with `status` may succeed next time,
+// client errors except timeout and rate limit are permanent.
+func retryable(status int) bool {
+	return status < 400 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// Dispatch queues `e` for every hook matching it, it never blocks.
+// Uses as `fs.Notifier` callback, events are ignored after `Close()`.
+func (d *Dispatcher) Dispatch(e *fs.Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return
+	}
+
+	for i, h := range d.opts.Hooks {
+		if !h.match(e) {
+			continue
+		}
+
+		select {
+		case d.queues[i] <- e:
+		default:
+			log.Printf("webhook (%s) queue is full, event %s of key (%s) is dropped", h.URL, e.Type, e.Key)
+		}
+	}
+}
+
+// send makes a single delivery attempt of `body` of event `typ` to `h`.
+func (d *Dispatcher) send(h *Hook, typ string, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, h.Timeout*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, typ)
+
+	if h.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(h.Secret, body))
+	}
+
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, &ErrDelivery{h.URL, resp.StatusCode}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// deliver delivers `e` to `h` with retries until it succeeds, fails permanently, attempts are over
+// or `Dispatcher` is closed.
+func (d *Dispatcher) deliver(h *Hook, e *fs.Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	backoff := h.Backoff * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		status, err := d.send(h, e.Type, body)
+		if err == nil || !retryable(status) || attempt >= h.Attempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+
+		select {
+		case <-timer.C:
+		case <-d.ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		backoff *= 2
+		if limit := h.MaxBackoff * time.Millisecond; backoff > limit {
+			backoff = limit
+		}
+	}
+}
+
+// worker delivers events of `queue` to `h` until it is closed.
+func (d *Dispatcher) worker(h *Hook, queue <-chan *fs.Event) {
+	defer d.wg.Done()
+
+	for e := range queue {
+		if d.ctx.Err() != nil {
+			continue
+		}
+
+		if err := d.deliver(h, e); err != nil {
+			log.Printf("webhook event %s of key (%s) is not delivered, err = %v", e.Type, e.Key, err)
+		}
+	}
+}
+
+// Close stops deliveries, in-flight and queued events are dropped.
+func (d *Dispatcher) Close() {
+	d.cancel()
+
+	d.mu.Lock()
+	d.closed = true
+
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+}
+
+// New returns "ready-to-use" `Dispatcher` of `opts`.
+// Panics if `opts` are invalid.
+func New(opts *Options) *Dispatcher {
+	if opts.QueueSize == 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+
+	if opts.QueueSize < 0 {
+		log.Panicf("negative QueueSize")
+	}
+
+	for _, h := range opts.Hooks {
+		h.validate()
+	}
+
+	d := &Dispatcher{opts: opts, client: &http.Client{}, queues: make([]chan *fs.Event, len(opts.Hooks))}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	for i, h := range opts.Hooks {
+		d.queues[i] = make(chan *fs.Event, opts.QueueSize)
+		d.wg.Add(1)
+
+		go d.worker(h, d.queues[i])
+	}
+
+	return d
+}
+
+// validate sets defaults of `h` and panics if it is invalid.
+func (h *Hook) validate() {
+	if h.URL == "" {
+		log.Panicf("empty hook URL")
+	}
+
+	for _, typ := range h.Events {
+		if typ != fs.EventExpired && typ != fs.EventDeleted {
+			log.Panicf("unknown hook event (%s)", typ)
+		}
+	}
+
+	if h.Attempts == 0 {
+		h.Attempts = defaultAttempts
+	}
+
+	if h.Attempts < 0 {
+		log.Panicf("negative hook Attempts")
+	}
+
+	if h.Backoff == 0 {
+		h.Backoff = defaultBackoff
+	}
+
+	if h.Backoff < 0 {
+		log.Panicf("negative hook Backoff")
+	}
+
+	if h.MaxBackoff == 0 {
+		h.MaxBackoff = defaultMaxBackoff
+	}
+
+	if h.MaxBackoff < 0 {
+		log.Panicf("negative hook MaxBackoff")
+	}
+
+	if h.Timeout == 0 {
+		h.Timeout = defaultTimeout
+	}
+
+	if h.Timeout < 0 {
+		log.Panicf("negative hook Timeout")
+	}
+}
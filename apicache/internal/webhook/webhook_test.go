@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// receiver records delivered events and replies with `statuses` one by one (200 when they are over).
+type receiver struct {
+	mu         sync.Mutex
+	statuses   []int
+	attempts   int
+	events     []string
+	signatures []string
+	bodies     [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.attempts++
+
+	if len(rc.statuses) > 0 {
+		status := rc.statuses[0]
+		rc.statuses = rc.statuses[1:]
+
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	var e fs.Event
+	_ = json.Unmarshal(body, &e)
+
+	rc.events = append(rc.events, r.Header.Get(EventHeader)+":"+e.Key)
+	rc.signatures = append(rc.signatures, r.Header.Get(SignatureHeader))
+	rc.bodies = append(rc.bodies, body)
+}
+
+// wait waits until `n` attempts are made and returns delivered events.
+func (rc *receiver) wait(t *testing.T, n int) []string {
+	t.Helper()
+
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		rc.mu.Lock()
+		attempts, events := rc.attempts, append([]string(nil), rc.events...)
+		rc.mu.Unlock()
+
+		if attempts >= n {
+			return events
+		}
+	}
+
+	t.Fatalf("attempts are not reached %d", n)
+
+	return nil
+}
+
+func TestDispatcherDispatch(t *testing.T) {
+	signed, all := &receiver{}, &receiver{}
+
+	signedServer := httptest.NewServer(signed)
+	defer signedServer.Close()
+
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+
+	d := New(&Options{Hooks: []*Hook{
+		{URL: signedServer.URL, Prefix: "user:", Events: []string{fs.EventExpired}, Secret: "secret"},
+		{URL: allServer.URL},
+	}})
+	defer d.Close()
+
+	for _, e := range []*fs.Event{
+		{Type: fs.EventExpired, Key: "user:1"},
+		{Type: fs.EventDeleted, Key: "user:2"},
+		{Type: fs.EventExpired, Key: "team:1"},
+	} {
+		d.Dispatch(e)
+	}
+
+	if got, want := all.wait(t, 3), []string{"expired:user:1", "deleted:user:2", "expired:team:1"}; !equal(got, want) {
+		t.Errorf("Dispatch() of all got = %v, want = %v", got, want)
+	}
+
+	if got, want := signed.wait(t, 1), []string{"expired:user:1"}; !equal(got, want) {
+		t.Errorf("Dispatch() of signed got = %v, want = %v", got, want)
+	}
+
+	if got, want := signed.signatures[0], Sign("secret", signed.bodies[0]); got != want {
+		t.Errorf("signature got = %v, want = %v", got, want)
+	}
+
+	if got := all.signatures[0]; got != "" {
+		t.Errorf("signature got = %v, want = %v", got, "")
+	}
+}
+
+func TestDispatcherRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int
+		attempts int
+		events   int
+	}{
+		{"recovered", []int{http.StatusInternalServerError, http.StatusTooManyRequests}, 3, 1},
+		{"permanent", []int{http.StatusBadRequest}, 1, 0},
+		{"exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 3, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc := &receiver{statuses: c.statuses}
+
+			ts := httptest.NewServer(rc)
+			defer ts.Close()
+
+			d := New(&Options{Hooks: []*Hook{{URL: ts.URL, Attempts: 3, Backoff: 1}}})
+			defer d.Close()
+
+			d.Dispatch(&fs.Event{Type: fs.EventDeleted, Key: "key"})
+
+			events := rc.wait(t, c.attempts)
+
+			// no more attempts are made
+			time.Sleep(20 * time.Millisecond)
+
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+
+			if rc.attempts != c.attempts || len(events) != c.events {
+				t.Errorf("attempts got = %d (%d delivered), want = %d (%d delivered)", rc.attempts, len(events), c.attempts, c.events)
+			}
+		})
+	}
+}
+
+func TestDispatcherClose(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusInternalServerError}}
+
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+
+	d := New(&Options{Hooks: []*Hook{{URL: ts.URL, Backoff: 60000}}})
+	d.Dispatch(&fs.Event{Type: fs.EventDeleted, Key: "key"})
+	rc.wait(t, 1)
+
+	// waiting retry is aborted and late events are ignored
+	done := make(chan struct{})
+
+	go func() {
+		d.Close()
+		d.Dispatch(&fs.Event{Type: fs.EventDeleted, Key: "late"})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Close() is not returned")
+	}
+}
+
+func TestNewInvalidOptions(t *testing.T) {
+	cases := []struct {
+		opts *Options
+		err  string
+	}{
+		{&Options{QueueSize: -1}, "negative QueueSize"},
+		{&Options{Hooks: []*Hook{{}}}, "empty hook URL"},
+		{&Options{Hooks: []*Hook{{URL: "http://a", Events: []string{"set"}}}}, "unknown hook event (set)"},
+		{&Options{Hooks: []*Hook{{URL: "http://a", Attempts: -1}}}, "negative hook Attempts"},
+		{&Options{Hooks: []*Hook{{URL: "http://a", Backoff: -1}}}, "negative hook Backoff"},
+		{&Options{Hooks: []*Hook{{URL: "http://a", MaxBackoff: -1}}}, "negative hook MaxBackoff"},
+		{&Options{Hooks: []*Hook{{URL: "http://a", Timeout: -1}}}, "negative hook Timeout"},
+	}
+	for _, c := range cases {
+		t.Run(c.err, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err != c.err {
+					t.Errorf("panic got = %v, want = %v", err, c.err)
+				}
+			}()
+
+			New(c.opts)
+		})
+	}
+}
+
+// equal checks `a` and `b` have the same elements in the same order.
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,6 +1,7 @@
 package test
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -27,10 +28,33 @@ const (
 	concurrentTimeout = 2
 )
 
+// sleep makes operation "slowly" if `IsConcurrent == true`
+// or returns `ctx` error if it is done before.
+func (d *DriverMock) sleep(ctx context.Context) error {
+	if !d.IsConcurrent {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(concurrentTimeout * time.Second)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Get emulates behaviour: gets key from key-value storage.
 func (d *DriverMock) Get(key string) (string, error) {
-	if d.IsConcurrent {
-		time.Sleep(concurrentTimeout * time.Second)
+	return d.GetContext(context.Background(), key)
+}
+
+// GetContext emulates behaviour: gets key from key-value storage until `ctx` is done.
+func (d *DriverMock) GetContext(ctx context.Context, key string) (string, error) {
+	if err := d.sleep(ctx); err != nil {
+		return "", err
 	}
 
 	if key == KeyError {
@@ -48,8 +72,13 @@ func (d *DriverMock) Get(key string) (string, error) {
 
 // Set emulates behaviour: sets key, value and "time-to-live" to key-value storage.
 func (d *DriverMock) Set(key, val string, ttl int) error {
-	if d.IsConcurrent {
-		time.Sleep(concurrentTimeout * time.Second)
+	return d.SetContext(context.Background(), key, val, ttl)
+}
+
+// SetContext emulates behaviour: sets key, value and "time-to-live" to key-value storage until `ctx` is done.
+func (d *DriverMock) SetContext(ctx context.Context, key, val string, ttl int) error {
+	if err := d.sleep(ctx); err != nil {
+		return err
 	}
 
 	if key == KeyError {
@@ -67,8 +96,13 @@ func (d *DriverMock) Set(key, val string, ttl int) error {
 
 // Delete emulates behaviour: deletes key from key-value storage.
 func (d *DriverMock) Delete(key string) (bool, error) {
-	if d.IsConcurrent {
-		time.Sleep(concurrentTimeout * time.Second)
+	return d.DeleteContext(context.Background(), key)
+}
+
+// DeleteContext emulates behaviour: deletes key from key-value storage until `ctx` is done.
+func (d *DriverMock) DeleteContext(ctx context.Context, key string) (bool, error) {
+	if err := d.sleep(ctx); err != nil {
+		return false, err
 	}
 
 	if key == KeyError {
@@ -0,0 +1,394 @@
+// Package memcachetest provides in-process memcached server speaking the text protocol
+// over loopback for hermetic tests of memcache clients.
+package memcachetest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// relativeExpireMax is the largest exptime treated as relative seconds,
+	// larger values are unix timestamps.
+	relativeExpireMax = 60 * 60 * 24 * 30
+	maxKeyLength      = 250
+
+	replyError   = "ERROR"
+	replyStored  = "STORED"
+	replyNot     = "NOT_STORED"
+	replyDeleted = "DELETED"
+	replyMiss    = "NOT_FOUND"
+	replyTouched = "TOUCHED"
+	replyOK      = "OK"
+	replyEnd     = "END"
+	version      = "VERSION 1.6.0-memcachetest"
+)
+
+type (
+	// Server is in-process memcached server.
+	// It supports only commands used by apicache drivers,
+	// keys expire lazily on access.
+	Server struct {
+		mu     sync.Mutex
+		ln     net.Listener
+		items  map[string]*item
+		errs   map[string]string
+		offset time.Duration
+		cas    uint64
+		conns  map[net.Conn]struct{}
+		wg     sync.WaitGroup
+	}
+	// item is a stored value with it's flags and expiration time.
+	item struct {
+		val    []byte
+		flags  uint32
+		expire time.Time
+		cas    uint64
+	}
+)
+
+// now returns the server time, it can be moved by `FastForward()`.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// expiration converts memcached `exptime` to time, zero time means no expiration.
+func (s *Server) expiration(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return s.now()
+	case exptime <= relativeExpireMax:
+		return s.now().Add(time.Duration(exptime) * time.Second)
+	default:
+		return time.Unix(exptime, 0)
+	}
+}
+
+// lookup returns not expired item of `key`, expired one is removed.
+func (s *Server) lookup(key string) (*item, bool) {
+	it, ok := s.items[key]
+
+	if ok && !it.expire.IsZero() && !s.now().Before(it.expire) {
+		delete(s.items, key)
+		return nil, false
+	}
+
+	return it, ok
+}
+
+// reply writes response `line` to `w`.
+func reply(w *bufio.Writer, line string) {
+	_, _ = w.WriteString(line + "\r\n")
+}
+
+// validKey checks `key` is accepted by memcached.
+func validKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+
+	return true
+}
+
+// injected returns the injected error reply of `cmd`.
+func (s *Server) injected(cmd string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.errs[cmd]
+
+	return msg, ok
+}
+
+// store executes storage command ("set", "add", "replace", "cas").
+// Data block is read even if the command is rejected.
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, args []string) bool {
+	cmd := args[0]
+
+	if cmd == "cas" && len(args) != 6 || cmd != "cas" && len(args) != 5 {
+		reply(w, replyError)
+		return true
+	}
+
+	flags, errFlags := strconv.ParseUint(args[2], 10, 32)
+	exptime, errExp := strconv.ParseInt(args[3], 10, 64)
+	size, errSize := strconv.Atoi(args[4])
+
+	if errFlags != nil || errExp != nil || errSize != nil || size < 0 {
+		reply(w, "CLIENT_ERROR bad command line format")
+		return false
+	}
+
+	data := make([]byte, size+2)
+
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false
+	}
+
+	if string(data[size:]) != "\r\n" {
+		reply(w, "CLIENT_ERROR bad data chunk")
+		return false
+	}
+
+	if msg, ok := s.injected(cmd); ok {
+		reply(w, msg)
+		return true
+	}
+
+	key := args[1]
+	if !validKey(key) {
+		reply(w, "CLIENT_ERROR bad command line format")
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, exists := s.lookup(key)
+
+	switch {
+	case cmd == "add" && exists:
+		reply(w, replyNot)
+		return true
+	case cmd == "replace" && !exists:
+		reply(w, replyNot)
+		return true
+	case cmd == "cas" && !exists:
+		reply(w, replyMiss)
+		return true
+	case cmd == "cas" && strconv.FormatUint(old.cas, 10) != args[5]:
+		reply(w, "EXISTS")
+		return true
+	}
+
+	s.cas++
+	s.items[key] = &item{
+		val:    data[:size],
+		flags:  uint32(flags),
+		expire: s.expiration(exptime),
+		cas:    s.cas,
+	}
+
+	reply(w, replyStored)
+
+	return true
+}
+
+// retrieve executes "get"/"gets" command.
+func (s *Server) retrieve(w *bufio.Writer, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range args[1:] {
+		it, ok := s.lookup(key)
+		if !ok {
+			continue
+		}
+
+		if args[0] == "gets" {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.val), it.cas)
+		} else {
+			_, _ = fmt.Fprintf(w, "VALUE %s %d %d\r\n", key, it.flags, len(it.val))
+		}
+
+		_, _ = w.Write(it.val)
+		_, _ = w.WriteString("\r\n")
+	}
+
+	reply(w, replyEnd)
+}
+
+// execute executes a command line which has no data block.
+func (s *Server) execute(w *bufio.Writer, args []string) {
+	cmd := args[0]
+
+	if msg, ok := s.injected(cmd); ok {
+		reply(w, msg)
+		return
+	}
+
+	switch {
+	case (cmd == "get" || cmd == "gets") && len(args) > 1:
+		s.retrieve(w, args)
+	case cmd == "delete" && len(args) == 2:
+		s.mu.Lock()
+		_, ok := s.lookup(args[1])
+		delete(s.items, args[1])
+		s.mu.Unlock()
+
+		if ok {
+			reply(w, replyDeleted)
+		} else {
+			reply(w, replyMiss)
+		}
+	case cmd == "touch" && len(args) == 3:
+		exptime, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			reply(w, "CLIENT_ERROR bad command line format")
+			return
+		}
+
+		s.mu.Lock()
+		it, ok := s.lookup(args[1])
+		if ok {
+			it.expire = s.expiration(exptime)
+		}
+		s.mu.Unlock()
+
+		if ok {
+			reply(w, replyTouched)
+		} else {
+			reply(w, replyMiss)
+		}
+	case cmd == "flush_all":
+		s.mu.Lock()
+		s.items = make(map[string]*item)
+		s.mu.Unlock()
+
+		reply(w, replyOK)
+	case cmd == "version":
+		reply(w, version)
+	default:
+		reply(w, replyError)
+	}
+}
+
+// serve serves a single client connection.
+func (s *Server) serve(nc net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+
+		_ = nc.Close()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		args := strings.Fields(line)
+
+		switch {
+		case len(args) == 0:
+			reply(w, replyError)
+		case args[0] == "quit":
+			return
+		case args[0] == "set" || args[0] == "add" || args[0] == "replace" || args[0] == "cas":
+			if !s.store(r, w, args) {
+				_ = w.Flush()
+				return
+			}
+		default:
+			s.execute(w, args)
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// accept accepts client connections until `Close()`.
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[nc] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+
+		go s.serve(nc)
+	}
+}
+
+// Addr returns the server address.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// SetError makes command `cmd` (e.g. "set") reply with `line`
+// (e.g. "SERVER_ERROR out of memory storing object").
+// Empty `line` removes the injected error.
+func (s *Server) SetError(cmd, line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if line == "" {
+		delete(s.errs, cmd)
+		return
+	}
+
+	s.errs[cmd] = line
+}
+
+// FastForward moves the server time by `d`, so keys expire without waiting.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset += d
+}
+
+// Close stops the server and closes all client connections.
+func (s *Server) Close() {
+	_ = s.ln.Close()
+
+	s.mu.Lock()
+	for nc := range s.conns {
+		_ = nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// NewServer starts a new `Server` on loopback.
+// Panics if it cannot listen.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Panicf("memcachetest listen err = %v", err)
+	}
+
+	s := &Server{
+		ln:    ln,
+		items: make(map[string]*item),
+		errs:  make(map[string]string),
+		conns: make(map[net.Conn]struct{}),
+	}
+
+	s.wg.Add(1)
+
+	go s.accept()
+
+	return s
+}
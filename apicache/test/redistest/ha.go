@@ -0,0 +1,66 @@
+package redistest
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// slots is the number of redis cluster hash slots.
+const slots = 16384
+
+func cmdSentinel(s *Server, _ *conn, args []string) interface{} {
+	if len(args) < 1 {
+		return errWrongArgs("sentinel")
+	}
+
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "get-master-addr-by-name" && len(args) == 2:
+		addr, ok := s.masters[args[1]]
+		if !ok {
+			return nil
+		}
+
+		host, port, _ := net.SplitHostPort(addr)
+
+		return []interface{}{host, port}
+	case sub == "sentinels" && len(args) == 2:
+		return []interface{}{}
+	default:
+		return replyError("ERR Unknown sentinel subcommand '" + args[0] + "'")
+	}
+}
+
+func cmdCluster(s *Server, _ *conn, args []string) interface{} {
+	if !s.cluster {
+		return replyError("ERR This instance has cluster support disabled")
+	}
+
+	if len(args) != 1 || strings.ToLower(args[0]) != "slots" {
+		return replyError("ERR Unknown subcommand or wrong number of arguments")
+	}
+
+	host, port, _ := net.SplitHostPort(s.Addr())
+	p, _ := strconv.ParseInt(port, 10, 64)
+
+	// the single node serves all slots
+	return []interface{}{
+		[]interface{}{int64(0), int64(slots - 1), []interface{}{host, p, "redistest"}},
+	}
+}
+
+// SetMaster makes the server to act as sentinel monitoring master `name` at `addr`.
+func (s *Server) SetMaster(name, addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.masters[name] = addr
+}
+
+// EnableCluster makes the server to act as the single node cluster serving all slots.
+func (s *Server) EnableCluster() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cluster = true
+}
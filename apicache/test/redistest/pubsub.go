@@ -0,0 +1,158 @@
+package redistest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+// notifyKeyspaceEvents is the only supported `CONFIG` parameter.
+const notifyKeyspaceEvents = "notify-keyspace-events"
+
+// subscribeCommands are allowed in subscribed mode.
+var subscribeCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"ping":         true,
+}
+
+// subscribed checks `c` is in subscribed mode.
+func (c *conn) subscribed() bool {
+	return len(c.channels)+len(c.patterns) > 0
+}
+
+// subscriptions returns subscriptions count of `c`.
+func (c *conn) subscriptions() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+
+// subscribe adds `names` to `set` of `c` and returns confirmations of `kind`.
+func subscribe(s *Server, c *conn, kind string, set *map[string]struct{}, names []string) interface{} {
+	if len(names) == 0 {
+		return errWrongArgs(kind)
+	}
+
+	if *set == nil {
+		*set = make(map[string]struct{})
+	}
+
+	out := make(replies, 0, len(names))
+
+	for _, name := range names {
+		(*set)[name] = struct{}{}
+		out = append(out, []interface{}{kind, name, c.subscriptions()})
+	}
+
+	s.subs[c] = struct{}{}
+
+	return out
+}
+
+// unsubscribe removes `names` (or all if empty) from `set` of `c` and returns confirmations of `kind`.
+func unsubscribe(s *Server, c *conn, kind string, set map[string]struct{}, names []string) interface{} {
+	if len(names) == 0 {
+		for name := range set {
+			names = append(names, name)
+		}
+	}
+
+	out := make(replies, 0, len(names))
+
+	for _, name := range names {
+		delete(set, name)
+		out = append(out, []interface{}{kind, name, c.subscriptions()})
+	}
+
+	if len(out) == 0 {
+		out = append(out, []interface{}{kind, nil, c.subscriptions()})
+	}
+
+	if !c.subscribed() {
+		delete(s.subs, c)
+	}
+
+	return out
+}
+
+func cmdSubscribe(s *Server, c *conn, args []string) interface{} {
+	return subscribe(s, c, "subscribe", &c.channels, args)
+}
+
+func cmdPSubscribe(s *Server, c *conn, args []string) interface{} {
+	return subscribe(s, c, "psubscribe", &c.patterns, args)
+}
+
+func cmdUnsubscribe(s *Server, c *conn, args []string) interface{} {
+	return unsubscribe(s, c, "unsubscribe", c.channels, args)
+}
+
+func cmdPUnsubscribe(s *Server, c *conn, args []string) interface{} {
+	return unsubscribe(s, c, "punsubscribe", c.patterns, args)
+}
+
+func cmdPublish(s *Server, _ *conn, args []string) interface{} {
+	if len(args) != 2 {
+		return errWrongArgs("publish")
+	}
+
+	return s.publish(args[0], args[1])
+}
+
+// publish sends `msg` to subscribers of `channel` and returns receivers count.
+func (s *Server) publish(channel, msg string) int64 {
+	var n int64
+
+	for c := range s.subs {
+		if _, ok := c.channels[channel]; ok {
+			_ = c.write([]interface{}{"message", channel, msg}, true)
+			n++
+		}
+
+		for pattern := range c.patterns {
+			if fs.Match(pattern, channel) {
+				_ = c.write([]interface{}{"pmessage", pattern, channel, msg}, true)
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+func cmdConfig(s *Server, _ *conn, args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs("config")
+	}
+
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "set" && len(args) == 3 && strings.ToLower(args[1]) == notifyKeyspaceEvents:
+		s.notify = args[2]
+		return simple("OK")
+	case sub == "get" && len(args) == 2:
+		if !fs.Match(args[1], notifyKeyspaceEvents) {
+			return []interface{}{}
+		}
+
+		return []interface{}{notifyKeyspaceEvents, s.notify}
+	default:
+		return replyError("ERR Unsupported CONFIG parameter: " + args[len(args)-1])
+	}
+}
+
+// keyevent publishes `event` ("del" or "expired") of `key` in `db` to "__keyevent@<db>__:<event>"
+// if it is enabled by "notify-keyspace-events" ("E" with "g" or "x" classes, "A" is alias for all).
+func (s *Server) keyevent(db int, event, key string) {
+	class := "g"
+	if event == "expired" {
+		class = "x"
+	}
+
+	if !strings.Contains(s.notify, "E") || !strings.Contains(s.notify, class) && !strings.Contains(s.notify, "A") {
+		return
+	}
+
+	s.publish(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+}
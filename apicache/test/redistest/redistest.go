@@ -0,0 +1,751 @@
+// Package redistest provides in-process redis server speaking RESP over loopback
+// for hermetic tests of redis clients.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kxnes/go-interviews/apicache/internal/fs"
+)
+
+const databases = 16
+
+type (
+	// Server is in-process redis server.
+	// It supports only commands used by apicache drivers,
+	// keys expire lazily on access and Lua scripts are emulated by `Script()`.
+	// Watched keys are touched by write commands even if value is not changed.
+	Server struct {
+		mu       sync.Mutex
+		ln       net.Listener
+		dbs      [databases]map[string]*item
+		errs     map[string]string
+		password string
+		offset   time.Duration
+		conns    map[net.Conn]struct{}
+		subs     map[*conn]struct{}
+		masters  map[string]string
+		cluster  bool
+		scripts  map[string]Script
+		loaded   map[string]struct{}
+		watchers map[watchKey]map[*conn]struct{}
+		notify   string
+		wg       sync.WaitGroup
+	}
+	// item is a stored string value (or sorted set if `zset` is not `nil`) with expiration time.
+	item struct {
+		val    string
+		zset   map[string]float64
+		expire time.Time
+	}
+	// conn is a client connection state.
+	// Writes are guarded by `mu` because messages are published from other connections.
+	conn struct {
+		mu       sync.Mutex
+		w        *bufio.Writer
+		db       int
+		authed   bool
+		channels map[string]struct{}
+		patterns map[string]struct{}
+		multi    bool
+		aborted  bool
+		queued   [][]string
+		watched  map[watchKey]struct{}
+		dirty    bool
+	}
+	// command is a handler of a single redis command.
+	command func(s *Server, c *conn, args []string) interface{}
+	// simple is RESP simple string reply.
+	simple string
+	// replyError is RESP error reply.
+	replyError string
+	// replies are several RESP replies of a single command.
+	replies []interface{}
+)
+
+// commands contains supported commands (lowercase).
+var commands = map[string]command{
+	"ping":     cmdPing,
+	"auth":     cmdAuth,
+	"select":   cmdSelect,
+	"get":      cmdGet,
+	"set":      cmdSet,
+	"incr":     cmdIncr,
+	"del":      cmdDel,
+	"exists":   cmdExists,
+	"ttl":      cmdTTL,
+	"pttl":     cmdPTTL,
+	"flushall": cmdFlush,
+	"flushdb":  cmdFlush,
+	"scan":     cmdScan,
+
+	"multi":   cmdMulti,
+	"discard": cmdDiscard,
+
+	"zadd":             cmdZAdd,
+	"zcard":            cmdZCard,
+	"zrem":             cmdZRem,
+	"zrangebyscore":    cmdZRangeByScore,
+	"zremrangebyscore": cmdZRemRangeByScore,
+
+	"subscribe":    cmdSubscribe,
+	"psubscribe":   cmdPSubscribe,
+	"unsubscribe":  cmdUnsubscribe,
+	"punsubscribe": cmdPUnsubscribe,
+	"publish":      cmdPublish,
+
+	"config": cmdConfig,
+
+	"sentinel": cmdSentinel,
+	"cluster":  cmdCluster,
+}
+
+func init() {
+	// EXEC runs other commands, so it is registered separately to break initialization cycle
+	commands["exec"] = cmdExec
+}
+
+// transactionCommands are executed immediately inside of MULTI.
+var transactionCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+}
+
+// errWrongType is error reply for operation against a key of the wrong kind.
+const errWrongType = replyError("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// errWrongArgs returns error reply for wrong arguments count of `cmd`.
+func errWrongArgs(cmd string) replyError {
+	return replyError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd))
+}
+
+// now returns the server time, it can be moved by `FastForward()`.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// lookup returns not expired item of `key` in `db`, expired one is removed.
+func (s *Server) lookup(db int, key string) (*item, bool) {
+	it, ok := s.dbs[db][key]
+
+	if ok && !it.expire.IsZero() && !s.now().Before(it.expire) {
+		delete(s.dbs[db], key)
+		s.touch(db, key)
+		s.keyevent(db, "expired", key)
+
+		return nil, false
+	}
+
+	return it, ok
+}
+
+func cmdPing(_ *Server, c *conn, args []string) interface{} {
+	payload := ""
+	if len(args) > 0 {
+		payload = args[0]
+	}
+
+	if c.subscribed() {
+		return []interface{}{"pong", payload}
+	}
+
+	if len(args) > 0 {
+		return payload
+	}
+
+	return simple("PONG")
+}
+
+func cmdAuth(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("auth")
+	}
+
+	if s.password == "" {
+		return replyError("ERR Client sent AUTH, but no password is set")
+	}
+
+	if args[0] != s.password {
+		return replyError("WRONGPASS invalid username-password pair")
+	}
+
+	c.authed = true
+
+	return simple("OK")
+}
+
+func cmdSelect(_ *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("select")
+	}
+
+	db, err := strconv.Atoi(args[0])
+	if err != nil || db < 0 || db >= databases {
+		return replyError("ERR DB index is out of range")
+	}
+
+	c.db = db
+
+	return simple("OK")
+}
+
+func cmdGet(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("get")
+	}
+
+	if it, ok := s.lookup(c.db, args[0]); ok {
+		if it.zset != nil {
+			return errWrongType
+		}
+
+		return it.val
+	}
+
+	return nil
+}
+
+func cmdSet(s *Server, c *conn, args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs("set")
+	}
+
+	var (
+		key, val = args[0], args[1]
+		expire   time.Time
+		nx, xx   bool
+	)
+
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(args[i]); opt {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ex", "px":
+			if i+1 == len(args) {
+				return replyError("ERR syntax error")
+			}
+
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return replyError("ERR invalid expire time in set")
+			}
+
+			unit := time.Second
+			if opt == "px" {
+				unit = time.Millisecond
+			}
+
+			expire = s.now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+
+	_, exists := s.lookup(c.db, key)
+	if nx && exists || xx && !exists {
+		return nil
+	}
+
+	s.dbs[c.db][key] = &item{val: val, expire: expire}
+
+	return simple("OK")
+}
+
+func cmdIncr(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("incr")
+	}
+
+	it, ok := s.lookup(c.db, args[0])
+	if !ok {
+		it = &item{val: "0"}
+		s.dbs[c.db][args[0]] = it
+	}
+
+	if it.zset != nil {
+		return errWrongType
+	}
+
+	n, err := strconv.ParseInt(it.val, 10, 64)
+	if err != nil {
+		return replyError("ERR value is not an integer or out of range")
+	}
+
+	n++
+	it.val = strconv.FormatInt(n, 10)
+
+	return n
+}
+
+func cmdDel(s *Server, c *conn, args []string) interface{} {
+	if len(args) == 0 {
+		return errWrongArgs("del")
+	}
+
+	var n int64
+
+	for _, key := range args {
+		if _, ok := s.lookup(c.db, key); ok {
+			delete(s.dbs[c.db], key)
+			s.keyevent(c.db, "del", key)
+			n++
+		}
+	}
+
+	return n
+}
+
+func cmdExists(s *Server, c *conn, args []string) interface{} {
+	if len(args) == 0 {
+		return errWrongArgs("exists")
+	}
+
+	var n int64
+
+	for _, key := range args {
+		if _, ok := s.lookup(c.db, key); ok {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ttl returns time to live of `key` in `unit`, -2 if key not exist and -1 if it has no expiration.
+func ttl(s *Server, c *conn, key string, unit time.Duration) int64 {
+	it, ok := s.lookup(c.db, key)
+
+	switch {
+	case !ok:
+		return -2
+	case it.expire.IsZero():
+		return -1
+	default:
+		return int64((it.expire.Sub(s.now()) + unit - 1) / unit)
+	}
+}
+
+func cmdTTL(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("ttl")
+	}
+
+	return ttl(s, c, args[0], time.Second)
+}
+
+func cmdPTTL(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("pttl")
+	}
+
+	return ttl(s, c, args[0], time.Millisecond)
+}
+
+func cmdFlush(s *Server, _ *conn, _ []string) interface{} {
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+
+	for _, conns := range s.watchers {
+		for c := range conns {
+			c.dirty = true
+		}
+	}
+
+	return simple("OK")
+}
+
+func cmdScan(s *Server, c *conn, args []string) interface{} {
+	if len(args) == 0 || len(args)%2 != 1 {
+		return errWrongArgs("scan")
+	}
+
+	if _, err := strconv.ParseUint(args[0], 10, 64); err != nil {
+		return replyError("ERR invalid cursor")
+	}
+
+	pattern := "*"
+
+	for i := 1; i < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+		default:
+			return replyError("ERR syntax error")
+		}
+	}
+
+	keys := make([]interface{}, 0)
+
+	// all keys are returned at once, so the cursor is always finished
+	for key := range s.dbs[c.db] {
+		if _, ok := s.lookup(c.db, key); ok && fs.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	return []interface{}{"0", keys}
+}
+
+func cmdMulti(_ *Server, c *conn, args []string) interface{} {
+	if len(args) != 0 {
+		return errWrongArgs("multi")
+	}
+
+	if c.multi {
+		return replyError("ERR MULTI calls can not be nested")
+	}
+
+	c.multi = true
+
+	return simple("OK")
+}
+
+func cmdExec(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 0 {
+		return errWrongArgs("exec")
+	}
+
+	if !c.multi {
+		return replyError("ERR EXEC without MULTI")
+	}
+
+	defer s.unwatch(c)
+
+	if c.aborted {
+		c.multi, c.aborted, c.queued = false, false, nil
+		return replyError("EXECABORT Transaction discarded because of previous errors.")
+	}
+
+	// watched key is touched, so transaction is failed
+	if c.dirty {
+		c.multi, c.queued = false, nil
+		return nil
+	}
+
+	out := make([]interface{}, 0, len(c.queued))
+
+	for _, q := range c.queued {
+		name := strings.ToLower(q[0])
+
+		if msg, ok := s.errs[name]; ok {
+			out = append(out, replyError(msg))
+			continue
+		}
+
+		s.touchArgs(c, name, q[1:])
+		out = append(out, commands[name](s, c, q[1:]))
+	}
+
+	c.multi, c.queued = false, nil
+
+	return out
+}
+
+func cmdDiscard(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 0 {
+		return errWrongArgs("discard")
+	}
+
+	if !c.multi {
+		return replyError("ERR DISCARD without MULTI")
+	}
+
+	c.multi, c.aborted, c.queued = false, false, nil
+	s.unwatch(c)
+
+	return simple("OK")
+}
+
+// readCommand reads RESP array of bulk strings (or inline command) from `r`.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	line = strings.TrimRight(line, "\r\n")
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length (%s)", line)
+	}
+
+	args := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		header, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("expected '$', got (%s)", strings.TrimSpace(header))
+		}
+
+		size, err := strconv.Atoi(strings.TrimRight(header[1:], "\r\n"))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length (%s)", strings.TrimSpace(header))
+		}
+
+		buf := make([]byte, size+2)
+
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+// writeReply writes `v` to `w` in RESP.
+func writeReply(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		_, _ = w.WriteString("$-1\r\n")
+	case simple:
+		_, _ = fmt.Fprintf(w, "+%s\r\n", v)
+	case replyError:
+		_, _ = fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		_, _ = fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		_, _ = fmt.Fprintf(w, "*%d\r\n", len(v))
+
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	case replies:
+		for _, e := range v {
+			writeReply(w, e)
+		}
+	default:
+		log.Panicf("unsupported reply type %T", v)
+	}
+}
+
+// exec executes `args` command for `c`.
+func (s *Server) exec(c *conn, args []string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := strings.ToLower(args[0])
+
+	// injected errors of queued commands are replied by EXEC
+	if c.multi && !transactionCommands[name] {
+		if _, ok := commands[name]; !ok {
+			c.aborted = true
+			return replyError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		}
+
+		c.queued = append(c.queued, args)
+
+		return simple("QUEUED")
+	}
+
+	if msg, ok := s.errs[name]; ok {
+		return replyError(msg)
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		return replyError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	}
+
+	if s.password != "" && !c.authed && name != "auth" {
+		return replyError("NOAUTH Authentication required.")
+	}
+
+	if c.subscribed() && !subscribeCommands[name] {
+		return replyError(fmt.Sprintf("ERR Can't execute '%s' in subscribed mode", name))
+	}
+
+	s.touchArgs(c, name, args[1:])
+
+	return cmd(s, c, args[1:])
+}
+
+// serve serves a single client connection.
+func (s *Server) serve(nc net.Conn) {
+	var (
+		c = &conn{w: bufio.NewWriter(nc)}
+		r = bufio.NewReader(nc)
+	)
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		delete(s.subs, c)
+		s.unwatch(c)
+		s.mu.Unlock()
+
+		_ = nc.Close()
+		s.wg.Done()
+	}()
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.write(replyError("ERR Protocol error: "+err.Error()), true)
+			}
+
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if strings.ToLower(args[0]) == "quit" {
+			c.write(simple("OK"), true)
+			return
+		}
+
+		// pipelined commands are replied together
+		if err := c.write(s.exec(c, args), r.Buffered() == 0); err != nil {
+			return
+		}
+	}
+}
+
+// accept accepts client connections until `Close()`.
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[nc] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+
+		go s.serve(nc)
+	}
+}
+
+// write writes reply `v` to `c` and flushes it if `flush` is set.
+func (c *conn) write(v interface{}, flush bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeReply(c.w, v)
+
+	if !flush {
+		return nil
+	}
+
+	return c.w.Flush()
+}
+
+// Addr returns the server address.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// SetError makes command `cmd` (e.g. "get") reply with error `msg`.
+// Empty `msg` removes the injected error.
+func (s *Server) SetError(cmd, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if msg == "" {
+		delete(s.errs, strings.ToLower(cmd))
+		return
+	}
+
+	s.errs[strings.ToLower(cmd)] = msg
+}
+
+// RequireAuth makes clients authenticate with `password`.
+func (s *Server) RequireAuth(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.password = password
+}
+
+// FastForward moves the server time by `d`, so keys expire without waiting.
+// Expired keys are removed at once as redis active expiration does.
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.offset += d
+
+	for db := range s.dbs {
+		for key := range s.dbs[db] {
+			s.lookup(db, key)
+		}
+	}
+}
+
+// Close stops the server and closes all client connections.
+func (s *Server) Close() {
+	_ = s.ln.Close()
+
+	s.mu.Lock()
+	for nc := range s.conns {
+		_ = nc.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+// NewServer starts a new `Server` on loopback.
+// Panics if it cannot listen.
+func NewServer() *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Panicf("redistest listen err = %v", err)
+	}
+
+	s := &Server{
+		ln:       ln,
+		errs:     make(map[string]string),
+		conns:    make(map[net.Conn]struct{}),
+		subs:     make(map[*conn]struct{}),
+		masters:  make(map[string]string),
+		scripts:  make(map[string]Script),
+		loaded:   make(map[string]struct{}),
+		watchers: make(map[watchKey]map[*conn]struct{}),
+	}
+
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+
+	s.wg.Add(1)
+
+	go s.accept()
+
+	return s
+}
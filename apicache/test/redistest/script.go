@@ -0,0 +1,125 @@
+package redistest
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+type (
+	// Script emulates Lua script, it runs atomically like the real one.
+	// `call` executes redis command as `redis.call()` does and returns it's reply
+	// (`string`, `int64`, `nil` or error reply).
+	Script func(call func(args ...string) interface{}, keys, argv []string) interface{}
+)
+
+func init() {
+	// scripts run other commands, so they are registered separately to break initialization cycle
+	commands["eval"] = cmdEval
+	commands["evalsha"] = cmdEvalSha
+	commands["script"] = cmdScript
+}
+
+// sha returns SHA1 digest of script `src` as redis does.
+func sha(src string) string {
+	sum := sha1.Sum([]byte(src))
+	return hex.EncodeToString(sum[:])
+}
+
+// Script registers `fn` as emulation of Lua script `src`, so it can be run by `EVAL` and `EVALSHA`.
+func (s *Server) Script(src string, fn Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[sha(src)] = fn
+}
+
+// run runs script with `digest` and `numkeys` keys followed by arguments of `args`.
+func (s *Server) run(c *conn, digest string, args []string) interface{} {
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 0 || n > len(args)-1 {
+		return replyError("ERR Number of keys can't be greater than number of args")
+	}
+
+	fn := s.scripts[digest]
+	call := func(args ...string) interface{} {
+		cmd, ok := commands[strings.ToLower(args[0])]
+		if !ok {
+			return replyError("ERR Unknown Redis command called from Lua script")
+		}
+
+		s.touchArgs(c, strings.ToLower(args[0]), args[1:])
+
+		return cmd(s, c, args[1:])
+	}
+
+	return fn(call, args[1:n+1], args[n+1:])
+}
+
+func cmdEval(s *Server, c *conn, args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs("eval")
+	}
+
+	digest := sha(args[0])
+
+	if _, ok := s.scripts[digest]; !ok {
+		return replyError("ERR Error compiling script (script is not emulated)")
+	}
+
+	s.loaded[digest] = struct{}{}
+
+	return s.run(c, digest, args[1:])
+}
+
+func cmdEvalSha(s *Server, c *conn, args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs("evalsha")
+	}
+
+	digest := strings.ToLower(args[0])
+
+	if _, ok := s.loaded[digest]; !ok {
+		return replyError("NOSCRIPT No matching script. Please use EVAL.")
+	}
+
+	return s.run(c, digest, args[1:])
+}
+
+func cmdScript(s *Server, _ *conn, args []string) interface{} {
+	if len(args) == 0 {
+		return errWrongArgs("script")
+	}
+
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "load" && len(args) == 2:
+		digest := sha(args[1])
+
+		if _, ok := s.scripts[digest]; !ok {
+			return replyError("ERR Error compiling script (script is not emulated)")
+		}
+
+		s.loaded[digest] = struct{}{}
+
+		return digest
+	case sub == "exists":
+		out := make([]interface{}, 0, len(args)-1)
+
+		for _, digest := range args[1:] {
+			var n int64
+			if _, ok := s.loaded[strings.ToLower(digest)]; ok {
+				n = 1
+			}
+
+			out = append(out, n)
+		}
+
+		return out
+	case sub == "flush" && len(args) == 1:
+		s.loaded = make(map[string]struct{})
+		return simple("OK")
+	default:
+		return replyError("ERR Unknown subcommand or wrong number of arguments for '" + args[0] + "'")
+	}
+}
@@ -0,0 +1,101 @@
+package redistest
+
+// writeCommands are commands modifying keys mapped to the index of their key argument (-1 if all arguments are keys).
+var writeCommands = map[string]int{
+	"set":              0,
+	"incr":             0,
+	"del":              -1,
+	"zadd":             0,
+	"zrem":             0,
+	"zremrangebyscore": 0,
+}
+
+// watchKey is `key` of `db` watched by connections.
+type watchKey struct {
+	db  int
+	key string
+}
+
+func init() {
+	// WATCH is executed immediately, so it is rejected inside of MULTI
+	commands["watch"] = cmdWatch
+	commands["unwatch"] = cmdUnwatch
+	transactionCommands["watch"] = true
+}
+
+// touch fails transactions of connections watching `key` of `db`.
+func (s *Server) touch(db int, key string) {
+	for c := range s.watchers[watchKey{db, key}] {
+		c.dirty = true
+	}
+}
+
+// touchArgs touches keys modified by command `name` with `args` of `c` before it is executed.
+// Missed keys are not modified, so they are not touched.
+func (s *Server) touchArgs(c *conn, name string, args []string) {
+	i, ok := writeCommands[name]
+	if !ok || len(s.watchers) == 0 || i >= len(args) {
+		return
+	}
+
+	keys := args
+	if i >= 0 {
+		keys = args[i : i+1]
+	}
+
+	for _, key := range keys {
+		if _, exists := s.lookup(c.db, key); exists || name == "set" || name == "incr" || name == "zadd" {
+			s.touch(c.db, key)
+		}
+	}
+}
+
+// unwatch forgets keys watched by `c`.
+func (s *Server) unwatch(c *conn) {
+	for key := range c.watched {
+		delete(s.watchers[key], c)
+
+		if len(s.watchers[key]) == 0 {
+			delete(s.watchers, key)
+		}
+	}
+
+	c.watched, c.dirty = nil, false
+}
+
+func cmdWatch(s *Server, c *conn, args []string) interface{} {
+	if len(args) == 0 {
+		return errWrongArgs("watch")
+	}
+
+	if c.multi {
+		return replyError("ERR WATCH inside MULTI is not allowed")
+	}
+
+	if c.watched == nil {
+		c.watched = make(map[watchKey]struct{})
+	}
+
+	for _, key := range args {
+		k := watchKey{c.db, key}
+		c.watched[k] = struct{}{}
+
+		if s.watchers[k] == nil {
+			s.watchers[k] = make(map[*conn]struct{})
+		}
+
+		s.watchers[k][c] = struct{}{}
+	}
+
+	return simple("OK")
+}
+
+func cmdUnwatch(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 0 {
+		return errWrongArgs("unwatch")
+	}
+
+	s.unwatch(c)
+
+	return simple("OK")
+}
@@ -0,0 +1,239 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// scoreRange is inclusive (or exclusive with "(" prefix) range of sorted set scores.
+type scoreRange struct {
+	min, max         float64
+	minExcl, maxExcl bool
+}
+
+// parseScore parses score bound `s` ("-inf", "+inf", "(1.5" and so on).
+func parseScore(s string) (float64, bool, error) {
+	excl := strings.HasPrefix(s, "(")
+	if excl {
+		s = s[1:]
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+
+	return f, excl, err
+}
+
+// parseRange parses `min` and `max` bounds of scores.
+func parseRange(min, max string) (*scoreRange, bool) {
+	var (
+		r   = &scoreRange{}
+		err error
+	)
+
+	if r.min, r.minExcl, err = parseScore(min); err != nil {
+		return nil, false
+	}
+
+	if r.max, r.maxExcl, err = parseScore(max); err != nil {
+		return nil, false
+	}
+
+	return r, true
+}
+
+// contains checks `score` is in `r`.
+func (r *scoreRange) contains(score float64) bool {
+	switch {
+	case score < r.min || r.minExcl && score == r.min:
+		return false
+	case score > r.max || r.maxExcl && score == r.max:
+		return false
+	default:
+		return true
+	}
+}
+
+// zset returns sorted set of `key` in `db` (`nil` if it doesn't exist) or error reply.
+func (s *Server) zset(db int, key string) (*item, interface{}) {
+	it, ok := s.lookup(db, key)
+	if !ok {
+		return nil, nil
+	}
+
+	if it.zset == nil {
+		return nil, errWrongType
+	}
+
+	return it, nil
+}
+
+// members returns members of `it` in `r` ordered by score and member.
+func members(it *item, r *scoreRange) []string {
+	out := make([]string, 0, len(it.zset))
+
+	for member, score := range it.zset {
+		if r.contains(score) {
+			out = append(out, member)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		si, sj := it.zset[out[i]], it.zset[out[j]]
+		if si != sj {
+			return si < sj
+		}
+
+		return out[i] < out[j]
+	})
+
+	return out
+}
+
+func cmdZAdd(s *Server, c *conn, args []string) interface{} {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return errWrongArgs("zadd")
+	}
+
+	it, reply := s.zset(c.db, args[0])
+	if reply != nil {
+		return reply
+	}
+
+	scores := make(map[string]float64, len(args)/2)
+
+	for i := 1; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || math.IsNaN(score) {
+			return replyError("ERR value is not a valid float")
+		}
+
+		scores[args[i+1]] = score
+	}
+
+	if it == nil {
+		it = &item{zset: make(map[string]float64)}
+		s.dbs[c.db][args[0]] = it
+	}
+
+	var n int64
+
+	for member, score := range scores {
+		if _, ok := it.zset[member]; !ok {
+			n++
+		}
+
+		it.zset[member] = score
+	}
+
+	return n
+}
+
+func cmdZCard(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 1 {
+		return errWrongArgs("zcard")
+	}
+
+	it, reply := s.zset(c.db, args[0])
+	if reply != nil {
+		return reply
+	}
+
+	if it == nil {
+		return int64(0)
+	}
+
+	return int64(len(it.zset))
+}
+
+func cmdZRem(s *Server, c *conn, args []string) interface{} {
+	if len(args) < 2 {
+		return errWrongArgs("zrem")
+	}
+
+	it, reply := s.zset(c.db, args[0])
+	if reply != nil || it == nil {
+		if reply == nil {
+			reply = int64(0)
+		}
+
+		return reply
+	}
+
+	var n int64
+
+	for _, member := range args[1:] {
+		if _, ok := it.zset[member]; ok {
+			delete(it.zset, member)
+			n++
+		}
+	}
+
+	s.prune(c.db, args[0], it)
+
+	return n
+}
+
+func cmdZRangeByScore(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 3 {
+		return errWrongArgs("zrangebyscore")
+	}
+
+	r, ok := parseRange(args[1], args[2])
+	if !ok {
+		return replyError("ERR min or max is not a float")
+	}
+
+	it, reply := s.zset(c.db, args[0])
+	if reply != nil {
+		return reply
+	}
+
+	out := make([]interface{}, 0)
+
+	if it != nil {
+		for _, member := range members(it, r) {
+			out = append(out, member)
+		}
+	}
+
+	return out
+}
+
+func cmdZRemRangeByScore(s *Server, c *conn, args []string) interface{} {
+	if len(args) != 3 {
+		return errWrongArgs("zremrangebyscore")
+	}
+
+	r, ok := parseRange(args[1], args[2])
+	if !ok {
+		return replyError("ERR min or max is not a float")
+	}
+
+	it, reply := s.zset(c.db, args[0])
+	if reply != nil || it == nil {
+		if reply == nil {
+			reply = int64(0)
+		}
+
+		return reply
+	}
+
+	removed := members(it, r)
+
+	for _, member := range removed {
+		delete(it.zset, member)
+	}
+
+	s.prune(c.db, args[0], it)
+
+	return int64(len(removed))
+}
+
+// prune removes empty sorted set `it` of `key` in `db` as redis does.
+func (s *Server) prune(db int, key string, it *item) {
+	if len(it.zset) == 0 {
+		delete(s.dbs[db], key)
+	}
+}